@@ -8,15 +8,25 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 const (
-	actionHTTPGet   = "httpGet"
-	actionExec      = "exec"
-	actionShell     = "shell"
-	actionDockerRun = "dockerRun"
+	actionHTTPGet    = "httpGet"
+	actionExec       = "exec"
+	actionShell      = "shell"
+	actionDockerRun  = "dockerRun"
+	actionComposeRun = "composeRun"
+	actionWebSocket  = "webSocket"
+	actionPublish    = "publish"
+	actionNotify     = "notify"
+	actionPlugin     = "plugin"
 )
 
 var actions = []string{
@@ -24,6 +34,11 @@ var actions = []string{
 	actionExec,
 	actionShell,
 	actionDockerRun,
+	actionComposeRun,
+	actionWebSocket,
+	actionPublish,
+	actionNotify,
+	actionPlugin,
 }
 
 var actionLocks = func() *Locks {
@@ -32,52 +47,188 @@ var actionLocks = func() *Locks {
 	return &l
 }()
 
+// actionSlots limits the number of concurrently running actions
+var actionSlots semaphore
+
 // Action is an operation triggered in response to an fsnotify event
 type Action struct {
-	*ActionHTTPGet   `json:"httpGet,omitempty" yaml:"httpGet,omitempty"`
-	*ActionExec      `json:"exec,omitempty" yaml:"exec,omitempty"`
-	*ActionShell     `json:"shell,omitempty" yaml:"shell,omitempty"`
-	*ActionDockerRun `json:"dockerRun,omitempty" yaml:"dockerRun,omitempty"`
-	Filter           `yaml:",inline,omitempty"`
-	Ignore           *Filter  `json:"ignore,omitempty" yaml:"ignore,omitempty"`
-	Delay            string   `json:"delay,omitempty" yaml:"delay,omitempty"`
-	Locks            []string `json:"locks,omitempty" yaml:"locks,flow,omitempty"`
-
-	trigger chan Event
-	run     chan struct{}
-	delay   time.Duration
-	tick    <-chan time.Time
-}
-
-func (a *Action) makeCanonical() {
-	a.Filter.makeCanonical()
-	if a.Ignore != nil {
-		a.Ignore.makeCanonical()
+	*ActionHTTPGet    `json:"httpGet,omitempty" yaml:"httpGet,omitempty"`
+	*ActionExec       `json:"exec,omitempty" yaml:"exec,omitempty"`
+	*ActionShell      `json:"shell,omitempty" yaml:"shell,omitempty"`
+	*ActionDockerRun  `json:"dockerRun,omitempty" yaml:"dockerRun,omitempty"`
+	*ActionComposeRun `json:"composeRun,omitempty" yaml:"composeRun,omitempty"`
+	*ActionWebSocket  `json:"webSocket,omitempty" yaml:"webSocket,omitempty"`
+	*ActionPublish    `json:"publish,omitempty" yaml:"publish,omitempty"`
+	*ActionNotify     `json:"notify,omitempty" yaml:"notify,omitempty"`
+	*ActionPlugin     `json:"plugin,omitempty" yaml:"plugin,omitempty"`
+	Filter            `yaml:",inline,omitempty"`
+	Name              string   `json:"name,omitempty" yaml:"name,omitempty"`
+	PrefixOutput      bool     `json:"prefixOutput,omitempty" yaml:"prefixOutput,omitempty"`
+	Ignore            *Filter  `json:"ignore,omitempty" yaml:"ignore,omitempty"`
+	Delay             string   `json:"delay,omitempty" yaml:"delay,omitempty"`
+	Locks             []string `json:"locks,omitempty" yaml:"locks,flow,omitempty"`
+	ReadLocks         []string `json:"readLocks,omitempty" yaml:"readLocks,flow,omitempty"`
+	LockTimeout       string   `json:"lockTimeout,omitempty" yaml:"lockTimeout,omitempty"`
+	CancelInFlight    bool     `json:"cancelInFlight,omitempty" yaml:"cancelInFlight,omitempty"`
+	Cooldown          string   `json:"cooldown,omitempty" yaml:"cooldown,omitempty"`
+	DebounceStrategy  string   `json:"debounceStrategy,omitempty" yaml:"debounceStrategy,omitempty"`
+	DependsOn         []string `json:"dependsOn,omitempty" yaml:"dependsOn,flow,omitempty"`
+	PerFile           bool     `json:"perFile,omitempty" yaml:"perFile,omitempty"`
+	After             *Action  `json:"after,omitempty" yaml:"after,omitempty"`
+	OnSuccess         *Action  `json:"onSuccess,omitempty" yaml:"onSuccess,omitempty"`
+	OnFailure         *Action  `json:"onFailure,omitempty" yaml:"onFailure,omitempty"`
+
+	trigger      chan []Event
+	state        *runState
+	dependencies []*Action
+	predecessors []*Action // the actions declared before this one, if `sequential` is set
+	run          chan struct{}
+	delay        time.Duration
+	lockTimeout  time.Duration
+	cooldown     time.Duration
+	debounce     debounceStrategy
+	stdout       *prefixWriter
+	stderr       *prefixWriter
+}
+
+// pendingRun is a triggered run of an action that has not started yet
+type pendingRun struct {
+	path    string // changed path, for `perFile` actions
+	events  []Event
+	batches int // number of dispatches covered by the run
+}
+
+func (a *Action) makeCanonical() error {
+	filterErr := a.Filter.makeCanonical()
+	if a.Ignore != nil && a.Ignore.CaseSensitive == nil {
+		a.Ignore.CaseSensitive = a.CaseSensitive
+	}
+	ignoreErr := a.Ignore.makeCanonical()
+	if ignoreErr == nil && a.Ignore != nil && !a.Ignore.constrains() {
+		ignoreErr = errEmptyIgnore
+	}
+	if ignoreErr != nil && filterErr == nil {
+		filterErr = fmt.Errorf("ignore: %v", ignoreErr)
 	}
 	if n, err := strconv.ParseInt(a.Delay, 10, 64); err == nil {
 		a.Delay = fmt.Sprint(time.Millisecond * time.Duration(n))
 	}
 	a.delay, _ = time.ParseDuration(a.Delay)
-	if a.delay > 0 {
-		a.tick = time.Tick(a.delay)
+	debounce, debounceErr := newDebounceStrategy(a.DebounceStrategy)
+	a.debounce = debounce
+	if debounceErr == nil && a.PerFile && a.debounce != (trailingDebounce{}) {
+		debounceErr = fmt.Errorf("debounceStrategy %q is not supported for perFile actions", a.DebounceStrategy)
+	}
+	if n, err := strconv.ParseInt(a.LockTimeout, 10, 64); err == nil {
+		a.LockTimeout = fmt.Sprint(time.Millisecond * time.Duration(n))
+	}
+	a.lockTimeout, _ = time.ParseDuration(a.LockTimeout)
+	if n, err := strconv.ParseInt(a.Cooldown, 10, 64); err == nil {
+		a.Cooldown = fmt.Sprint(time.Millisecond * time.Duration(n))
+	}
+	a.cooldown, _ = time.ParseDuration(a.Cooldown)
+	var output actionOutput
+	a.stdout, a.stderr = nil, nil
+	if a.PrefixOutput {
+		name := a.Name
+		if name == "" {
+			name = a.Type()
+		}
+		prefix := fmt.Sprintf("[%s] ", name)
+		a.stdout = newPrefixWriter(os.Stdout, &stdoutJSONMu, prefix)
+		a.stderr = newPrefixWriter(os.Stderr, &stderrJSONMu, prefix)
+		output = actionOutput{stdout: a.stdout, stderr: a.stderr}
 	}
 	switch {
+	case a.ActionHTTPGet != nil:
+		a.ActionHTTPGet.output = output
 	case a.ActionExec != nil:
-		a.ActionExec.makeCanonical()
+		a.ActionExec.output = output
 	case a.ActionShell != nil:
-		a.ActionShell.makeCanonical()
+		a.ActionShell.output = output
 	case a.ActionDockerRun != nil:
-		a.ActionDockerRun.makeCanonical()
+		a.ActionDockerRun.output = output
+	case a.ActionComposeRun != nil:
+		a.ActionComposeRun.output = output
+	case a.ActionPlugin != nil:
+		a.ActionPlugin.output = output
 	}
+	var err error
+	switch {
+	case a.ActionExec != nil:
+		err = a.ActionExec.makeCanonical()
+	case a.ActionShell != nil:
+		err = a.ActionShell.makeCanonical()
+	case a.ActionDockerRun != nil:
+		err = a.ActionDockerRun.makeCanonical()
+	case a.ActionComposeRun != nil:
+		err = a.ActionComposeRun.makeCanonical()
+	case a.ActionWebSocket != nil:
+		err = a.ActionWebSocket.makeCanonical()
+	case a.ActionPublish != nil:
+		err = a.ActionPublish.makeCanonical()
+	case a.ActionNotify != nil:
+		err = a.ActionNotify.makeCanonical()
+	case a.ActionPlugin != nil:
+		err = a.ActionPlugin.makeCanonical()
+	}
+	hookErr := firstError(
+		a.makeHookCanonical(a.After, "after"),
+		a.makeHookCanonical(a.OnSuccess, "onSuccess"),
+		a.makeHookCanonical(a.OnFailure, "onFailure"),
+	)
+	return firstError(filterErr, debounceErr, err, hookErr)
+}
+
+// Type returns the action's type name
+func (a *Action) Type() string {
+	switch {
+	case a.ActionHTTPGet != nil:
+		return actionHTTPGet
+	case a.ActionExec != nil:
+		return actionExec
+	case a.ActionShell != nil:
+		return actionShell
+	case a.ActionDockerRun != nil:
+		return actionDockerRun
+	case a.ActionComposeRun != nil:
+		return actionComposeRun
+	case a.ActionWebSocket != nil:
+		return actionWebSocket
+	case a.ActionPublish != nil:
+		return actionPublish
+	case a.ActionNotify != nil:
+		return actionNotify
+	case a.ActionPlugin != nil:
+		return actionPlugin
+	}
+	return ""
+}
+
+// interactive returns whether the action receives watchfs's stdin
+func (a *Action) interactive() bool {
+	switch {
+	case a.ActionExec != nil:
+		return a.ActionExec.Interactive
+	case a.ActionShell != nil:
+		return a.ActionShell.Interactive
+	}
+	return false
 }
 
 // Match returns whether an event passes the action's filters.
 func (a *Action) Match(e Event) bool {
-	if all, any := a.Filter.Match(e); !(all || any) {
+	if e.Op&fsnotify.Chmod != 0 && !config.IncludeChmod && !config.Filter.ops[fsnotify.Chmod] && !a.Filter.ops[fsnotify.Chmod] {
+		// an action that does not want chmod events matches a combined op (e.g. `write|chmod`) by its other ops
+		if e.Op &^= fsnotify.Chmod; e.Op == 0 {
+			return false
+		}
+	}
+	if !a.Filter.Match(e, matchAny) {
 		return false
 	}
 	if a.Ignore != nil {
-		if all, any := a.Ignore.Match(e); all && any {
+		if a.Ignore.Match(e, matchAll) {
 			return false
 		}
 	}
@@ -95,30 +246,205 @@ func (a *Action) Notify(e Event) (bool, error) {
 		return a.ActionShell.Notify(e)
 	case a.ActionDockerRun != nil:
 		return a.ActionDockerRun.Notify(e)
+	case a.ActionComposeRun != nil:
+		return a.ActionComposeRun.Notify(e)
+	case a.ActionWebSocket != nil:
+		return a.ActionWebSocket.Notify(e)
+	case a.ActionPublish != nil:
+		return a.ActionPublish.Notify(e)
+	case a.ActionNotify != nil:
+		return a.ActionNotify.Notify(e)
+	case a.ActionPlugin != nil:
+		return a.ActionPlugin.Notify(e)
 	}
 	return false, nil
 }
 
-// Run runs the action
-func (a *Action) Run(ctx context.Context) error {
-	actionLocks.Lock(a.Locks)
-	defer actionLocks.Unlock(a.Locks)
+// Run runs the action for the events coalesced since its previous run
+func (a *Action) Run(ctx context.Context, events []Event) error {
+	start := time.Now()
+	if err := a.waitForDependencies(ctx, events); err != nil {
+		return err
+	}
+	if err := a.waitForPredecessors(ctx); err != nil {
+		return err
+	}
+	lockCtx := ctx
+	if a.lockTimeout > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, a.lockTimeout)
+		defer cancel()
+	}
+	if err := actionLocks.LockAll(lockCtx, a.Locks, a.ReadLocks); err != nil {
+		return fmt.Errorf("skipped run: could not acquire locks %v (read locks %v): %v", a.Locks, a.ReadLocks, err)
+	}
+	defer actionLocks.UnlockAll(a.Locks, a.ReadLocks)
+	if err := actionSlots.acquire(ctx); err != nil {
+		return err
+	}
+	defer actionSlots.release()
+	onActionStarted(a, events, time.Since(start))
+	if a.PrefixOutput {
+		defer a.stderr.Flush()
+		defer a.stdout.Flush()
+	}
 	switch {
 	case a.ActionHTTPGet != nil:
-		return a.ActionHTTPGet.Run(ctx)
+		return a.ActionHTTPGet.Run(ctx, events)
 	case a.ActionExec != nil:
-		return a.ActionExec.Run(ctx)
+		return a.ActionExec.Run(ctx, events)
 	case a.ActionShell != nil:
-		return a.ActionShell.Run(ctx)
+		return a.ActionShell.Run(ctx, events)
 	case a.ActionDockerRun != nil:
-		return a.ActionDockerRun.Run(ctx)
+		return a.ActionDockerRun.Run(ctx, events)
+	case a.ActionComposeRun != nil:
+		return a.ActionComposeRun.Run(ctx, events)
+	case a.ActionWebSocket != nil:
+		return a.ActionWebSocket.Run(ctx, events)
+	case a.ActionPublish != nil:
+		return a.ActionPublish.Run(ctx, events)
+	case a.ActionNotify != nil:
+		return a.ActionNotify.Run(ctx, events)
+	case a.ActionPlugin != nil:
+		return a.ActionPlugin.Run(ctx, events)
 	}
 	return nil
 }
 
+// commandLine returns the command line the action runs for the events, if it runs a single command
+func (a *Action) commandLine(events []Event) []string {
+	switch {
+	case a.ActionExec != nil:
+		command, err := a.ActionExec.commandLine(events)
+		if err != nil {
+			return nil
+		}
+		return command
+	case a.ActionShell != nil:
+		name, args := a.ActionShell.commandLine()
+		return append([]string{name}, args...)
+	case a.ActionDockerRun != nil && a.ActionDockerRun.Name == "":
+		args, err := a.ActionDockerRun.runArgs(events)
+		if err != nil {
+			return nil
+		}
+		return append([]string{"docker"}, args...)
+	case a.ActionComposeRun != nil:
+		return append([]string{"docker"}, a.ActionComposeRun.args()...)
+	case a.ActionNotify != nil:
+		command, err := a.ActionNotify.commandLine(events)
+		if err != nil {
+			return nil
+		}
+		return command
+	case a.ActionPlugin != nil:
+		return a.ActionPlugin.commandLine()
+	}
+	return nil
+}
+
+// actionStart describes an action run that has started, after waiting for its
+// dependencies, locks and a free slot
+type actionStart struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	Command []string `json:"command,omitempty"`
+	Waited  string   `json:"waited"`
+	Batches []uint64 `json:"batches,omitempty"`
+}
+
+// actionResult describes a completed action run
+type actionResult struct {
+	Type     string   `json:"type"`
+	Name     string   `json:"name,omitempty"`
+	ExitCode int      `json:"exitCode"`
+	Duration string   `json:"duration"`
+	Path     string   `json:"path,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Batches  []uint64 `json:"batches,omitempty"`
+}
+
+// exitCode returns the exit status of a command from its error, 0 for no error,
+// and -1 if the command did not exit normally.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// Environment variables describing the last triggering event
+const (
+	envWatchfsPath = "WATCHFS_PATH"
+	envWatchfsOp   = "WATCHFS_OP"
+	envWatchfsTime = "WATCHFS_TIME"
+	// envWatchfsExitCode is the exit code of the run an `after` hook runs for
+	envWatchfsExitCode = "WATCHFS_EXIT_CODE"
+)
+
+// eventEnv returns the environment variables describing the last of the events, if any
+func eventEnv(events []Event) map[string]string {
+	if len(events) == 0 {
+		return nil
+	}
+	e := events[len(events)-1]
+	env := make(map[string]string)
+	if e.Name != "" {
+		env[envWatchfsPath] = e.Name
+		env[envWatchfsOp] = strings.ToLower(e.Op.String())
+		env[envWatchfsTime] = e.Time
+	}
+	if e.result != nil {
+		env[envWatchfsExitCode] = fmt.Sprint(e.result.exitCode)
+	}
+	return env
+}
+
+// commandEnv returns the environment for a command, with later maps taking precedence.
+// If all maps are empty, nil is returned so that the command inherits watchfs's environment.
+func commandEnv(envs ...map[string]string) (out []string) {
+	for _, env := range envs {
+		for k, v := range env {
+			out = append(out, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return append(os.Environ(), out...)
+}
+
+// resolveWorkDir expands a (templated) working directory and checks that it exists.
+// An empty working directory resolves to watchfs's working directory.
+func resolveWorkDir(workDir string, events []Event) (string, error) {
+	if workDir == "" {
+		return "", nil
+	}
+	dir, err := expandTemplate(workDir, newTemplateData(events))
+	if err != nil {
+		return "", fmt.Errorf("workdir %q: %v", workDir, err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("workdir %q: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("workdir %q: not a directory", dir)
+	}
+	return dir, nil
+}
+
 // ActionHTTPGet performs an HTTP GET to the given endpoint
 type ActionHTTPGet struct {
 	URL string `json:"url" yaml:"url"`
+
+	output actionOutput
 }
 
 // Notify notifies the action about a filesystem event
@@ -127,7 +453,7 @@ func (a *ActionHTTPGet) Notify(e Event) (bool, error) {
 }
 
 // Run runs the action
-func (a *ActionHTTPGet) Run(ctx context.Context) error {
+func (a *ActionHTTPGet) Run(ctx context.Context, events []Event) error {
 	parsed, err := url.Parse(a.URL)
 	if err != nil {
 		return err
@@ -143,224 +469,540 @@ func (a *ActionHTTPGet) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	return resp.Write(os.Stdout)
+	return resp.Write(a.output.Stdout())
 }
 
 // ActionExec runs the given command
 type ActionExec struct {
 	Command       []string          `json:"command,omitempty" yaml:"command,flow,omitempty"`
+	Template      bool              `json:"template,omitempty" yaml:"template,omitempty"`
 	Env           map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	EnvFile       stringList        `json:"envFile,omitempty" yaml:"envFile,flow,omitempty"`
+	WorkDir       string            `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+	Stdin         *string           `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+	Interactive   bool              `json:"interactive,omitempty" yaml:"interactive,omitempty"`
 	Signal        string            `json:"signal,omitempty" yaml:"signal,omitempty"`
+	Signals       []signalStep      `json:"signals,omitempty" yaml:"signals,omitempty"`
+	SignalMap     map[string]string `json:"signalMap,omitempty" yaml:"signalMap,omitempty"`
+	StdoutFile    string            `json:"stdoutFile,omitempty" yaml:"stdoutFile,omitempty"`
+	StderrFile    string            `json:"stderrFile,omitempty" yaml:"stderrFile,omitempty"`
+	AppendOutput  bool              `json:"appendOutput,omitempty" yaml:"appendOutput,omitempty"`
 	IgnoreSignals bool              `json:"ignoreSignals,omitempty" yaml:"ignoreSignals,omitempty"`
-	command       *exec.Cmd
+	process       runningProcess
 	signal        *os.Signal
+	signalMap     signalMap
+	envFile       map[string]string
+	output        actionOutput
 }
 
-func (a *ActionExec) makeCanonical() {
-	if a.Signal != "" {
-		signal, ok := parseSignal[a.Signal]
-		if !ok {
-			signal = defaultSignal
-		}
-		a.signal = &signal
-	}
+func (a *ActionExec) makeCanonical() error {
+	signal, signalErr := parseSignalOption(a.Signal)
+	a.signal = signal
+	stepsErr := makeSignalStepsCanonical(a.Signals)
+	signalMap, signalMapErr := parseSignalMap(a.SignalMap)
+	a.signalMap = signalMap
+	envFile, envErr := loadEnvFiles(a.EnvFile, config.environment())
+	a.envFile = envFile
+	var stdinErr error
+	if a.Interactive && a.Stdin != nil {
+		stdinErr = fmt.Errorf("interactive actions cannot have a stdin template")
+	}
+	return firstError(signalErr, stepsErr, signalMapErr, envErr, stdinErr)
 }
 
 // Notify notifies the action about a filesystem event
 func (a *ActionExec) Notify(e Event) (bool, error) {
-	if a.command == nil {
-		return false, nil
-	}
-	if a.command.Process == nil {
+	process, done := a.process.get()
+	if process == nil {
 		return false, nil
 	}
 	if a.IgnoreSignals {
 		return true, nil
 	}
-	s := config.signal
-	if a.signal != nil {
-		s = *a.signal
-	}
-	err := a.command.Process.Signal(s)
+	err := signalProcess(process, done, eventSignalSteps(e, a.signalMap, a.Signals, a.signal))
 	return err == nil, err
 }
 
+// commandLine returns the command, with its elements expanded as templates if `template` is set
+func (a *ActionExec) commandLine(events []Event) ([]string, error) {
+	if !a.Template {
+		return a.Command, nil
+	}
+	return expandTemplates(a.Command, events)
+}
+
 // Run runs the action
-func (a *ActionExec) Run(ctx context.Context) error {
+func (a *ActionExec) Run(ctx context.Context, events []Event) error {
 	if len(a.Command) == 0 {
 		return nil
 	}
-	name := a.Command[0]
+	dir, err := resolveWorkDir(a.WorkDir, events)
+	if err != nil {
+		return err
+	}
+	commandLine, err := a.commandLine(events)
+	if err != nil {
+		return fmt.Errorf("command: %v", err)
+	}
+	name := commandLine[0]
 	var args []string
-	if len(a.Command) > 1 {
-		args = a.Command[1:]
-	}
-	a.command = exec.CommandContext(ctx, name, args...)
-	a.command.Stdout = os.Stdout
-	a.command.Stderr = os.Stderr
-	if len(a.Env) > 0 || len(config.Env) > 0 {
-		a.command.Env = append(a.command.Env, os.Environ()...)
-		for k, v := range config.Env {
-			a.command.Env = append(a.command.Env, fmt.Sprintf("%s=%s", k, v))
+	if len(commandLine) > 1 {
+		args = commandLine[1:]
+	}
+	command := exec.CommandContext(ctx, name, args...)
+	command.Dir = dir
+	if a.Stdin != nil {
+		stdin, err := expandTemplate(*a.Stdin, newTemplateData(events))
+		if err != nil {
+			return fmt.Errorf("stdin: %v", err)
 		}
-		for k, v := range a.Env {
-			a.command.Env = append(a.command.Env, fmt.Sprintf("%s=%s", k, v))
+		command.Stdin = strings.NewReader(stdin)
+	}
+	if a.Interactive {
+		stdin, err := command.StdinPipe()
+		if err != nil {
+			return err
 		}
+		interactiveStdin.attach(stdin)
+		defer interactiveStdin.detach(stdin)
 	}
-	return a.command.Run()
+	output, closeOutput, err := a.output.tee(a.StdoutFile, a.StderrFile, a.AppendOutput)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+	command.Stdout = output.Stdout()
+	command.Stderr = output.Stderr()
+	command.Env = commandEnv(eventEnv(events), config.envFile, config.Env, a.envFile, a.Env)
+	return a.process.run(command)
 }
 
 // ActionShell runs the given command
 type ActionShell struct {
 	Command       string            `json:"command,omitempty" yaml:"command,flow,omitempty"`
-	Shell         []string          `json:"shell,omitempty" yaml:"shell,flow,omitempty"`
+	Shell         stringList        `json:"shell,omitempty" yaml:"shell,flow,omitempty"`
 	Env           map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	EnvFile       stringList        `json:"envFile,omitempty" yaml:"envFile,flow,omitempty"`
+	WorkDir       string            `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+	Stdin         *string           `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+	Interactive   bool              `json:"interactive,omitempty" yaml:"interactive,omitempty"`
 	IgnoreSignals bool              `json:"ignoreSignals,omitempty" yaml:"ignoreSignals,omitempty"`
 	Signal        string            `json:"signal,omitempty" yaml:"signal,omitempty"`
+	Signals       []signalStep      `json:"signals,omitempty" yaml:"signals,omitempty"`
+	SignalMap     map[string]string `json:"signalMap,omitempty" yaml:"signalMap,omitempty"`
+	StdoutFile    string            `json:"stdoutFile,omitempty" yaml:"stdoutFile,omitempty"`
+	StderrFile    string            `json:"stderrFile,omitempty" yaml:"stderrFile,omitempty"`
+	AppendOutput  bool              `json:"appendOutput,omitempty" yaml:"appendOutput,omitempty"`
 
-	command *exec.Cmd
-	signal  *os.Signal
+	process   runningProcess
+	signal    *os.Signal
+	signalMap signalMap
+	envFile   map[string]string
+	output    actionOutput
 }
 
-func (a *ActionShell) makeCanonical() {
-	if a.Signal != "" {
-		signal, ok := parseSignal[a.Signal]
-		if !ok {
-			signal = defaultSignal
-		}
-		a.signal = &signal
-	}
+func (a *ActionShell) makeCanonical() error {
+	signal, signalErr := parseSignalOption(a.Signal)
+	a.signal = signal
+	stepsErr := makeSignalStepsCanonical(a.Signals)
+	signalMap, signalMapErr := parseSignalMap(a.SignalMap)
+	a.signalMap = signalMap
+	envFile, envErr := loadEnvFiles(a.EnvFile, config.environment())
+	a.envFile = envFile
+	var stdinErr error
+	if a.Interactive && a.Stdin != nil {
+		stdinErr = fmt.Errorf("interactive actions cannot have a stdin template")
+	}
+	return firstError(signalErr, stepsErr, signalMapErr, envErr, stdinErr)
 }
 
 // Notify notifies the action about a filesystem event
 func (a *ActionShell) Notify(e Event) (bool, error) {
-	if a.command == nil {
-		return false, nil
-	}
-	if a.command.Process == nil {
+	process, done := a.process.get()
+	if process == nil {
 		return false, nil
 	}
 	if a.IgnoreSignals {
 		return true, nil
 	}
-	s := config.signal
-	if a.signal != nil {
-		s = *a.signal
-	}
-	err := a.command.Process.Signal(s)
+	err := signalProcess(process, done, eventSignalSteps(e, a.signalMap, a.Signals, a.signal))
 	return err == nil, err
 }
 
+// commandLine returns the shell and its arguments for running the command: the action's
+// `shell`, or else the top-level `shell`, or else the platform's default shell.
+// A shell given without arguments is passed the arguments given by shellArgs.
+func (a *ActionShell) commandLine() (name string, args []string) {
+	shell := a.Shell
+	if len(shell) == 0 {
+		shell = config.Shell
+	}
+	if len(shell) == 0 {
+		shell = stringList{defaultShell()}
+	}
+	name = shell[0]
+	if len(shell) > 1 {
+		args = append([]string(nil), shell[1:]...)
+	} else {
+		args = shellArgs(name)
+	}
+	return name, append(args, a.Command)
+}
+
 // Run runs the action
-func (a *ActionShell) Run(ctx context.Context) error {
+func (a *ActionShell) Run(ctx context.Context, events []Event) error {
 	if len(a.Command) == 0 {
 		return nil
 	}
-	name := defaultShell
-	args := append([]string(nil), defaultShellArgs...)
-	if len(a.Shell) > 0 {
-		name = a.Shell[0]
-		if len(a.Shell) > 1 {
-			args = a.Shell[1:]
-		}
+	name, args := a.commandLine()
+	dir, err := resolveWorkDir(a.WorkDir, events)
+	if err != nil {
+		return err
 	}
-	args = append(args, a.Command)
-	a.command = exec.CommandContext(ctx, name, args...)
-	a.command.Stdout = os.Stdout
-	a.command.Stderr = os.Stderr
-	if len(a.Env) > 0 || len(config.Env) > 0 {
-		a.command.Env = append(a.command.Env, os.Environ()...)
-		for k, v := range config.Env {
-			a.command.Env = append(a.command.Env, fmt.Sprintf("%s=%s", k, v))
+	command := exec.CommandContext(ctx, name, args...)
+	command.Dir = dir
+	if a.Stdin != nil {
+		stdin, err := expandTemplate(*a.Stdin, newTemplateData(events))
+		if err != nil {
+			return fmt.Errorf("stdin: %v", err)
 		}
-		for k, v := range a.Env {
-			a.command.Env = append(a.command.Env, fmt.Sprintf("%s=%s", k, v))
+		command.Stdin = strings.NewReader(stdin)
+	}
+	if a.Interactive {
+		stdin, err := command.StdinPipe()
+		if err != nil {
+			return err
 		}
+		interactiveStdin.attach(stdin)
+		defer interactiveStdin.detach(stdin)
 	}
-	return a.command.Run()
+	output, closeOutput, err := a.output.tee(a.StdoutFile, a.StderrFile, a.AppendOutput)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+	command.Stdout = output.Stdout()
+	command.Stderr = output.Stderr()
+	command.Env = commandEnv(eventEnv(events), config.envFile, config.Env, a.envFile, a.Env)
+	return a.process.run(command)
 }
 
 // ActionDockerRun runs a docker container for the given image
 type ActionDockerRun struct {
-	Image      string            `json:"image" yaml:"image"`
-	Entrypoint *string           `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
-	Command    *[]string         `json:"command,omitempty" yaml:"command,flow,omitempty"`
-	Env        map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
-	ExtraArgs  []string          `json:"extraArgs,omitempty" yaml:"extraArgs,omitempty"`
-	WorkDir    *string           `json:"workdir,omitempty" yaml:"workdir,omitempty"`
-	Volumes    []struct {
-		Source string `json:"source,omitempty" yaml:"source,omitempty"`
-		Target string `json:"target,omitempty" yaml:"target,omitempty"`
-		Type   string `json:"type,omitempty" yaml:"type,omitempty"`
-	} `json:"volumes,omitempty" yaml:"volumes,omitempty"`
-	IgnoreSignals bool   `json:"ignoreSignals,omitempty" yaml:"ignoreSignals,omitempty"`
-	Signal        string `json:"signal,omitempty" yaml:"signal,omitempty"`
-
-	signal  *os.Signal
-	command *exec.Cmd
-}
-
-func (a *ActionDockerRun) makeCanonical() {
-	if a.Signal != "" {
-		signal, ok := parseSignal[a.Signal]
-		if !ok {
-			signal = defaultSignal
+	Image         string            `json:"image" yaml:"image"`
+	Name          string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Exec          *[]string         `json:"exec,omitempty" yaml:"exec,flow,omitempty"`
+	Entrypoint    *string           `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
+	Command       *[]string         `json:"command,omitempty" yaml:"command,flow,omitempty"`
+	Env           map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	ExtraArgs     []string          `json:"extraArgs,omitempty" yaml:"extraArgs,omitempty"`
+	WorkDir       *string           `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+	Volumes       []dockerVolume    `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+	IgnoreSignals bool              `json:"ignoreSignals,omitempty" yaml:"ignoreSignals,omitempty"`
+	Signal        string            `json:"signal,omitempty" yaml:"signal,omitempty"`
+	Signals       []signalStep      `json:"signals,omitempty" yaml:"signals,omitempty"`
+	SignalMap     map[string]string `json:"signalMap,omitempty" yaml:"signalMap,omitempty"`
+	StdoutFile    string            `json:"stdoutFile,omitempty" yaml:"stdoutFile,omitempty"`
+	StderrFile    string            `json:"stderrFile,omitempty" yaml:"stderrFile,omitempty"`
+	AppendOutput  bool              `json:"appendOutput,omitempty" yaml:"appendOutput,omitempty"`
+
+	signal    *os.Signal
+	signalMap signalMap
+	process   runningProcess
+	output    actionOutput
+}
+
+func (a *ActionDockerRun) makeCanonical() error {
+	signal, signalErr := parseSignalOption(a.Signal)
+	a.signal = signal
+	stepsErr := makeSignalStepsCanonical(a.Signals)
+	signalMap, signalMapErr := parseSignalMap(a.SignalMap)
+	a.signalMap = signalMap
+	var execErr error
+	if a.Exec != nil && a.Name == "" {
+		execErr = fmt.Errorf("exec requires a container name")
+	}
+	var volumeErr error
+	for i, v := range a.Volumes {
+		if err := v.validate(); err != nil && volumeErr == nil {
+			volumeErr = fmt.Errorf("volume %d: %v", i, err)
 		}
-		a.signal = &signal
 	}
+	return firstError(signalErr, stepsErr, signalMapErr, execErr, volumeErr)
 }
 
-// Notify notifies the action about a filesystem event
-func (a *ActionDockerRun) Notify(e Event) (bool, error) {
-	if a.command == nil {
-		return false, nil
+// dockerVolume is a volume mounted into a docker container
+type dockerVolume struct {
+	Source      string `json:"source,omitempty" yaml:"source,omitempty"`
+	Target      string `json:"target,omitempty" yaml:"target,omitempty"`
+	Type        string `json:"type,omitempty" yaml:"type,omitempty"`
+	ReadOnly    bool   `json:"readonly,omitempty" yaml:"readonly,omitempty"`
+	Consistency string `json:"consistency,omitempty" yaml:"consistency,omitempty"`
+}
+
+var dockerVolumeConsistencies = []string{"default", "consistent", "cached", "delegated"}
+
+func (v dockerVolume) validate() error {
+	if v.Consistency == "" {
+		return nil
+	}
+	for _, c := range dockerVolumeConsistencies {
+		if v.Consistency == c {
+			return nil
+		}
 	}
-	if a.command.Process == nil {
+	return fmt.Errorf("invalid consistency %q (choices: %v)", v.Consistency, dockerVolumeConsistencies)
+}
+
+// mount returns the value of the volume's `--mount` option
+func (v dockerVolume) mount() string {
+	volumeType := "bind"
+	if v.Type != "" {
+		volumeType = v.Type
+	}
+	if volumeType == "bind" {
+		v.Source, _ = filepath.Abs(v.Source)
+	}
+	mount := fmt.Sprintf("type=%s,source=%s,target=%s", volumeType, v.Source, v.Target)
+	if v.ReadOnly {
+		mount += ",readonly"
+	}
+	if v.Consistency != "" {
+		mount += ",consistency=" + v.Consistency
+	}
+	return mount
+}
+
+// Notify notifies the action about a filesystem event.
+// A named container is signalled using `docker kill --signal`.
+func (a *ActionDockerRun) Notify(e Event) (bool, error) {
+	process, done := a.process.get()
+	if process == nil {
 		return false, nil
 	}
 	if a.IgnoreSignals {
 		return true, nil
 	}
-	s := config.signal
-	if a.signal != nil {
-		s = *a.signal
+	steps := eventSignalSteps(e, a.signalMap, a.Signals, a.signal)
+	if a.Name != "" {
+		err := signalSequence(a.killContainer, done, steps)
+		return err == nil, err
 	}
-	err := a.command.Process.Signal(s)
+	err := signalProcess(process, done, steps)
 	return err == nil, err
 }
 
-// Run runs the action
-func (a *ActionDockerRun) Run(ctx context.Context) error {
-	args := []string{"run", "--init", "--rm", "-t", "-a", "stdout", "-a", "stderr"}
-	if a.Entrypoint != nil {
-		args = append(args, "--entrypoint", *a.Entrypoint)
+// dockerKillTimeout bounds the `docker kill` that signals a container, which runs while
+// the event that caused it is being handled
+const dockerKillTimeout = 10 * time.Second
+
+func (a *ActionDockerRun) killContainer(signal os.Signal) error {
+	name := signal.String()
+	if s, ok := signal.(syscall.Signal); ok {
+		name = strconv.Itoa(int(s))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dockerKillTimeout)
+	defer cancel()
+	return exec.CommandContext(ctx, "docker", "kill", "--signal", name, a.Name).Run()
+}
+
+// commands returns the docker commands to run. A named container that already
+// exists is stopped and started again (or has `exec` run in it); otherwise a
+// new container is run. Only unnamed containers are removed after they exit.
+// `docker exec` is passed the environment like a new container (see envArgs);
+// a container that is started again keeps the environment it was created with.
+func (a *ActionDockerRun) commands(exists bool, events []Event) ([][]string, error) {
+	if a.Name != "" && exists {
+		if a.Exec != nil {
+			args, err := expandTemplates(*a.Exec, events)
+			if err != nil {
+				return nil, fmt.Errorf("exec: %v", err)
+			}
+			execArgs := append([]string{"exec"}, a.envArgs(events)...)
+			return [][]string{append(append(execArgs, a.Name), args...)}, nil
+		}
+		return [][]string{
+			{"stop", a.Name},
+			{"start", "-a", a.Name},
+		}, nil
 	}
-	for k, v := range config.Env {
-		args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
+	args, err := a.runArgs(events)
+	if err != nil {
+		return nil, err
 	}
-	for k, v := range a.Env {
-		args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
+	return [][]string{args}, nil
+}
+
+// runArgs returns the arguments for `docker run`. The environment is passed to the
+// container (see envArgs), and `command` and `extraArgs` are expanded as templates.
+func (a *ActionDockerRun) runArgs(events []Event) ([]string, error) {
+	args := []string{"run", "--init"}
+	if a.Name != "" {
+		args = append(args, "--name", a.Name)
+	} else {
+		args = append(args, "--rm")
+	}
+	args = append(args, "-t", "-a", "stdout", "-a", "stderr")
+	if a.Entrypoint != nil {
+		args = append(args, "--entrypoint", *a.Entrypoint)
 	}
+	args = append(args, a.envArgs(events)...)
 	for _, v := range a.Volumes {
-		volumeType := "bind"
-		if v.Type != "" {
-			volumeType = v.Type
-		}
-		if volumeType == "bind" {
-			v.Source, _ = filepath.Abs(v.Source)
-		}
-		args = append(args, "--mount", fmt.Sprintf("type=%s,source=%s,target=%s", volumeType, v.Source, v.Target))
+		args = append(args, "--mount", v.mount())
 	}
 	if a.WorkDir != nil {
 		args = append(args, "--workdir", *a.WorkDir)
 	}
-	args = append(args, a.ExtraArgs...)
+	extraArgs, err := expandTemplates(a.ExtraArgs, events)
+	if err != nil {
+		return nil, fmt.Errorf("extraArgs: %v", err)
+	}
+	args = append(args, extraArgs...)
 	args = append(args, a.Image)
 	if a.Command != nil {
-		args = append(args, *a.Command...)
+		command, err := expandTemplates(*a.Command, events)
+		if err != nil {
+			return nil, fmt.Errorf("command: %v", err)
+		}
+		args = append(args, command...)
+	}
+	return args, nil
+}
+
+// envArgs returns the `-e` arguments for the environment of the container: the
+// top-level `env`, the action's `env`, and the last triggering event as
+// $WATCHFS_PATH, $WATCHFS_OP and $WATCHFS_TIME
+func (a *ActionDockerRun) envArgs(events []Event) (args []string) {
+	for _, env := range []map[string]string{config.environment(), a.Env} {
+		keys := make([]string, 0, len(env))
+		for k := range env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			args = append(args, "-e", fmt.Sprintf("%s=%s", k, env[k]))
+		}
+	}
+	env := eventEnv(events)
+	for _, k := range []string{envWatchfsPath, envWatchfsOp, envWatchfsTime} {
+		if v, ok := env[k]; ok {
+			args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	return args
+}
+
+// containerExists returns whether a container with the given name exists
+func containerExists(ctx context.Context, name string) bool {
+	return exec.CommandContext(ctx, "docker", "container", "inspect", name).Run() == nil
+}
+
+// Run runs the action
+func (a *ActionDockerRun) Run(ctx context.Context, events []Event) error {
+	exists := a.Name != "" && containerExists(ctx, a.Name)
+	commands, err := a.commands(exists, events)
+	if err != nil {
+		return err
+	}
+	output, closeOutput, err := a.output.tee(a.StdoutFile, a.StderrFile, a.AppendOutput)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+	for _, args := range commands {
+		command := exec.CommandContext(ctx, "docker", args...)
+		command.Stdout = output.Stdout()
+		command.Stderr = output.Stderr()
+		if err := a.process.run(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	composeUp      = "up"
+	composeRestart = "restart"
+	composeBuild   = "build"
+)
+
+var composeCommands = []string{composeUp, composeRestart, composeBuild}
+
+// ActionComposeRun runs a `docker compose` command for a compose file and (optionally) a service
+type ActionComposeRun struct {
+	File          string            `json:"file,omitempty" yaml:"file,omitempty"`
+	Service       string            `json:"service,omitempty" yaml:"service,omitempty"`
+	Command       string            `json:"command,omitempty" yaml:"command,omitempty"`
+	ExtraArgs     []string          `json:"extraArgs,omitempty" yaml:"extraArgs,omitempty"`
+	IgnoreSignals bool              `json:"ignoreSignals,omitempty" yaml:"ignoreSignals,omitempty"`
+	Signal        string            `json:"signal,omitempty" yaml:"signal,omitempty"`
+	Signals       []signalStep      `json:"signals,omitempty" yaml:"signals,omitempty"`
+	SignalMap     map[string]string `json:"signalMap,omitempty" yaml:"signalMap,omitempty"`
+	StdoutFile    string            `json:"stdoutFile,omitempty" yaml:"stdoutFile,omitempty"`
+	StderrFile    string            `json:"stderrFile,omitempty" yaml:"stderrFile,omitempty"`
+	AppendOutput  bool              `json:"appendOutput,omitempty" yaml:"appendOutput,omitempty"`
+
+	signal    *os.Signal
+	signalMap signalMap
+	process   runningProcess
+	output    actionOutput
+}
+
+func (a *ActionComposeRun) makeCanonical() error {
+	if a.Command == "" {
+		a.Command = composeUp
+	}
+	var commandErr error
+	switch a.Command {
+	case composeUp, composeRestart, composeBuild:
+	default:
+		commandErr = fmt.Errorf("invalid compose command %q (choices: %v)", a.Command, composeCommands)
+	}
+	signal, signalErr := parseSignalOption(a.Signal)
+	a.signal = signal
+	stepsErr := makeSignalStepsCanonical(a.Signals)
+	signalMap, signalMapErr := parseSignalMap(a.SignalMap)
+	a.signalMap = signalMap
+	return firstError(commandErr, signalErr, stepsErr, signalMapErr)
+}
+
+// Notify notifies the action about a filesystem event
+func (a *ActionComposeRun) Notify(e Event) (bool, error) {
+	process, done := a.process.get()
+	if process == nil {
+		return false, nil
+	}
+	if a.IgnoreSignals {
+		return true, nil
+	}
+	err := signalProcess(process, done, eventSignalSteps(e, a.signalMap, a.Signals, a.signal))
+	return err == nil, err
+}
+
+// args returns the arguments for the `docker` command
+func (a *ActionComposeRun) args() []string {
+	args := []string{"compose"}
+	if a.File != "" {
+		args = append(args, "-f", a.File)
+	}
+	args = append(args, a.Command)
+	args = append(args, a.ExtraArgs...)
+	if a.Service != "" {
+		args = append(args, a.Service)
+	}
+	return args
+}
+
+// Run runs the action
+func (a *ActionComposeRun) Run(ctx context.Context, events []Event) error {
+	command := exec.CommandContext(ctx, "docker", a.args()...)
+	output, closeOutput, err := a.output.tee(a.StdoutFile, a.StderrFile, a.AppendOutput)
+	if err != nil {
+		return err
 	}
-	a.command = exec.CommandContext(ctx, "docker", args...)
-	a.command.Stdout = os.Stdout
-	a.command.Stderr = os.Stderr
-	return a.command.Run()
+	defer closeOutput()
+	command.Stdout = output.Stdout()
+	command.Stderr = output.Stderr()
+	command.Env = commandEnv(config.envFile, config.Env)
+	return a.process.run(command)
 }
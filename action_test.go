@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestCancelInFlight(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- cancelInFlight: true
+  exec: {command: [sleep, "10"]}
+`)
+	w.waitFor("the initial run to start", func() bool { return len(w.stdout.recordsWith(t, "actionStarted")) == 1 })
+	start := time.Now()
+	w.write("a.txt", "a")
+	w.waitFor("the initial run to be cancelled", func() bool { return len(w.completed()) == 1 })
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the run was cancelled after %v", elapsed)
+	}
+	if result := w.completed()[0]; result["error"] == nil || result["exitCode"] == 0.0 {
+		t.Errorf("actionCompleted = %v; want the cancelled run to fail", result)
+	}
+	w.waitFor("the run for the new event to start", func() bool { return len(w.stdout.recordsWith(t, "actionStarted")) == 2 })
+	if started := w.stdout.recordsWith(t, "actionStarted")[1]["actionStarted"].(map[string]interface{}); started["path"] != w.path("a.txt") {
+		t.Errorf("actionStarted = %v; want a run for %s", started, w.path("a.txt"))
+	}
+}
+
+func TestActionCompletedRecords(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- name: succeeds
+  exec: {command: ["true"]}
+- name: exits3
+  shell: {command: exit 3}
+`)
+	w.waitFor("the initial runs", func() bool { return len(w.completed()) == 2 })
+	w.write("a.txt", "a")
+	w.waitFor("the runs for the event", func() bool { return len(w.completed()) >= 4 })
+	want := map[string]struct {
+		typ      string
+		exitCode float64
+	}{
+		"succeeds": {"exec", 0},
+		"exits3":   {"shell", 3},
+	}
+	for i, result := range w.completed() {
+		name, _ := result["name"].(string)
+		expected, ok := want[name]
+		if !ok {
+			t.Fatalf("actionCompleted for an unknown action: %v", result)
+		}
+		if result["type"] != expected.typ || result["exitCode"] != expected.exitCode {
+			t.Errorf("actionCompleted = %v; want a %s action with exit code %v", result, expected.typ, expected.exitCode)
+		}
+		if (expected.exitCode != 0) != (result["error"] != nil) {
+			t.Errorf("actionCompleted = %v; want an error only for a non-zero exit code", result)
+		}
+		if _, err := time.ParseDuration(result["duration"].(string)); err != nil {
+			t.Errorf("actionCompleted has an invalid duration: %v", err)
+		}
+		if path := result["path"]; i >= 2 && path != w.path("a.txt") {
+			t.Errorf("actionCompleted is for %v; want %s", path, w.path("a.txt"))
+		}
+	}
+}
+
+func TestActionWorkDir(t *testing.T) {
+	captureStdout(t)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	pkg := filepath.Join(dir, "pkg")
+	if err := os.Mkdir(pkg, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := os.Getwd()
+	tests := []struct {
+		name    string
+		workDir string
+		want    string // the directory the command runs in
+		wantErr string
+	}{
+		{"unset", "", cwd, ""},
+		{"fixed", pkg, pkg, ""},
+		{"template", "{{.Dir}}", pkg, ""},
+		{"missing", "{{.Dir}}/missing", "", fmt.Sprintf("workdir %q: ", filepath.Join(pkg, "missing"))},
+		{"not a directory", "{{.Path}}", "", fmt.Sprintf("workdir %q: not a directory", filepath.Join(pkg, "a.go"))},
+		{"invalid template", "{{.Dir", "", `workdir "{{.Dir": `},
+	}
+	writeFile(t, pkg, "a.go", "")
+	events := []Event{{Name: filepath.Join(pkg, "a.go")}}
+	for _, tt := range tests {
+		actions := []Action{
+			{ActionExec: &ActionExec{Command: []string{"sh", "-c", "pwd > " + out}, WorkDir: tt.workDir}},
+			{ActionShell: &ActionShell{Command: "pwd > " + out, WorkDir: tt.workDir}},
+		}
+		for _, a := range actions {
+			os.Remove(out)
+			useConfig(t, configuration{Actions: []Action{a}})
+			if err := config.makeCanonical(); err != nil {
+				t.Fatal(err)
+			}
+			a := &config.Actions[0]
+			err := a.Run(context.Background(), events)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("%s (%s): Run() = %v; want an error starting with %q", tt.name, a.Type(), err, tt.wantErr)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s (%s): Run() = %v", tt.name, a.Type(), err)
+				continue
+			}
+			data, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(data)); got != tt.want {
+				t.Errorf("%s (%s): the command ran in %s; want %s", tt.name, a.Type(), got, tt.want)
+			}
+		}
+	}
+}
+
+func TestActionStdin(t *testing.T) {
+	captureStdout(t)
+	out := filepath.Join(t.TempDir(), "out")
+	stdin := func(s string) *string { return &s }
+	events := []Event{{Name: "a.txt"}, {Name: "b.txt"}, {Name: "c/d.txt"}}
+	tests := []struct {
+		name  string
+		stdin *string
+		want  string
+	}{
+		{"unset", nil, ""},
+		{"paths", stdin("{{lines .Paths}}"), "a.txt\nb.txt\nc/d.txt\n"},
+		{"template", stdin("{{.Base}} in {{.Dir}}"), "d.txt in c"},
+		{"literal", stdin("text"), "text"},
+	}
+	for _, tt := range tests {
+		actions := []Action{
+			{ActionExec: &ActionExec{Command: []string{"sh", "-c", "cat > " + out}, Stdin: tt.stdin}},
+			{ActionShell: &ActionShell{Command: "cat > " + out, Stdin: tt.stdin}},
+		}
+		for _, a := range actions {
+			os.Remove(out)
+			useConfig(t, configuration{Actions: []Action{a}})
+			if err := config.makeCanonical(); err != nil {
+				t.Fatal(err)
+			}
+			a := &config.Actions[0]
+			if err := a.Run(context.Background(), events); err != nil {
+				t.Errorf("%s (%s): Run() = %v", tt.name, a.Type(), err)
+				continue
+			}
+			data, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("%s (%s): the command read %q; want %q", tt.name, a.Type(), data, tt.want)
+			}
+		}
+	}
+}
+
+func TestChmodSuppression(t *testing.T) {
+	chmod := Filter{Ops: []string{"chmod"}}
+	tests := []struct {
+		name string
+		c    configuration
+		// whether a chmod event, and a write|chmod event, trigger the action
+		wantChmod, wantCombined bool
+	}{
+		{"default", configuration{}, false, true},
+		{"includeChmod", configuration{IncludeChmod: true}, true, true},
+		{"top-level op filter", configuration{Filter: chmod}, true, true},
+		{"action op filter", configuration{}, true, true},
+		{"write op filter", configuration{Filter: Filter{Ops: []string{"write"}}}, false, true},
+	}
+	for _, tt := range tests {
+		c := tt.c
+		c.Actions = []Action{{ActionExec: &ActionExec{Command: []string{"true"}}}}
+		if tt.name == "action op filter" {
+			c.Actions[0].Filter = chmod
+		}
+		useConfig(t, c)
+		if err := config.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		captureStdout(t)
+		for _, e := range []struct {
+			op   fsnotify.Op
+			want bool
+		}{{fsnotify.Chmod, tt.wantChmod}, {fsnotify.Write | fsnotify.Chmod, tt.wantCombined}} {
+			event := Event{Name: "a.txt", Op: e.op}
+			got := shouldNotify(&event) && config.Actions[0].Match(event)
+			if got != e.want {
+				t.Errorf("%s: a %v event triggers the action: %v; want %v", tt.name, e.op, got, e.want)
+			}
+		}
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+actions:
+- shell: {command: date +%%s%%N >> %s}
+  cooldown: 300ms
+`, out))
+	// trigger the action continuously, well within the cooldown
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(20 * time.Millisecond) {
+		w.write("a.txt", time.Now().String())
+	}
+	w.waitFor("the pending run after the cooldown", func() bool {
+		data, _ := ioutil.ReadFile(out)
+		return strings.Count(string(data), "\n") >= 3
+	})
+	time.Sleep(500 * time.Millisecond)
+	w.stop()
+	data, _ := ioutil.ReadFile(out)
+	var starts []time.Time
+	for _, line := range strings.Fields(string(data)) {
+		var ns int64
+		fmt.Sscan(line, &ns)
+		starts = append(starts, time.Unix(0, ns))
+	}
+	if len(starts) > 6 {
+		t.Errorf("%d runs in about 1s; want at most one per cooldown", len(starts))
+	}
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 300*time.Millisecond {
+			t.Errorf("run %d started %v after the previous one; want at least the cooldown", i, gap)
+		}
+	}
+}
+
+func TestComposeRunArgs(t *testing.T) {
+	tests := []struct {
+		action *ActionComposeRun
+		want   []string
+	}{
+		{&ActionComposeRun{}, []string{"compose", "up"}},
+		{&ActionComposeRun{Command: "up", File: "dev.yml"}, []string{"compose", "-f", "dev.yml", "up"}},
+		{&ActionComposeRun{Command: "restart", Service: "web"}, []string{"compose", "restart", "web"}},
+		{&ActionComposeRun{Command: "build", File: "dev.yml", Service: "web", ExtraArgs: []string{"--pull"}}, []string{"compose", "-f", "dev.yml", "build", "--pull", "web"}},
+	}
+	for _, tt := range tests {
+		if err := tt.action.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tt.action.args(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("args() = %q; want %q", got, tt.want)
+		}
+	}
+	invalid := ActionComposeRun{Command: "down"}
+	if err := invalid.makeCanonical(); err == nil || !strings.Contains(err.Error(), `invalid compose command "down"`) {
+		t.Errorf("makeCanonical() = %v; want an error for an unsupported command", err)
+	}
+}
+
+func TestDockerRunCommands(t *testing.T) {
+	useConfig(t, configuration{})
+	command := []string{"make"}
+	exec := []string{"make", "test"}
+	run := []string{"run", "--init", "--rm", "-t", "-a", "stdout", "-a", "stderr", "golang"}
+	runNamed := []string{"run", "--init", "--name", "dev", "-t", "-a", "stdout", "-a", "stderr", "golang", "make"}
+	tests := []struct {
+		name   string
+		action *ActionDockerRun
+		exists bool
+		want   [][]string
+	}{
+		{"unnamed", &ActionDockerRun{Image: "golang"}, false, [][]string{run}},
+		{"named, new", &ActionDockerRun{Image: "golang", Name: "dev", Command: &command}, false, [][]string{runNamed}},
+		{"named, existing", &ActionDockerRun{Image: "golang", Name: "dev", Command: &command}, true, [][]string{{"stop", "dev"}, {"start", "-a", "dev"}}},
+		{"exec, new", &ActionDockerRun{Image: "golang", Name: "dev", Command: &command, Exec: &exec}, false, [][]string{runNamed}},
+		{"exec, existing", &ActionDockerRun{Image: "golang", Name: "dev", Exec: &exec}, true, [][]string{{"exec", "dev", "make", "test"}}},
+	}
+	for _, tt := range tests {
+		if err := tt.action.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := tt.action.commands(tt.exists, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: commands() = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+	unnamed := &ActionDockerRun{Image: "golang", Exec: &exec}
+	if err := unnamed.makeCanonical(); err == nil {
+		t.Error("accepted exec without a container name")
+	}
+}
+
+func TestDockerRunEventArgs(t *testing.T) {
+	useConfig(t, configuration{})
+	command := []string{"go", "vet", "{{.Dir}}"}
+	a := &ActionDockerRun{Image: "golang", Command: &command, ExtraArgs: []string{"--label", "changed={{.Base}}"}}
+	if err := a.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	events := []Event{
+		{Name: "pkg/a/a.go", Op: fsnotify.Create, Time: "t1"},
+		{Name: "pkg/b/b.go", Op: fsnotify.Write, Time: "t2"},
+	}
+	got, err := a.runArgs(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"run", "--init", "--rm", "-t", "-a", "stdout", "-a", "stderr",
+		"-e", "WATCHFS_PATH=pkg/b/b.go", "-e", "WATCHFS_OP=write", "-e", "WATCHFS_TIME=t2",
+		"--label", "changed=b.go",
+		"golang", "go", "vet", "pkg/b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("runArgs() = %q; want %q", got, want)
+	}
+	invalid := []string{"{{.Missing}}"}
+	a.Command = &invalid
+	if _, err := a.runArgs(events); err == nil || !strings.HasPrefix(err.Error(), "command: ") {
+		t.Errorf("runArgs() = %v; want an error for the invalid template", err)
+	}
+}
+
+func TestDockerRunExistingContainerEnv(t *testing.T) {
+	useConfig(t, configuration{Env: map[string]string{"GOFLAGS": "-mod=vendor"}})
+	exec := []string{"go", "test", "./{{.Dir}}"}
+	a := &ActionDockerRun{Image: "golang", Name: "dev", Exec: &exec, Env: map[string]string{"CGO_ENABLED": "0", "B": "b"}}
+	if err := a.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	events := []Event{{Name: "pkg/a.go", Op: fsnotify.Write, Time: "t1"}}
+	env := []string{
+		"-e", "GOFLAGS=-mod=vendor",
+		"-e", "B=b", "-e", "CGO_ENABLED=0",
+		"-e", "WATCHFS_PATH=pkg/a.go", "-e", "WATCHFS_OP=write", "-e", "WATCHFS_TIME=t1",
+	}
+	// the command run in the existing container gets the environment of a new one
+	got, err := a.commands(true, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{append(append(append([]string{"exec"}, env...), "dev"), "go", "test", "./pkg")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commands() = %q; want %q", got, want)
+	}
+	run, err := a.runArgs(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]string{"run", "--init", "--name", "dev", "-t", "-a", "stdout", "-a", "stderr"}, append(env, "golang")...); !reflect.DeepEqual(run, want) {
+		t.Errorf("runArgs() = %q; want %q", run, want)
+	}
+}
+
+func TestDockerVolumeMount(t *testing.T) {
+	source, err := filepath.Abs("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		volume dockerVolume
+		want   string
+	}{
+		{dockerVolume{Source: "src", Target: "/src"}, "type=bind,source=" + source + ",target=/src"},
+		{dockerVolume{Source: "src", Target: "/src", ReadOnly: true}, "type=bind,source=" + source + ",target=/src,readonly"},
+		{dockerVolume{Source: "src", Target: "/src", Consistency: "cached"}, "type=bind,source=" + source + ",target=/src,consistency=cached"},
+		{dockerVolume{Source: "src", Target: "/src", ReadOnly: true, Consistency: "delegated"}, "type=bind,source=" + source + ",target=/src,readonly,consistency=delegated"},
+		{dockerVolume{Source: "cache", Target: "/cache", Type: "volume", ReadOnly: true}, "type=volume,source=cache,target=/cache,readonly"},
+	}
+	for _, tt := range tests {
+		if err := tt.volume.validate(); err != nil {
+			t.Errorf("validate(%+v) = %v", tt.volume, err)
+		}
+		if got := tt.volume.mount(); got != tt.want {
+			t.Errorf("mount() = %q; want %q", got, tt.want)
+		}
+	}
+	a := &ActionDockerRun{Image: "golang", Volumes: []dockerVolume{{}, {Consistency: "fast"}}}
+	if err := a.makeCanonical(); err == nil || !strings.Contains(err.Error(), `volume 1: invalid consistency "fast"`) {
+		t.Errorf("makeCanonical() = %v; want an error for the invalid consistency", err)
+	}
+}
+
+func TestActionStartedRecords(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- name: build
+  exec: {command: [sh, -c, sleep 0.05]}
+- name: lint
+  shell: {command: "true"}
+`)
+	w.write("a.txt", "a")
+	w.waitFor("the runs", func() bool { return len(w.completed()) >= 4 })
+	w.stop()
+	running := map[string]bool{}
+	starts := 0
+	for _, record := range w.stdout.records(t) {
+		if start, ok := record["actionStarted"].(map[string]interface{}); ok {
+			name := start["name"].(string)
+			if running[name] {
+				t.Errorf("%s started again before its run completed", name)
+			}
+			running[name] = true
+			starts++
+			if start["command"] == nil || start["waited"] == nil {
+				t.Errorf("actionStarted = %v; want the command and the time waited", start)
+			}
+		}
+		if result, ok := record["actionCompleted"].(map[string]interface{}); ok {
+			name := result["name"].(string)
+			if !running[name] {
+				t.Errorf("%s completed without a start record", name)
+			}
+			running[name] = false
+		}
+	}
+	if starts != len(w.completed()) {
+		t.Errorf("got %d start records for %d completed runs", starts, len(w.completed()))
+	}
+
+	saved := quiet
+	quiet = true
+	defer func() { quiet = saved }()
+	stdout := captureStdout(t)
+	onActionStarted(&Action{Name: "quiet", ActionShell: &ActionShell{Command: "true"}}, nil, 0)
+	if records := stdout.recordsWith(t, "actionStarted"); len(records) != 0 {
+		t.Errorf("got %v with -quiet", records)
+	}
+}
+
+func TestEventEnv(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	script := `echo "$WATCHFS_PATH $WATCHFS_OP $WATCHFS_TIME $FROM_CONFIG $FROM_ACTION" >> ` + out
+	useConfig(t, configuration{
+		Env: map[string]string{"FROM_CONFIG": "config"},
+		Actions: []Action{
+			{ActionExec: &ActionExec{Command: []string{"sh", "-c", script}, Env: map[string]string{"FROM_ACTION": "exec"}}},
+			{ActionShell: &ActionShell{Command: script, Env: map[string]string{"FROM_ACTION": "shell"}}},
+		},
+	})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	events := []Event{
+		{Name: "a.txt", Op: fsnotify.Create, Time: "t1"},
+		{Name: "b.txt", Op: fsnotify.Write, Time: "t2"},
+	}
+	for i := range config.Actions {
+		if err := config.Actions[i].Run(context.Background(), events); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a run without events (like the one at startup) has none of the variables
+	if err := config.Actions[1].Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "b.txt write t2 config exec\nb.txt write t2 config shell\n   config shell\n"; string(data) != want {
+		t.Errorf("the runs saw %q; want %q", data, want)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+const (
+	// crashLoopThreshold is the run duration below which a failed run counts as a crash
+	crashLoopThreshold = time.Second
+	// crashLoopMinBackoff is the backoff after the second consecutive crash; it doubles with each further crash
+	crashLoopMinBackoff = 250 * time.Millisecond
+	crashLoopMaxBackoff = 30 * time.Second
+)
+
+// crashBackoff tracks consecutive runs of an action that failed right after starting
+type crashBackoff struct {
+	crashes int
+}
+
+// next records the result of a run and returns how long to wait before the next one.
+// Runs that succeed or that last at least `crashLoopThreshold` reset the backoff.
+func (b *crashBackoff) next(duration time.Duration, err error) time.Duration {
+	if err == nil || duration >= crashLoopThreshold {
+		b.crashes = 0
+		return 0
+	}
+	b.crashes++
+	if b.crashes < 2 {
+		return 0
+	}
+	backoff := crashLoopMinBackoff
+	for i := 2; i < b.crashes && backoff < crashLoopMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > crashLoopMaxBackoff {
+		backoff = crashLoopMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCrashBackoff(t *testing.T) {
+	crash := errors.New("exit status 1")
+	var b crashBackoff
+	var got []time.Duration
+	for i := 0; i < 10; i++ {
+		got = append(got, b.next(10*time.Millisecond, crash))
+	}
+	want := []time.Duration{0, 250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("backoffs %v; want %v", got, want)
+	}
+	// a long-lived run resets the backoff, even if it fails
+	if d := b.next(crashLoopThreshold, crash); d != 0 || b.crashes != 0 {
+		t.Errorf("next() = %v after a long run, with %d crashes; want the backoff reset", d, b.crashes)
+	}
+	b.next(0, crash)
+	b.next(0, crash)
+	if d := b.next(0, nil); d != 0 || b.crashes != 0 {
+		t.Errorf("next() = %v after a successful run, with %d crashes; want the backoff reset", d, b.crashes)
+	}
+}
+
+func TestCrashLoopingActionBacksOff(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- exec: {command: ["false"]}
+`)
+	w.waitFor("the startup run", func() bool { return len(w.completed()) >= 1 })
+	var runs []time.Time
+	for i := 0; i < 3; i++ {
+		w.write(fmt.Sprintf("%d.txt", i), "a")
+		w.waitFor("the run for the event", func() bool { return len(w.completed()) >= i+2 })
+		runs = append(runs, time.Now())
+	}
+	var got []interface{}
+	for _, info := range w.infos("backoff") {
+		got = append(got, info["backoff"])
+	}
+	if want := []interface{}{"250ms", "500ms", "1s"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("backed off %v; want %v", got, want)
+	}
+	// each run waits for the backoff after the previous one (less the polling interval of waitFor)
+	for i, want := range []time.Duration{250 * time.Millisecond, 500 * time.Millisecond} {
+		if gap := runs[i+1].Sub(runs[i]); gap < want-10*time.Millisecond {
+			t.Errorf("run %d followed the previous one after %v; want at least %v", i+2, gap, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// batchSeq is the ID of the last batch
+var batchSeq uint64
+
+// batch is the set of action runs triggered by one dispatch of events (see `batchWindow`).
+// It finishes once the runs covering all events dispatched to actions have completed.
+type batch struct {
+	id    uint64
+	start time.Time
+
+	mu          sync.Mutex
+	outstanding int // dispatched events whose runs have not completed
+	runs        int
+	failed      int
+	cancelled   int
+	finished    bool
+}
+
+// batchStart describes a started batch
+type batchStart struct {
+	Batch   uint64 `json:"batch"`
+	Events  int    `json:"events"`
+	Actions int    `json:"actions"`
+}
+
+// batchResult describes a finished batch
+type batchResult struct {
+	Batch     uint64 `json:"batch"`
+	Runs      int    `json:"runs"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Cancelled int    `json:"cancelled,omitempty"`
+	Duration  string `json:"duration"`
+	// Interrupted is set if the watch generation ended (on exit or reload) before runs
+	// covering all of the batch's events completed
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// startBatch tags the events dispatched to each action with a new batch, and reports it
+func startBatch(events []Event, matched [][]Event) *batch {
+	b := &batch{
+		id:    atomic.AddUint64(&batchSeq, 1),
+		start: time.Now(),
+	}
+	actions := 0
+	for i := range matched {
+		for j := range matched[i] {
+			matched[i][j].batch = b
+		}
+		if len(matched[i]) > 0 {
+			actions++
+		}
+		b.outstanding += len(matched[i])
+	}
+	start := batchStart{Batch: b.id, Events: len(events), Actions: actions}
+	stdoutRecord(!quiet, func(n recordNumber) interface{} {
+		return struct {
+			BatchStarted batchStart `json:"batchStarted"`
+			recordNumber
+		}{start, n}
+	})
+	return b
+}
+
+// runBatches returns the IDs of the batches of the events, in order
+func runBatches(events []Event) (ids []uint64) {
+	seen := make(map[*batch]bool)
+	for _, e := range events {
+		if e.batch != nil && !seen[e.batch] {
+			seen[e.batch] = true
+			ids = append(ids, e.batch.id)
+		}
+	}
+	return ids
+}
+
+// completeBatches records that a run covering the events has completed
+func completeBatches(events []Event, err error, cancelled bool) {
+	for _, c := range batchCounts(events) {
+		c.batch.complete(c.events, true, err, cancelled)
+	}
+}
+
+// releaseBatches records that the events will not trigger a run (see `debounceStrategy`)
+func releaseBatches(events []Event) {
+	for _, c := range batchCounts(events) {
+		c.batch.complete(c.events, false, nil, false)
+	}
+}
+
+type batchCount struct {
+	batch  *batch
+	events int
+}
+
+// batchCounts returns the batches of the events with their number of events, in order
+func batchCounts(events []Event) (counts []batchCount) {
+	index := make(map[*batch]int)
+	for _, e := range events {
+		if e.batch == nil {
+			continue
+		}
+		i, ok := index[e.batch]
+		if !ok {
+			i = len(counts)
+			index[e.batch] = i
+			counts = append(counts, batchCount{batch: e.batch})
+		}
+		counts[i].events++
+	}
+	return counts
+}
+
+// complete records that n of the batch's events are covered, by a run that has completed
+// (if ran is set) or by none, and reports the batch once all of its events are covered
+func (b *batch) complete(n int, ran bool, err error, cancelled bool) {
+	b.mu.Lock()
+	if ran {
+		b.runs++
+		switch {
+		case cancelled:
+			b.cancelled++
+		case err != nil:
+			b.failed++
+		}
+	}
+	b.outstanding -= n
+	if b.outstanding > 0 || b.finished {
+		b.mu.Unlock()
+		return
+	}
+	b.finish(false)
+}
+
+// interrupt reports the batch if it has not finished yet, e.g. because the watch generation
+// ended while runs were pending or in flight
+func (b *batch) interrupt() {
+	b.mu.Lock()
+	if b.finished {
+		b.mu.Unlock()
+		return
+	}
+	b.finish(true)
+}
+
+// finish reports the batch's result; it is called with b.mu held, and unlocks it
+func (b *batch) finish(interrupted bool) {
+	b.finished = true
+	result := batchResult{
+		Batch:       b.id,
+		Runs:        b.runs,
+		Succeeded:   b.runs - b.failed - b.cancelled,
+		Failed:      b.failed,
+		Cancelled:   b.cancelled,
+		Duration:    time.Since(b.start).String(),
+		Interrupted: interrupted,
+	}
+	b.mu.Unlock()
+	stdoutRecord(result.Failed > 0 || interrupted || !quiet, func(n recordNumber) interface{} {
+		return struct {
+			BatchFinished batchResult `json:"batchFinished"`
+			recordNumber
+		}{result, n}
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	errFailed := errors.New("exit status 1")
+	type step struct {
+		action    int // the index of the action whose events are covered
+		ran       bool
+		err       error
+		cancelled bool
+	}
+	tests := []struct {
+		name    string
+		matched []int // the number of events dispatched to each action
+		steps   []step
+		want    *batchResult // nil: not finished
+	}{
+		{"all succeed", []int{2, 1}, []step{{0, true, nil, false}, {1, true, nil, false}},
+			&batchResult{Runs: 2, Succeeded: 2}},
+		{"one fails", []int{2, 1}, []step{{1, true, errFailed, false}, {0, true, nil, false}},
+			&batchResult{Runs: 2, Succeeded: 1, Failed: 1}},
+		{"cancelled", []int{1}, []step{{0, true, errFailed, true}},
+			&batchResult{Runs: 1, Cancelled: 1}},
+		{"dropped events trigger no run", []int{1, 1}, []step{{0, false, nil, false}, {1, true, nil, false}},
+			&batchResult{Runs: 1, Succeeded: 1}},
+		{"pending runs", []int{1, 1}, []step{{0, true, nil, false}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := captureStdout(t)
+			var events []Event
+			matched := make([][]Event, len(tt.matched))
+			for i, n := range tt.matched {
+				for j := 0; j < n; j++ {
+					e := Event{Name: "file"}
+					events = append(events, e)
+					matched[i] = append(matched[i], e)
+				}
+			}
+			b := startBatch(events, matched)
+			for _, step := range tt.steps {
+				if step.ran {
+					completeBatches(matched[step.action], step.err, step.cancelled)
+				} else {
+					releaseBatches(matched[step.action])
+				}
+			}
+			records := stdout.records(t)
+			if len(records) == 0 || records[0]["batchStarted"] == nil {
+				t.Fatalf("got records %v; want a batchStarted record first", records)
+			}
+			started := records[0]["batchStarted"].(map[string]interface{})
+			if started["batch"] != float64(b.id) || started["events"] != float64(len(events)) || started["actions"] != float64(len(tt.matched)) {
+				t.Errorf("batchStarted = %v", started)
+			}
+			finished := stdout.recordsWith(t, "batchFinished")
+			if tt.want == nil {
+				if len(finished) > 0 {
+					t.Fatalf("finished with runs pending: %v", finished)
+				}
+				return
+			}
+			if len(finished) != 1 {
+				t.Fatalf("got %d batchFinished records; want 1", len(finished))
+			}
+			got := finished[0]["batchFinished"].(map[string]interface{})
+			want := map[string]interface{}{
+				"batch":     float64(b.id),
+				"runs":      float64(tt.want.Runs),
+				"succeeded": float64(tt.want.Succeeded),
+				"failed":    float64(tt.want.Failed),
+				"duration":  got["duration"],
+			}
+			if tt.want.Cancelled > 0 {
+				want["cancelled"] = float64(tt.want.Cancelled)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("batchFinished = %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBatchInterrupt(t *testing.T) {
+	stdout := captureStdout(t)
+	events := []Event{{Name: "a"}, {Name: "b"}}
+	matched := [][]Event{events[:1], events[1:]}
+	b := startBatch(events, matched)
+	completeBatches(matched[0], nil, false)
+	b.interrupt()
+	b.interrupt()
+	completeBatches(matched[1], nil, false) // after the generation has ended
+	finished := stdout.recordsWith(t, "batchFinished")
+	if len(finished) != 1 {
+		t.Fatalf("got %d batchFinished records; want 1", len(finished))
+	}
+	if got := finished[0]["batchFinished"].(map[string]interface{}); got["interrupted"] != true || got["runs"] != 1.0 {
+		t.Errorf("batchFinished = %v; want 1 run, interrupted", got)
+	}
+}
+
+func TestBatchFramesActionRuns(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+batchWindow: 50ms
+actions:
+- name: one
+  exec: {command: ["true"]}
+- name: two
+  exec: {command: ["false"]}
+`)
+	w.waitFor("the initial runs", func() bool { return len(w.completed()) == 2 })
+	w.write("a.txt", "a")
+	w.waitFor("the batch to finish", func() bool { return len(w.stdout.recordsWith(t, "batchFinished")) > 0 })
+
+	var kinds []string
+	for _, record := range w.stdout.records(t) {
+		for _, kind := range []string{"batchStarted", "actionStarted", "actionCompleted", "batchFinished"} {
+			if record[kind] != nil {
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+	// after the initial runs, the runs of both actions are framed by one batch
+	kinds = kinds[4:]
+	if len(kinds) != 6 || kinds[0] != "batchStarted" || kinds[5] != "batchFinished" {
+		t.Fatalf("got records %v; want the starts and completions of both runs between batchStarted and batchFinished", kinds)
+	}
+	finished := w.stdout.recordsWith(t, "batchFinished")[0]["batchFinished"].(map[string]interface{})
+	if finished["runs"] != 2.0 || finished["succeeded"] != 1.0 || finished["failed"] != 1.0 {
+		t.Errorf("batchFinished = %v; want 2 runs, one failed", finished)
+	}
+	for _, result := range w.completed()[2:] {
+		if batches, _ := result["batches"].([]interface{}); len(batches) != 1 || batches[0] != finished["batch"] {
+			t.Errorf("actionCompleted %v does not belong to batch %v", result, finished["batch"])
+		}
+	}
+}
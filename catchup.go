@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultCatchupPath = ".watchfs-snapshot.json"
+
+// snapshotTree returns the states of all files and directories below the given paths,
+// skipping excluded paths in the same way as watchRecursive.
+func snapshotTree(paths []string) map[string]fileState {
+	snapshot := make(map[string]fileState)
+	catchupPathAbs, _ := filepath.Abs(catchupPath)
+	for _, root := range paths {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if shouldExclude(path, info) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if absPath, err := filepath.Abs(path); err == nil && absPath == catchupPathAbs {
+				return nil
+			}
+			if path != root || !info.IsDir() {
+				snapshot[path] = newFileState(info)
+			}
+			return nil
+		})
+	}
+	return snapshot
+}
+
+func loadSnapshot(path string) (map[string]fileState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot map[string]fileState
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func saveSnapshot(path string, snapshot map[string]fileState) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// catchUp synthesizes events for changes made since the snapshot at catchupPath was saved.
+func catchUp(d *dispatcher, paths []string) {
+	old, err := loadSnapshot(catchupPath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		onError(err)
+		return
+	}
+	for _, e := range diffSnapshots(old, snapshotTree(paths)) {
+		onEvent(d, Event{
+			Name: e.Name,
+			Op:   e.Op,
+			Time: time.Now().Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	useConfig(t, configuration{})
+	dir := t.TempDir()
+	saved := catchupPath
+	catchupPath = filepath.Join(dir, "snapshot.json")
+	defer func() { catchupPath = saved }()
+	a := writeFile(t, dir, "a.txt", "a")
+	b := writeFile(t, dir, "sub/b.txt", "b")
+	if err := saveSnapshot(catchupPath, snapshotTree([]string{dir})); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := loadSnapshot(catchupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for path := range snapshot {
+		got = append(got, path)
+	}
+	sort.Strings(got)
+	// the root and the snapshot itself are not part of the snapshot
+	if want := []string{a, filepath.Join(dir, "sub"), b}; !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot has %v; want %v", got, want)
+	}
+	if diff := diffSnapshots(snapshot, snapshotTree([]string{dir})); len(diff) != 0 {
+		t.Errorf("the loaded snapshot differs from the tree: %v", diff)
+	}
+	if _, err := loadSnapshot(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("loadSnapshot() = %v for a missing file", err)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	savedCatchup, savedPath := catchup, catchupPath
+	catchup, catchupPath = true, filepath.Join(t.TempDir(), "snapshot.json")
+	t.Cleanup(func() { catchup, catchupPath = savedCatchup, savedPath })
+	dir := t.TempDir()
+	changed := writeFile(t, dir, "changed.txt", "a")
+	removed := writeFile(t, dir, "removed.txt", "b")
+	writeFile(t, dir, "unchanged.txt", "c")
+	yaml := `
+paths: [$DIR]
+`
+	w := startWatchfsIn(t, dir, yaml)
+	if events := w.events(); len(events) != 0 {
+		t.Errorf("reported %v without a snapshot", events)
+	}
+	w.stop() // saves the snapshot
+
+	writeFile(t, dir, "changed.txt", "changed while down")
+	os.Remove(removed)
+	created := writeFile(t, dir, "created.txt", "d")
+	w = startWatchfsIn(t, dir, yaml)
+	want := map[string]string{changed: "write", removed: "remove", created: "create"}
+	w.waitFor("the catch-up events", func() bool { return len(w.events()) >= len(want) })
+	w.stop()
+	got := map[string]string{}
+	for _, e := range w.events() {
+		got[e["path"].(string)] = e["op"].(string)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "time"
+
+// Clock is the source of the current time and of timers for watchfs's timing logic
+// (debouncing, cooldowns, backoff, rate limits, polling), so that it can be replaced
+// by a fake clock that is advanced explicitly
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a timer started by Clock.AfterFunc
+type Timer interface {
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// Ticker is a ticker started by Clock.NewTicker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// clock is the clock used by watchfs
+var clock Clock = realClock{}
+
+// realClock is the system clock
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when advanced. Timers and tickers that
+// become due are run by advance, in the order of their deadlines.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// useFakeClock replaces the clock used by watchfs until the test has finished
+func useFakeClock(t *testing.T) *fakeClock {
+	c := newFakeClock()
+	saved := clock
+	clock = c
+	t.Cleanup(func() { clock = saved })
+	return c
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	period   time.Duration // for tickers
+	f        func()
+	c        chan time.Time
+	active   bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.start(t, d)
+	return t.c
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{clock: c, f: f}
+	c.start(t, d)
+	return t
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	t := &fakeTimer{clock: c, period: d, c: make(chan time.Time, 1)}
+	c.start(t, d)
+	return fakeTicker{t}
+}
+
+func (c *fakeClock) start(t *fakeTimer, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.deadline = c.now.Add(d)
+	if !t.active {
+		t.active = true
+		c.timers = append(c.timers, t)
+	}
+}
+
+// advance moves the time forward by d, running the timers that become due on the way
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].deadline.Before(c.timers[j].deadline) })
+		if len(c.timers) == 0 || c.timers[0].deadline.After(end) {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.now = t.deadline
+		if t.period > 0 {
+			t.deadline = t.deadline.Add(t.period)
+		} else {
+			t.active = false
+			c.timers = c.timers[1:]
+		}
+		now := c.now
+		c.mu.Unlock()
+		if t.f != nil {
+			t.f()
+			continue
+		}
+		select {
+		case t.c <- now:
+		default:
+		}
+	}
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	active := t.Stop()
+	t.clock.start(t, d)
+	return active
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i := range c.timers {
+		if c.timers[i] == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+type fakeTicker struct {
+	t *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time { return t.t.c }
+func (t fakeTicker) Stop()               { t.t.Stop() }
+
+// fired returns whether the debouncer has fired since the last call
+func fired(d *debouncer) bool {
+	select {
+	case <-d.ready():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestDebouncerWithFakeClock(t *testing.T) {
+	c := useFakeClock(t)
+	d := newDebouncer(100*time.Millisecond, nil)
+	defer d.stop()
+
+	if started, dropped := d.add(Event{Name: "a"}); !started || dropped {
+		t.Fatalf("add() = %v, %v; want a new wait", started, dropped)
+	}
+	c.advance(99 * time.Millisecond)
+	d.add(Event{Name: "b"})
+	c.advance(99 * time.Millisecond)
+	if fired(d) {
+		t.Fatal("fired while events are still arriving")
+	}
+	c.advance(1 * time.Millisecond)
+	if !fired(d) {
+		t.Fatal("did not fire once no events arrived for the delay")
+	}
+	if events := d.take(); len(events) != 2 {
+		t.Fatalf("take() = %v; want both events", events)
+	}
+	c.advance(time.Second)
+	if fired(d) {
+		t.Fatal("fired again without events")
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock()
+	start := c.Now()
+	var calls []time.Duration
+	timer := c.AfterFunc(2*time.Second, func() { calls = append(calls, c.Now().Sub(start)) })
+	c.AfterFunc(time.Second, func() { calls = append(calls, c.Now().Sub(start)) })
+	after := c.After(3 * time.Second)
+	ticker := c.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	c.advance(1500 * time.Millisecond)
+	timer.Reset(time.Second) // now due at 2.5s
+	c.advance(1500 * time.Millisecond)
+	if want := []time.Duration{time.Second, 2500 * time.Millisecond}; len(calls) != 2 || calls[0] != want[0] || calls[1] != want[1] {
+		t.Errorf("timers ran at %v; want %v", calls, want)
+	}
+	select {
+	case now := <-after:
+		if got := now.Sub(start); got != 3*time.Second {
+			t.Errorf("After fired at %v; want 3s", got)
+		}
+	default:
+		t.Error("After did not fire")
+	}
+	select {
+	case <-ticker.C():
+	default:
+		t.Error("ticker did not tick")
+	}
+	if timer.Stop() {
+		t.Error("Stop() = true for a timer that has run")
+	}
+	if got := c.Now().Sub(start); got != 3*time.Second {
+		t.Errorf("Now() is %v after start; want 3s", got)
+	}
+}
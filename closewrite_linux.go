@@ -0,0 +1,216 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+)
+
+// closeWriteMask selects the inotify events reported by closeWriteWatcher.
+// Writes are reported only once the writer closes the file (IN_CLOSE_WRITE), not per write (IN_MODIFY).
+const closeWriteMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_MOVED_TO |
+	unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MOVED_FROM | unix.IN_MOVE_SELF | unix.IN_ATTRIB
+
+// closeWriteMinBackoff and closeWriteMaxBackoff bound the delay before retrying a failed read
+const (
+	closeWriteMinBackoff = 10 * time.Millisecond
+	closeWriteMaxBackoff = time.Second
+)
+
+// closeWriteWatcher is a Watcher backed by inotify that reports a file as written
+// when it is closed after being opened for writing
+type closeWriteWatcher struct {
+	file      *os.File
+	events    chan fsnotify.Event
+	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu    sync.Mutex
+	paths map[int]string
+	wds   map[string]int
+}
+
+func newCloseWriteWatcher() (Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &closeWriteWatcher{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+		paths:  make(map[int]string),
+		wds:    make(map[string]int),
+	}
+	go w.loop()
+	return w, nil
+}
+
+// Add starts watching the given path. For directories, the direct children are watched.
+func (w *closeWriteWatcher) Add(path string) error {
+	path = filepath.Clean(path)
+	wd, err := unix.InotifyAddWatch(int(w.file.Fd()), path, closeWriteMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paths[wd] = path
+	w.wds[path] = wd
+	return nil
+}
+
+// Remove stops watching the given path
+func (w *closeWriteWatcher) Remove(path string) error {
+	path = filepath.Clean(path)
+	w.mu.Lock()
+	wd, ok := w.wds[path]
+	if ok {
+		delete(w.wds, path)
+		delete(w.paths, wd)
+	}
+	w.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if _, err := unix.InotifyRmWatch(int(w.file.Fd()), uint32(wd)); err != nil {
+		return &os.PathError{Op: "inotify_rm_watch", Path: path, Err: err}
+	}
+	return nil
+}
+
+// Close stops watching and closes the event and error channels.
+func (w *closeWriteWatcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.file.Close()
+	})
+	return err
+}
+
+// Events returns the event channel
+func (w *closeWriteWatcher) Events() <-chan fsnotify.Event {
+	return w.events
+}
+
+// Errors returns the error channel
+func (w *closeWriteWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *closeWriteWatcher) loop() {
+	defer close(w.errors)
+	defer close(w.events)
+	var buf [unix.SizeofInotifyEvent * 4096]byte
+	var failures int // consecutive failed reads
+	var lastErr string
+	for {
+		n, err := w.file.Read(buf[:])
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+		if err != nil {
+			// a persistent error is reported once, and the read retried with a growing backoff
+			if failures == 0 || err.Error() != lastErr {
+				if !w.sendError(err) {
+					return
+				}
+			}
+			lastErr = err.Error()
+			backoff := closeWriteMaxBackoff
+			if failures < 7 {
+				backoff = closeWriteMinBackoff << uint(failures)
+			}
+			failures++
+			select {
+			case <-w.done:
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		failures = 0
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(raw.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+			offset = nameEnd
+			if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+				if !w.sendError(fsnotify.ErrEventOverflow) {
+					return
+				}
+				continue
+			}
+			e, ok := w.event(int(raw.Wd), name, raw.Mask)
+			if !ok {
+				continue
+			}
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+func (w *closeWriteWatcher) sendError(err error) bool {
+	select {
+	case w.errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// event translates an inotify event to an fsnotify event
+func (w *closeWriteWatcher) event(wd int, name string, mask uint32) (e fsnotify.Event, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	path, ok := w.paths[wd]
+	if !ok {
+		return e, false
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		delete(w.wds, path)
+		return e, false
+	}
+	if name != "" {
+		path = filepath.Join(path, name)
+	}
+	e.Name = path
+	switch {
+	case mask&unix.IN_CLOSE_WRITE != 0:
+		e.Op = fsnotify.Write
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		e.Op = fsnotify.Create
+	case mask&(unix.IN_DELETE|unix.IN_DELETE_SELF) != 0:
+		e.Op = fsnotify.Remove
+	case mask&(unix.IN_MOVED_FROM|unix.IN_MOVE_SELF) != 0:
+		e.Op = fsnotify.Rename
+	case mask&unix.IN_ATTRIB != 0:
+		e.Op = fsnotify.Chmod
+	default:
+		return e, false
+	}
+	return e, true
+}
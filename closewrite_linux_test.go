@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+)
+
+func TestCloseWriteEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		mask   uint32
+		wantOp fsnotify.Op
+		wantOk bool
+	}{
+		{"a.txt", unix.IN_CLOSE_WRITE, fsnotify.Write, true},
+		{"a.txt", unix.IN_CREATE, fsnotify.Create, true},
+		{"a.txt", unix.IN_MOVED_TO, fsnotify.Create, true},
+		{"a.txt", unix.IN_DELETE, fsnotify.Remove, true},
+		{"", unix.IN_DELETE_SELF, fsnotify.Remove, true},
+		{"a.txt", unix.IN_MOVED_FROM, fsnotify.Rename, true},
+		{"", unix.IN_MOVE_SELF, fsnotify.Rename, true},
+		{"a.txt", unix.IN_ATTRIB, fsnotify.Chmod, true},
+		{"a.txt", unix.IN_CREATE | unix.IN_ISDIR, fsnotify.Create, true},
+		{"a.txt", unix.IN_MODIFY, 0, false},
+		{"a.txt", unix.IN_CLOSE_NOWRITE, 0, false},
+	}
+	w := &closeWriteWatcher{paths: map[int]string{1: "/src"}, wds: map[string]int{"/src": 1}}
+	for _, tt := range tests {
+		e, ok := w.event(1, tt.name, tt.mask)
+		if ok != tt.wantOk || e.Op != tt.wantOp {
+			t.Errorf("event(%q, %#x) = %v, %v; want %v, %v", tt.name, tt.mask, e, ok, tt.wantOp, tt.wantOk)
+			continue
+		}
+		if want := filepath.Join("/src", tt.name); ok && e.Name != want {
+			t.Errorf("event(%q, %#x) is for %q; want %q", tt.name, tt.mask, e.Name, want)
+		}
+	}
+	if _, ok := w.event(2, "a.txt", unix.IN_CLOSE_WRITE); ok {
+		t.Error("reported an event for an unknown watch descriptor")
+	}
+	if _, ok := w.event(1, "", unix.IN_IGNORED); ok || len(w.paths) != 0 || len(w.wds) != 0 {
+		t.Errorf("IN_IGNORED: got an event or kept the watch (%v, %v)", w.paths, w.wds)
+	}
+}
+
+// collectUntil returns the events received until one for the sentinel path
+func collectUntil(t *testing.T, w Watcher, sentinel string) (events []fsnotify.Event) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-w.Events():
+			if e.Name == sentinel {
+				return events
+			}
+			events = append(events, e)
+		case err := <-w.Errors():
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("timed out; got %v", events)
+		}
+	}
+}
+
+func TestCloseWriteWatcher(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newCloseWriteWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "a.txt")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := f.Write([]byte("chunk\n")); err != nil {
+			t.Fatal(err)
+		}
+		f.Sync()
+	}
+	f.Close()
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path + ".old")
+	writeFile(t, dir, "sentinel", "")
+
+	want := []fsnotify.Event{
+		{Name: path, Op: fsnotify.Create},
+		{Name: path, Op: fsnotify.Write}, // once, for all the chunks
+		{Name: path, Op: fsnotify.Rename},
+		{Name: path + ".old", Op: fsnotify.Create},
+		{Name: path + ".old", Op: fsnotify.Remove},
+	}
+	if got := collectUntil(t, w, filepath.Join(dir, "sentinel")); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v; want %v", got, want)
+	}
+
+	if err := w.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Add succeeded for a missing path")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range w.Events() {
+	}
+	if _, ok := <-w.Errors(); ok {
+		t.Error("the error channel is open after Close")
+	}
+}
+
+func TestCloseWriteWatcherReportsPersistentErrorsOnce(t *testing.T) {
+	w, err := newCloseWriteWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// closing the inotify file makes every read fail with the same error
+	w.(*closeWriteWatcher).file.Close()
+	select {
+	case err := <-w.Errors():
+		if err == nil {
+			t.Fatal("got a nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the read error was not reported")
+	}
+	// the reads are retried after 10ms, 20ms, 40ms and 80ms without reporting the error again
+	select {
+	case err := <-w.Errors():
+		t.Errorf("the error was reported again: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
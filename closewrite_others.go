@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func newCloseWriteWatcher() (Watcher, error) {
+	return nil, errors.New("closeWrite is only supported on Linux")
+}
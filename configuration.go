@@ -1,16 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/google/shlex"
-
 	"gopkg.in/yaml.v2"
 )
 
@@ -18,43 +21,167 @@ const defaultSignal = syscall.SIGKILL
 
 type configuration struct {
 	// User-facing representation
-	Paths       []string `json:"paths,omitempty" yaml:"paths,omitempty"`
-	Watch       []string `json:"watch,omitempty" yaml:"watch,omitempty"`
-	Filter      `yaml:",inline,omitempty"`
-	IgnoreWatch []string          `json:"ignore,omitempty" yaml:"ignore,omitempty"`
-	Ignore      []Filter          `json:"ignores,omitempty" yaml:"ignores,omitempty"`
-	Env         map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
-	ExecMap     map[string]string `json:"execMap,omitempty" yaml:"execMap,omitempty"`
-	Actions     []Action          `json:"actions,omitempty" yaml:"actions,omitempty"`
-	Delay       string            `json:"delay,omitempty" yaml:"delay,omitempty"`
-	Signal      string            `json:"signal,omitempty" yaml:"signal,omitempty"`
-	Self        *bool             `json:"self,omitempty" yaml:"self,omitempty"`
+	Paths              watchTargetList `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Watch              watchTargetList `json:"watch,omitempty" yaml:"watch,omitempty"`
+	WatchFromFile      stringList      `json:"watchFromFile,omitempty" yaml:"watchFromFile,flow,omitempty"`
+	Depth              *int            `json:"depth,omitempty" yaml:"depth,omitempty"`
+	RequirePaths       bool            `json:"requirePaths,omitempty" yaml:"requirePaths,omitempty"`
+	WatchMissing       bool            `json:"watchMissing,omitempty" yaml:"watchMissing,omitempty"`
+	Filter             `yaml:",inline,omitempty"`
+	IgnoreWatch        []string          `json:"ignore,omitempty" yaml:"ignore,omitempty"`
+	NoDefaultIgnores   bool              `json:"noDefaultIgnores,omitempty" yaml:"noDefaultIgnores,omitempty"`
+	FollowSymlinks     bool              `json:"followSymlinks,omitempty" yaml:"followSymlinks,omitempty"`
+	Ignore             []Filter          `json:"ignores,omitempty" yaml:"ignores,omitempty"`
+	Env                map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	EnvFile            stringList        `json:"envFile,omitempty" yaml:"envFile,flow,omitempty"`
+	Shell              stringList        `json:"shell,omitempty" yaml:"shell,flow,omitempty"`
+	ExecMap            map[string]string `json:"execMap,omitempty" yaml:"execMap,omitempty"`
+	ExecMapFile        stringList        `json:"execMapFile,omitempty" yaml:"execMapFile,flow,omitempty"`
+	Actions            []Action          `json:"actions,omitempty" yaml:"actions,omitempty"`
+	Delay              string            `json:"delay,omitempty" yaml:"delay,omitempty"`
+	GlobalDelay        string            `json:"globalDelay,omitempty" yaml:"globalDelay,omitempty"`
+	DebounceStrategy   string            `json:"debounceStrategy,omitempty" yaml:"debounceStrategy,omitempty"`
+	BatchWindow        string            `json:"batchWindow,omitempty" yaml:"batchWindow,omitempty"`
+	Signal             string            `json:"signal,omitempty" yaml:"signal,omitempty"`
+	Signals            []signalStep      `json:"signals,omitempty" yaml:"signals,omitempty"`
+	Self               *bool             `json:"self,omitempty" yaml:"self,omitempty"`
+	SelfIgnore         []string          `json:"selfIgnore,omitempty" yaml:"selfIgnore,flow,omitempty"`
+	SelfReloadDelay    string            `json:"selfReloadDelay,omitempty" yaml:"selfReloadDelay,omitempty"`
+	StartupGrace       string            `json:"startupGrace,omitempty" yaml:"startupGrace,omitempty"`
+	Poll               bool              `json:"poll,omitempty" yaml:"poll,omitempty"`
+	PollInterval       string            `json:"pollInterval,omitempty" yaml:"pollInterval,omitempty"`
+	IncludeChmod       bool              `json:"includeChmod,omitempty" yaml:"includeChmod,omitempty"`
+	CloseWrite         bool              `json:"closeWrite,omitempty" yaml:"closeWrite,omitempty"`
+	ContentHash        bool              `json:"contentHash,omitempty" yaml:"contentHash,omitempty"`
+	ClearScreen        bool              `json:"clearScreen,omitempty" yaml:"clearScreen,omitempty"`
+	Bell               bool              `json:"bell,omitempty" yaml:"bell,omitempty"`
+	RescanOnOverflow   bool              `json:"rescanOnOverflow,omitempty" yaml:"rescanOnOverflow,omitempty"`
+	LockTimeout        string            `json:"lockTimeout,omitempty" yaml:"lockTimeout,omitempty"`
+	MaxConcurrency     int               `json:"maxConcurrency,omitempty" yaml:"maxConcurrency,omitempty"`
+	Sequential         bool              `json:"sequential,omitempty" yaml:"sequential,omitempty"`
+	MaxEventsPerSecond float64           `json:"maxEventsPerSecond,omitempty" yaml:"maxEventsPerSecond,omitempty"`
 
 	// Code-facing representation
-	signal os.Signal
-	delay  time.Duration
+	signal          os.Signal
+	delay           time.Duration
+	globalDelay     time.Duration
+	batchWindow     time.Duration
+	selfReloadDelay time.Duration
+	startupGrace    time.Duration
+	pollInterval    time.Duration
+	depth           int // -1: unlimited
+	envFile         map[string]string
+	ignoreGlobs     []string        // `ignore`, and the default ignores unless `noDefaultIgnores` is set
+	keys            map[string]bool // the top-level keys set in the config file
+}
+
+// execMapDefault is the `execMap` key for the command to run for all other extensions
+const execMapDefault = "*"
+
+// stringList is a list of strings that may also be given as a single string
+type stringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var ss []string
+	if err := unmarshal(&ss); err != nil {
+		return err
+	}
+	*l = ss
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return err
+	}
+	*l = ss
+	return nil
 }
 
-func (c *configuration) makeCanonical() {
-	c.Filter.makeCanonical()
+func (c *configuration) makeCanonical() error {
+	if len(c.Watch) > 0 {
+		stderrJSONEncode(struct {
+			Warning string `json:"warning"`
+		}{
+			Warning: "the `watch` field is deprecated and has been merged into `paths`",
+		})
+		c.Paths = append(c.Paths, c.Watch...)
+		c.Watch = nil
+	}
+	paths, pathsErr := c.Paths.makeCanonical(c.WatchFromFile, c.CaseSensitive)
+	c.Paths, c.WatchFromFile = paths, nil
+	c.ignoreGlobs = append([]string{}, c.IgnoreWatch...)
+	if !c.NoDefaultIgnores {
+		c.ignoreGlobs = append(c.ignoreGlobs, defaultIgnoreGlobs()...)
+	}
+	filterErr := c.Filter.makeCanonical()
 	for i := range c.Ignore {
-		c.Ignore[i].makeCanonical()
+		if c.Ignore[i].CaseSensitive == nil {
+			c.Ignore[i].CaseSensitive = c.CaseSensitive
+		}
+		err := c.Ignore[i].makeCanonical()
+		if err == nil && !c.Ignore[i].constrains() {
+			err = errEmptyIgnore
+		}
+		if err != nil && filterErr == nil {
+			filterErr = fmt.Errorf("ignores %d: %v", i, err)
+		}
 	}
-	s, ok := parseSignal[c.Signal]
-	if !ok {
+	s, signalErr := lookupSignal(c.Signal)
+	if signalErr != nil {
 		s = defaultSignal
 	}
 	c.signal = s
-	for ext, command := range c.ExecMap {
-		tokens, err := shlex.Split(command)
+	stepsErr := makeSignalStepsCanonical(c.Signals)
+	envFile, envErr := loadEnvFiles(c.EnvFile, c.Env)
+	c.envFile = envFile
+	execMap, execMapErr := loadExecMapFiles(c.ExecMapFile, c.ExecMap)
+	c.ExecMap, c.ExecMapFile = execMap, nil
+	firstErr := firstError(pathsErr, filterErr, signalErr, stepsErr, envErr, execMapErr)
+	var execMapExts []string
+	for ext := range c.ExecMap {
+		if ext != execMapDefault {
+			execMapExts = append(execMapExts, ext)
+		}
+	}
+	sort.Strings(execMapExts)
+	if _, ok := c.ExecMap[execMapDefault]; ok {
+		execMapExts = append(execMapExts, execMapDefault)
+	}
+	for _, ext := range execMapExts {
+		command := c.ExecMap[ext]
+		tokens, err := splitCommandTemplate(command)
 		if err != nil {
 			tokens = []string{command}
 		}
-		filter := Filter{Extensions: []string{ext}}
+		filter := Filter{Extensions: []string{ext}, CaseSensitive: c.CaseSensitive}
+		if ext == execMapDefault {
+			// the default command runs for all extensions without their own entry
+			filter.Extensions = nil
+			for _, other := range execMapExts {
+				if other != execMapDefault {
+					filter.Extensions = append(filter.Extensions, "!"+other)
+				}
+			}
+		}
 		filter.makeCanonical()
 		c.Actions = append(c.Actions, Action{
 			ActionExec: &ActionExec{
-				Command: tokens,
+				Command:  tokens,
+				Template: strings.Contains(command, "{{"),
 			},
 			Filter: filter,
 		})
@@ -64,23 +191,259 @@ func (c *configuration) makeCanonical() {
 		c.Delay = fmt.Sprint(time.Millisecond * time.Duration(n))
 	}
 	c.delay, _ = time.ParseDuration(c.Delay)
+	if n, err := strconv.ParseInt(c.GlobalDelay, 10, 64); err == nil {
+		c.GlobalDelay = fmt.Sprint(time.Millisecond * time.Duration(n))
+	}
+	c.globalDelay, _ = time.ParseDuration(c.GlobalDelay)
+	if n, err := strconv.ParseInt(c.BatchWindow, 10, 64); err == nil {
+		c.BatchWindow = fmt.Sprint(time.Millisecond * time.Duration(n))
+	}
+	c.batchWindow, _ = time.ParseDuration(c.BatchWindow)
+	if c.batchWindow > c.globalDelay {
+		// a batch is the events collected by the global debouncer
+		c.globalDelay = c.batchWindow
+	}
+	c.selfReloadDelay = defaultSelfReloadDelay
+	if c.SelfReloadDelay != "" {
+		if n, err := strconv.ParseInt(c.SelfReloadDelay, 10, 64); err == nil {
+			c.SelfReloadDelay = fmt.Sprint(time.Millisecond * time.Duration(n))
+		}
+		c.selfReloadDelay, _ = time.ParseDuration(c.SelfReloadDelay)
+	}
+	if n, err := strconv.ParseInt(c.StartupGrace, 10, 64); err == nil {
+		c.StartupGrace = fmt.Sprint(time.Millisecond * time.Duration(n))
+	}
+	c.startupGrace, _ = time.ParseDuration(c.StartupGrace)
+	if n, err := strconv.ParseInt(c.PollInterval, 10, 64); err == nil {
+		c.PollInterval = fmt.Sprint(time.Millisecond * time.Duration(n))
+	}
+	c.pollInterval, _ = time.ParseDuration(c.PollInterval)
+	if c.pollInterval <= 0 {
+		c.pollInterval = defaultPollInterval
+	}
+	c.depth = -1
+	if c.Depth != nil && *c.Depth >= 0 {
+		c.depth = *c.Depth
+	}
+	interactive := 0
+	for i := range c.Actions {
+		if c.Actions[i].interactive() {
+			interactive++
+		}
+	}
+	if interactive > 1 && firstErr == nil {
+		firstErr = fmt.Errorf("at most one action can be interactive, found %d", interactive)
+	}
 	for i := range c.Actions {
 		if c.Actions[i].Delay == "" {
 			c.Actions[i].Delay = c.Delay
 		}
-		c.Actions[i].makeCanonical()
+		if c.Actions[i].DebounceStrategy == "" {
+			c.Actions[i].DebounceStrategy = c.DebounceStrategy
+		}
+		if c.Actions[i].LockTimeout == "" {
+			c.Actions[i].LockTimeout = c.LockTimeout
+		}
+		if c.Actions[i].CaseSensitive == nil {
+			c.Actions[i].CaseSensitive = c.CaseSensitive
+		}
+		if err := c.Actions[i].makeCanonical(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("action %d: %v", i, err)
+		}
+	}
+	return firstErr
+}
+
+// firstError returns the first non-nil error
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// environment returns the top-level environment, with `env` taking precedence over `envFile`
+func (c *configuration) environment() map[string]string {
+	env := make(map[string]string, len(c.envFile)+len(c.Env))
+	for k, v := range c.envFile {
+		env[k] = v
+	}
+	for k, v := range c.Env {
+		env[k] = v
 	}
+	return env
 }
 
-func (c *configuration) load(path string) error {
+func (c *configuration) load(path, format string) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	dec := yaml.NewDecoder(f)
-	dec.SetStrict(true)
-	return dec.Decode(c)
+	var r io.Reader = f
+	if isJSONPath(path) {
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(stripJSONComments(data))
+	}
+	if format == "" && filepath.Base(path) == nodemonConfigBasename {
+		return c.decodeNodemon(r)
+	}
+	if format == "" {
+		format = configFormatOf(path)
+	}
+	return c.decode(r, format)
+}
+
+// decodeNodemon reads a nodemon.json, translating its options and warning about those that are not supported
+func (c *configuration) decodeNodemon(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	translated, unsupported, err := decodeNodemon(data)
+	if err != nil {
+		return err
+	}
+	*c = translated
+	if len(unsupported) > 0 {
+		stderrJSONEncode(struct {
+			Warning string   `json:"warning"`
+			Options []string `json:"options"`
+		}{
+			Warning: "ignoring unsupported nodemon.json options",
+			Options: unsupported,
+		})
+	}
+	return nil
+}
+
+// configFormatOf returns the config format for the path's extension.
+// Files other than TOML are decoded as YAML, which also accepts JSON; this way
+// `.json` files may use YAML's `#` comments and anchors as well (see `stripJSONComments`).
+func configFormatOf(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		return formatTOML
+	}
+	return formatYAML
+}
+
+// decode reads a configuration in the given format; an empty input is an empty configuration.
+// Unknown keys are an error, unless -lax is set, in which case they are reported as a warning.
+func (c *configuration) decode(r io.Reader, format string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if format == formatTOML {
+		doc, err := decodeTOML(string(data))
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return err
+		}
+		format = formatJSON
+	} else if format == formatJSON {
+		data = stripJSONComments(data)
+	}
+	if format == formatJSON {
+		if data, err = normalizeDurations(data); err != nil {
+			return err
+		}
+	}
+	c.keys = topLevelKeys(data)
+	if !laxConfig {
+		return c.decodeData(data, format, true)
+	}
+	if err := c.decodeData(data, format, false); err != nil {
+		return err
+	}
+	var scratch configuration
+	if err := scratch.decodeData(data, format, true); err != nil {
+		unknown := []string{err.Error()}
+		if err, ok := err.(*yaml.TypeError); ok {
+			unknown = err.Errors
+		}
+		stderrJSONEncode(struct {
+			Warning string   `json:"warning"`
+			Unknown []string `json:"unknown"`
+		}{
+			Warning: "ignoring unknown config keys (-lax)",
+			Unknown: unknown,
+		})
+	}
+	return nil
+}
+
+// normalizeDurations replaces numbers in the JSON document's `durationFields` by strings,
+// since the fields are strings: YAML decodes `delay: 500` as the string "500", while
+// encoding/json rejects a number.
+func normalizeDurations(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err == io.EOF {
+		return data, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var normalize func(v interface{})
+	normalize = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				if n, ok := value.(json.Number); ok && durationFields[key] {
+					v[key] = n.String()
+					continue
+				}
+				normalize(value)
+			}
+		case []interface{}:
+			for _, value := range v {
+				normalize(value)
+			}
+		}
+	}
+	normalize(doc)
+	return json.Marshal(doc)
+}
+
+// topLevelKeys returns the keys of the YAML (or JSON) document's top-level mapping
+func topLevelKeys(data []byte) map[string]bool {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	keys := make(map[string]bool, len(doc))
+	for key := range doc {
+		keys[key] = true
+	}
+	return keys
+}
+
+// decodeData decodes JSON or YAML, optionally failing on unknown keys
+func (c *configuration) decodeData(data []byte, format string, strict bool) error {
+	if format == formatJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if strict {
+			dec.DisallowUnknownFields()
+		}
+		if err := dec.Decode(c); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.SetStrict(strict)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
 }
 
 func (c *configuration) writeJSON(w io.Writer) error {
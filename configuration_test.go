@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// writeFile writes the file below dir, creating its directory, and returns its path
+func writeFile(t testing.TB, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	want := configuration{
+		Paths:   watchTargetsOf("src", "lib"),
+		Filter:  Filter{Extensions: []string{"go"}},
+		Ignore:  []Filter{{Extensions: []string{"tmp"}}},
+		Delay:   "500ms",
+		Actions: []Action{{Name: "build", ActionExec: &ActionExec{Command: []string{"make", "build"}}}},
+		keys:    map[string]bool{"paths": true, "exts": true, "ignores": true, "delay": true, "actions": true},
+	}
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{formatYAML, `
+paths: [src, lib]
+exts: [go]
+ignores: [{exts: [tmp]}]
+delay: 500ms
+actions:
+- name: build
+  exec: {command: [make, build]}
+`},
+		{formatJSON, `{
+  // comments are allowed in JSON configs
+  "paths": ["src", "lib"],
+  "exts": ["go"],
+  "ignores": [{"exts": ["tmp"]}],
+  "delay": "500ms",
+  "actions": [{"name": "build", "exec": {"command": ["make", "build"]}}]
+}`},
+		{formatTOML, `
+paths = ["src", "lib"]
+exts = ["go"]
+ignores = [{exts = ["tmp"]}]
+delay = "500ms"
+
+[[actions]]
+name = "build"
+exec.command = ["make", "build"]
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			// the extension does not match any format, so only the explicit format applies
+			path := writeFile(t, dir, tt.format+".conf", tt.data)
+			var c configuration
+			if err := c.load(path, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c, want) {
+				t.Errorf("loaded %+v; want %+v", c, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFormatOverridesExtension(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "watchfs.yaml", "paths = [\"src\"]\n")
+	var c configuration
+	if err := c.load(path, ""); err == nil {
+		t.Fatal("loading TOML as YAML succeeded")
+	}
+	c = configuration{}
+	if err := c.load(path, formatTOML); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Paths.paths(); !reflect.DeepEqual(got, []string{"src"}) {
+		t.Errorf("paths = %q; want [src]", got)
+	}
+	path = writeFile(t, dir, "watchfs.toml", "paths: [src]\n")
+	if err := (&configuration{}).load(path, ""); err == nil || !strings.HasPrefix(err.Error(), "toml: ") {
+		t.Errorf("loading YAML from a .toml file: error %v; want a TOML error", err)
+	}
+	if err := (&configuration{}).load(path, formatYAML); err != nil {
+		t.Errorf("loading YAML from a .toml file with an explicit format: %v", err)
+	}
+}
+
+func TestConfigFormatOf(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"watchfs.yaml", formatYAML},
+		{"watchfs.yml", formatYAML},
+		{"watchfs.json", formatYAML},
+		{"watchfs.toml", formatTOML},
+		{"dir/WATCHFS.TOML", formatTOML},
+		{"watchfs", formatYAML},
+	}
+	for _, tt := range tests {
+		if got := configFormatOf(tt.path); got != tt.want {
+			t.Errorf("configFormatOf(%q) = %q; want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWatchFieldIsMergedIntoPaths(t *testing.T) {
+	w := startWatchfs(t, `
+watch: [$DIR]
+actions:
+- exec: {command: ["true"]}
+`)
+	if paths := config.Paths.paths(); !reflect.DeepEqual(paths, []string{w.dir}) || len(config.Watch) != 0 {
+		t.Errorf("paths = %q, watch = %v; want the watch paths merged into paths", paths, config.Watch)
+	}
+	if warnings := w.stderr.recordsWith(t, "warning"); len(warnings) != 1 || !strings.Contains(warnings[0]["warning"].(string), "deprecated") {
+		t.Errorf("got warnings %v; want a deprecation warning", warnings)
+	}
+	w.write("a.txt", "a")
+	w.waitFor("an event for a.txt", func() bool {
+		for _, e := range w.events() {
+			if e["path"] == w.path("a.txt") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestDecodeConfigFromReader(t *testing.T) {
+	var c configuration
+	if err := c.decode(strings.NewReader("paths: [src]\nactions:\n- exec: {command: [make]}\n"), formatYAML); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Paths.paths(); !reflect.DeepEqual(got, []string{"src"}) || len(c.Actions) != 1 {
+		t.Errorf("decoded %+v; want the paths and action", c)
+	}
+	c = configuration{}
+	if err := c.decode(strings.NewReader(""), formatYAML); err != nil || len(c.Paths) != 0 {
+		t.Errorf("decoding empty input: %+v, %v; want an empty configuration", c, err)
+	}
+}
+
+func TestConfigFromStdin(t *testing.T) {
+	stdin := writeFile(t, t.TempDir(), "stdin", `{"paths": ["src"], "actions": [{"exec": {"command": ["make"]}}]}`)
+	f, err := os.Open(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	savedStdin, savedPath, savedAbs, savedData, savedFormat := os.Stdin, configPath, configPathAbs, stdinConfig, configFormat.Value
+	os.Stdin, configPath, configPathAbs, stdinConfig, configFormat.Value = f, configPathStdin, "/previous/watchfs.yaml", nil, formatJSON
+	t.Cleanup(func() {
+		os.Stdin, configPath, configPathAbs, stdinConfig, configFormat.Value = savedStdin, savedPath, savedAbs, savedData, savedFormat
+	})
+	for i := 0; i < 2; i++ {
+		// reloads reuse the configuration read the first time
+		useConfig(t, configuration{})
+		loadProjectConfig()
+		if got := config.Paths.paths(); !reflect.DeepEqual(got, []string{"src"}) || len(config.Actions) != 1 {
+			t.Errorf("load %d: got %+v; want the configuration from stdin", i, config)
+		}
+	}
+	if configPathAbs != "" {
+		t.Errorf("configPathAbs = %q; want no config file to reload on writes", configPathAbs)
+	}
+}
+
+func TestExecMapDefault(t *testing.T) {
+	useConfig(t, configuration{ExecMap: map[string]string{"go": "go vet", "md": "mdlint", execMapDefault: "make"}})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want []string // the commands that run for the path
+	}{
+		{"main.go", []string{"go vet"}},
+		{"README.md", []string{"mdlint"}},
+		{"style.css", []string{"make"}},
+		{"Makefile", []string{"make"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for i := range config.Actions {
+			if a := &config.Actions[i]; a.Match(Event{Name: tt.path, Op: fsnotify.Write}) {
+				got = append(got, strings.Join(a.ActionExec.Command, " "))
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s runs %q; want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExecMapTemplates(t *testing.T) {
+	useConfig(t, configuration{ExecMap: map[string]string{"go": `go build "{{.Dir}}/..."`}})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	// the command is split at load time, and expanded for each run
+	a := config.Actions[0].ActionExec
+	if want := []string{"go", "build", "{{.Dir}}/..."}; !reflect.DeepEqual(a.Command, want) || !a.Template {
+		t.Errorf("command = %q, template = %v; want %q templated", a.Command, a.Template, want)
+	}
+
+	out := t.TempDir()
+	w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+execMap:
+  txt: cp "{{.Path}}" %s/copy-of-{{.Base}}
+`, out))
+	w.write("a file.txt", "a")
+	copied := filepath.Join(out, "copy-of-a file.txt")
+	w.waitFor("the templated command", func() bool {
+		data, _ := ioutil.ReadFile(copied)
+		return string(data) == "a"
+	})
+}
+
+func TestExecMapTemplatesPerEvent(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	dir := t.TempDir()
+	for _, sub := range []string{"src", "lib"} {
+		writeFile(t, dir, filepath.Join(sub, "a.go"), "package a")
+	}
+	w := startWatchfsIn(t, dir, fmt.Sprintf(`
+paths: [$DIR]
+execMap:
+  go: sh -c "echo '{{.Dir}}' >> %s"
+`, out))
+	read := func() string {
+		data, _ := ioutil.ReadFile(out)
+		return string(data)
+	}
+	// each run expands the command against the file that changed
+	for _, sub := range []string{"src", "lib"} {
+		w.write(filepath.Join(sub, "a.go"), "package a // changed")
+		want := w.path(sub) + "\n"
+		w.waitFor("the run for "+sub, func() bool { return strings.Contains(read(), want) })
+	}
+	if strings.Contains(read(), "{{") {
+		t.Errorf("a run used the unexpanded command: %q", read())
+	}
+}
+
+func TestLoadExecMapFiles(t *testing.T) {
+	dir := t.TempDir()
+	shared := writeFile(t, dir, "shared.yaml", "go: go build ./...\nmd: mdlint\nproto: protoc\n")
+	team := writeFile(t, dir, "team.json", `{"go": "go vet ./...", "css": "stylelint"}`)
+	got, err := loadExecMapFiles([]string{shared, team}, map[string]string{"md": "markdownlint"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// later files override earlier ones, and the inline entries override all files
+	want := map[string]string{"go": "go vet ./...", "md": "markdownlint", "proto": "protoc", "css": "stylelint"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %v; want %v", got, want)
+	}
+	inline := map[string]string{"go": "make"}
+	if got, err := loadExecMapFiles(nil, inline); err != nil || !reflect.DeepEqual(got, inline) {
+		t.Errorf("loadExecMapFiles() = %v, %v without files; want the inline map", got, err)
+	}
+	for _, path := range []string{filepath.Join(dir, "missing.yaml"), writeFile(t, dir, "invalid.yaml", "go: [not, a, command]\n")} {
+		if _, err := loadExecMapFiles([]string{path}, nil); err == nil {
+			t.Errorf("loaded %s", path)
+		}
+	}
+}
+
+func TestExecMapFile(t *testing.T) {
+	file := writeFile(t, t.TempDir(), "execmap.yaml", "go: go build\nmd: mdlint\n")
+	saved := execMapFrom
+	execMapFrom = file
+	defer func() { execMapFrom = saved }()
+	useConfig(t, configuration{ExecMap: map[string]string{"go": "go vet"}})
+	flagsToConfiguration()
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range config.Actions {
+		got = append(got, strings.Join(a.ActionExec.Command, " "))
+	}
+	sort.Strings(got)
+	if want := []string{"go vet", "mdlint"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the exec map from -exec-map-from runs %q; want %q", got, want)
+	}
+	if len(config.ExecMapFile) != 0 {
+		t.Errorf("execMapFile = %q after loading it", config.ExecMapFile)
+	}
+}
+
+func TestLaxConfig(t *testing.T) {
+	const unknown = "paths: [src]\nwatchfsVersion: 2\nactions:\n- exec: {command: [make], retries: 3}\n"
+	saved := laxConfig
+	defer func() { laxConfig = saved }()
+	for _, format := range []string{formatYAML, formatJSON} {
+		input := unknown
+		if format == formatJSON {
+			input = `{"paths": ["src"], "watchfsVersion": 2, "actions": [{"exec": {"command": ["make"], "retries": 3}}]}`
+		}
+
+		laxConfig = false
+		stderr := captureStderr(t)
+		var c configuration
+		if err := c.decode(strings.NewReader(input), format); err == nil {
+			t.Errorf("%s: strict decoding accepted unknown keys", format)
+		}
+
+		laxConfig = true
+		c = configuration{}
+		if err := c.decode(strings.NewReader(input), format); err != nil {
+			t.Fatalf("%s: lax decoding failed: %v", format, err)
+		}
+		if got := c.Paths.paths(); !reflect.DeepEqual(got, []string{"src"}) || len(c.Actions) != 1 || !reflect.DeepEqual(c.Actions[0].ActionExec.Command, []string{"make"}) {
+			t.Errorf("%s: decoded %+v; want the known keys", format, c)
+		}
+		// encoding/json stops at the first unknown key it meets, while YAML lists all of them
+		want := []string{"watchfsVersion", "retries"}
+		if format == formatJSON {
+			want = []string{"unknown field"}
+		}
+		warning := stderr.String()
+		if !strings.Contains(warning, `"warning":"ignoring unknown config keys (-lax)"`) {
+			t.Errorf("%s: warned %q; want the -lax warning", format, warning)
+		}
+		for _, key := range want {
+			if !strings.Contains(warning, key) {
+				t.Errorf("%s: warned %q; want %s listed", format, warning, key)
+			}
+		}
+
+		// a config without unknown keys decodes without a warning
+		stderr = captureStderr(t)
+		c = configuration{}
+		if err := c.decode(strings.NewReader("paths: [src]\n"), formatYAML); err != nil || stderr.String() != "" {
+			t.Errorf("%s: decoding known keys: %v, warned %q", format, err, stderr.String())
+		}
+	}
+}
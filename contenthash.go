@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// contentHashMaxSize is the size above which files are not hashed; their writes are always reported
+	contentHashMaxSize = 32 << 20
+	// contentHashMaxEntries bounds the number of remembered hashes
+	contentHashMaxEntries = 1 << 16
+	// contentHashQueueSize is the number of events that may wait for their file to be hashed
+	contentHashQueueSize = 1024
+)
+
+// contentHashes remembers a hash of the content of each written file, for `contentHash`
+var contentHashes = newHashCache(contentHashMaxEntries)
+
+// hashCache maps paths to the hash of their content when they were last seen
+type hashCache struct {
+	mu     sync.Mutex
+	limit  int
+	hashes map[string][sha256.Size]byte
+}
+
+func newHashCache(limit int) *hashCache {
+	return &hashCache{
+		limit:  limit,
+		hashes: make(map[string][sha256.Size]byte),
+	}
+}
+
+// unchanged hashes the file and returns whether its content is the same as when it was last hashed.
+// Files that cannot be read, are not regular files or are too large are never unchanged.
+func (c *hashCache) unchanged(path string) bool {
+	sum, ok := hashFile(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok {
+		delete(c.hashes, path)
+		return false
+	}
+	previous, seen := c.hashes[path]
+	if !seen && len(c.hashes) >= c.limit {
+		for evicted := range c.hashes {
+			delete(c.hashes, evicted)
+			break
+		}
+	}
+	c.hashes[path] = sum
+	return seen && previous == sum
+}
+
+// prime remembers the hash of a file that has not been hashed yet, e.g. while walking
+// the watched directories, so that its first change can already be recognized
+func (c *hashCache) prime(path string) {
+	c.mu.Lock()
+	_, seen := c.hashes[path]
+	full := len(c.hashes) >= c.limit
+	c.mu.Unlock()
+	if seen || full {
+		return
+	}
+	sum, ok := hashFile(path)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, seen := c.hashes[path]; !seen && len(c.hashes) < c.limit {
+		c.hashes[path] = sum
+	}
+}
+
+// contentChanged returns whether the event may have changed its file's content; a write
+// or create that left the content unchanged is reported as filtered
+func contentChanged(e Event) bool {
+	switch {
+	case e.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		contentHashes.forget(e.Name)
+	case e.Op&(fsnotify.Write|fsnotify.Create) != 0 && contentHashes.unchanged(e.Name):
+		onEventFiltered(e, "contentHash", "unchanged")
+		return false
+	}
+	return true
+}
+
+// forget drops the hash of the path, e.g. when the file is removed
+func (c *hashCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.hashes, path)
+}
+
+func hashFile(path string) (sum [sha256.Size]byte, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() > contentHashMaxSize {
+		return sum, false
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, false
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, true
+}
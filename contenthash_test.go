@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashCache(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.txt", "a")
+	c := newHashCache(2)
+	if c.unchanged(a) {
+		t.Error("a file that was never hashed is unchanged")
+	}
+	if !c.unchanged(a) {
+		t.Error("re-saving identical content is a change")
+	}
+	writeFile(t, dir, "a.txt", "changed")
+	if c.unchanged(a) {
+		t.Error("changed content is unchanged")
+	}
+
+	// primed files are recognized on their first event
+	b := writeFile(t, dir, "b.txt", "b")
+	c.prime(b)
+	if !c.unchanged(b) {
+		t.Error("a primed file is changed without a write")
+	}
+	c.forget(b)
+	if c.unchanged(b) {
+		t.Error("a forgotten file is unchanged")
+	}
+
+	// the cache stays within its limit
+	for _, name := range []string{"c.txt", "d.txt", "e.txt"} {
+		c.unchanged(writeFile(t, dir, name, name))
+	}
+	if n := len(c.hashes); n > c.limit {
+		t.Errorf("the cache holds %d hashes; want at most %d", n, c.limit)
+	}
+
+	// directories and missing files are never unchanged
+	for _, path := range []string{dir, filepath.Join(dir, "missing")} {
+		c.unchanged(path)
+		if c.unchanged(path) {
+			t.Errorf("%s is unchanged", path)
+		}
+	}
+}
+
+func TestHashFileSkipsLargeFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "large")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(contentHashMaxSize + 1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, ok := hashFile(path); ok {
+		t.Error("hashed a file above contentHashMaxSize")
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	useVerbose(t, true)
+	dir := t.TempDir()
+	path := writeFile(t, dir, "a.txt", "a")
+	w := startWatchfsIn(t, dir, `
+paths: [$DIR]
+contentHash: true
+actions:
+- exec: {command: ["true"]}
+`)
+	// save overwrites the file in place, without truncating it first, so that each save is a single write
+	save := func(content string) {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unchanged := func() (n int) {
+		for _, info := range w.infos("filtered") {
+			if info["stage"] == "contentHash" {
+				n++
+			}
+		}
+		return n
+	}
+	runs := func() (n int) {
+		for _, result := range w.completed() {
+			if result["path"] == path {
+				n++
+			}
+		}
+		return n
+	}
+	// re-saving the content found while walking does not run the action
+	save("a")
+	w.waitFor("the unchanged write", func() bool { return unchanged() == 1 })
+	save("b")
+	w.waitFor("the run", func() bool { return runs() > 0 })
+	// neither does re-saving what was written last
+	save("b")
+	w.waitFor("the unchanged write", func() bool { return unchanged() == 2 })
+	w.stop()
+	if n := runs(); n != 1 {
+		t.Errorf("got %d runs; want one for the changed content:\n%s", n, w.stdout)
+	}
+}
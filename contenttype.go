@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// contentTypeSniffLen is the number of bytes http.DetectContentType considers
+	contentTypeSniffLen = 512
+	// contentTypeCacheTTL is how long a sniffed content type is reused for an unmodified file
+	contentTypeCacheTTL = 2 * time.Second
+	// contentTypeMaxEntries bounds the number of remembered content types
+	contentTypeMaxEntries = 1 << 12
+)
+
+// contentTypes remembers the sniffed content types of recently seen files, for `contentType`
+var contentTypes = newContentTypeCache(contentTypeMaxEntries, contentTypeCacheTTL)
+
+// contentTypeCache maps paths to their sniffed content type, valid while the file's
+// size and modification time are unchanged and the entry is not older than the TTL
+type contentTypeCache struct {
+	mu      sync.Mutex
+	limit   int
+	ttl     time.Duration
+	entries map[string]contentTypeEntry
+}
+
+type contentTypeEntry struct {
+	contentType string
+	size        int64
+	modTime     time.Time
+	sniffedAt   time.Time
+}
+
+func newContentTypeCache(limit int, ttl time.Duration) *contentTypeCache {
+	return &contentTypeCache{
+		limit:   limit,
+		ttl:     ttl,
+		entries: make(map[string]contentTypeEntry),
+	}
+}
+
+// of returns the sniffed content type of the file, and false if it is not a readable regular file
+func (c *contentTypeCache) of(path string) (string, bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		c.forget(path)
+		return "", false
+	}
+	now := time.Now()
+	c.mu.Lock()
+	entry, seen := c.entries[path]
+	c.mu.Unlock()
+	if seen && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) && now.Sub(entry.sniffedAt) < c.ttl {
+		return entry.contentType, true
+	}
+	contentType, ok := sniffContentType(path)
+	if !ok {
+		c.forget(path)
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, seen := c.entries[path]; !seen && len(c.entries) >= c.limit {
+		for evicted := range c.entries {
+			delete(c.entries, evicted)
+			break
+		}
+	}
+	c.entries[path] = contentTypeEntry{
+		contentType: contentType,
+		size:        info.Size(),
+		modTime:     info.ModTime(),
+		sniffedAt:   now,
+	}
+	return contentType, true
+}
+
+// forget drops the content type of the path
+func (c *contentTypeCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, path)
+}
+
+func sniffContentType(path string) (string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	buf := make([]byte, contentTypeSniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", false
+	}
+	return http.DetectContentType(buf[:n]), true
+}
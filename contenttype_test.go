@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pngHeader is the signature of a PNG file
+const pngHeader = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+func TestContentTypeFilter(t *testing.T) {
+	dir := t.TempDir()
+	png := writeFile(t, dir, "photo.dat", pngHeader)
+	text := writeFile(t, dir, "notes.png", "just some text")
+	f := Filter{ContentTypes: []string{" Image/ "}}
+	if err := f.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		op   fsnotify.Op
+		want bool
+	}{
+		{png, fsnotify.Write, true},
+		{png, fsnotify.Create, true},
+		{text, fsnotify.Write, false},
+		{png, fsnotify.Remove, false},
+		{png, fsnotify.Chmod, false},
+		{dir, fsnotify.Create, false},
+		{filepath.Join(dir, "missing.png"), fsnotify.Create, false},
+	}
+	for _, tt := range tests {
+		if got := f.Match(Event{Name: tt.path, Op: tt.op}, matchAny); got != tt.want {
+			t.Errorf("%s %v matches image/: %v; want %v", filepath.Base(tt.path), tt.op, got, tt.want)
+		}
+	}
+}
+
+func TestContentTypeCache(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "a", pngHeader)
+	c := newContentTypeCache(2, time.Hour)
+	if contentType, ok := c.of(path); !ok || contentType != "image/png" {
+		t.Fatalf("of() = %q, %v; want image/png", contentType, ok)
+	}
+	// the cached type is used while the file is unmodified
+	entry := c.entries[path]
+	entry.contentType = "cached"
+	c.entries[path] = entry
+	if contentType, _ := c.of(path); contentType != "cached" {
+		t.Errorf("of() = %q for an unmodified file; want the cached type", contentType)
+	}
+	writeFile(t, dir, "a", "now it is text")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if contentType, _ := c.of(path); contentType != "text/plain; charset=utf-8" {
+		t.Errorf("of() = %q for a modified file; want it sniffed again", contentType)
+	}
+	for _, name := range []string{"b", "c", "d"} {
+		c.of(writeFile(t, dir, name, name))
+	}
+	if n := len(c.entries); n > c.limit {
+		t.Errorf("the cache holds %d types; want at most %d", n, c.limit)
+	}
+	os.Remove(path)
+	if _, ok := c.of(path); ok {
+		t.Error("of() succeeded for a removed file")
+	}
+	if _, cached := c.entries[path]; cached {
+		t.Error("the removed file's type is still cached")
+	}
+}
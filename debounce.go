@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	debounceTrailing        = "trailing"
+	debounceLeading         = "leading"
+	debounceLeadingTrailing = "leading-trailing"
+	debounceThrottle        = "throttle"
+)
+
+var debounceStrategies = []string{debounceTrailing, debounceLeading, debounceLeadingTrailing, debounceThrottle}
+
+// debounceStrategy decides when a debouncer fires. A debouncer has a single timer,
+// which is either waiting (running) or not.
+type debounceStrategy interface {
+	// add is called when events arrive, with whether the timer is waiting. It returns
+	// whether to keep the events (or else drop them), whether to fire now,
+	// and whether to (re)start the timer.
+	add(waiting bool) (keep, fire, restart bool)
+	// expire is called when the timer has expired, with whether kept events are pending.
+	// It returns whether to fire, and whether to restart the timer.
+	expire(pending bool) (fire, restart bool)
+}
+
+// trailingDebounce fires once no events have arrived for the delay
+type trailingDebounce struct{}
+
+func (trailingDebounce) add(waiting bool) (keep, fire, restart bool) { return true, false, true }
+func (trailingDebounce) expire(pending bool) (fire, restart bool)    { return pending, false }
+
+// leadingDebounce fires on the first event, and drops the events following it
+// until none have arrived for the delay
+type leadingDebounce struct{}
+
+func (leadingDebounce) add(waiting bool) (keep, fire, restart bool) { return !waiting, !waiting, true }
+func (leadingDebounce) expire(pending bool) (fire, restart bool)    { return false, false }
+
+// leadingTrailingDebounce fires on the first event, and again for the events following it
+// once none have arrived for the delay
+type leadingTrailingDebounce struct{}
+
+func (leadingTrailingDebounce) add(waiting bool) (keep, fire, restart bool) {
+	return true, !waiting, true
+}
+func (leadingTrailingDebounce) expire(pending bool) (fire, restart bool) { return pending, false }
+
+// throttleDebounce fires on the first event, and then at most once per delay
+// for the events that have arrived in the meantime
+type throttleDebounce struct{}
+
+func (throttleDebounce) add(waiting bool) (keep, fire, restart bool) { return true, !waiting, !waiting }
+func (throttleDebounce) expire(pending bool) (fire, restart bool)    { return pending, pending }
+
+// newDebounceStrategy returns the strategy with the given name (default: trailing)
+func newDebounceStrategy(name string) (debounceStrategy, error) {
+	switch name {
+	case "", debounceTrailing:
+		return trailingDebounce{}, nil
+	case debounceLeading:
+		return leadingDebounce{}, nil
+	case debounceLeadingTrailing:
+		return leadingTrailingDebounce{}, nil
+	case debounceThrottle:
+		return throttleDebounce{}, nil
+	}
+	return nil, fmt.Errorf("invalid value for `debounceStrategy`: %q (choices: %v)", name, debounceStrategies)
+}
+
+// debouncer collects events and decides when to fire using its strategy with the given delay
+// (by default, once none have arrived for `delay`).
+// When it fires, a value is sent on ready(); take() then returns the collected events.
+// A nil debouncer is valid and never becomes ready.
+type debouncer struct {
+	delay    time.Duration
+	strategy debounceStrategy
+	clock    Clock
+	fired    chan struct{}
+
+	mu      sync.Mutex
+	events  []Event
+	timer   Timer
+	waiting bool
+	gen     int // incremented when the timer is (re)started or stopped, to ignore stale expiries
+	stopped bool
+}
+
+func newDebouncer(delay time.Duration, strategy debounceStrategy) *debouncer {
+	if delay <= 0 {
+		return nil
+	}
+	if strategy == nil {
+		strategy = trailingDebounce{}
+	}
+	return &debouncer{
+		delay:    delay,
+		strategy: strategy,
+		clock:    clock,
+		fired:    make(chan struct{}, 1),
+	}
+}
+
+// add adds events, unless the strategy drops them.
+// It returns whether a new wait has started (the events are kept, but not fired yet,
+// and no events were pending before), and whether the events were dropped.
+func (d *debouncer) add(events ...Event) (started, dropped bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return false, true
+	}
+	keep, fire, restart := d.strategy.add(d.waiting)
+	if !keep {
+		dropped = true
+	} else {
+		started = len(d.events) == 0 && !fire
+		d.events = append(d.events, events...)
+	}
+	if restart {
+		d.restartLocked()
+	}
+	if fire {
+		d.fire()
+	}
+	return started, dropped
+}
+
+func (d *debouncer) restartLocked() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.gen++
+	gen := d.gen
+	d.waiting = true
+	d.timer = d.clock.AfterFunc(d.delay, func() { d.expire(gen) })
+}
+
+func (d *debouncer) expire(gen int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped || gen != d.gen {
+		return
+	}
+	d.waiting = false
+	fire, restart := d.strategy.expire(len(d.events) > 0)
+	if restart {
+		d.restartLocked()
+	}
+	if fire {
+		d.fire()
+	}
+}
+
+// ready returns a channel that receives a value when the debouncer fires
+func (d *debouncer) ready() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.fired
+}
+
+// take returns and clears the collected events
+func (d *debouncer) take() []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	events := d.events
+	d.events = nil
+	return events
+}
+
+// stop discards the collected events and stops the timer
+func (d *debouncer) stop() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	d.events = nil
+	d.gen++
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+func (d *debouncer) fire() {
+	select {
+	case d.fired <- struct{}{}:
+	default:
+	}
+}
+
+// dedupEvents removes repeated events for the same path and op, keeping the first occurrence
+func dedupEvents(events []Event) (out []Event) {
+	type key struct {
+		name string
+		op   uint32
+	}
+	seen := make(map[key]bool, len(events))
+	for _, e := range events {
+		k := key{e.Name, uint32(e.Op)}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, e)
+	}
+	return
+}
+
+// perFileDebounceLimit is the maximum number of paths a keyedDebouncer waits for at once
+const perFileDebounceLimit = 256
+
+// keyedDebouncer collects events separately for each path, until none have arrived
+// for that path for `delay`. The events of each path whose quiet period has passed
+// are then returned as a separate batch by take(). At most `limit` paths wait at
+// once; beyond that, the path that has been waiting longest ends its wait early.
+// A nil keyedDebouncer is valid and never becomes ready.
+type keyedDebouncer struct {
+	delay time.Duration
+	limit int
+	clock Clock
+	fired chan struct{}
+
+	mu      sync.Mutex
+	waiting map[string]*keyedWait
+	order   []*keyedWait // waits, oldest first
+	batches [][]Event
+	stopped bool
+}
+
+type keyedWait struct {
+	path   string
+	events []Event
+	timer  Timer
+}
+
+func newKeyedDebouncer(delay time.Duration, limit int) *keyedDebouncer {
+	if delay <= 0 {
+		return nil
+	}
+	return &keyedDebouncer{
+		delay:   delay,
+		limit:   limit,
+		clock:   clock,
+		fired:   make(chan struct{}, 1),
+		waiting: make(map[string]*keyedWait),
+	}
+}
+
+// add adds events, restarting the quiet period of their paths.
+// It returns the number of paths for which a new wait has started.
+func (d *keyedDebouncer) add(events ...Event) (started int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return 0
+	}
+	for _, e := range events {
+		w, ok := d.waiting[e.Name]
+		if ok {
+			w.events = append(w.events, e)
+			w.timer.Reset(d.delay)
+			continue
+		}
+		w = &keyedWait{path: e.Name, events: []Event{e}}
+		w.timer = d.clock.AfterFunc(d.delay, func() { d.end(w) })
+		d.waiting[e.Name] = w
+		d.order = append(d.order, w)
+		started++
+	}
+	for len(d.waiting) > d.limit {
+		d.endLocked(d.order[0])
+	}
+	return started
+}
+
+// end ends the wait, making its events ready
+func (d *keyedDebouncer) end(w *keyedWait) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.endLocked(w)
+}
+
+func (d *keyedDebouncer) endLocked(w *keyedWait) {
+	if d.waiting[w.path] != w {
+		return
+	}
+	w.timer.Stop()
+	delete(d.waiting, w.path)
+	for i := range d.order {
+		if d.order[i] == w {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+	d.batches = append(d.batches, w.events)
+	select {
+	case d.fired <- struct{}{}:
+	default:
+	}
+}
+
+// ready returns a channel that receives a value when the quiet period of a path has passed
+func (d *keyedDebouncer) ready() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.fired
+}
+
+// take returns and clears the batches of events that are ready, one per path
+func (d *keyedDebouncer) take() [][]Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	batches := d.batches
+	d.batches = nil
+	return batches
+}
+
+// stop discards the collected events and stops the timers
+func (d *keyedDebouncer) stop() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	for _, w := range d.waiting {
+		w.timer.Stop()
+	}
+	d.waiting, d.order, d.batches = nil, nil, nil
+}
+
+// splitByPath splits events into one batch per path, in the order the paths first occur
+func splitByPath(events []Event) (batches [][]Event) {
+	index := make(map[string]int)
+	for _, e := range events {
+		i, ok := index[e.Name]
+		if !ok {
+			i = len(batches)
+			index[e.Name] = i
+			batches = append(batches, nil)
+		}
+		batches[i] = append(batches[i], e)
+	}
+	return batches
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// firing is a time at which a debouncer fired, and the events it had collected
+type firing struct {
+	at     time.Duration
+	events string
+}
+
+func TestDebounceStrategies(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	// a burst of events 30ms apart, followed by a quiet period
+	burst := map[time.Duration]string{0: "a", 30 * time.Millisecond: "b", 60 * time.Millisecond: "c"}
+	tests := []struct {
+		strategy string
+		want     []firing
+	}{
+		{debounceTrailing, []firing{{160 * time.Millisecond, "abc"}}},
+		{debounceLeading, []firing{{0, "a"}}},
+		{debounceLeadingTrailing, []firing{{0, "a"}, {160 * time.Millisecond, "bc"}}},
+		{debounceThrottle, []firing{{0, "a"}, {100 * time.Millisecond, "bc"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			c := useFakeClock(t)
+			strategy, err := newDebounceStrategy(tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := newDebouncer(delay, strategy)
+			defer d.stop()
+			var got []firing
+			for at := time.Duration(0); at <= 500*time.Millisecond; at += 10 * time.Millisecond {
+				if at > 0 {
+					c.advance(10 * time.Millisecond)
+				}
+				if name, ok := burst[at]; ok {
+					d.add(Event{Name: name})
+				}
+				if fired(d) {
+					f := firing{at: at}
+					for _, e := range d.take() {
+						f.events += e.Name
+					}
+					got = append(got, f)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fired %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDebounceStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    debounceStrategy
+		wantErr bool
+	}{
+		{"", trailingDebounce{}, false},
+		{debounceTrailing, trailingDebounce{}, false},
+		{debounceLeading, leadingDebounce{}, false},
+		{debounceLeadingTrailing, leadingTrailingDebounce{}, false},
+		{debounceThrottle, throttleDebounce{}, false},
+		{"Trailing", nil, true},
+		{"bogus", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := newDebounceStrategy(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("newDebounceStrategy(%q) = %v, %v; want %v (error: %v)", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestKeyedDebouncer(t *testing.T) {
+	c := useFakeClock(t)
+	d := newKeyedDebouncer(100*time.Millisecond, 2)
+	defer d.stop()
+
+	if started := d.add(Event{Name: "a"}, Event{Name: "b"}); started != 2 {
+		t.Fatalf("add() started %d waits; want 2", started)
+	}
+	c.advance(50 * time.Millisecond)
+	d.add(Event{Name: "a"})
+	c.advance(50 * time.Millisecond)
+	if batches := d.take(); len(batches) != 1 || batches[0][0].Name != "b" {
+		t.Fatalf("take() = %v; want the events of b, whose quiet period has passed", batches)
+	}
+	// exceeding the limit ends the oldest wait early
+	d.add(Event{Name: "c"}, Event{Name: "d"})
+	if batches := d.take(); len(batches) != 1 || len(batches[0]) != 2 || batches[0][0].Name != "a" {
+		t.Fatalf("take() = %v; want the two events of a", batches)
+	}
+	c.advance(100 * time.Millisecond)
+	if batches := d.take(); len(batches) != 2 {
+		t.Fatalf("take() = %v; want the events of c and d", batches)
+	}
+}
+
+func TestDedupEvents(t *testing.T) {
+	events := []Event{{Name: "a", Op: 1}, {Name: "b", Op: 1}, {Name: "a", Op: 1}, {Name: "a", Op: 2}}
+	want := []Event{{Name: "a", Op: 1}, {Name: "b", Op: 1}, {Name: "a", Op: 2}}
+	if got := dedupEvents(events); !reflect.DeepEqual(got, want) {
+		t.Errorf("dedupEvents() = %v; want %v", got, want)
+	}
+}
+
+func TestDebounceReporting(t *testing.T) {
+	for _, verbose := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verbose=%v", verbose), func(t *testing.T) {
+			useVerbose(t, verbose)
+			w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- delay: 100ms
+  exec: {command: ["true"]}
+`)
+			for i := 0; i < 3; i++ {
+				w.write(fmt.Sprintf("%d.txt", i), "a")
+			}
+			w.waitFor("the run for the burst", func() bool { return len(w.completed()) >= 2 })
+			var states []string
+			var events float64
+			for _, info := range w.infos("debounce") {
+				states = append(states, info["debounce"].(string))
+				events = info["events"].(float64)
+			}
+			switch {
+			case !verbose && len(states) != 0:
+				t.Errorf("got debounce records %v without -verbose", states)
+			case verbose && (len(states) != 2 || states[0] != "waiting" || states[1] != "firing"):
+				t.Errorf("got debounce records %v; want waiting, then firing", states)
+			case verbose && events != float64(len(w.events())):
+				t.Errorf("firing with %v events; want the %d events of the burst", events, len(w.events()))
+			}
+		})
+	}
+}
+
+// useVerbose sets -verbose until the test has finished
+func useVerbose(t *testing.T, v bool) {
+	saved := verbose
+	verbose = v
+	t.Cleanup(func() { verbose = saved })
+}
+
+func TestPerFileRuns(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+actions:
+- perFile: true
+  delay: 150ms
+  shell: {command: 'echo "$WATCHFS_PATH" >> %s', ignoreSignals: true}
+`, out))
+	w.waitFor("the startup run", func() bool { return len(w.completed()) >= 1 })
+	// both files are edited within the delay, which would coalesce them into one run without perFile
+	w.write("a.txt", "a")
+	time.Sleep(30 * time.Millisecond)
+	w.write("b.txt", "b")
+	w.write("a.txt", "a again")
+	w.waitFor("a run per file", func() bool { return len(w.completed()) >= 3 })
+	time.Sleep(300 * time.Millisecond)
+	w.stop()
+	data, _ := ioutil.ReadFile(out)
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line != "" {
+			got = append(got, line)
+		}
+	}
+	sort.Strings(got)
+	if want := []string{w.path("a.txt"), w.path("b.txt")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ran for %q; want one run per file", got)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+// defaultIgnores are the names of directories that are not watched, and whose events are
+// ignored, unless `noDefaultIgnores` is set: version control metadata, dependency trees,
+// editor settings, caches and build output
+var defaultIgnores = []string{
+	".git", ".hg", ".svn",
+	"node_modules", "bower_components", "vendor", ".venv",
+	".idea", ".vscode",
+	"__pycache__", ".cache",
+	"dist", "build", "target",
+}
+
+// defaultIgnoreGlobs returns the `ignore` globs for the default ignores (see matchIgnoreGlob):
+// each matches a directory of that name below a watched path, and everything in it
+func defaultIgnoreGlobs() []string {
+	globs := make([]string, len(defaultIgnores))
+	for i, name := range defaultIgnores {
+		globs[i] = "**/" + name + "/"
+	}
+	return globs
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestDefaultIgnores(t *testing.T) {
+	savedFlag := noDefaultIgnores
+	defer func() { noDefaultIgnores = savedFlag }()
+	dir := t.TempDir()
+	watchRoots.Lock()
+	savedRoots := watchRoots.targets
+	watchRoots.Unlock()
+	setWatchRoots(watchTargetList{{Path: dir}})
+	defer setWatchRoots(savedRoots)
+	for _, name := range []string{".git/objects/a", "node_modules/lib/a.js", "src/vendor/b.go", "src/main.go", "builder/a.go"} {
+		writeFile(t, dir, name, "x")
+	}
+	tests := []struct {
+		name       string
+		c          configuration
+		flag       bool
+		watched    []string // the watched directories, relative to dir
+		ignoredGit bool
+	}{
+		{"default", configuration{}, false, []string{".", "builder", "src"}, true},
+		{"noDefaultIgnores", configuration{NoDefaultIgnores: true}, false, []string{".", ".git", ".git/objects", "builder", "node_modules", "node_modules/lib", "src", "src/vendor"}, false},
+		{"-no-default-ignores", configuration{}, true, []string{".", ".git", ".git/objects", "builder", "node_modules", "node_modules/lib", "src", "src/vendor"}, false},
+	}
+	for _, tt := range tests {
+		noDefaultIgnores = tt.flag
+		useConfig(t, tt.c)
+		flagsToConfiguration()
+		if err := config.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		saved := watched
+		watched = newWatchSet()
+		w := &addWatcher{}
+		watchRecursive(w, dir)
+		watched = saved
+		var got []string
+		for _, path := range w.paths() {
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if !reflect.DeepEqual(got, tt.watched) {
+			t.Errorf("%s: watched %q; want %q", tt.name, got, tt.watched)
+		}
+		e := Event{Name: filepath.Join(dir, ".git", "index"), Op: fsnotify.Write}
+		if ignored := !shouldNotify(&e); ignored != tt.ignoredGit {
+			t.Errorf("%s: the event for .git/index is ignored: %v; want %v", tt.name, ignored, tt.ignoredGit)
+		}
+	}
+}
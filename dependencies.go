@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// runState tracks whether an action has triggered runs that have not completed yet,
+// and the result of its last run without events (the run on startup)
+type runState struct {
+	mu         sync.Mutex
+	queued     int
+	idle       chan struct{} // closed when queued drops to zero
+	initialErr error
+}
+
+func newRunState() *runState {
+	return &runState{}
+}
+
+// queue records n triggered runs that have not completed yet
+func (s *runState) queue(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n <= 0 {
+		return
+	}
+	if s.queued == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.queued += n
+}
+
+// done records that a run for the events, covering n triggered runs, has completed with err
+func (s *runState) done(a *Action, events []Event, n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(events) == 0 {
+		s.initialErr = err
+	}
+	if err != nil {
+		for _, e := range events {
+			e.dispatch.fail(a, err)
+		}
+	}
+	s.releaseLocked(n)
+}
+
+// release records that n triggered runs will not run, e.g. because they have been split into others
+func (s *runState) release(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(n)
+}
+
+func (s *runState) releaseLocked(n int) {
+	if s.queued == 0 {
+		return
+	}
+	s.queued -= n
+	if s.queued <= 0 {
+		s.queued = 0
+		close(s.idle)
+	}
+}
+
+// wait waits until no runs are outstanding
+func (s *runState) wait(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.queued == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		idle := s.idle
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-idle:
+		}
+	}
+}
+
+// waitForDependencies waits for the runs of the action's `dependsOn` actions to complete.
+// It fails if any of them failed a run for the same dispatches as the events (see dispatchRuns),
+// or for a run without events, if the last run of any of them without events failed.
+func (a *Action) waitForDependencies(ctx context.Context, events []Event) error {
+	for _, dependency := range a.dependencies {
+		if err := dependency.state.wait(ctx); err != nil {
+			return err
+		}
+		var err error
+		if len(events) == 0 {
+			dependency.state.mu.Lock()
+			err = dependency.state.initialErr
+			dependency.state.mu.Unlock()
+		}
+		for _, e := range events {
+			if err == nil {
+				err = e.dispatch.failed(dependency)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("skipped run: dependency %q failed: %v", dependency.Name, err)
+		}
+	}
+	return nil
+}
+
+// dispatchRuns records which of the actions triggered by one dispatch of events failed
+// a run for them, so that an action only skips its run because of a dependency that
+// was triggered by the same events
+type dispatchRuns struct {
+	mu     sync.Mutex
+	errors map[*Action]error
+}
+
+// fail records that a run of the action for the dispatched events failed
+func (d *dispatchRuns) fail(a *Action, err error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.errors == nil {
+		d.errors = make(map[*Action]error)
+	}
+	d.errors[a] = err
+}
+
+// failed returns the error of a failed run of the action for the dispatched events, if any
+func (d *dispatchRuns) failed(a *Action) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.errors[a]
+}
+
+// waitForPredecessors waits for the runs of the actions declared before this one to complete
+// (see `sequential`). Unlike waitForDependencies, it does not fail if any of them failed.
+func (a *Action) waitForPredecessors(ctx context.Context) error {
+	for _, predecessor := range a.predecessors {
+		predecessor.state.wait(ctx)
+	}
+	return ctx.Err()
+}
+
+// resolveSequence links each action to the actions declared before it if `sequential` is set.
+// It returns an error for a `dependsOn` naming an action declared after the action,
+// since each would wait for the other.
+func resolveSequence(actions []Action) error {
+	for i := range actions {
+		actions[i].predecessors = nil
+		if !config.Sequential {
+			continue
+		}
+		for j := range actions[:i] {
+			actions[i].predecessors = append(actions[i].predecessors, &actions[j])
+		}
+		for _, dependency := range actions[i].dependencies {
+			for j := i + 1; j < len(actions); j++ {
+				if dependency == &actions[j] {
+					return fmt.Errorf("action %q: dependsOn: %q is declared after it (sequential is set)", actions[i].Name, dependency.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolveDependencies links each action to the actions named in its `dependsOn`.
+// Names of actions in `all` that are not in `actions` (e.g. due to -skip) are ignored.
+// It returns an error for unknown names and for dependency cycles.
+func resolveDependencies(actions []Action, all []Action) error {
+	known := make(map[string]bool, len(all))
+	for _, a := range all {
+		if a.Name != "" {
+			known[a.Name] = true
+		}
+	}
+	byName := make(map[string]*Action, len(actions))
+	for i := range actions {
+		if actions[i].Name != "" {
+			byName[actions[i].Name] = &actions[i]
+		}
+	}
+	for i := range actions {
+		a := &actions[i]
+		a.dependencies = nil
+		for _, name := range a.DependsOn {
+			if !known[name] {
+				return fmt.Errorf("action %q: dependsOn: no action named %q", a.Name, name)
+			}
+			if dependency, ok := byName[name]; ok {
+				a.dependencies = append(a.dependencies, dependency)
+			}
+		}
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[*Action]int, len(actions))
+	var path []string
+	var visit func(a *Action) error
+	visit = func(a *Action) error {
+		switch marks[a] {
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, a.Name), " -> "))
+		case visited:
+			return nil
+		}
+		marks[a] = visiting
+		path = append(path, a.Name)
+		for _, dependency := range a.dependencies {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[a] = visited
+		return nil
+	}
+	for i := range actions {
+		if err := visit(&actions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
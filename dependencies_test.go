@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []Action
+		wantErr string
+	}{
+		{"chain", []Action{{Name: "test", DependsOn: []string{"build"}}, {Name: "build", DependsOn: []string{"generate"}}, {Name: "generate"}}, ""},
+		{"unknown", []Action{{Name: "build", DependsOn: []string{"generate"}}}, `action "build": dependsOn: no action named "generate"`},
+		{"cycle", []Action{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"c"}}, {Name: "c", DependsOn: []string{"a"}}}, "dependency cycle: a -> b -> c -> a"},
+		{"self", []Action{{Name: "a", DependsOn: []string{"a"}}}, "dependency cycle: a -> a"},
+	}
+	for _, tt := range tests {
+		err := resolveDependencies(tt.actions, tt.actions)
+		if got := fmt.Sprint(err); tt.wantErr == "" && err != nil || tt.wantErr != "" && got != tt.wantErr {
+			t.Errorf("%s: resolveDependencies() = %v; want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// dependencies on actions that are not selected (e.g. with -skip) are dropped
+	all := []Action{{Name: "build", DependsOn: []string{"generate"}}, {Name: "generate"}}
+	selected := all[:1]
+	if err := resolveDependencies(selected, all); err != nil || len(selected[0].dependencies) != 0 {
+		t.Errorf("resolveDependencies() = %v with dependencies %v; want the skipped dependency dropped", err, selected[0].dependencies)
+	}
+}
+
+func TestDependencyOrdering(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+actions:
+- name: build
+  dependsOn: [generate]
+  shell: {command: echo build >> %s}
+- name: generate
+  shell: {command: sleep 0.2; echo generate >> %[1]s}
+`, out))
+	read := func() string {
+		data, _ := ioutil.ReadFile(out)
+		return string(data)
+	}
+	w.waitFor("the startup runs", func() bool { return strings.Count(read(), "\n") >= 2 })
+	w.write("a.txt", "a")
+	w.waitFor("the runs for the event", func() bool { return strings.Count(read(), "\n") >= 4 })
+	w.stop()
+	if got := read(); !strings.HasPrefix(got, "generate\nbuild\ngenerate\nbuild\n") {
+		t.Errorf("the actions ran in the order %q; want generate before each build", got)
+	}
+}
+
+func TestFailedDependencySkipsDependents(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+actions:
+- name: generate
+  exec: {command: ["false"]}
+- name: build
+  dependsOn: [generate]
+  shell: {command: echo build >> %s}
+`, out))
+	w.write("a.txt", "a")
+	w.waitFor("the runs for the event", func() bool { return len(w.completed()) >= 4 })
+	w.stop()
+	if data, _ := ioutil.ReadFile(out); len(data) != 0 {
+		t.Errorf("build ran %q after generate failed", data)
+	}
+	for _, result := range w.completed() {
+		if result["name"] == "build" && !strings.Contains(fmt.Sprint(result["error"]), `dependency "generate" failed`) {
+			t.Errorf("actionCompleted = %v; want build skipped", result)
+		}
+	}
+}
+
+func TestResolveSequence(t *testing.T) {
+	useConfig(t, configuration{Sequential: true})
+	actions := []Action{{Name: "generate"}, {Name: "build"}, {Name: "test"}}
+	if err := resolveSequence(actions); err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range actions {
+		if len(a.predecessors) != i {
+			t.Errorf("%s has %d predecessors; want %d", a.Name, len(a.predecessors), i)
+		}
+		for j, predecessor := range a.predecessors {
+			if predecessor != &actions[j] {
+				t.Errorf("predecessor %d of %s is %s; want %s", j, a.Name, predecessor.Name, actions[j].Name)
+			}
+		}
+	}
+
+	// a dependency declared later would wait for the action, and the action for it
+	actions = []Action{{Name: "build", DependsOn: []string{"generate"}}, {Name: "generate"}}
+	if err := resolveDependencies(actions, actions); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolveSequence(actions); fmt.Sprint(err) != `action "build": dependsOn: "generate" is declared after it (sequential is set)` {
+		t.Errorf("resolveSequence() = %v; want an error for the dependency declared later", err)
+	}
+
+	useConfig(t, configuration{})
+	if err := resolveSequence(actions); err != nil || actions[1].predecessors != nil {
+		t.Errorf("resolveSequence() = %v with predecessors %v; want none without sequential", err, actions[1].predecessors)
+	}
+}
+
+func TestSequentialFlag(t *testing.T) {
+	saved := sequential
+	sequential = true
+	defer func() { sequential = saved }()
+	useConfig(t, configuration{})
+	flagsToConfiguration()
+	if !config.Sequential {
+		t.Error("sequential is not set with -sequential")
+	}
+}
+
+func TestSequentialActions(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+sequential: true
+actions:
+- name: slow
+  shell: {command: sleep 0.3; echo slow >> %s}
+- name: failing
+  shell: {command: sleep 0.1; echo failing >> %[1]s; false}
+- name: fast
+  shell: {command: echo fast >> %[1]s}
+`, out))
+	read := func() string {
+		data, _ := ioutil.ReadFile(out)
+		return string(data)
+	}
+	w.waitFor("the startup runs", func() bool { return strings.Count(read(), "\n") >= 3 })
+	w.write("a.txt", "a")
+	w.waitFor("the runs for the event", func() bool { return strings.Count(read(), "\n") >= 6 })
+	w.stop()
+	// a failed run does not stop the actions declared after it
+	if got, want := read(), strings.Repeat("slow\nfailing\nfast\n", 2); got != want {
+		t.Errorf("the actions ran in the order %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// dispatcher passes the events of one generation of watchContext to its actions,
+// collecting them first for `globalDelay` if it is set. Each generation has its own,
+// so that events of a generation that is ending never reach the actions of the next one.
+type dispatcher struct {
+	ctx      context.Context
+	actions  []Action
+	debounce *debouncer // nil without `globalDelay`
+	// hashQueue holds the events waiting for their file to be hashed, so that hashing
+	// (with `contentHash`) does not hold up the event loop; nil without `contentHash`
+	hashQueue chan Event
+
+	mu      sync.Mutex
+	batches []*batch // the batches started by dispatch (see `batchWindow`) that may not have finished
+}
+
+func newDispatcher(ctx context.Context, actions []Action) *dispatcher {
+	d := &dispatcher{
+		ctx:      ctx,
+		actions:  actions,
+		debounce: newDebouncer(config.globalDelay, nil),
+	}
+	if config.ContentHash {
+		d.hashQueue = make(chan Event, contentHashQueueSize)
+	}
+	return d
+}
+
+// queueForHash queues the event for the `contentHash` check. All events after the
+// filters pass through the queue, so that they are reported in order.
+func (d *dispatcher) queueForHash(e Event) {
+	select {
+	case d.hashQueue <- e:
+	case <-d.ctx.Done():
+	}
+}
+
+// add dispatches the event, or adds it to the `globalDelay` debouncer
+func (d *dispatcher) add(e Event) {
+	if d.debounce == nil {
+		d.dispatch([]Event{e})
+		return
+	}
+	if started, _ := d.debounce.add(e); started {
+		onDebounce("waiting", nil, 1)
+	}
+}
+
+// run checks the content of the queued events, and dispatches the events collected
+// by the `globalDelay` debouncer, until the context is done
+func (d *dispatcher) run(wg *sync.WaitGroup) {
+	if d.hashQueue != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-d.ctx.Done():
+					return
+				case e := <-d.hashQueue:
+					if contentChanged(e) {
+						notify(d, e)
+					}
+				}
+			}
+		}()
+	}
+	if d.debounce == nil {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer d.debounce.stop()
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-d.debounce.ready():
+				events := dedupEvents(d.debounce.take())
+				onDebounce("firing", nil, len(events))
+				d.dispatch(events)
+			}
+		}
+	}()
+}
+
+// interruptBatches reports the batches that have not finished when the generation has ended,
+// e.g. since their runs were cancelled or their events discarded (see `batch.interrupt`)
+func (d *dispatcher) interruptBatches() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, b := range d.batches {
+		b.interrupt()
+	}
+	d.batches = nil
+}
+
+// dispatch triggers each matching action once with the events it matches
+// (with -once, only the first events that match any action are dispatched)
+func (d *dispatcher) dispatch(events []Event) {
+	matched := make([][]Event, len(d.actions))
+	triggered, runs := 0, 0
+	for i := range d.actions {
+		for _, e := range events {
+			if d.actions[i].Match(e) {
+				matched[i] = append(matched[i], e)
+			}
+		}
+		if len(matched[i]) == 0 {
+			continue
+		}
+		triggered++
+		if d.actions[i].PerFile {
+			// a perFile action runs once for each path
+			runs += len(splitByPath(matched[i]))
+		} else {
+			runs++
+		}
+	}
+	if triggered == 0 || (once && !startOnceRound(runs)) {
+		return
+	}
+	results := &dispatchRuns{}
+	for i := range matched {
+		for j := range matched[i] {
+			matched[i][j].dispatch = results
+		}
+	}
+	if config.batchWindow > 0 {
+		b := startBatch(events, matched)
+		d.mu.Lock()
+		unfinished := d.batches[:0]
+		for _, b := range d.batches {
+			b.mu.Lock()
+			if !b.finished {
+				unfinished = append(unfinished, b)
+			}
+			b.mu.Unlock()
+		}
+		d.batches = append(unfinished, b)
+		d.mu.Unlock()
+	}
+	// mark all triggered actions as running before any of them starts, so that actions
+	// wait for the dependencies triggered by the same events
+	for i := range d.actions {
+		if len(matched[i]) > 0 {
+			d.actions[i].state.queue(1)
+		}
+	}
+	for i := range d.actions {
+		if len(matched[i]) == 0 {
+			continue
+		}
+		select {
+		case d.actions[i].trigger <- matched[i]:
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
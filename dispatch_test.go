@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGlobalDelayFlushesOncePerBurst(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+globalDelay: 100ms
+actions:
+- name: build
+  exec: {command: ["true"]}
+- name: test
+  exec: {command: ["true"]}
+`)
+	w.waitFor("the initial runs", func() bool { return len(w.completed()) == 2 })
+	for burst := 1; burst <= 2; burst++ {
+		for i := 0; i < 5; i++ {
+			w.write(fmt.Sprintf("%d.txt", i), fmt.Sprint(burst))
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.waitFor(fmt.Sprintf("the runs for burst %d", burst), func() bool { return len(w.completed()) >= 2+2*burst })
+		time.Sleep(300 * time.Millisecond)
+		if n := len(w.completed()); n != 2+2*burst {
+			t.Fatalf("got %d runs after burst %d; want one run of each action per burst", n, burst)
+		}
+	}
+	// both actions run for the same, deduplicated events
+	started := w.stdout.recordsWith(t, "actionStarted")
+	for _, record := range started[2:] {
+		if path := record["actionStarted"].(map[string]interface{})["path"]; path != w.path("4.txt") {
+			t.Errorf("a run was for %v; want the whole burst, ending with %s", path, w.path("4.txt"))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// loadEnvFiles reads the given .env files in order; later files override earlier ones.
+// Variable references are expanded using the variables defined so far, falling back to `base`.
+func loadEnvFiles(paths []string, base map[string]string) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = parseEnvFile(f, env, base)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return env, nil
+}
+
+// parseEnvFile parses `KEY=VALUE` lines into env.
+//
+// Blank lines and lines starting with `#` are skipped, as is an `export ` prefix.
+// Single-quoted values are taken literally. Double-quoted values support the
+// escapes \n, \t, \", \\ and $-expansion. Unquoted values are trimmed, may be
+// followed by a ` #` comment, and support $-expansion.
+func parseEnvFile(r io.Reader, env, base map[string]string) error {
+	lookup := func(key string) string {
+		if v, ok := env[key]; ok {
+			return v
+		}
+		if v, ok := base[key]; ok {
+			return v
+		}
+		return os.Getenv(key)
+	}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return fmt.Errorf("line %d: expected KEY=VALUE", lineNumber)
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return fmt.Errorf("line %d: unterminated single quote", lineNumber)
+			}
+			value = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			unquoted, ok := unquoteEnvValue(value[1:])
+			if !ok {
+				return fmt.Errorf("line %d: unterminated double quote", lineNumber)
+			}
+			value = os.Expand(unquoted, lookup)
+		default:
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+			value = os.Expand(value, lookup)
+		}
+		env[key] = value
+	}
+	return scanner.Err()
+}
+
+// unquoteEnvValue returns the contents of a double-quoted value up to the closing quote
+func unquoteEnvValue(s string) (string, bool) {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return out.String(), true
+		case '\\':
+			if i+1 == len(s) {
+				return "", false
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			default:
+				out.WriteByte(s[i])
+			}
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	base := map[string]string{"BASE": "base"}
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{"plain", "A=1\nB = 2 \n", map[string]string{"A": "1", "B": "2"}, ""},
+		{"comments and blank lines", "# comment\n\nA=1 # trailing\nB=x#y\n", map[string]string{"A": "1", "B": "x#y"}, ""},
+		{"export prefix", "export A=1\n", map[string]string{"A": "1"}, ""},
+		{"single quotes are literal", `A='$B \n # x'`, map[string]string{"A": `$B \n # x`}, ""},
+		{"double quotes", `A="a \"b\"\n\t# c"`, map[string]string{"A": "a \"b\"\n\t# c"}, ""},
+		{"expansion", "A=1\nB=${A}2\nC=\"$B-$BASE\"\nD='$A'\n", map[string]string{"A": "1", "B": "12", "C": "12-base", "D": "$A"}, ""},
+		{"later lines override", "A=1\nA=2\n", map[string]string{"A": "2"}, ""},
+		{"empty value", "A=\n", map[string]string{"A": ""}, ""},
+		{"missing equals sign", "A=1\nB\n", nil, "line 2: expected KEY=VALUE"},
+		{"missing key", "=1\n", nil, "line 1: expected KEY=VALUE"},
+		{"unterminated single quote", "A='x\n", nil, "line 1: unterminated single quote"},
+		{"unterminated double quote", `A="x\"`, nil, "line 1: unterminated double quote"},
+	}
+	for _, tt := range tests {
+		env := make(map[string]string)
+		err := parseEnvFile(strings.NewReader(tt.content), env, base)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: parseEnvFile() = %v; want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseEnvFile() = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(env, tt.want) {
+			t.Errorf("%s: parseEnvFile() = %v; want %v", tt.name, env, tt.want)
+		}
+	}
+}
+
+func TestLoadEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := writeFile(t, dir, "first.env", "A=1\nB=1\n")
+	second := writeFile(t, dir, "second.env", "B=2\nC=$A$B\n")
+	env, err := loadEnvFiles([]string{first, second}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"A": "1", "B": "2", "C": "12"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("loadEnvFiles() = %v; want %v", env, want)
+	}
+	if _, err := loadEnvFiles([]string{first, filepath.Join(dir, "missing.env")}, nil); err == nil {
+		t.Error("loadEnvFiles() succeeded for a missing file")
+	}
+	invalid := writeFile(t, dir, "invalid.env", "A\n")
+	if _, err := loadEnvFiles([]string{invalid}, nil); err == nil || !strings.HasPrefix(err.Error(), invalid+": ") {
+		t.Errorf("loadEnvFiles() = %v; want an error naming the file", err)
+	}
+}
+
+func TestEnvFileMissingIsAConfigError(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.env")
+	tests := []configuration{
+		{EnvFile: stringList{missing}},
+		{Actions: []Action{{ActionExec: &ActionExec{Command: []string{"true"}, EnvFile: stringList{missing}}}}},
+		{Actions: []Action{{ActionShell: &ActionShell{Command: "true", EnvFile: stringList{missing}}}}},
+	}
+	for i, c := range tests {
+		useConfig(t, c)
+		if err := config.makeCanonical(); err == nil || !strings.Contains(err.Error(), missing) {
+			t.Errorf("%d: makeCanonical() = %v; want an error for the missing file", i, err)
+		}
+	}
+}
+
+func TestEnvFilePrecedence(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	writeFile(t, dir, "global.env", "A=global-file\nB=global-file\nC=global-file\nD=global-file\n")
+	writeFile(t, dir, "action.env", "C=action-file\nD=action-file\nE=$B\n")
+	w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+envFile: %[1]s/global.env
+env: {B: global-env, C: global-env}
+actions:
+- shell:
+    command: echo "$A $B $C $D $E" > %[1]s/out
+    envFile: %[1]s/action.env
+    env: {D: action-env}
+`, dir))
+	w.waitFor("the initial run", func() bool { return len(w.completed()) == 1 })
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// inline env takes precedence over envFile, and an action's over the configuration's
+	if got, want := strings.TrimSpace(string(data)), "global-file global-env action-file action-env global-env"; got != want {
+		t.Errorf("the command saw %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errorRepeatWindow is the period during which repeats of an error are collapsed
+var errorRepeatWindow = 5 * time.Second
+
+// errorRepeats are the recently reported errors, keyed by their JSON encoding.
+// It is guarded by stderrJSONMu.
+var errorRepeats = make(map[string]*errorRepeat)
+
+// errorRepeatsRunning counts the errorRepeatWindow timers that have fired and not yet finished
+var errorRepeatsRunning sync.WaitGroup
+
+// errorRepeat counts the suppressed repeats of an error until its window ends
+type errorRepeat struct {
+	count int
+	timer Timer
+}
+
+// errorRecord is an error as written to stderr; repeats collapsed into it are counted in `repeated`
+type errorRecord struct {
+	Error    interface{} `json:"error"`
+	Repeated int         `json:"repeated,omitempty"`
+	Seq      uint64      `json:"seq"`
+	ID       string      `json:"id"`
+}
+
+// writeErrorRecord writes the error to stderr with the number of its collapsed repeats
+func writeErrorRecord(err interface{}, repeated int) {
+	stderrRecord(func(n recordNumber) interface{} {
+		return errorRecord{Error: err, Repeated: repeated, Seq: n.Seq, ID: n.ID}
+	})
+}
+
+// reportError writes the error to stderr, unless the same error has been written
+// within errorRepeatWindow. Suppressed repeats are reported as a single record
+// with their count when the window ends.
+func reportError(err interface{}) {
+	key := errorKey(err)
+	stderrJSONMu.Lock()
+	if r, ok := errorRepeats[key]; ok {
+		r.count++
+		stderrJSONMu.Unlock()
+		return
+	}
+	r := &errorRepeat{}
+	errorRepeats[key] = r
+	errorRepeatsRunning.Add(1)
+	r.timer = clock.AfterFunc(errorRepeatWindow, func() {
+		defer errorRepeatsRunning.Done()
+		stderrJSONMu.Lock()
+		if errorRepeats[key] != r {
+			// dropped by resetErrorRepeats
+			stderrJSONMu.Unlock()
+			return
+		}
+		delete(errorRepeats, key)
+		stderrJSONMu.Unlock()
+		if r.count > 0 {
+			writeErrorRecord(err, r.count)
+		}
+	})
+	stderrJSONMu.Unlock()
+	writeErrorRecord(err, 0)
+}
+
+// resetErrorRepeats drops the suppressed repeats of the recently reported errors, stopping
+// their errorRepeatWindow timers and waiting for those already running to finish, so that
+// no repeats are written afterwards
+func resetErrorRepeats() {
+	stderrJSONMu.Lock()
+	for key, r := range errorRepeats {
+		if r.timer.Stop() {
+			errorRepeatsRunning.Done()
+		}
+		delete(errorRepeats, key)
+	}
+	stderrJSONMu.Unlock()
+	errorRepeatsRunning.Wait()
+}
+
+func errorKey(err interface{}) string {
+	if b, e := json.Marshal(err); e == nil {
+		return string(b)
+	}
+	return fmt.Sprint(err)
+}
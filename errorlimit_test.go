@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestReportErrorCollapsesRepeats(t *testing.T) {
+	c := useFakeClock(t)
+	stderr := captureStderr(t)
+	reported := func() []map[string]interface{} { return stderr.recordsWith(t, "error") }
+	for i := 0; i < 10; i++ {
+		onError(errors.New("repeated"))
+	}
+	for i := 0; i < 3; i++ {
+		onError(fmt.Sprintf("distinct %d", i))
+	}
+	errs := reported()
+	var got []interface{}
+	for _, e := range errs {
+		got = append(got, e["error"])
+	}
+	want := []interface{}{"repeated", "distinct 0", "distinct 1", "distinct 2"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("reported %q immediately; want each distinct error once", got)
+	}
+	for _, e := range errs {
+		if _, ok := e["repeated"]; ok {
+			t.Errorf("the first report %v has a repeat count", e)
+		}
+	}
+
+	c.advance(errorRepeatWindow)
+	repeats := reported()[len(want):]
+	if len(repeats) != 1 || repeats[0]["error"] != want[0] || repeats[0]["repeated"] != 9.0 {
+		t.Fatalf("got %v after the window; want one record of the 9 repeats", repeats)
+	}
+
+	// after the window, the error is reported again immediately
+	onError(errors.New("repeated"))
+	if errs := reported(); len(errs) != len(want)+2 {
+		t.Errorf("got %d error records; want the error reported again after the window", len(errs))
+	}
+}
+
+func TestResetErrorRepeats(t *testing.T) {
+	c := useFakeClock(t)
+	stderr := captureStderr(t)
+	onError("repeated")
+	onError("repeated")
+	resetErrorRepeats()
+	c.advance(errorRepeatWindow)
+	if errs := stderr.recordsWith(t, "error"); len(errs) != 1 {
+		t.Errorf("got errors %v; want the repeat dropped by the reset", errs)
+	}
+	// the reset error is reported again immediately
+	onError("repeated")
+	if errs := stderr.recordsWith(t, "error"); len(errs) != 2 {
+		t.Errorf("got errors %v; want the error reported again after the reset", errs)
+	}
+}
@@ -7,4 +7,8 @@ type Event struct {
 	Name string
 	Op   fsnotify.Op
 	Time string
+
+	batch    *batch        // the batch the event was dispatched in, if `batchWindow` is set
+	dispatch *dispatchRuns // the results of the runs the event was dispatched to
+	result   *runResult    // the result of the run an `after` hook runs for
 }
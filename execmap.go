@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadExecMapFiles reads the given exec map files (YAML or JSON objects mapping extensions to
+// commands) in order; later files override earlier ones, and `inline` overrides all of them.
+func loadExecMapFiles(paths []string, inline map[string]string) (map[string]string, error) {
+	if len(paths) == 0 {
+		return inline, nil
+	}
+	execMap := make(map[string]string)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return inline, err
+		}
+		var entries map[string]string
+		if err := yaml.UnmarshalStrict(data, &entries); err != nil {
+			return inline, fmt.Errorf("%s: %v", path, err)
+		}
+		for ext, command := range entries {
+			execMap[ext] = command
+		}
+	}
+	for ext, command := range inline {
+		execMap[ext] = command
+	}
+	return execMap, nil
+}
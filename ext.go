@@ -6,5 +6,10 @@ import (
 )
 
 func ext(path string) string {
-	return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	return strings.ToLower(caseExt(path))
+}
+
+// caseExt returns the extension of the path without the leading dot, preserving its case
+func caseExt(path string) string {
+	return strings.TrimPrefix(filepath.Ext(path), ".")
 }
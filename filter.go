@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/fsnotify/fsnotify"
@@ -8,53 +11,244 @@ import (
 
 // Filter is an filesystem event filter
 type Filter struct {
-	ExtensionsCSV string   `json:"ext,omitempty" yaml:"ext,omitempty"`
-	Extensions    []string `json:"exts,omitempty" yaml:"exts,flow,omitempty"`
-	OpsCSV        string   `json:"op,omitempty" yaml:"op,omitempty"`
-	Ops           []string `json:"ops,omitempty" yaml:"ops,flow,omitempty"`
+	ExtensionsCSV string     `json:"ext,omitempty" yaml:"ext,omitempty"`
+	Extensions    []string   `json:"exts,omitempty" yaml:"exts,flow,omitempty"`
+	OpsCSV        string     `json:"op,omitempty" yaml:"op,omitempty"`
+	Ops           []string   `json:"ops,omitempty" yaml:"ops,flow,omitempty"`
+	Only          string     `json:"only,omitempty" yaml:"only,omitempty"`
+	ContentTypes  stringList `json:"contentType,omitempty" yaml:"contentType,flow,omitempty"`
+	MatchMode     string     `json:"matchMode,omitempty" yaml:"matchMode,omitempty"`
+	CaseSensitive *bool      `json:"caseSensitive,omitempty" yaml:"caseSensitive,omitempty"`
 
+	extensions   map[string]bool
+	suffixes     []string
+	excluded     extensionSet
+	ops          map[fsnotify.Op]bool
+	contentTypes []string
+}
+
+const (
+	matchAny = "any"
+	matchAll = "all"
+)
+
+var matchModes = []string{matchAny, matchAll}
+
+// Match returns whether the event matches the filter according to its `matchMode`,
+// or defaultMode if it has none: with `all`, each of `exts`, `ops` and `contentType` that is
+// specified must match; with `any`, at least one of them must. An event for the
+// wrong kind of path (see `only`), or with an excluded extension (`!ext`), never matches.
+func (f *Filter) Match(e Event, defaultMode string) bool {
+	ok, _ := f.explain(e, defaultMode)
+	return ok
+}
+
+// explain is Match, additionally returning which predicates rejected a non-matching event
+func (f *Filter) explain(e Event, defaultMode string) (ok bool, reason string) {
+	if !f.matchKind(e) {
+		return false, "only: " + f.Only
+	}
+	name := f.fold(filepath.Base(e.Name))
+	if f.excluded.match(name) {
+		return false, "exts: excluded"
+	}
+	var specified int
+	var failed []string
+	if f.extensions != nil || f.suffixes != nil {
+		specified++
+		if !f.matchExtension(name) {
+			failed = append(failed, "exts")
+		}
+	}
+	if f.ops != nil {
+		specified++
+		if !f.matchOp(e.Op) {
+			failed = append(failed, "ops")
+		}
+	}
+	if len(f.contentTypes) > 0 {
+		specified++
+		if !f.matchContentType(e) {
+			failed = append(failed, "contentType")
+		}
+	}
+	mode := f.MatchMode
+	if mode == "" {
+		mode = defaultMode
+	}
+	switch {
+	case specified == 0:
+		return true, ""
+	case mode == matchAll && len(failed) > 0:
+		return false, strings.Join(failed, ", ")
+	case mode != matchAll && len(failed) == specified:
+		return false, strings.Join(failed, ", ")
+	}
+	return true, ""
+}
+
+// matchExtension returns whether the (case-folded) base name has one of the included extensions or suffixes
+func (f *Filter) matchExtension(name string) bool {
+	return f.extensions[caseExt(name)] || hasAnySuffix(name, f.suffixes)
+}
+
+// matchOp returns whether any of the event's op bits is one of the filter's ops, so that
+// events with combined ops (e.g. `create|write`) match a filter for either of them
+func (f *Filter) matchOp(op fsnotify.Op) bool {
+	for bit := range f.ops {
+		if op&bit != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchContentType returns whether the event is a create or write of a readable file
+// whose sniffed content type starts with one of the `contentType` prefixes
+func (f *Filter) matchContentType(e Event) bool {
+	if e.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		return false
+	}
+	contentType, ok := contentTypes.of(e.Name)
+	if !ok {
+		return false
+	}
+	return hasAnyPrefix(strings.ToLower(contentType), f.contentTypes)
+}
+
+// isCaseSensitive returns whether the filter preserves case when comparing extensions
+func (f *Filter) isCaseSensitive() bool {
+	return f.CaseSensitive != nil && *f.CaseSensitive
+}
+
+// fold returns s lower-cased, unless the filter is case-sensitive
+func (f *Filter) fold(s string) string {
+	if f.isCaseSensitive() {
+		return s
+	}
+	return strings.ToLower(s)
+}
+
+// extensionSet is a set of filename extensions and filename suffixes
+type extensionSet struct {
 	extensions map[string]bool
-	ops        map[fsnotify.Op]bool
+	suffixes   []string
+}
+
+func (s *extensionSet) add(pattern string, caseSensitive bool) {
+	ext, suffix := parseExtensionPattern(pattern, caseSensitive)
+	if suffix {
+		s.suffixes = append(s.suffixes, ext)
+		return
+	}
+	if s.extensions == nil {
+		s.extensions = make(map[string]bool)
+	}
+	s.extensions[ext] = true
+}
+
+func (s *extensionSet) empty() bool {
+	return s.extensions == nil && s.suffixes == nil
+}
+
+// match returns whether the (case-folded) base name has one of the extensions or suffixes
+func (s *extensionSet) match(name string) bool {
+	if s.empty() {
+		return false
+	}
+	return s.extensions[caseExt(name)] || hasAnySuffix(name, s.suffixes)
 }
 
-// Match returns whether an event satisfies `all` or `any` of its predicates.
-func (f *Filter) Match(e Event) (all, any bool) {
-	ext := ext(e.Name)
-	ext = strings.ToLower(ext)
-	extensionsOk := f.extensions[ext]
-	opsOk := f.ops[e.Op]
-	empty := f.extensions == nil && f.ops == nil
-	all = extensionsOk && opsOk
-	all = all || empty
-	any = extensionsOk || opsOk
-	return
+// parseExtensionPattern parses an entry of `exts`. Entries that contain a dot other
+// than a leading one (e.g. `_test.go`, `.tar.gz`) are filename suffixes, matched
+// literally against the end of the file name. Other entries are extensions.
+// Unless caseSensitive is set, the pattern is lower-cased.
+func parseExtensionPattern(pattern string, caseSensitive bool) (ext string, suffix bool) {
+	pattern = strings.TrimSpace(pattern)
+	if !caseSensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	ext = strings.TrimPrefix(pattern, ".")
+	if strings.Contains(ext, ".") {
+		return pattern, true
+	}
+	return ext, false
 }
 
-func (f *Filter) makeCanonical() {
+func hasAnySuffix(name string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	onlyFile = "file"
+	onlyDir  = "dir"
+	onlyAny  = "any"
+)
+
+var onlyChoices = []string{onlyFile, onlyDir, onlyAny}
+
+// matchKind returns whether the event's path is of the kind given by `only`.
+// Removed and renamed paths no longer exist, so they match any kind.
+func (f *Filter) matchKind(e Event) bool {
+	if f.Only == "" || f.Only == onlyAny || e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		return true
+	}
+	isDir := watched.hasDir(e.Name)
+	if !isDir {
+		info, err := os.Stat(e.Name)
+		if err != nil {
+			return true
+		}
+		isDir = info.IsDir()
+	}
+	return isDir == (f.Only == onlyDir)
+}
+
+func (f *Filter) makeCanonical() error {
 	if f == nil {
-		return
+		return nil
 	}
 	if len(f.ExtensionsCSV) > 0 {
-		for _, v := range strings.Split(f.ExtensionsCSV, ",") {
-			f.Extensions = append(f.Extensions, v)
+		values, err := splitCSV(f.ExtensionsCSV)
+		if err != nil {
+			return fmt.Errorf("ext: %v", err)
 		}
+		f.Extensions = append(f.Extensions, values...)
 		f.ExtensionsCSV = ""
 	}
 	if len(f.OpsCSV) > 0 {
-		for _, v := range strings.Split(f.OpsCSV, ",") {
-			f.Ops = append(f.Ops, v)
+		values, err := splitCSV(f.OpsCSV)
+		if err != nil {
+			return fmt.Errorf("op: %v", err)
 		}
+		f.Ops = append(f.Ops, values...)
 		f.OpsCSV = ""
 	}
-	if len(f.Extensions) > 0 {
-		f.extensions = make(map[string]bool, len(f.Extensions))
-		for _, ext := range f.Extensions {
-			ext = strings.TrimSpace(ext)
-			ext = strings.TrimPrefix(ext, ".")
-			ext = strings.ToLower(ext)
-			f.extensions[ext] = true
+	var included extensionSet
+	f.excluded = extensionSet{}
+	for _, pattern := range f.Extensions {
+		pattern = strings.TrimSpace(pattern)
+		if strings.HasPrefix(pattern, "!") {
+			f.excluded.add(strings.TrimPrefix(pattern, "!"), f.isCaseSensitive())
+			continue
 		}
+		included.add(pattern, f.isCaseSensitive())
 	}
+	f.extensions, f.suffixes = included.extensions, included.suffixes
 	if len(f.Ops) > 0 {
 		f.ops = make(map[fsnotify.Op]bool, len(f.Ops))
 		for _, opName := range f.Ops {
@@ -64,4 +258,31 @@ func (f *Filter) makeCanonical() {
 			}
 		}
 	}
+	f.contentTypes = nil
+	for _, prefix := range f.ContentTypes {
+		if prefix = strings.ToLower(strings.TrimSpace(prefix)); prefix != "" {
+			f.contentTypes = append(f.contentTypes, prefix)
+		}
+	}
+	switch f.Only {
+	case "", onlyFile, onlyDir, onlyAny:
+	default:
+		return fmt.Errorf("invalid value for `only`: %q (choices: %v)", f.Only, onlyChoices)
+	}
+	switch f.MatchMode {
+	case "", matchAny, matchAll:
+	default:
+		return fmt.Errorf("invalid value for `matchMode`: %q (choices: %v)", f.MatchMode, matchModes)
+	}
+	return nil
+}
+
+// constrains returns whether the canonical filter has any predicate. A filter without one
+// matches every event, so it is rejected as an ignore filter, where it would ignore everything.
+func (f *Filter) constrains() bool {
+	return f.extensions != nil || f.suffixes != nil || !f.excluded.empty() || f.ops != nil ||
+		len(f.contentTypes) > 0 || (f.Only != "" && f.Only != onlyAny)
 }
+
+// errEmptyIgnore is the error for an ignore filter without predicates (see constrains)
+var errEmptyIgnore = fmt.Errorf("the filter has no `exts`, `ops`, `only` or `contentType`, so it would ignore all events")
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestFilterOnly(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "a.txt", "a")
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+	tests := []struct {
+		path string
+		op   fsnotify.Op
+		// whether the event matches `only: file`, `only: dir` and `only: any`
+		file, dir, any bool
+	}{
+		{file, fsnotify.Write, true, false, true},
+		{file, fsnotify.Create, true, false, true},
+		{sub, fsnotify.Create, false, true, true},
+		{sub, fsnotify.Chmod, false, true, true},
+		// removed and renamed paths no longer exist, so they are of any kind
+		{file, fsnotify.Remove, true, true, true},
+		{sub, fsnotify.Rename, true, true, true},
+		{missing, fsnotify.Remove, true, true, true},
+		{missing, fsnotify.Write, true, true, true},
+	}
+	for _, tt := range tests {
+		for only, want := range map[string]bool{onlyFile: tt.file, onlyDir: tt.dir, onlyAny: tt.any, "": true} {
+			f := Filter{Only: only}
+			if err := f.makeCanonical(); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(Event{Name: tt.path, Op: tt.op}, matchAny); got != want {
+				t.Errorf("only %q: Match(%s %v) = %v; want %v", only, filepath.Base(tt.path), tt.op, got, want)
+			}
+		}
+	}
+
+	// directories known to be watched are not stat-ed
+	saved := watched
+	watched = newWatchSet()
+	defer func() { watched = saved }()
+	watched.addDir(missing)
+	f := Filter{Only: onlyDir}
+	f.makeCanonical()
+	if !f.Match(Event{Name: missing, Op: fsnotify.Create}, matchAny) {
+		t.Error("a watched directory did not match only: dir")
+	}
+
+	if err := (&Filter{Only: "files"}).makeCanonical(); err == nil {
+		t.Error("accepted an invalid value for only")
+	}
+}
+
+func TestFilterExtensions(t *testing.T) {
+	caseSensitive := true
+	tests := []struct {
+		exts          []string
+		caseSensitive *bool
+		match         []string
+		noMatch       []string
+	}{
+		{[]string{"go"}, nil, []string{"main.go", "main_test.go", "MAIN.GO", "dir.go/a.go"}, []string{"main.gox", "go", "main.txt"}},
+		{[]string{".go", " md "}, nil, []string{"a.go", "README.md"}, []string{"a.txt"}},
+		{[]string{"go", "!_test.go"}, nil, []string{"main.go"}, []string{"main_test.go", "MAIN_TEST.GO", "a.txt"}},
+		{[]string{"!_test.go"}, nil, []string{"main.go", "a.txt"}, []string{"main_test.go"}},
+		{[]string{"!txt", "!md"}, nil, []string{"a.go"}, []string{"a.txt", "b.md"}},
+		// entries with an inner dot are suffixes, including the part before the dot
+		{[]string{".tar.gz"}, nil, []string{"a.tar.gz"}, []string{"a.gz", "a.tar"}},
+		{[]string{"_test.go"}, nil, []string{"a_test.go"}, []string{"a.go", "test.go"}},
+		{[]string{"go", "!_test.go"}, &caseSensitive, []string{"main.go", "MAIN_TEST.go"}, []string{"MAIN.GO", "main_test.go"}},
+	}
+	for _, tt := range tests {
+		f := Filter{Extensions: tt.exts, CaseSensitive: tt.caseSensitive}
+		if err := f.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range tt.match {
+			if !f.Match(Event{Name: name, Op: fsnotify.Write}, matchAny) {
+				t.Errorf("exts %q: %s does not match", tt.exts, name)
+			}
+		}
+		for _, name := range tt.noMatch {
+			if f.Match(Event{Name: name, Op: fsnotify.Write}, matchAny) {
+				t.Errorf("exts %q: %s matches", tt.exts, name)
+			}
+		}
+	}
+}
+
+func TestFilterCombinedOps(t *testing.T) {
+	createWrite := fsnotify.Create | fsnotify.Write
+	tests := []struct {
+		ops  []string
+		op   fsnotify.Op
+		want bool
+	}{
+		{[]string{"create"}, createWrite, true},
+		{[]string{"write"}, createWrite, true},
+		{[]string{"create", "write"}, createWrite, true},
+		{[]string{"remove"}, createWrite, false},
+		{[]string{"chmod"}, createWrite, false},
+		{[]string{"write"}, fsnotify.Write | fsnotify.Chmod, true},
+		{[]string{"create"}, fsnotify.Write, false},
+	}
+	for _, tt := range tests {
+		f := Filter{Ops: tt.ops}
+		if err := f.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		for _, mode := range matchModes {
+			if got := f.Match(Event{Name: "a.txt", Op: tt.op}, mode); got != tt.want {
+				t.Errorf("ops %v (%s): Match(%v) = %v; want %v", tt.ops, mode, tt.op, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFilterMatchMode(t *testing.T) {
+	goWrite := Event{Name: "a.go", Op: fsnotify.Write}
+	goCreate := Event{Name: "a.go", Op: fsnotify.Create}
+	txtWrite := Event{Name: "a.txt", Op: fsnotify.Write}
+	txtCreate := Event{Name: "a.txt", Op: fsnotify.Create}
+	tests := []struct {
+		mode, defaultMode string
+		match             []Event
+		noMatch           []Event
+	}{
+		{"", matchAny, []Event{goWrite, goCreate, txtWrite}, []Event{txtCreate}},
+		{"", matchAll, []Event{goWrite}, []Event{goCreate, txtWrite, txtCreate}},
+		{matchAny, matchAll, []Event{goWrite, goCreate, txtWrite}, []Event{txtCreate}},
+		{matchAll, matchAny, []Event{goWrite}, []Event{goCreate, txtWrite, txtCreate}},
+	}
+	for _, tt := range tests {
+		f := Filter{Extensions: []string{"go"}, Ops: []string{"write"}, MatchMode: tt.mode}
+		if err := f.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range tt.match {
+			if !f.Match(e, tt.defaultMode) {
+				t.Errorf("matchMode %q (default %q): %s %v does not match", tt.mode, tt.defaultMode, e.Name, e.Op)
+			}
+		}
+		for _, e := range tt.noMatch {
+			if f.Match(e, tt.defaultMode) {
+				t.Errorf("matchMode %q (default %q): %s %v matches", tt.mode, tt.defaultMode, e.Name, e.Op)
+			}
+		}
+	}
+	if err := (&Filter{MatchMode: "both"}).makeCanonical(); err == nil {
+		t.Error("accepted an invalid matchMode")
+	}
+}
+
+func TestFilterMatchModeDefaults(t *testing.T) {
+	captureStdout(t)
+	captureStderr(t)
+	// the filter defaults to any, and ignores default to all
+	useConfig(t, configuration{
+		Filter: Filter{Extensions: []string{"go"}, Ops: []string{"write"}},
+		Ignore: []Filter{{Extensions: []string{"go"}, Ops: []string{"create"}}},
+	})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		e    Event
+		want bool
+	}{
+		{Event{Name: "a.go", Op: fsnotify.Write}, true},
+		{Event{Name: "a.txt", Op: fsnotify.Write}, true},
+		{Event{Name: "a.go", Op: fsnotify.Create}, false},
+		{Event{Name: "a.txt", Op: fsnotify.Create}, false},
+		{Event{Name: "a.go", Op: fsnotify.Remove}, true},
+	} {
+		e := tt.e
+		if got := shouldNotify(&e); got != tt.want {
+			t.Errorf("shouldNotify(%s %v) = %v; want %v", tt.e.Name, tt.e.Op, got, tt.want)
+		}
+	}
+}
+
+func TestSingleDimensionIgnores(t *testing.T) {
+	captureStdout(t)
+	captureStderr(t)
+	tests := []struct {
+		name    string
+		ignore  Filter
+		ignored []Event
+		kept    []Event
+	}{
+		{"extension only", Filter{Extensions: []string{"log"}},
+			[]Event{{Name: "a.log", Op: fsnotify.Write}, {Name: "b.log", Op: fsnotify.Create}},
+			[]Event{{Name: "a.go", Op: fsnotify.Write}}},
+		{"op only", Filter{Ops: []string{"remove"}},
+			[]Event{{Name: "a.go", Op: fsnotify.Remove}, {Name: "a.log", Op: fsnotify.Remove}},
+			[]Event{{Name: "a.go", Op: fsnotify.Write}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ignore := tt.ignore
+			useConfig(t, configuration{Ignore: []Filter{tt.ignore}})
+			if err := config.makeCanonical(); err != nil {
+				t.Fatal(err)
+			}
+			action := Action{Ignore: &ignore}
+			if err := action.Ignore.makeCanonical(); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range tt.ignored {
+				if event := e; shouldNotify(&event) {
+					t.Errorf("ignores: %s %v is not excluded", e.Name, e.Op)
+				}
+				if action.Match(e) {
+					t.Errorf("action ignore: %s %v is not excluded", e.Name, e.Op)
+				}
+			}
+			for _, e := range tt.kept {
+				if event := e; !shouldNotify(&event) {
+					t.Errorf("ignores: %s %v is excluded", e.Name, e.Op)
+				}
+				if !action.Match(e) {
+					t.Errorf("action ignore: %s %v is excluded", e.Name, e.Op)
+				}
+			}
+		})
+	}
+}
+
+func TestCaseSensitiveExtensions(t *testing.T) {
+	for _, tt := range []struct {
+		caseSensitive string
+		want          []string // the files the `exts: [C]` action runs for
+	}{
+		{"false", []string{"a.c", "b.C"}},
+		{"true", []string{"b.C"}},
+	} {
+		t.Run("caseSensitive: "+tt.caseSensitive, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+caseSensitive: %s
+actions:
+- exts: [C]
+  shell: {command: 'xargs -n1 basename >> %s', stdin: "{{lines .Paths}}", ignoreSignals: true}
+`, tt.caseSensitive, out))
+			for _, name := range []string{"a.c", "b.C", "c.h"} {
+				w.write(name, name)
+				w.waitFor("the event for "+name, func() bool { return len(w.eventsFor(w.path(name))) > 0 })
+			}
+			w.waitFor("the runs", func() bool { return len(w.completed()) >= len(tt.want) })
+			time.Sleep(100 * time.Millisecond)
+			w.stop()
+			data, _ := ioutil.ReadFile(out)
+			ran := map[string]bool{}
+			for _, name := range strings.Fields(string(data)) {
+				ran[name] = true
+			}
+			var got []string
+			for name := range ran {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("the action ran for %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerboseFilterReasons(t *testing.T) {
+	useVerbose(t, true)
+	stderr := captureStderr(t)
+	useConfig(t, configuration{
+		Filter:      Filter{Extensions: []string{"go"}, Ops: []string{"write"}, MatchMode: matchAll},
+		Ignore:      []Filter{{Extensions: []string{"_test.go"}}},
+		IgnoreWatch: []string{"**/vendor/*"},
+		Paths:       watchTargetList{{Path: "src"}, {Path: "docs", Filter: Filter{Extensions: []string{"md"}}}},
+	})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	watchRoots.Lock()
+	savedRoots := watchRoots.targets
+	watchRoots.Unlock()
+	setWatchRoots(config.Paths)
+	defer setWatchRoots(savedRoots)
+	tests := []struct {
+		name          string
+		op            fsnotify.Op
+		stage, reason string
+	}{
+		{"src/a.go", fsnotify.Chmod, "chmod", ""},
+		{"src/a.txt", fsnotify.Write, "filter", "exts"},
+		{"src/a.go", fsnotify.Create, "filter", "ops"},
+		{"src/a.txt", fsnotify.Remove, "filter", "exts, ops"},
+		{"docs/a.txt", fsnotify.Write, "paths[1]", "exts"},
+		{"src/a_test.go", fsnotify.Write, "ignores[0]", ""},
+		{"src/vendor/a.go", fsnotify.Write, "ignore", "**/vendor/*"},
+	}
+	for _, tt := range tests {
+		e := Event{Name: tt.name, Op: tt.op}
+		before := len(stderr.recordsWith(t, "info"))
+		if shouldNotify(&e) {
+			t.Errorf("%s %v was not filtered", tt.name, tt.op)
+			continue
+		}
+		infos := stderr.recordsWith(t, "info")
+		if len(infos) != before+1 {
+			t.Errorf("%s %v: got %d info records; want one", tt.name, tt.op, len(infos)-before)
+			continue
+		}
+		info := infos[before]["info"].(map[string]interface{})
+		if info["filtered"] != tt.name || info["stage"] != tt.stage || (info["reason"] != nil || tt.reason != "") && info["reason"] != tt.reason {
+			t.Errorf("%s %v: filtered record %v; want stage %q and reason %q", tt.name, tt.op, info, tt.stage, tt.reason)
+		}
+	}
+	e := Event{Name: "src/a.go", Op: fsnotify.Write}
+	if !shouldNotify(&e) {
+		t.Error("a matching event was filtered")
+	}
+
+	useVerbose(t, false)
+	before := len(stderr.records(t))
+	e = Event{Name: "src/a.txt", Op: fsnotify.Write}
+	shouldNotify(&e)
+	if len(stderr.records(t)) != before {
+		t.Error("reported a filtered event without -verbose")
+	}
+}
+
+func TestExcludedExtensionFlags(t *testing.T) {
+	savedExts, savedCSV := extensions, extensionsCSV
+	defer func() { extensions, extensionsCSV = savedExts, savedCSV }()
+	tests := []struct {
+		ext        []string
+		exts       string
+		match, not []string
+	}{
+		{nil, "!log,!tmp", []string{"a.go", "Makefile"}, []string{"a.log", "b.tmp"}},
+		{[]string{"go", "!_test.go"}, "", []string{"main.go"}, []string{"main_test.go", "a.txt"}},
+		{[]string{"!_test.go"}, "go, md", []string{"main.go", "README.md"}, []string{"main_test.go", "a.txt"}},
+	}
+	for _, tt := range tests {
+		extensions, extensionsCSV = stringsSetVar{}, tt.exts
+		for _, ext := range tt.ext {
+			extensions.Set(ext)
+		}
+		useConfig(t, configuration{})
+		flagsToConfiguration()
+		if err := config.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range tt.match {
+			if !config.Filter.Match(Event{Name: name, Op: fsnotify.Write}, matchAny) {
+				t.Errorf("-ext %q -exts %q: %s does not match", tt.ext, tt.exts, name)
+			}
+		}
+		for _, name := range tt.not {
+			if config.Filter.Match(Event{Name: name, Op: fsnotify.Write}, matchAny) {
+				t.Errorf("-ext %q -exts %q: %s matches", tt.ext, tt.exts, name)
+			}
+		}
+	}
+}
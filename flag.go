@@ -1,10 +1,56 @@
 package main
 
 import (
+	"encoding/csv"
 	"fmt"
+	"regexp"
 	"strings"
 )
 
+// csvSpaceAfterQuote matches whitespace between a closing quote and the next separator,
+// which encoding/csv would otherwise reject
+var csvSpaceAfterQuote = regexp.MustCompile(`"[ \t]+(,|$)`)
+
+// splitCSV splits a comma-separated list, trimming whitespace around each entry.
+// Entries may be double-quoted to contain commas (e.g. `a, "b,c"`); empty entries are dropped.
+func splitCSV(s string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(csvSpaceAfterQuote.ReplaceAllString(strings.TrimSpace(s), `"$1`)))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	var out []string
+	for _, record := range records {
+		for _, v := range record {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+	}
+	return out, nil
+}
+
+// stringsVarCSV collects the entries of comma-separated values (see splitCSV); malformed CSV is a flag error
+type stringsVarCSV struct {
+	Value []string
+}
+
+// Set implements the flag.Value interface.
+func (so *stringsVarCSV) Set(vs string) error {
+	values, err := splitCSV(vs)
+	if err != nil {
+		return err
+	}
+	so.Value = append(so.Value, values...)
+	return nil
+}
+
+func (so *stringsVarCSV) String() string {
+	return strings.Join(so.Value, ",")
+}
+
 type enumVar struct {
 	Choices []string
 	Value   string
@@ -91,7 +137,11 @@ type enumSetVarCSV struct{ enumSetVar }
 
 func (so *enumSetVarCSV) Set(vs string) error {
 	if len(vs) > 0 {
-		for _, v := range strings.Split(vs, ",") {
+		values, err := splitCSV(vs)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
 			if err := so.enumSetVar.Set(v); err != nil {
 				return err
 			}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCSV(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"a,b", []string{"a", "b"}},
+		{" a , b ", []string{"a", "b"}},
+		{`"a,b", c`, []string{"a,b", "c"}},
+		{` "with, comma" , "quoted"  ,plain`, []string{"with, comma", "quoted", "plain"}},
+		{`"say ""hi""",x`, []string{`say "hi"`, "x"}},
+		{"a,,b,", []string{"a", "b"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := splitCSV(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCSV(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := splitCSV(`"unterminated`); err == nil {
+		t.Error("splitCSV accepted an unterminated quote")
+	}
+}
+
+func TestStringsVarCSVFlag(t *testing.T) {
+	var watches stringsVarCSV
+	flags := flag.NewFlagSet("watchfs", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.Var(&watches, "watches", "")
+	if err := flags.Parse([]string{"-watches", `src, "data/a,b"`, "-watches", "docs"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"src", "data/a,b", "docs"}; !reflect.DeepEqual(watches.Value, want) {
+		t.Errorf("watches = %q; want %q", watches.Value, want)
+	}
+	if err := flags.Parse([]string{"-watches", `"src`}); err == nil {
+		t.Error("accepted malformed CSV")
+	}
+}
+
+func TestFilterCSV(t *testing.T) {
+	f := Filter{ExtensionsCSV: ` go, "tar.gz" `, OpsCSV: "create , write"}
+	if err := f.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.go", "a.tar.gz"} {
+		if !f.matchExtension(name) {
+			t.Errorf("%s does not match exts %q", name, f.ExtensionsCSV)
+		}
+	}
+	if len(f.ops) != 2 {
+		t.Errorf("ops = %v; want create and write", f.ops)
+	}
+	f = Filter{ExtensionsCSV: `"go`}
+	if err := f.makeCanonical(); err == nil {
+		t.Error("accepted malformed exts CSV")
+	}
+}
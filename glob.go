@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// matchIgnoreGlob returns whether the path matches an `ignore` glob.
+// Like extensions, globs are compared ignoring case unless `caseSensitive` is set.
+// A `**` segment matches any number of path segments, and a glob ending in `/`
+// matches directories below a watched path, and everything in them.
+func matchIgnoreGlob(pattern, path string) bool {
+	fold := config.CaseSensitive == nil || !*config.CaseSensitive
+	if fold {
+		pattern = strings.ToLower(pattern)
+	}
+	if strings.HasSuffix(pattern, "/") {
+		return matchDirGlob(strings.TrimSuffix(pattern, "/"), path, fold)
+	}
+	if fold {
+		path = strings.ToLower(path)
+	}
+	return matchGlob(pattern, path)
+}
+
+// matchDirGlob returns whether the pattern matches one of the directories leading to the
+// path below its watched path, or the path itself if it is a directory. Watched paths
+// themselves never match, so that e.g. `-watch vendor/lib` watches that directory.
+func matchDirGlob(pattern, path string, fold bool) bool {
+	root, rel := "", path
+	if t, _ := watchRootOf(path); t != nil {
+		if r, err := filepath.Rel(t.Path, path); err == nil {
+			root, rel = t.Path, r
+		}
+	}
+	if rel == "." {
+		return false
+	}
+	names := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range names {
+		dir := filepath.Join(root, filepath.Join(names[:i+1]...))
+		candidate := dir
+		if fold {
+			candidate = strings.ToLower(candidate)
+		}
+		if !matchGlob(pattern, candidate) {
+			continue
+		}
+		if i < len(names)-1 {
+			return true
+		}
+		info, err := os.Lstat(dir)
+		return err == nil && info.IsDir()
+	}
+	return false
+}
+
+// matchGlob matches the path against the pattern segment by segment (see filepath.Match);
+// a `**` segment matches any number of segments
+func matchGlob(pattern, path string) bool {
+	return matchGlobSegments(strings.Split(filepath.ToSlash(pattern), "/"), strings.Split(filepath.ToSlash(path), "/"))
+}
+
+func matchGlobSegments(pattern, names []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(names); i >= 0; i-- {
+				if matchGlobSegments(pattern[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, err := filepath.Match(pattern[0], names[0]); err != nil || !ok {
+			return false
+		}
+		pattern, names = pattern[1:], names[1:]
+	}
+	return len(names) == 0
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestMatchIgnoreGlob(t *testing.T) {
+	caseSensitive := true
+	tests := []struct {
+		pattern, path string
+		caseSensitive *bool
+		want          bool
+	}{
+		{"*.log", "a.log", nil, true},
+		{"*.log", "a.txt", nil, false},
+		{"**/tmp/*", "src/tmp/a", nil, true},
+		{"**/tmp/*", "tmp/a", nil, true},
+		{"**/tmp/*", "src/tmp/a/b", nil, false},
+		{"*.LOG", "a.log", nil, true},
+		{"*.log", "A.LOG", nil, true},
+		{"*.LOG", "a.log", &caseSensitive, false},
+		{"*.LOG", "a.LOG", &caseSensitive, true},
+		{"**/Build/*", "src/build/a", &caseSensitive, false},
+		{"**/Build/*", "src/Build/a", &caseSensitive, true},
+	}
+	for _, tt := range tests {
+		useConfig(t, configuration{Filter: Filter{CaseSensitive: tt.caseSensitive}})
+		if got := matchIgnoreGlob(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchIgnoreGlob(%q, %q) with caseSensitive %v = %v; want %v", tt.pattern, tt.path, tt.caseSensitive != nil, got, tt.want)
+		}
+	}
+}
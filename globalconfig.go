@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// globalConfigBasenames are the names of the global config file in the watchfs config directory
+var globalConfigBasenames = []string{
+	"config.yaml",
+	"config.json",
+	"config.toml",
+}
+
+// globalConfigAppendedKeys are the list-valued config keys whose global entries are kept
+// in addition to the project's, rather than being replaced by them
+var globalConfigAppendedKeys = map[string]bool{
+	"ignore":      true,
+	"ignores":     true,
+	"selfIgnore":  true,
+	"envFile":     true,
+	"execMapFile": true,
+}
+
+// globalConfigDir returns the watchfs config directory: $XDG_CONFIG_HOME/watchfs,
+// or $HOME/.config/watchfs if XDG_CONFIG_HOME is not set
+func globalConfigDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "watchfs")
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "watchfs")
+	}
+	return ""
+}
+
+// findGlobalConfig returns the path of the global config file, or "" if there is none
+func findGlobalConfig() string {
+	dir := globalConfigDir()
+	if dir == "" {
+		return ""
+	}
+	for _, name := range globalConfigBasenames {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// mergeGlobal fills in the settings the configuration leaves unset from the global configuration.
+// Maps are merged (the configuration's entries win), the lists in globalConfigAppendedKeys
+// are concatenated (global entries first), and all other settings of the global
+// configuration apply only if the configuration does not set them. A key set in the
+// project's config file counts as set even if its value is the zero value (e.g. `poll: false`).
+func (c *configuration) mergeGlobal(global configuration) {
+	mergeFields(reflect.ValueOf(c).Elem(), reflect.ValueOf(global), c.keys)
+}
+
+func mergeFields(local, global reflect.Value, keys map[string]bool) {
+	t := local.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		l, g := local.Field(i), global.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			mergeFields(l, g, keys)
+			continue
+		}
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch {
+		case field.Type.Kind() == reflect.Map && !g.IsNil():
+			if l.IsNil() {
+				l.Set(reflect.MakeMap(field.Type))
+			}
+			for _, k := range g.MapKeys() {
+				if !l.MapIndex(k).IsValid() {
+					l.SetMapIndex(k, g.MapIndex(k))
+				}
+			}
+		case field.Type.Kind() == reflect.Slice && globalConfigAppendedKeys[key] && g.Len() > 0:
+			l.Set(reflect.AppendSlice(reflect.AppendSlice(reflect.MakeSlice(field.Type, 0, l.Len()+g.Len()), g), l))
+		case keys[key]:
+		case reflect.DeepEqual(l.Interface(), reflect.Zero(field.Type).Interface()):
+			l.Set(g)
+		}
+	}
+}
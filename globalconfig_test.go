@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlobalConfigDir(t *testing.T) {
+	tests := []struct {
+		xdg, home string
+		want      string
+	}{
+		{"/xdg", "/home/u", filepath.Join("/xdg", "watchfs")},
+		{"", "/home/u", filepath.Join("/home/u", ".config", "watchfs")},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		t.Setenv("XDG_CONFIG_HOME", tt.xdg)
+		t.Setenv("HOME", tt.home)
+		if got := globalConfigDir(); got != tt.want {
+			t.Errorf("XDG_CONFIG_HOME=%q HOME=%q: globalConfigDir() = %q; want %q", tt.xdg, tt.home, got, tt.want)
+		}
+	}
+}
+
+func TestFindGlobalConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", home)
+	if path := findGlobalConfig(); path != "" {
+		t.Errorf("findGlobalConfig() = %q without a global config", path)
+	}
+	dir := filepath.Join(home, ".config", "watchfs")
+	jsonPath := writeFile(t, dir, "config.json", "{}")
+	if path := findGlobalConfig(); path != jsonPath {
+		t.Errorf("findGlobalConfig() = %q; want %q", path, jsonPath)
+	}
+	yamlPath := writeFile(t, dir, "config.yaml", "")
+	if path := findGlobalConfig(); path != yamlPath {
+		t.Errorf("findGlobalConfig() = %q; want %q, which comes first", path, yamlPath)
+	}
+}
+
+func TestGlobalConfigMerge(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", home)
+	writeFile(t, filepath.Join(home, ".config", "watchfs"), "config.yaml", `
+exts: [go]
+ignore: ["**/node_modules"]
+env: {EDITOR: vim, GOFLAGS: -mod=vendor}
+delay: 1s
+poll: true
+`)
+	project := writeFile(t, t.TempDir(), "watchfs.yaml", `
+paths: [src]
+ignore: [build]
+env: {GOFLAGS: -race}
+delay: 2s
+poll: false
+`)
+	savedPath, savedAbs, savedNoGlobal, savedGlobalPath := configPath, configPathAbs, noGlobalConfig, globalConfigPath
+	configPath, noGlobalConfig = project, false
+	t.Cleanup(func() {
+		configPath, configPathAbs, noGlobalConfig, globalConfigPath = savedPath, savedAbs, savedNoGlobal, savedGlobalPath
+	})
+	useConfig(t, configuration{})
+	loadProjectConfig()
+	loadGlobalConfig()
+	if want := filepath.Join(home, ".config", "watchfs", "config.yaml"); globalConfigPath != want {
+		t.Errorf("globalConfigPath = %q; want %q", globalConfigPath, want)
+	}
+	got := struct {
+		Paths      []string
+		Extensions []string
+		Ignore     []string
+		Env        map[string]string
+		Delay      string
+		Poll       bool
+	}{config.Paths.paths(), config.Extensions, config.IgnoreWatch, config.Env, config.Delay, config.Poll}
+	want := got
+	want.Paths = []string{"src"}
+	want.Extensions = []string{"go"}                   // not set by the project
+	want.Ignore = []string{"**/node_modules", "build"} // appended
+	want.Env = map[string]string{"EDITOR": "vim", "GOFLAGS": "-race"}
+	want.Delay = "2s"
+	want.Poll = false // set by the project, even though it is the zero value
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %+v; want %+v", got, want)
+	}
+
+	noGlobalConfig = true
+	useConfig(t, configuration{})
+	loadProjectConfig()
+	loadGlobalConfig()
+	if globalConfigPath != "" || len(config.Extensions) != 0 {
+		t.Errorf("merged the global config %q with -no-global-config", globalConfigPath)
+	}
+}
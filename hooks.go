@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// runResult is the result of an action run, passed to its hooks
+type runResult struct {
+	exitCode int
+}
+
+// withResult returns copies of the events carrying the result of the run they triggered.
+// A run without events (the initial run) is represented by a single event without a path.
+func withResult(events []Event, err error) []Event {
+	result := &runResult{exitCode: exitCode(err)}
+	if len(events) == 0 {
+		return []Event{{result: result}}
+	}
+	out := make([]Event, len(events))
+	for i, e := range events {
+		e.result = result
+		out[i] = e
+	}
+	return out
+}
+
+// runHooks runs the action's hooks for a run of the action that ended with err:
+// `onSuccess` or `onFailure` (neither if the run was cancelled), and then `after`
+func (a *Action) runHooks(ctx context.Context, events []Event, err error, cancelled bool) {
+	switch {
+	case cancelled:
+	case err == nil:
+		a.runHook(ctx, a.OnSuccess, "onSuccess", events, err)
+	default:
+		a.runHook(ctx, a.OnFailure, "onFailure", events, err)
+	}
+	a.runHook(ctx, a.After, "after", events, err)
+}
+
+// runHook runs a hook of the action for a run that ended with err, and then the hook's own hooks
+func (a *Action) runHook(ctx context.Context, hook *Action, field string, events []Event, err error) {
+	if hook == nil || ctx.Err() != nil {
+		return
+	}
+	events = withResult(events, err)
+	start := time.Now()
+	hookErr := hook.Run(ctx, events)
+	duration := time.Since(start)
+	if hookErr != nil && ctx.Err() == nil {
+		onError(struct {
+			Message string  `json:"message"`
+			Action  *Action `json:"action"`
+		}{
+			Message: fmt.Sprintf("%s: %v", field, hookErr),
+			Action:  a,
+		})
+	}
+	onActionCompleted(hook, events, duration, hookErr)
+	hook.runHooks(ctx, events, hookErr, false)
+}
+
+// makeHookCanonical prepares a hook of the action; unnamed hooks are named after the action
+func (a *Action) makeHookCanonical(hook *Action, field string) error {
+	if hook == nil {
+		return nil
+	}
+	if hook.Name == "" {
+		hook.Name = field
+		if a.Name != "" {
+			hook.Name = a.Name + "." + field
+		}
+	}
+	if hook.CaseSensitive == nil {
+		hook.CaseSensitive = a.CaseSensitive
+	}
+	if hook.interactive() {
+		return fmt.Errorf("%s: hooks cannot be interactive", field)
+	}
+	if len(hook.DependsOn) > 0 {
+		return fmt.Errorf("%s: hooks cannot have dependsOn", field)
+	}
+	if err := hook.makeCanonical(); err != nil {
+		return fmt.Errorf("%s: %v", field, err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runWithHooks runs the action for the events, like the per-action goroutine, followed by its hooks
+func runWithHooks(ctx context.Context, a *Action, events []Event) {
+	err := a.Run(ctx, events)
+	a.runHooks(ctx, events, err, false)
+}
+
+func TestAfterHook(t *testing.T) {
+	captureStdout(t)
+	out := filepath.Join(t.TempDir(), "out")
+	for _, exit := range []int{0, 3} {
+		useConfig(t, configuration{Actions: []Action{{
+			ActionShell: &ActionShell{Command: fmt.Sprintf("exit %d", exit)},
+			After: &Action{
+				ActionExec: &ActionExec{Command: []string{"sh", "-c", `echo "$WATCHFS_EXIT_CODE {{.ExitCode}} $WATCHFS_PATH" >> ` + out}, Template: true},
+			},
+		}}})
+		if err := config.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		a := &config.Actions[0]
+		if a.After.Name != "after" {
+			t.Errorf("the hook is named %q; want it named after its field", a.After.Name)
+		}
+		runWithHooks(context.Background(), a, []Event{{Name: "a.go", Time: "t"}})
+		// the initial run has no events
+		runWithHooks(context.Background(), a, nil)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0 0 a.go\n0 0 \n3 3 a.go\n3 3 \n"; string(data) != want {
+		t.Errorf("the hook saw %q; want %q", data, want)
+	}
+}
+
+func TestAfterHookCompleted(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- name: build
+  exec: {command: ["false"]}
+  after: {exec: {command: ["true"]}}
+`)
+	w.waitFor("the hook", func() bool {
+		for _, result := range w.completed() {
+			if result["name"] == "build.after" {
+				return true
+			}
+		}
+		return false
+	})
+	w.stop()
+	var names []interface{}
+	for _, result := range w.completed() {
+		names = append(names, result["name"])
+	}
+	if len(names) < 2 || names[0] != "build" || names[1] != "build.after" {
+		t.Errorf("completed %v; want the hook to complete after the action", names)
+	}
+}
+
+func TestConditionalHooks(t *testing.T) {
+	captureStdout(t)
+	captureStderr(t)
+	dir := t.TempDir()
+	record := func(name string) *Action {
+		return &Action{ActionShell: &ActionShell{Command: fmt.Sprintf("echo %s >> %s", name, filepath.Join(dir, "out"))}}
+	}
+	tests := []struct {
+		command   string
+		cancelled bool
+		want      string
+	}{
+		{"exit 0", false, "onSuccess\nnested\nafter\n"},
+		{"exit 1", false, "onFailure\nafter\n"},
+		{"exit 1", true, "after\n"},
+	}
+	for _, tt := range tests {
+		onSuccess := record("onSuccess")
+		onSuccess.After = record("nested")
+		useConfig(t, configuration{Actions: []Action{{
+			ActionShell: &ActionShell{Command: tt.command},
+			OnSuccess:   onSuccess,
+			OnFailure:   record("onFailure"),
+			After:       record("after"),
+		}}})
+		if err := config.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		a := &config.Actions[0]
+		err := a.Run(context.Background(), nil)
+		a.runHooks(context.Background(), nil, err, tt.cancelled)
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "out"))
+		if string(data) != tt.want {
+			t.Errorf("%s (cancelled: %v): ran %q; want %q", tt.command, tt.cancelled, data, tt.want)
+		}
+		os.Remove(filepath.Join(dir, "out"))
+	}
+
+	// no hooks run once watchfs is stopping
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config.Actions[0].runHooks(ctx, nil, nil, false)
+	if _, err := os.Stat(filepath.Join(dir, "out")); err == nil {
+		t.Error("ran hooks after the context was cancelled")
+	}
+}
+
+func TestHooksCannotBeInteractive(t *testing.T) {
+	useConfig(t, configuration{Actions: []Action{{
+		ActionExec: &ActionExec{Command: []string{"make"}},
+		OnFailure:  &Action{ActionShell: &ActionShell{Command: "cat", Interactive: true}},
+	}}})
+	if err := config.makeCanonical(); err == nil || !strings.Contains(err.Error(), "onFailure: hooks cannot be interactive") {
+		t.Errorf("makeCanonical() = %v; want an error for the interactive hook", err)
+	}
+}
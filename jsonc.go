@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// isJSONPath returns whether the config file at the path is named as JSON
+func isJSONPath(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// stripJSONComments replaces `//` line comments and `/* */` block comments outside of
+// double-quoted strings with spaces, keeping newlines so that error line numbers still match.
+// A comment must start at the beginning of a line or after whitespace, `,`, `[` or `{`,
+// so that e.g. the `//` in an unquoted YAML URL is kept.
+func stripJSONComments(data []byte) []byte {
+	out := append([]byte{}, data...)
+	inString := false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		switch {
+		case inString:
+			switch c {
+			case '\\':
+				i++
+			case '"', '\n':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(out) && (out[i+1] == '/' || out[i+1] == '*') && commentMayStartAfter(out, i):
+			end := len(out)
+			if out[i+1] == '/' {
+				if n := bytes.IndexByte(out[i:], '\n'); n >= 0 {
+					end = i + n
+				}
+			} else if n := bytes.Index(out[i+2:], []byte("*/")); n >= 0 {
+				end = i + 2 + n + 2
+			}
+			for j := i; j < end; j++ {
+				if out[j] != '\n' {
+					out[j] = ' '
+				}
+			}
+			i = end - 1
+		}
+	}
+	return out
+}
+
+func commentMayStartAfter(data []byte, i int) bool {
+	if i == 0 {
+		return true
+	}
+	switch data[i-1] {
+	case ' ', '\t', '\r', '\n', ',', '[', '{':
+		return true
+	}
+	return false
+}
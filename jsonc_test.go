@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestStripJSONComments(t *testing.T) {
+	tests := []struct {
+		data, want string
+	}{
+		{`{"a": 1} // comment`, `{"a": 1}           `},
+		{"// comment\n{}", "          \n{}"},
+		{"{/* a\nb */\"a\": 1}", "{    \n    \"a\": 1}"},
+		{`["//", "/* */"]`, `["//", "/* */"]`},
+		{`["\"//", 1]`, `["\"//", 1]`},
+		{`[1,// comment`, `[1,          `},
+		{"url: http://example.com", "url: http://example.com"},
+		{"{/* unterminated", "{               "},
+	}
+	for _, tt := range tests {
+		if got := string(stripJSONComments([]byte(tt.data))); got != tt.want {
+			t.Errorf("stripJSONComments(%q) = %q; want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestLoadCommentedJSON(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "watchfs.json", `{
+  // the sources
+  "paths": ["src"], /* and only Go:
+                       other files are generated */
+  "exts": ["go"],
+  "actions": [
+    {"name": "build", "exec": &build {"command": ["make", "build"]}},
+    {"name": "again", "exec": *build}
+  ]
+}`)
+	var c configuration
+	if err := c.load(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	build := &ActionExec{Command: []string{"make", "build"}}
+	want := configuration{
+		Paths:   watchTargetsOf("src"),
+		Filter:  Filter{Extensions: []string{"go"}},
+		Actions: []Action{{Name: "build", ActionExec: build}, {Name: "again", ActionExec: build}},
+		keys:    map[string]bool{"paths": true, "exts": true, "actions": true},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("loaded %+v; want %+v", c, want)
+	}
+
+	// unknown keys are still an error
+	path = writeFile(t, dir, "watchfs.json", `{
+  // a typo
+  "pahts": ["src"]
+}`)
+	if err := (&configuration{}).load(path, ""); err == nil || !strings.Contains(err.Error(), "pahts") {
+		t.Errorf("loading an unknown key: error %v; want one naming the key", err)
+	}
+}
+
+func TestSelfReloadCommentedJSON(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+`)
+	savedCancel := setGenerationCancel(nil)
+	defer setGenerationCancel(savedCancel)
+	// the next generations load a commented JSON config instead, which reloads itself when written
+	jsonConfig := func(delay string) {
+		writeFile(t, filepath.Dir(configPath), "watchfs.json", fmt.Sprintf(`{
+  // the watched directory
+  "paths": [%q],
+  "delay": %q /* changed on reload */
+}`, w.dir, delay))
+	}
+	jsonConfig("10ms")
+	reload := func(w *watchfsTest, name string) *watchfsTest {
+		previous := configPath
+		configPath = filepath.Join(filepath.Dir(configPath), name)
+		setGenerationCancel(w.cancel)
+		// the config file is outside the watched directory, so its event is delivered directly
+		onEvent(newDispatcher(context.Background(), nil), Event{Name: previous, Op: fsnotify.Write})
+		select {
+		case <-w.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the generation did not end when the config file was written")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		next := &watchfsTest{t: t, dir: w.dir, stdout: w.stdout, stderr: w.stderr, cancel: cancel, done: make(chan struct{})}
+		t.Cleanup(next.stop)
+		go func() {
+			defer close(next.done)
+			watchContext(ctx)
+		}()
+		watching := len(w.infos("watching"))
+		next.waitFor("the next generation", func() bool { return len(next.infos("watching")) > watching })
+		return next
+	}
+	w = reload(w, "watchfs.json")
+	if config.Delay != "10ms" {
+		t.Errorf("delay = %q after loading the JSON config; want 10ms", config.Delay)
+	}
+	jsonConfig("20ms")
+	w = reload(w, "watchfs.json")
+	if config.Delay != "20ms" {
+		t.Errorf("delay = %q after the JSON config reloaded itself; want 20ms", config.Delay)
+	}
+	for _, info := range w.infos("start")[1:] {
+		if start := info["start"].(map[string]interface{}); start["reason"] != startSelfReload || start["config"] != configPathAbs {
+			t.Errorf("start record %v; want a self-reload of %s", start, configPathAbs)
+		}
+	}
+}
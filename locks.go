@@ -1,52 +1,190 @@
 package main
 
-import "sync"
+import (
+	"context"
+	"sort"
+	"sync"
+)
 
-// Locks is a set of named mutexes
+// Locks is a set of named readers/writer locks
 type Locks struct {
-	Map map[string]*sync.Mutex
-	mu  sync.RWMutex
+	Map map[string]*rwSemaphore
+	mu  sync.Mutex
 }
 
 // Init initializes the lock map
 func (l *Locks) Init() {
-	l.Map = make(map[string]*sync.Mutex)
+	l.Map = make(map[string]*rwSemaphore)
 }
 
-// Lock locks the mutexes with the given names
+// Lock locks the mutexes with the given names for writing
 func (l *Locks) Lock(names []string) {
-	if len(names) == 0 {
-		return
-	}
-	var wg sync.WaitGroup
-	for _, name := range names {
-		name := name
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			l.mu.Lock()
-			lock, ok := l.Map[name]
-			if !ok {
-				lock = &sync.Mutex{}
-				l.Map[name] = lock
+	l.LockAll(context.Background(), names, nil)
+}
+
+// Unlock unlocks the mutexes with the given names for writing
+func (l *Locks) Unlock(names []string) {
+	l.UnlockAll(names, nil)
+}
+
+// RLock locks the mutexes with the given names for reading
+func (l *Locks) RLock(names []string) {
+	l.LockAll(context.Background(), nil, names)
+}
+
+// RUnlock unlocks the mutexes with the given names for reading
+func (l *Locks) RUnlock(names []string) {
+	l.UnlockAll(nil, names)
+}
+
+// LockAll locks the `write` mutexes for writing and the `read` mutexes for reading.
+// A name in both sets is locked for writing only. Mutexes are acquired in sorted
+// name order, so that concurrent callers cannot deadlock each other.
+// If the context is done before all locks are acquired, the locks acquired so far
+// are released and the context's error is returned.
+func (l *Locks) LockAll(ctx context.Context, write, read []string) error {
+	order := lockOrder(write, read)
+	for i, name := range order {
+		if err := l.get(name.name).acquire(ctx, name.write); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				l.get(order[j].name).release(order[j].write)
 			}
-			l.mu.Unlock()
-			lock.Lock()
-		}()
+			return err
+		}
 	}
-	wg.Wait()
+	return nil
 }
 
-// Unlock unlocks the mutexes with the given names
-func (l *Locks) Unlock(names []string) {
-	if len(names) == 0 {
-		return
+// UnlockAll unlocks mutexes locked by LockAll with the same arguments
+func (l *Locks) UnlockAll(write, read []string) {
+	order := lockOrder(write, read)
+	for i := len(order) - 1; i >= 0; i-- {
+		l.get(order[i].name).release(order[i].write)
+	}
+}
+
+func (l *Locks) get(name string) *rwSemaphore {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock, ok := l.Map[name]
+	if !ok {
+		lock = newRWSemaphore()
+		l.Map[name] = lock
+	}
+	return lock
+}
+
+type lockName struct {
+	name  string
+	write bool
+}
+
+func lockOrder(write, read []string) (out []lockName) {
+	modes := make(map[string]bool, len(write)+len(read))
+	for _, name := range read {
+		modes[name] = false
+	}
+	for _, name := range write {
+		modes[name] = true
+	}
+	for name, write := range modes {
+		out = append(out, lockName{name: name, write: write})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].name < out[j].name
+	})
+	return
+}
+
+// rwSemaphore is a readers/writer lock whose acquisition can be cancelled.
+// Waiting writers block new readers, so that writers are not starved.
+type rwSemaphore struct {
+	mu             sync.Mutex
+	readers        int
+	writer         bool
+	writersWaiting int
+	changed        chan struct{}
+}
+
+func newRWSemaphore() *rwSemaphore {
+	return &rwSemaphore{changed: make(chan struct{})}
+}
+
+func (s *rwSemaphore) acquire(ctx context.Context, write bool) error {
+	s.mu.Lock()
+	if write {
+		s.writersWaiting++
+		defer func() {
+			s.mu.Lock()
+			s.writersWaiting--
+			s.notify()
+			s.mu.Unlock()
+		}()
 	}
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-	for _, name := range names {
-		if lock, ok := l.Map[name]; ok {
-			lock.Unlock()
+	for {
+		free := !s.writer && (s.readers == 0 || !write) && (write || s.writersWaiting == 0)
+		if free {
+			if write {
+				s.writer = true
+			} else {
+				s.readers++
+			}
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
+		s.mu.Lock()
+	}
+}
+
+func (s *rwSemaphore) release(write bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if write {
+		s.writer = false
+	} else if s.readers > 0 {
+		s.readers--
+	}
+	s.notify()
+}
+
+// notify wakes up all waiters; must be called with s.mu held
+func (s *rwSemaphore) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// semaphore limits the number of concurrent holders; a nil semaphore is unlimited
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s == nil {
+		return
 	}
+	<-s
 }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// holder records how many goroutines hold a lock at the same time
+type holder struct {
+	mu              sync.Mutex
+	readers, writer int
+	maxReaders      int
+	overlap         bool // a writer held the lock together with another holder
+}
+
+func (h *holder) hold(write bool) {
+	h.mu.Lock()
+	if write {
+		h.writer++
+	} else {
+		h.readers++
+	}
+	if h.writer > 1 || h.writer > 0 && h.readers > 0 {
+		h.overlap = true
+	}
+	if h.readers > h.maxReaders {
+		h.maxReaders = h.readers
+	}
+	h.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	h.mu.Lock()
+	if write {
+		h.writer--
+	} else {
+		h.readers--
+	}
+	h.mu.Unlock()
+}
+
+func TestLocksReadersOverlap(t *testing.T) {
+	var l Locks
+	l.Init()
+	var h holder
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			l.RLock([]string{"a"})
+			defer l.RUnlock([]string{"a"})
+			h.hold(false)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if h.maxReaders < 2 {
+		t.Errorf("at most %d readers held the lock at once; want them to overlap", h.maxReaders)
+	}
+}
+
+func TestLocksWriterExcludes(t *testing.T) {
+	tests := []struct {
+		name        string
+		write, read [][]string // the locks taken by each goroutine
+	}{
+		{"writers", [][]string{{"a"}, {"a"}, {"a"}}, [][]string{nil, nil, nil}},
+		{"writer and readers", [][]string{{"a"}, nil, nil, {"a"}}, [][]string{nil, {"a"}, {"a"}, nil}},
+		{"a name in both sets is locked for writing", [][]string{{"a"}, {"a"}}, [][]string{{"a"}, {"a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l Locks
+			l.Init()
+			var h holder
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := range tt.write {
+				write, read := tt.write[i], tt.read[i]
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					if err := l.LockAll(context.Background(), write, read); err != nil {
+						t.Error(err)
+						return
+					}
+					defer l.UnlockAll(write, read)
+					h.hold(len(write) > 0)
+				}()
+			}
+			close(start)
+			wg.Wait()
+			if h.overlap {
+				t.Error("a writer held the lock together with another holder")
+			}
+		})
+	}
+}
+
+func TestLocksDoNotDeadlock(t *testing.T) {
+	var l Locks
+	l.Init()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(2)
+			// the same locks, listed in opposite orders and modes
+			go func() {
+				defer wg.Done()
+				l.LockAll(context.Background(), []string{"a", "b"}, []string{"c"})
+				l.UnlockAll([]string{"a", "b"}, []string{"c"})
+			}()
+			go func() {
+				defer wg.Done()
+				l.LockAll(context.Background(), []string{"c", "b"}, []string{"a"})
+				l.UnlockAll([]string{"c", "b"}, []string{"a"})
+			}()
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlocked")
+	}
+}
+
+func TestLocksLockAllCancelled(t *testing.T) {
+	var l Locks
+	l.Init()
+	l.Lock([]string{"b"})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.LockAll(ctx, []string{"a", "b"}, nil); err != context.DeadlineExceeded {
+		t.Fatalf("LockAll() = %v; want %v", err, context.DeadlineExceeded)
+	}
+	// "a" was released when "b" could not be acquired
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.LockAll(ctx, []string{"a"}, nil); err != nil {
+		t.Errorf("LockAll(a) = %v after the cancelled LockAll", err)
+	}
+}
+
+func TestLocksWaitingWriterBlocksNewReaders(t *testing.T) {
+	var l Locks
+	l.Init()
+	l.RLock([]string{"a"})
+	locked := make(chan struct{})
+	go func() {
+		l.Lock([]string{"a"})
+		close(locked)
+	}()
+	time.Sleep(20 * time.Millisecond) // let the writer wait
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.LockAll(ctx, nil, []string{"a"}); err == nil {
+		t.Fatal("a new reader acquired the lock while a writer was waiting")
+	}
+	l.RUnlock([]string{"a"})
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the writer did not acquire the lock")
+	}
+}
+
+func TestLockOrder(t *testing.T) {
+	got := lockOrder([]string{"c", "a"}, []string{"b", "a"})
+	want := []lockName{{"a", true}, {"b", false}, {"c", true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lockOrder() = %v; want %v", got, want)
+	}
+}
+
+func TestActionLockTimeout(t *testing.T) {
+	stdout := captureStdout(t)
+	c := configuration{LockTimeout: "20", Actions: []Action{{
+		Locks:      []string{"timeout-test"},
+		ActionExec: &ActionExec{Command: []string{"true"}},
+	}}}
+	if err := c.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, c)
+	a := &config.Actions[0]
+	if a.LockTimeout != "20ms" || a.lockTimeout != 20*time.Millisecond {
+		t.Fatalf("lockTimeout = %q (%v); want the configuration's 20ms", a.LockTimeout, a.lockTimeout)
+	}
+	actionLocks.Lock([]string{"timeout-test"})
+	start := time.Now()
+	err := a.Run(context.Background(), []Event{{Name: "a.txt"}})
+	actionLocks.Unlock([]string{"timeout-test"})
+	if err == nil || !strings.Contains(err.Error(), "skipped run") {
+		t.Fatalf("Run() = %v; want the run to be skipped", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() returned after %v", elapsed)
+	}
+	if started := stdout.recordsWith(t, "actionStarted"); len(started) != 0 {
+		t.Errorf("the action was started: %v", started)
+	}
+	if err := a.Run(context.Background(), []Event{{Name: "a.txt"}}); err != nil {
+		t.Errorf("Run() = %v once the lock is free", err)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	if s := newSemaphore(0); s != nil {
+		t.Errorf("newSemaphore(0) = %v; want unlimited", s)
+	}
+	var unlimited semaphore
+	for i := 0; i < 10; i++ {
+		if err := unlimited.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unlimited.release()
+	s := newSemaphore(2)
+	s.acquire(context.Background())
+	s.acquire(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("acquire() = %v with both slots taken; want %v", err, context.DeadlineExceeded)
+	}
+	s.release()
+	if err := s.acquire(context.Background()); err != nil {
+		t.Errorf("acquire() = %v after a release", err)
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	// each run counts the runs whose marker files exist while it runs
+	command := fmt.Sprintf(`cd %s && touch running.$$ && ls running.* | wc -l >> counts && sleep 0.1 && rm running.$$`, dir)
+	yaml := "paths: [$DIR]\nmaxConcurrency: 2\nactions:\n"
+	for i := 0; i < 6; i++ {
+		yaml += fmt.Sprintf("- shell: {command: %q}\n", command)
+	}
+	w := startWatchfs(t, yaml)
+	w.waitFor("the initial runs", func() bool { return len(w.completed()) == 6 })
+	data, err := ioutil.ReadFile(filepath.Join(dir, "counts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	max := 0
+	for _, line := range strings.Fields(string(data)) {
+		var n int
+		fmt.Sscan(line, &n)
+		if n > max {
+			max = n
+		}
+	}
+	if max > 2 {
+		t.Errorf("%d actions ran at once; want at most 2", max)
+	}
+	if max < 2 {
+		t.Errorf("the actions did not run concurrently")
+	}
+}
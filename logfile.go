@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// jsonLogFile is an append-only JSON Lines file.
+// The file is re-opened when it has been removed or replaced (e.g. by log rotation),
+// and when a write fails.
+type jsonLogFile struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	info os.FileInfo
+}
+
+func openJSONLogFile(path string) (*jsonLogFile, error) {
+	l := &jsonLogFile{path: path}
+	if err := l.reopen(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Encode appends the JSON encoding of v as a single line
+func (l *jsonLogFile) Encode(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil || l.replaced() {
+		if err := l.reopen(); err != nil {
+			return err
+		}
+	}
+	if _, err := l.f.Write(data); err != nil {
+		if err := l.reopen(); err != nil {
+			return err
+		}
+		_, err = l.f.Write(data)
+		return err
+	}
+	return nil
+}
+
+// Close closes the underlying file
+func (l *jsonLogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+func (l *jsonLogFile) replaced() bool {
+	info, err := os.Stat(l.path)
+	return err != nil || !os.SameFile(info, l.info)
+}
+
+func (l *jsonLogFile) reopen() error {
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.info = info
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readJSONLines decodes the records of a JSON Lines file
+func readJSONLines(t *testing.T, path string) (records []map[string]interface{}) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestJSONLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchfs.log")
+	l, err := openJSONLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	steps := []struct {
+		name   string
+		before func()
+		want   []string // the values of the records in the file afterwards
+	}{
+		{"append", func() {}, []string{"a"}},
+		{"removed", func() { os.Remove(path) }, []string{"b"}},
+		{"replaced", func() { os.Rename(path, path+".1"); ioutil.WriteFile(path, []byte(`{"v":"new"}`+"\n"), 0644) }, []string{"new", "c"}},
+		{"closed", func() { l.Close() }, []string{"new", "c", "d"}},
+	}
+	for i, step := range steps {
+		step.before()
+		if err := l.Encode(map[string]string{"v": string(rune('a' + i))}); err != nil {
+			t.Fatalf("%s: Encode() = %v", step.name, err)
+		}
+		var got []string
+		for _, record := range readJSONLines(t, path) {
+			got = append(got, record["v"].(string))
+		}
+		if strings.Join(got, ",") != strings.Join(step.want, ",") {
+			t.Errorf("%s: the file has %v; want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestLogFileRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchfs.log")
+	l, err := openJSONLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pending error repeats would be written to the log file while it is replaced
+	resetErrorRepeats()
+	saved := logFile
+	logFile = l
+	t.Cleanup(func() {
+		resetErrorRepeats()
+		logFile = saved
+		l.Close()
+	})
+	w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- exec: {command: ["true"]}
+`)
+	w.write("a.txt", "a")
+	w.waitFor("the run for the event", func() bool { return len(w.completed()) >= 2 })
+	onError("an error for " + path) // repeated errors are reported once per window
+	w.stop()
+
+	records := readJSONLines(t, path)
+	for _, kind := range []string{"op", "error", "info", "actionCompleted"} {
+		found := false
+		for _, record := range records {
+			found = found || record[kind] != nil
+		}
+		if !found {
+			t.Errorf("the log file has no %s record; got %v", kind, records)
+		}
+	}
+}
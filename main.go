@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"flag"
@@ -11,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -31,6 +33,7 @@ var defaultConfigBasenames = []string{
 const (
 	formatJSON = "json"
 	formatYAML = "yaml"
+	formatTOML = "toml"
 )
 
 var formats = []string{
@@ -38,6 +41,19 @@ var formats = []string{
 	formatYAML,
 }
 
+// configFormats are the formats the config file may be given in
+var configFormats = []string{
+	formatJSON,
+	formatYAML,
+	formatTOML,
+}
+
+// defaultStartupGrace is the startup grace period set by -ignore-initial
+const defaultStartupGrace = 500 * time.Millisecond
+
+// exitCodeTimeout is the exit code used when -timeout is exceeded
+const exitCodeTimeout = 124
+
 var config configuration
 var (
 	configPath          string
@@ -45,7 +61,7 @@ var (
 	extensions          stringsSetVar
 	extensionsCSV       string
 	watch               stringsSetVar
-	watchCSV            string
+	watchCSV            stringsVarCSV
 	ignore              stringsSetVar
 	ignoreCSV           string
 	ignoreExtensions    stringsSetVar
@@ -61,22 +77,73 @@ var (
 	stderrJSON          = json.NewEncoder(os.Stderr)
 	stderrJSONMu        sync.Mutex
 	printConfigAndExit  bool
+	printSchemaAndExit  bool
+	listWatches         bool
+	listWatchesAndExit  bool
 	printConfigFormat   = enumVar{Choices: formats, Value: formatYAML}
+	configFormat        = enumVar{Choices: configFormats}
+	laxConfig           bool
 	quiet               bool
-	ctx                 context.Context
-	ctxCancel           func()
+	catchup             bool
+	catchupPath         = defaultCatchupPath
+	watched             = newWatchSet()
+	onlyActionsCSV      string
+	skipActionsCSV      string
+	maxConcurrency      int
+	sequential          bool
+	logFilePath         string
+	pidFilePath         string
+	logFile             *jsonLogFile
+	poll                bool
+	pollInterval        string
+	metricsAddr         string
+	rescanOnOverflow    bool
+	closeWrite          bool
+	contentHash         bool
+	clearScreenFlag     bool
+	bell                bool
+	startupGrace        string
+	ignoreInitial       bool
+	readyAt             int64 // events before this time (Unix nanoseconds) are ignored, see `startupGrace`
+	noSelf              bool
+	replayPath          string
+	noGlobalConfig      bool
+	execMapFrom         string
+	batchWindow         string
+	debounceStrategyVar = enumVar{Choices: debounceStrategies}
+	strictPaths         bool
+	noDefaultIgnores    bool
+	followSymlinks      bool
+	watchMissing        bool
+	maxEventsPerSecond  float64
+	globalConfigPath    string // the global config file merged into the configuration, if any
+	replaySpeed         = 1.0
+	depth               = -1
+	noRecursive         bool
+	serveDir            string
+	serveAddr           = defaultServeAddr
+	serveReloadPath     = defaultWebSocketServePath
+	includeChmod        bool
+	verbose             bool
+	once                bool
+	timeout             time.Duration
 )
 
 func init() {
 	log.SetOutput(ioutil.Discard)
-	flag.StringVar(&configPath, "config", configPath, fmt.Sprintf("use the config file (JSON or YAML) at this path (defaults: %v)", defaultConfigBasenames))
+	flag.StringVar(&configPath, "config", configPath, fmt.Sprintf("use the config file (JSON, YAML or TOML) at this path, or - to read it from stdin (defaults: %v)", defaultConfigBasenames))
 	flag.StringVar(&configPath, "c", configPath, "(alias for -config)")
-	flag.Var(&extensions, "ext", "add an extension to watch")
-	flag.StringVar(&extensionsCSV, "exts", extensionsCSV, "add multiple watched extensions (CSV)")
+	flag.Var(&configFormat, "config-format", fmt.Sprintf("decode the config file in this format instead of detecting it from the file extension (choices: %v)", configFormats))
+	flag.BoolVar(&noGlobalConfig, "no-global-config", noGlobalConfig, "do not merge the global config file ($XDG_CONFIG_HOME/watchfs/config.yaml or ~/.config/watchfs/config.yaml) into the configuration")
+	flag.BoolVar(&laxConfig, "lax", laxConfig, "ignore unknown config keys (reporting them as a warning) instead of failing")
+	flag.Var(&extensions, "ext", "add an extension to watch (!EXT: watch all other extensions instead)")
+	flag.StringVar(&extensionsCSV, "exts", extensionsCSV, "add multiple watched extensions (CSV) (!EXT: watch all other extensions instead)")
 	flag.StringVar(&extensionsCSV, "e", extensionsCSV, "(alias for -exts)")
 	flag.Var(&watch, "watch", "add a path to watch")
-	flag.StringVar(&watchCSV, "watches", watchCSV, "add multiple watched paths (CSV)")
-	flag.StringVar(&watchCSV, "w", watchCSV, "(alias for -watches)")
+	flag.Var(&watchCSV, "watches", "add multiple watched paths (CSV)")
+	flag.Var(&watchCSV, "w", "(alias for -watches)")
+	flag.IntVar(&depth, "depth", depth, "watch subdirectories at most this many levels below each watched directory (0: only the directory itself, -1: unlimited)")
+	flag.BoolVar(&noRecursive, "no-recursive", noRecursive, "watch only the given directories, not their subdirectories (same as -depth 0)")
 	flag.Var(&ignore, "ignore", "add a path/glob to ignore")
 	flag.Var(&ignore, "i", "(alias for -ignore)")
 	flag.Var(&ignoreExtensions, "ignore-ext", "add an extension to ignore")
@@ -90,13 +157,59 @@ func init() {
 	flag.Var(&ignoreOps, "ignore-op", fmt.Sprintf("add a filesystem operation to ignore (choices: %v)", ops))
 	flag.Var(&ignoreOpsCSV, "ignore-ops", fmt.Sprintf("add multiple ignored filesystem operations (CSV) (choices: %v)", ops))
 	flag.BoolVar(&printConfigAndExit, "print-config", false, "print config to stdout and exit")
+	flag.BoolVar(&printSchemaAndExit, "print-schema", false, "print a JSON Schema for the config file to stdout and exit")
+	flag.BoolVar(&listWatches, "list-watches", false, "after setting up the watches, print the watched paths to stdout")
+	flag.BoolVar(&noDefaultIgnores, "no-default-ignores", noDefaultIgnores, fmt.Sprintf("watch the directories ignored by default (%s) (same as noDefaultIgnores: true in the config)", strings.Join(defaultIgnores, ", ")))
+	flag.BoolVar(&followSymlinks, "follow-symlinks", followSymlinks, "also watch the directories that symlinks below the watched paths point to (same as followSymlinks: true in the config)")
+	flag.BoolVar(&watchMissing, "watch-missing", watchMissing, "wait for watch paths that do not exist yet (or are removed) and watch them once they are created, instead of reporting an error (same as watchMissing: true in the config)")
+	flag.BoolVar(&strictPaths, "strict-paths", strictPaths, "exit with an error if no paths to watch are specified, instead of watching the current directory (same as requirePaths: true in the config)")
+	flag.BoolVar(&listWatchesAndExit, "list-watches-and-exit", false, "print the watched paths to stdout and exit")
 	flag.Var(&printConfigFormat, "print-config-format", fmt.Sprintf("print config in this format (choices: %v)", printConfigFormat.Choices))
 	flag.BoolVar(&quiet, "quiet", quiet, "do not print events to stdout")
 	flag.BoolVar(&quiet, "q", quiet, "(alias for -quiet)")
-	flag.Parse()
+	flag.Var(&debounceStrategyVar, "debounce-strategy", fmt.Sprintf("when actions with a delay run for a burst of events (choices: %v) (default %s)", debounceStrategies, debounceTrailing))
+	flag.StringVar(&batchWindow, "batch-window", batchWindow, "collect the events arriving within this duration into one batch, and report the start and result of the action runs each batch triggers")
+	flag.BoolVar(&clearScreenFlag, "clear", clearScreenFlag, "clear the terminal before each action run")
+	flag.BoolVar(&bell, "bell", bell, "ring the terminal bell when an action run fails")
+	flag.StringVar(&onlyActionsCSV, "only", onlyActionsCSV, "run only the actions with these names (CSV)")
+	flag.StringVar(&skipActionsCSV, "skip", skipActionsCSV, "do not run the actions with these names (CSV)")
+	flag.Float64Var(&maxEventsPerSecond, "max-events-per-second", maxEventsPerSecond, "drop events arriving faster than this rate, reporting the number dropped (0: unlimited)")
+	flag.IntVar(&maxConcurrency, "j", maxConcurrency, "run at most this many actions at once (0: unlimited)")
+	flag.BoolVar(&sequential, "sequential", sequential, "run the actions triggered by the same events one after another, in the order they are declared (same as sequential: true in the config)")
+	flag.BoolVar(&catchup, "catchup", catchup, "on startup, report changes made since the last run (compares against a snapshot saved on exit)")
+	flag.StringVar(&catchupPath, "catchup-file", catchupPath, "path of the snapshot file used by -catchup")
+	flag.StringVar(&execMapFrom, "exec-map-from", execMapFrom, "load execMap entries from this YAML or JSON file (entries in the config file win)")
+	flag.StringVar(&pidFilePath, "pid-file", pidFilePath, "write the PID of watchfs to this file on startup, and remove it on exit (e.g. for kill -HUP $(cat watchfs.pid) to reload the configuration)")
+	flag.StringVar(&logFilePath, "log-file", logFilePath, "append all events, errors, info messages and action results to this file (JSON Lines)")
+	flag.BoolVar(&poll, "poll", poll, "poll the filesystem for changes instead of using OS notifications (for network/virtual filesystems)")
+	flag.StringVar(&pollInterval, "poll-interval", pollInterval, fmt.Sprintf("interval between polls when polling (default %v)", defaultPollInterval))
+	flag.StringVar(&startupGrace, "startup-grace", startupGrace, "ignore events for this long after the watches have been set up")
+	flag.BoolVar(&ignoreInitial, "ignore-initial", ignoreInitial, fmt.Sprintf("ignore events for %v after the watches have been set up (same as -startup-grace %v)", defaultStartupGrace, defaultStartupGrace))
+	flag.StringVar(&replayPath, "replay", replayPath, "instead of watching the filesystem, re-emit the event records of this recorded event log (stdout or -log-file output), then exit once the triggered runs have completed")
+	flag.Float64Var(&replaySpeed, "replay-speed", replaySpeed, "replay events this many times faster than recorded (0: without delays)")
+	flag.DurationVar(&timeout, "timeout", timeout, fmt.Sprintf("exit after this duration, stopping running actions (exit code %d)", exitCodeTimeout))
+	flag.BoolVar(&once, "once", once, "wait for the first change that triggers actions, run them to completion, then exit with their exit code")
+	flag.BoolVar(&verbose, "verbose", verbose, "report additional information, such as why events are filtered out and when actions wait for and end their debounce delay")
+	flag.BoolVar(&verbose, "v", verbose, "(alias for -verbose)")
+	flag.BoolVar(&includeChmod, "include-chmod", includeChmod, "trigger actions on chmod events (ignored by default unless requested with -op chmod)")
+	flag.BoolVar(&closeWrite, "close-write", closeWrite, "(Linux) report writes only when the writer closes the file, instead of on every write")
+	flag.BoolVar(&contentHash, "content-hash", contentHash, "ignore writes that leave a file's content unchanged (compares a hash of each written file)")
+	flag.BoolVar(&rescanOnOverflow, "rescan-on-overflow", rescanOnOverflow, "when the OS event queue overflows, rescan the watched paths and report files modified since the last scan")
+	flag.BoolVar(&noSelf, "no-self", noSelf, "do not reload the configuration when the config file changes (same as self: false in the config)")
+	flag.StringVar(&serveDir, "serve", serveDir, "serve this directory over HTTP, reloading HTML pages in the browser when watched files change")
+	flag.StringVar(&serveAddr, "serve-addr", serveAddr, "address of the -serve HTTP server")
+	flag.StringVar(&serveReloadPath, "serve-path", serveReloadPath, "URL path of the -serve live-reload WebSocket endpoint and script (PATH/reload.js)")
+	flag.StringVar(&metricsAddr, "metrics-addr", metricsAddr, "serve metrics at this address (e.g. :9090): JSON at /stats, Prometheus text format at /metrics")
 }
 
 func main() {
+	flag.Parse()
+	if printSchemaAndExit {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(configurationSchema())
+		return
+	}
 	if printConfigAndExit {
 		switch printConfigFormat.Value {
 		case formatJSON:
@@ -106,56 +219,212 @@ func main() {
 		}
 		return
 	}
+	if logFilePath != "" {
+		var err error
+		logFile, err = openJSONLogFile(logFilePath)
+		if err != nil {
+			onError(err)
+			exit(1)
+		}
+	}
+	if pidFilePath != "" {
+		if err := writePIDFile(pidFilePath); err != nil {
+			onError(err)
+			exit(1)
+		}
+	}
+	handleShutdownSignals()
+	handleReloadSignal()
+	root := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		root, cancel = context.WithTimeout(root, timeout)
+		defer cancel()
+	}
 	for {
-		ctx, ctxCancel = context.WithCancel(context.Background())
+		ctx, cancel := context.WithCancel(root)
+		setGenerationCancel(cancel)
+		go func() {
+			select {
+			case <-shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
 		watchContext(ctx)
-		ctxCancel()
+		cancel()
+		if root.Err() == context.DeadlineExceeded {
+			onInfo(fmt.Sprintf("timeout of %v exceeded, exiting", timeout))
+			exit(exitCodeTimeout)
+		}
+		select {
+		case <-shutdown:
+			removePIDFile(pidFilePath)
+			if exitStatus != 0 {
+				exit(exitStatus)
+			}
+			return
+		default:
+		}
 	}
 }
 
 func watchContext(ctx context.Context) {
+	config = configuration{}
 	loadConfigFile()
 	flagsToConfiguration()
-	config.makeCanonical()
-	noPaths := config.Paths == nil || len(config.Paths) == 0
-	noWatch := config.Watch == nil || len(config.Watch) == 0
-	if noPaths && noWatch {
+	if err := config.makeCanonical(); err != nil {
+		onError(err)
+		exit(1)
+	}
+	onStart()
+	actions, err := selectActions(config.Actions, onlyActionsCSV, skipActionsCSV)
+	if err != nil {
+		onError(err)
+		exit(1)
+	}
+	if err := resolveDependencies(actions, config.Actions); err != nil {
+		onError(err)
+		exit(1)
+	}
+	if err := resolveSequence(actions); err != nil {
+		onError(err)
+		exit(1)
+	}
+	config.Actions = actions
+	actionSlots = newSemaphore(config.MaxConcurrency)
+	eventThrottle.setRate(config.MaxEventsPerSecond)
+	if len(config.Paths) == 0 && config.RequirePaths {
+		onError("no paths to watch specified (requirePaths is set)")
+		exit(1)
+	}
+	if len(config.Paths) == 0 {
 		stderrJSONEncode(struct {
 			Warning string `json:"warning"`
 		}{
 			Warning: "no paths to watch specified. watching the current directory.",
 		})
-		config.Paths = append(config.Paths, ".")
+		config.Paths = append(config.Paths, watchTarget{Path: "."})
 	}
-	w, err := fsnotify.NewWatcher()
+	w, err := newWatcher()
 	if err != nil {
 		onError(err)
-		os.Exit(1)
+		exit(1)
 	}
 	defer w.Close()
 
-	for _, path := range config.Paths {
-		watchRecursive(w, path)
+	watched = newWatchSet()
+	watchStart := time.Now()
+	targets := expandWatchPaths(config.Paths)
+	if len(targets) == 0 && config.RequirePaths {
+		onError("none of the paths to watch match any files (requirePaths is set)")
+		exit(1)
+	}
+	setWatchRoots(targets)
+	paths := walkRoots(targets)
+	if replayPath == "" {
+		for _, path := range paths {
+			watchRecursive(w, path)
+		}
+	}
+	atomic.StoreInt64(&readyAt, time.Now().Add(config.startupGrace).UnixNano())
+	if listWatches || listWatchesAndExit {
+		printWatches()
+		if listWatchesAndExit {
+			exit(0)
+		}
+	}
+	if !quiet {
+		onWatchSummary(time.Since(watchStart))
+	}
+	if metricsAddr != "" {
+		defer serveMetrics(metricsAddr)()
 	}
 	for i := range config.Actions {
-		action := &config.Actions[i]
-		action.trigger = make(chan Event, 1)
+		if a := config.Actions[i].ActionWebSocket; a != nil {
+			defer a.serve()()
+		}
+		if a := config.Actions[i].ActionPublish; a != nil {
+			defer a.close()
+		}
+	}
+	// all goroutines of this generation are joined before it ends, so that none of them
+	// sees the configuration or actions of the next one
+	var running sync.WaitGroup
+	d := newDispatcher(ctx, config.Actions)
+	defer func() {
+		running.Wait()
+		d.interruptBatches()
+	}()
+	d.run(&running)
+	for i := range d.actions {
+		action := &d.actions[i]
+		action.trigger = make(chan []Event, 1)
 		action.run = make(chan struct{}, 1)
-		action.run <- struct{}{}
-		skipTriggersUntilTick := func() {
-			if action.tick != nil {
-				for {
+		action.state = newRunState()
+		debounce := newDebouncer(action.delay, action.debounce)
+		var perFile *keyedDebouncer
+		if action.PerFile {
+			debounce, perFile = nil, newKeyedDebouncer(action.delay, perFileDebounceLimit)
+		}
+		var cancelRun context.CancelFunc
+		var runningPath string   // path of the in-flight run (perFile only)
+		var pending []pendingRun // one per path if perFile, otherwise at most one
+		var mu sync.Mutex
+		if !once {
+			action.run <- struct{}{}
+			action.state.queue(1)
+			pending = []pendingRun{{batches: 1}}
+		}
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			var crashes crashBackoff
+			for {
+				// run is never closed, since both this goroutine and trigger send on it
+				select {
+				case <-ctx.Done():
+					return
+				case <-action.run:
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				mu.Lock()
+				if len(pending) == 0 {
+					// the events were already taken by the previous run
+					mu.Unlock()
+					continue
+				}
+				next := pending[0]
+				pending = pending[1:]
+				if len(pending) > 0 {
 					select {
-					case <-action.tick:
-						break
-					case <-action.trigger:
+					case action.run <- struct{}{}:
+					default:
 					}
 				}
-			}
-		}
-		go func() {
-			for range action.run {
-				if err := action.Run(ctx); err != nil {
+				events, batches := next.events, next.batches
+				runCtx, cancel := context.WithCancel(ctx)
+				cancelRun, runningPath = cancel, next.path
+				mu.Unlock()
+				clearScreen()
+				start := clock.Now()
+				err := action.Run(runCtx, events)
+				duration := clock.Now().Sub(start)
+				action.state.done(action, events, batches, err)
+				cancelled := runCtx.Err() != nil && ctx.Err() == nil
+				cancel()
+				switch {
+				case err != nil && cancelled:
+					onInfo(struct {
+						Message string  `json:"message"`
+						Action  *Action `json:"action"`
+					}{
+						Message: "cancelled in-flight run",
+						Action:  action,
+					})
+				case err != nil:
 					onError(struct {
 						Message string  `json:"message"`
 						Action  *Action `json:"action"`
@@ -163,51 +432,213 @@ func watchContext(ctx context.Context) {
 						Message: err.Error(),
 						Action:  action,
 					})
+					ringBell()
+				}
+				stats.onActionCompleted(duration, err)
+				onActionCompleted(action, events, duration, err)
+				action.runHooks(ctx, events, err, cancelled)
+				completeBatches(events, err, cancelled)
+				if once {
+					onceRunCompleted(err)
+				}
+				wait := action.cooldown
+				if !cancelled && ctx.Err() == nil {
+					if backoff := crashes.next(duration, err); backoff > 0 {
+						onInfo(struct {
+							Message string  `json:"message"`
+							Backoff string  `json:"backoff"`
+							Crashes int     `json:"crashes"`
+							Action  *Action `json:"action"`
+						}{
+							Message: "action is crash-looping, backing off",
+							Backoff: backoff.String(),
+							Crashes: crashes.crashes,
+							Action:  action,
+						})
+						if backoff > wait {
+							wait = backoff
+						}
+					}
+				}
+				if wait > 0 {
+					select {
+					case <-ctx.Done():
+					case <-clock.After(wait):
+					}
 				}
 			}
 		}()
+		trigger := func(events []Event, batches int) {
+			if len(events) == 0 {
+				return
+			}
+			var path string
+			if action.PerFile {
+				path = events[len(events)-1].Name
+			}
+			mu.Lock()
+			merged := false
+			for i := range pending {
+				if pending[i].path == path {
+					pending[i].events = append(pending[i].events, events...)
+					pending[i].batches += batches
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				pending = append(pending, pendingRun{path: path, events: events, batches: batches})
+			}
+			if action.CancelInFlight && cancelRun != nil && runningPath == path {
+				cancelRun()
+			}
+			mu.Unlock()
+			if !action.CancelInFlight {
+				action.Notify(events[len(events)-1])
+			}
+			select {
+			case action.run <- struct{}{}:
+			default:
+				// a run is already signalled; it takes the pending runs in turn
+			}
+		}
+		running.Add(1)
 		go func() {
-			defer close(action.run)
+			defer running.Done()
+			defer debounce.stop()
+			defer perFile.stop()
+			batches := 0 // number of dispatches collected by the debouncer
 			for {
 				select {
 				case <-ctx.Done():
 					return
-				case e := <-action.trigger:
-					skipTriggersUntilTick()
-					action.Notify(e)
-					select {
-					case <-action.run:
-						action.run <- struct{}{}
-					case action.run <- struct{}{}:
+				case events := <-action.trigger:
+					switch {
+					case perFile != nil:
+						// the dispatch is replaced by one triggered run per path
+						if started := perFile.add(events...); started > 0 {
+							action.state.queue(started)
+							onDebounce("waiting", action, len(events))
+						}
+						action.state.release(1)
+						continue
+					case action.PerFile:
+						paths := splitByPath(events)
+						action.state.queue(len(paths))
+						action.state.release(1)
+						for _, events := range paths {
+							trigger(events, 1)
+						}
+						continue
+					case debounce == nil:
+						trigger(events, 1)
+						continue
+					}
+					started, dropped := debounce.add(events...)
+					if dropped {
+						// the strategy dropped the events, so they trigger no run
+						onDebounce("dropped", action, len(events))
+						action.state.release(1)
+						releaseBatches(events)
+						continue
+					}
+					batches++
+					if started {
+						onDebounce("waiting", action, len(events))
+					}
+				case <-debounce.ready():
+					events := debounce.take()
+					onDebounce("firing", action, len(events))
+					trigger(events, batches)
+					batches = 0
+				case <-perFile.ready():
+					for _, events := range perFile.take() {
+						onDebounce("firing", action, len(events))
+						trigger(events, 1)
 					}
 				}
 			}
 		}()
 	}
+	running.Add(1)
 	go func() {
-		for e := range w.Events {
+		defer running.Done()
+		for {
+			var e fsnotify.Event
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-w.Events():
+				if !ok {
+					return
+				}
+				e = event
+			}
+			if !onMissingEvent(w, e) {
+				continue
+			}
 			info, err := os.Stat(e.Name)
-			if err == nil && info.IsDir() {
-				w.Add(e.Name)
+			if err == nil && info.IsDir() && withinDepth(paths, e.Name) && !shouldExclude(e.Name, info) && !watched.hasDir(filepath.Clean(e.Name)) {
+				if w.Add(e.Name) == nil {
+					watched.addDir(e.Name)
+				}
 			}
-			onEvent(Event{
+			onEvent(d, Event{
 				Name: e.Name,
 				Op:   e.Op,
 				Time: time.Now().Format(time.RFC3339),
 			})
+			if e.Op&(fsnotify.Rename|fsnotify.Remove) != 0 && watched.isFile(e.Name) {
+				running.Add(1)
+				go func(path string) {
+					defer running.Done()
+					rewatchFile(ctx, w, d, path)
+				}(e.Name)
+			}
 		}
 	}()
+	running.Add(1)
 	go func() {
-		for err := range w.Errors {
-			onError(struct {
-				Message string `json:"message"`
-			}{
-				Message: err.Error(),
-			})
+		defer running.Done()
+		lastScan := watchStart
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.Errors():
+				if !ok {
+					return
+				}
+				err = e
+			}
+			scanStart := time.Now()
+			if onWatcherError(w, d, paths, lastScan, err) {
+				lastScan = scanStart
+			}
 		}
 	}()
+	if catchup {
+		catchUp(d, paths)
+	}
+	if replay, ok := w.(*replayWatcher); ok {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			replay.wait(ctx, d.actions)
+			if ctx.Err() == nil {
+				onInfo(fmt.Sprintf("replay of %s finished, exiting", replay.path))
+				requestShutdown()
+			}
+		}()
+	}
 
 	<-ctx.Done()
+	if catchup {
+		if err := saveSnapshot(catchupPath, snapshotTree(paths)); err != nil {
+			onError(err)
+		}
+	}
 }
 
 func flagsToConfiguration() {
@@ -218,12 +649,40 @@ func flagsToConfiguration() {
 		config.ExtensionsCSV = extensionsCSV
 	}
 	if len(watch.Value) > 0 {
-		config.Paths = watch.Values()
+		config.Paths = watchTargetsOf(watch.Values()...)
 	}
-	if len(watchCSV) > 0 {
-		for _, v := range strings.Split(watchCSV, ",") {
-			config.Paths = append(config.Paths, strings.TrimSpace(v))
-		}
+	if len(watchCSV.Value) > 0 {
+		config.Paths = append(config.Paths, watchTargetsOf(watchCSV.Value...)...)
+	}
+	if maxEventsPerSecond > 0 {
+		config.MaxEventsPerSecond = maxEventsPerSecond
+	}
+	if noDefaultIgnores {
+		config.NoDefaultIgnores = true
+	}
+	if followSymlinks {
+		config.FollowSymlinks = true
+	}
+	if watchMissing {
+		config.WatchMissing = true
+	}
+	if strictPaths {
+		config.RequirePaths = true
+	}
+	if batchWindow != "" {
+		config.BatchWindow = batchWindow
+	}
+	if debounceStrategyVar.Value != "" {
+		config.DebounceStrategy = debounceStrategyVar.Value
+	}
+	if execMapFrom != "" {
+		config.ExecMapFile = append(config.ExecMapFile, execMapFrom)
+	}
+	if noRecursive {
+		depth = 0
+	}
+	if depth >= 0 {
+		config.Depth = &depth
 	}
 	if len(watchOps.Value) > 0 {
 		config.Ops = watchOps.Values()
@@ -257,6 +716,57 @@ func flagsToConfiguration() {
 	if len(signal.Value) > 0 {
 		config.Signal = signal.Value
 	}
+	if poll {
+		config.Poll = true
+	}
+	if includeChmod {
+		config.IncludeChmod = true
+	}
+	if closeWrite {
+		config.CloseWrite = true
+	}
+	if contentHash {
+		config.ContentHash = true
+	}
+	if clearScreenFlag {
+		config.ClearScreen = true
+	}
+	if bell {
+		config.Bell = true
+	}
+	if rescanOnOverflow {
+		config.RescanOnOverflow = true
+	}
+	if noSelf {
+		self := false
+		config.Self = &self
+	}
+	if len(pollInterval) > 0 {
+		config.PollInterval = pollInterval
+	}
+	if ignoreInitial && startupGrace == "" {
+		config.StartupGrace = defaultStartupGrace.String()
+	}
+	if len(startupGrace) > 0 {
+		config.StartupGrace = startupGrace
+	}
+	if maxConcurrency > 0 {
+		config.MaxConcurrency = maxConcurrency
+	}
+	if sequential {
+		config.Sequential = true
+	}
+	if serveDir != "" {
+		a, err := serveAction(serveDir, serveAddr, serveReloadPath)
+		if err != nil {
+			onError(err)
+			exit(1)
+		}
+		config.Actions = append(config.Actions, a)
+		if len(config.Paths) == 0 {
+			config.Paths = watchTargetsOf(serveDir)
+		}
+	}
 	if flag.NArg() > 0 {
 		switch action.Value {
 		case actionShell:
@@ -286,6 +796,56 @@ func flagsToConfiguration() {
 					Command: &args,
 				},
 			})
+		case actionComposeRun:
+			if flag.NArg() > 2 {
+				onError(fmt.Sprintf("too many arguments for action '%s': %v", action.Value, flag.Args()))
+			}
+			config.Actions = append(config.Actions, Action{
+				ActionComposeRun: &ActionComposeRun{
+					Command: flag.Arg(0),
+					Service: flag.Arg(1),
+				},
+			})
+		case actionWebSocket:
+			if flag.NArg() > 1 {
+				onError(fmt.Sprintf("too many arguments for action '%s': %v", action.Value, flag.Args()))
+			}
+			config.Actions = append(config.Actions, Action{
+				ActionWebSocket: &ActionWebSocket{
+					Addr: flag.Arg(0),
+				},
+			})
+		case actionPublish:
+			if flag.NArg() > 2 {
+				onError(fmt.Sprintf("too many arguments for action '%s': %v", action.Value, flag.Args()))
+			}
+			config.Actions = append(config.Actions, Action{
+				ActionPublish: &ActionPublish{
+					Channel: flag.Arg(0),
+					Address: flag.Arg(1),
+				},
+			})
+		case actionNotify:
+			if flag.NArg() > 2 {
+				onError(fmt.Sprintf("too many arguments for action '%s': %v", action.Value, flag.Args()))
+			}
+			config.Actions = append(config.Actions, Action{
+				ActionNotify: &ActionNotify{
+					Message: flag.Arg(0),
+					Title:   flag.Arg(1),
+				},
+			})
+		case actionPlugin:
+			var args []string
+			if flag.NArg() > 1 {
+				args = flag.Args()[1:]
+			}
+			config.Actions = append(config.Actions, Action{
+				ActionPlugin: &ActionPlugin{
+					Path: flag.Arg(0),
+					Args: args,
+				},
+			})
 		case actionHTTPGet:
 			if flag.NArg() > 1 {
 				onError(fmt.Sprintf("too many arguments for action '%s': %v", action.Value, flag.Args()))
@@ -299,33 +859,113 @@ func flagsToConfiguration() {
 	}
 }
 
-func stdoutJSONEncode(v interface{}) error {
-	stdoutJSONMu.Lock()
-	defer stdoutJSONMu.Unlock()
-	return stdoutJSON.Encode(v)
+// selectActions filters actions by name using the -only and -skip flags
+func selectActions(actions []Action, onlyCSV, skipCSV string) ([]Action, error) {
+	if onlyCSV == "" && skipCSV == "" {
+		return actions, nil
+	}
+	names := make(map[string]bool)
+	for _, a := range actions {
+		if a.Name != "" {
+			names[a.Name] = true
+		}
+	}
+	parse := func(csv string) (map[string]bool, error) {
+		set := make(map[string]bool)
+		if csv == "" {
+			return set, nil
+		}
+		list, err := splitCSV(csv)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range list {
+			if !names[name] {
+				return nil, fmt.Errorf("no action named %q", name)
+			}
+			set[name] = true
+		}
+		return set, nil
+	}
+	only, err := parse(onlyCSV)
+	if err != nil {
+		return nil, err
+	}
+	skip, err := parse(skipCSV)
+	if err != nil {
+		return nil, err
+	}
+	var out []Action
+	for _, a := range actions {
+		if len(only) > 0 && !only[a.Name] {
+			continue
+		}
+		if skip[a.Name] {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out, nil
 }
 
 func stderrJSONEncode(v interface{}) error {
+	logJSONEncode(v)
 	stderrJSONMu.Lock()
 	defer stderrJSONMu.Unlock()
 	return stderrJSON.Encode(v)
 }
 
+func logJSONEncode(v interface{}) {
+	if err := logEncode(v); err != nil {
+		stderrJSONMu.Lock()
+		defer stderrJSONMu.Unlock()
+		stderrJSON.Encode(logError{Error: err.Error()})
+	}
+}
+
+// logEncode writes v to the log file, if there is one
+func logEncode(v interface{}) error {
+	if logFile == nil {
+		return nil
+	}
+	return logFile.Encode(v)
+}
+
+// logError is the error written to stderr when a record cannot be written to the log file
+type logError struct {
+	Error string `json:"error"`
+}
+
+// loadConfigFile loads the project config file (or stdin), and merges the global config file into it
 func loadConfigFile() {
+	loadProjectConfig()
+	loadGlobalConfig()
+	if err := config.makeCanonical(); err != nil {
+		onError(err)
+		exit(1)
+	}
+}
+
+func loadProjectConfig() {
 	load := func(name string) bool {
 		if _, err := os.Stat(name); err == nil {
-			err := config.load(name)
+			err := config.load(name, configFormat.Value)
 			if err != nil {
 				onError(err)
+				exit(1)
 			}
-			config.makeCanonical()
 			configPathAbs, _ = filepath.Abs(name)
 			return true
 		}
 		return false
 	}
+	if configPath == configPathStdin {
+		loadConfigStdin()
+		return
+	}
 	if configPath != "" {
 		load(configPath)
+		return
 	}
 	for _, name := range defaultConfigBasenames {
 		if load(name) {
@@ -335,122 +975,469 @@ func loadConfigFile() {
 	}
 }
 
+// loadGlobalConfig merges the global config file (see globalConfigDir) into the
+// configuration, unless -no-global-config is set
+func loadGlobalConfig() {
+	globalConfigPath = ""
+	if noGlobalConfig {
+		return
+	}
+	path := findGlobalConfig()
+	if path == "" {
+		return
+	}
+	var global configuration
+	if err := global.load(path, ""); err != nil {
+		onError(fmt.Errorf("%s: %v", path, err))
+		exit(1)
+	}
+	config.mergeGlobal(global)
+	globalConfigPath = path
+}
+
+// configPathStdin is the -config value for reading the configuration from stdin
+const configPathStdin = "-"
+
+// stdinConfig is the configuration read from stdin, kept for reloads
+var stdinConfig []byte
+
+// loadConfigStdin loads the configuration from stdin. Stdin is only read once;
+// reloads (e.g. on SIGHUP) use the configuration read the first time.
+func loadConfigStdin() {
+	if stdinConfig == nil {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			onError(fmt.Errorf("reading config from stdin: %v", err))
+			exit(1)
+		}
+		stdinConfig = append([]byte{}, data...)
+	}
+	format := configFormat.Value
+	if format == "" {
+		format = formatYAML
+	}
+	if err := config.decode(bytes.NewReader(stdinConfig), format); err != nil {
+		onError(err)
+		exit(1)
+	}
+	if err := config.makeCanonical(); err != nil {
+		onError(err)
+		exit(1)
+	}
+	configPathAbs = ""
+	for i := range config.Actions {
+		if config.Actions[i].interactive() {
+			stderrJSONEncode(struct {
+				Warning string `json:"warning"`
+			}{
+				Warning: "the config is read from stdin, so interactive actions receive no input",
+			})
+			break
+		}
+	}
+}
+
 func onError(err interface{}) {
 	if v, ok := err.(error); ok {
 		err = v.Error()
 	}
-	stderrJSONEncode(struct {
-		Error interface{} `json:"error"`
+	reportError(err)
+}
+
+func onInfo(info interface{}) {
+	stderrRecord(func(n recordNumber) interface{} {
+		return struct {
+			Info interface{} `json:"info"`
+			Seq  uint64      `json:"seq"`
+			ID   string      `json:"id"`
+		}{
+			Info: info,
+			Seq:  n.Seq,
+			ID:   n.ID,
+		}
+	})
+}
+
+// onDebounce reports (if -verbose is set) that a debouncer has started waiting for
+// a quiet period, or that it is firing with the given number of coalesced events.
+// A nil action refers to the `globalDelay` debouncer.
+func onDebounce(state string, action *Action, events int) {
+	if !verbose {
+		return
+	}
+	onInfo(struct {
+		Debounce string  `json:"debounce"`
+		Events   int     `json:"events"`
+		Global   bool    `json:"global,omitempty"`
+		Action   *Action `json:"action,omitempty"`
 	}{
-		Error: err,
+		Debounce: state,
+		Events:   events,
+		Global:   action == nil,
+		Action:   action,
 	})
 }
 
-func onInfo(info interface{}) {
-	stderrJSONEncode(struct {
-		Info interface{} `json:"info"`
+// printWatches prints the sorted lists of watched directories and files to stdout
+func printWatches() {
+	var watches struct {
+		Dirs  []string `json:"dirs"`
+		Files []string `json:"files"`
+	}
+	watches.Dirs, watches.Files = watched.list()
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(watches)
+}
+
+// onWatchSummary reports what is being watched, how long it took to set up the
+// watches, and which actions are registered
+func onWatchSummary(duration time.Duration) {
+	type actionSummary struct {
+		Type string `json:"type"`
+		Name string `json:"name,omitempty"`
+	}
+	summary := struct {
+		Config      string          `json:"config,omitempty"`
+		Global      string          `json:"globalConfig,omitempty"`
+		Dirs        int             `json:"dirs"`
+		Files       int             `json:"files"`
+		Duration    string          `json:"duration"`
+		Extensions  []string        `json:"exts,omitempty"`
+		Ops         []string        `json:"ops,omitempty"`
+		IgnoreWatch []string        `json:"ignore,omitempty"`
+		Actions     []actionSummary `json:"actions"`
+	}{
+		Config:      configPathAbs,
+		Global:      globalConfigPath,
+		Duration:    duration.String(),
+		Extensions:  config.Extensions,
+		Ops:         config.Ops,
+		IgnoreWatch: config.IgnoreWatch,
+		Actions:     []actionSummary{},
+	}
+	summary.Dirs, summary.Files = watched.counts()
+	for i := range config.Actions {
+		a := &config.Actions[i]
+		summary.Actions = append(summary.Actions, actionSummary{Type: a.Type(), Name: a.Name})
+	}
+	onInfo(struct {
+		Watching interface{} `json:"watching"`
 	}{
-		Info: info,
+		Watching: summary,
 	})
 }
 
-func shouldNotify(e Event) bool {
-	if all, any := config.Filter.Match(e); !(all || any) {
+func onActionStarted(a *Action, events []Event, waited time.Duration) {
+	start := actionStart{
+		Type:    a.Type(),
+		Name:    a.Name,
+		Command: a.commandLine(events),
+		Waited:  waited.String(),
+		Batches: runBatches(events),
+	}
+	if len(events) > 0 {
+		start.Path = events[len(events)-1].Name
+	}
+	stdoutRecord(!quiet, func(n recordNumber) interface{} {
+		return struct {
+			ActionStarted actionStart `json:"actionStarted"`
+			recordNumber
+		}{start, n}
+	})
+}
+
+func onActionCompleted(a *Action, events []Event, duration time.Duration, err error) {
+	result := actionResult{
+		Type:     a.Type(),
+		Name:     a.Name,
+		ExitCode: exitCode(err),
+		Duration: duration.String(),
+		Batches:  runBatches(events),
+	}
+	if len(events) > 0 {
+		result.Path = events[len(events)-1].Name
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	stdoutRecord(err != nil || !quiet, func(n recordNumber) interface{} {
+		return struct {
+			ActionCompleted actionResult `json:"actionCompleted"`
+			recordNumber
+		}{result, n}
+	})
+}
+
+// shouldNotify returns whether the event passes the filters. The chmod bit of a combined op
+// (e.g. `write|chmod`) is dropped from the event unless chmod events are wanted.
+func shouldNotify(e *Event) bool {
+	if e.Op&fsnotify.Chmod != 0 && !chmodWanted() {
+		if e.Op == fsnotify.Chmod {
+			onEventFiltered(*e, "chmod", "")
+			return false
+		}
+		e.Op &^= fsnotify.Chmod
+	}
+	if root, i := watchRootOf(e.Name); root != nil && root.hasFilter() {
+		if ok, reason := root.explain(*e, matchAny); !ok {
+			onEventFiltered(*e, fmt.Sprintf("paths[%d]", i), reason)
+			return false
+		}
+	} else if ok, reason := config.Filter.explain(*e, matchAny); !ok {
+		onEventFiltered(*e, "filter", reason)
 		return false
 	}
-	for _, f := range config.Ignore {
-		if all, any := f.Match(e); all && any {
+	for i, f := range config.Ignore {
+		if f.Match(*e, matchAll) {
+			onEventFiltered(*e, fmt.Sprintf("ignores[%d]", i), "")
 			return false
 		}
 	}
-	for _, pattern := range config.IgnoreWatch {
-		if ok, err := filepath.Match(pattern, e.Name); err == nil && ok {
+	for _, pattern := range config.ignoreGlobs {
+		if matchIgnoreGlob(pattern, e.Name) {
+			onEventFiltered(*e, "ignore", pattern)
 			return false
 		}
 	}
 	return true
 }
 
-func onEvent(e Event) {
-	if config.Self == nil || *config.Self == true {
-		absPath, err := filepath.Abs(e.Name)
-		if err == nil && e.Op == fsnotify.Write && absPath == configPathAbs {
-			onInfo("reloading watchfs configuration")
-			ctxCancel()
+// onEventFiltered counts an event rejected by the filters, and reports (if -verbose
+// is set) the stage that rejected it: `chmod` for ignored chmod events, `filter`
+// for the top-level filter, `paths[i]` for the filter of the watched path the event
+// is below, `ignores[i]` for an ignore filter, `ignore` for an ignore glob (including
+// the default ignores), `contentHash` for writes that left the content unchanged,
+// `startupGrace` for events during the startup grace period, or `throttle` for events
+// dropped by the rate limit. The reason names the predicates that did not match, or the glob.
+func onEventFiltered(e Event, stage, reason string) {
+	stats.onEventFiltered()
+	if !verbose {
+		return
+	}
+	onInfo(struct {
+		Filtered string `json:"filtered"`
+		Op       string `json:"op"`
+		Stage    string `json:"stage"`
+		Reason   string `json:"reason,omitempty"`
+	}{
+		Filtered: e.Name,
+		Op:       strings.ToLower(e.Op.String()),
+		Stage:    stage,
+		Reason:   reason,
+	})
+}
+
+// chmodWanted returns whether chmod-only events are of interest: either because
+// `includeChmod` is set, or because the top-level filter or an action's filter asks for them.
+func chmodWanted() bool {
+	if config.IncludeChmod || config.Filter.ops[fsnotify.Chmod] {
+		return true
+	}
+	for i := range config.Actions {
+		if config.Actions[i].Filter.ops[fsnotify.Chmod] {
+			return true
 		}
 	}
-	if !shouldNotify(e) {
+	return false
+}
+
+func onEvent(d *dispatcher, e Event) {
+	stats.onEvent()
+	if time.Now().UnixNano() < atomic.LoadInt64(&readyAt) {
+		onEventFiltered(e, "startupGrace", "")
 		return
 	}
-	for _, action := range config.Actions {
-		if action.Match(e) {
-			action.trigger <- e
+	if replayPath == "" && (config.Self == nil || *config.Self == true) {
+		absPath, err := filepath.Abs(e.Name)
+		if err == nil && e.Op&fsnotify.Write != 0 && absPath == configPathAbs && !selfIgnored(absPath) {
+			scheduleReload(config.selfReloadDelay)
 		}
 	}
-	if quiet {
+	if !shouldNotify(&e) {
 		return
 	}
-	stdoutJSONEncode(struct {
-		Op   string `json:"op"`
-		Path string `json:"path"`
-	}{
-		Path: e.Name,
-		Op:   strings.ToLower(e.Op.String()),
+	if d.hashQueue != nil {
+		d.queueForHash(e)
+		return
+	}
+	notify(d, e)
+}
+
+// notify reports the event that passed the filters, and dispatches it to the actions
+func notify(d *dispatcher, e Event) {
+	if !eventThrottle.allow() {
+		onEventFiltered(e, "throttle", "")
+		return
+	}
+	d.add(e)
+	now := time.Now().Format(time.RFC3339Nano)
+	stdoutRecord(!quiet, func(n recordNumber) interface{} {
+		return struct {
+			Op   string   `json:"op"`
+			Ops  []string `json:"ops"`
+			Path string   `json:"path"`
+			Time string   `json:"time"`
+			recordNumber
+		}{
+			Path:         e.Name,
+			Op:           strings.ToLower(e.Op.String()),
+			Ops:          opNames(e.Op),
+			Time:         now,
+			recordNumber: n,
+		}
 	})
 }
 
+// selfIgnored returns whether changes to the config file at the absolute path
+// should not reload it, i.e. whether the path or its base name matches a `selfIgnore` glob
+func selfIgnored(path string) bool {
+	for _, pattern := range config.SelfIgnore {
+		if matchIgnoreGlob(pattern, path) || matchIgnoreGlob(pattern, filepath.Base(path)) {
+			return true
+		}
+	}
+	return false
+}
+
 func shouldExclude(path string, info os.FileInfo) bool {
-	for _, pattern := range config.IgnoreWatch {
-		if ok, err := filepath.Match(pattern, path); err == nil && ok {
+	for _, pattern := range config.ignoreGlobs {
+		if matchIgnoreGlob(pattern, path) {
 			return true
 		}
 	}
 	for _, f := range config.Ignore {
-		if _, any := f.Match(Event{Name: path}); any {
+		if f.Match(Event{Name: path}, matchAll) {
 			return true
 		}
 	}
 	return false
 }
 
-func watchRecursive(w *fsnotify.Watcher, path string) {
-	_, err := os.Stat(path)
+const (
+	rewatchInterval = 50 * time.Millisecond
+	rewatchTimeout  = 2 * time.Second
+)
+
+// rewatchFile re-adds a watched file after it has been renamed or removed, waiting for it
+// to reappear for up to rewatchTimeout. Editors commonly save by writing a temporary file
+// and renaming it over the original, which drops the watch on the original file.
+// Once the file is watched again, its replacement is reported as a write.
+func rewatchFile(ctx context.Context, w Watcher, d *dispatcher, path string) {
+	if !watched.startRewatch(path) {
+		return
+	}
+	defer watched.endRewatch(path)
+	timeout := clock.After(rewatchTimeout)
+	ticker := clock.NewTicker(rewatchInterval)
+	defer ticker.Stop()
+	for {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && w.Add(path) == nil {
+			onEvent(d, Event{
+				Name: path,
+				Op:   fsnotify.Write,
+				Time: time.Now().Format(time.RFC3339),
+			})
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// expandWatchPaths expands glob patterns in the given watch paths.
+// Paths without glob metacharacters are returned unchanged.
+func expandWatchPaths(targets watchTargetList) (out watchTargetList) {
+	for _, target := range targets {
+		path := target.Path
+		if !strings.ContainsAny(path, "*?[") {
+			out = append(out, target)
+			continue
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			onError(fmt.Errorf("watch path %q: %v", path, err))
+			continue
+		}
+		if len(matches) == 0 {
+			stderrJSONEncode(struct {
+				Warning string `json:"warning"`
+			}{
+				Warning: fmt.Sprintf("watch path %q does not match any files", path),
+			})
+		}
+		for _, match := range matches {
+			target.Path = match
+			out = append(out, target)
+		}
+	}
+	return
+}
+
+func watchRecursive(w Watcher, path string) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) && config.WatchMissing {
+		awaitPath(w, path)
+		return
+	}
 	if err != nil {
 		onError(err)
 		return
 	}
-	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			switch v := err.(type) {
-			case *os.PathError:
-				path := v.Path
-				if absPath, err := filepath.Abs(path); err == nil {
-					path = absPath
-				}
-				onError(struct {
-					Op      string `json:"op"`
-					Path    string `json:"path"`
-					Message string `json:"message"`
-				}{
-					Op:      v.Op,
-					Path:    v.Path,
-					Message: v.Err.Error(),
-				})
-			default:
-				onError(err)
-			}
-			return filepath.SkipDir
+	if config.WatchMissing {
+		watched.found(path)
+	}
+	if !info.IsDir() {
+		if err := w.Add(path); err != nil {
+			onError(err)
+			return
+		}
+		watched.addFile(path)
+		if config.ContentHash {
+			contentHashes.prime(path)
 		}
+		return
+	}
+	root := path
+	walkDirs(path, info, func(path string, info os.FileInfo) bool {
 		if shouldExclude(path, info) {
-			return filepath.SkipDir
+			return false
 		}
-		if info.IsDir() {
-			err := w.Add(path)
-			if err != nil {
-				onError(err)
-				return filepath.SkipDir
-			}
+		if err := w.Add(path); err != nil {
+			onWalkError(&os.PathError{Op: "watch", Path: path, Err: err})
+			return false
 		}
-		return nil
+		watched.addDir(path)
+		return config.depth < 0 || pathDepth(root, path) < config.depth
 	})
 }
+
+// withinDepth returns whether the directory is at most `depth` levels below one of the roots
+func withinDepth(roots []string, path string) bool {
+	if config.depth < 0 {
+		return true
+	}
+	for _, root := range roots {
+		if d := pathDepth(root, path); d >= 0 && d <= config.depth {
+			return true
+		}
+	}
+	return false
+}
+
+// pathDepth returns the number of levels the path is below root, or -1 if it is not below it
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return -1
+	}
+	if rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that may be written while it is read
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// records decodes the JSON records written to the buffer, one per line
+func (b *syncBuffer) records(t *testing.T) (records []map[string]interface{}) {
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// recordsWith returns the records that have the key
+func (b *syncBuffer) recordsWith(t *testing.T, key string) (records []map[string]interface{}) {
+	for _, record := range b.records(t) {
+		if _, ok := record[key]; ok {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// captureStdout redirects the JSON records written to stdout to the returned buffer until the test has finished
+func captureStdout(t *testing.T) *syncBuffer {
+	b := &syncBuffer{}
+	stdoutJSONMu.Lock()
+	saved := stdoutJSON
+	stdoutJSON = json.NewEncoder(b)
+	stdoutJSONMu.Unlock()
+	t.Cleanup(func() {
+		stdoutJSONMu.Lock()
+		stdoutJSON = saved
+		stdoutJSONMu.Unlock()
+	})
+	return b
+}
+
+// captureStderr redirects the JSON records written to stderr to the returned buffer until the test has finished
+func captureStderr(t *testing.T) *syncBuffer {
+	b := &syncBuffer{}
+	stderrJSONMu.Lock()
+	saved := stderrJSON
+	stderrJSON = json.NewEncoder(b)
+	stderrJSONMu.Unlock()
+	t.Cleanup(func() {
+		// the repeats of errors reported by the test would be written after it has finished
+		resetErrorRepeats()
+		stderrJSONMu.Lock()
+		stderrJSON = saved
+		stderrJSONMu.Unlock()
+	})
+	return b
+}
+
+// useConfig makes c the configuration until the test has finished
+func useConfig(t *testing.T, c configuration) {
+	saved := config
+	config = c
+	t.Cleanup(func() { config = saved })
+}
+
+// watchfsTest is a watch generation (see watchContext) running in the test
+type watchfsTest struct {
+	t      *testing.T
+	dir    string // the watched directory
+	stdout *syncBuffer
+	stderr *syncBuffer
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startWatchfs runs watchContext with the YAML config, in which $DIR is replaced by a
+// new temporary directory, until the test has finished. It returns once the watches are set up.
+func startWatchfs(t *testing.T, yaml string) *watchfsTest {
+	return startWatchfsIn(t, t.TempDir(), yaml)
+}
+
+// startWatchfsIn is startWatchfs with $DIR replaced by the given (existing) directory
+func startWatchfsIn(t *testing.T, dir, yaml string) *watchfsTest {
+	w := &watchfsTest{t: t, dir: dir, done: make(chan struct{})}
+	savedPath, savedNoGlobal := configPath, noGlobalConfig
+	configPath = writeFile(t, t.TempDir(), "watchfs.yaml", strings.Replace(yaml, "$DIR", w.dir, -1))
+	noGlobalConfig = true
+	useConfig(t, configuration{})
+	w.stdout, w.stderr = captureStdout(t), captureStderr(t)
+	t.Cleanup(func() {
+		w.stop()
+		configPath, noGlobalConfig = savedPath, savedNoGlobal
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	go func() {
+		defer close(w.done)
+		watchContext(ctx)
+	}()
+	w.waitFor("the watches to be set up", func() bool { return len(w.infos("watching")) > 0 })
+	return w
+}
+
+// stop ends the watch generation, and waits for its goroutines to finish
+func (w *watchfsTest) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// path returns the path of the file in the watched directory
+func (w *watchfsTest) path(name string) string {
+	return filepath.Join(w.dir, name)
+}
+
+// write writes the file in the watched directory
+func (w *watchfsTest) write(name, content string) {
+	writeFile(w.t, w.dir, name, content)
+}
+
+// waitFor waits until the condition holds, failing the test after a timeout
+func (w *watchfsTest) waitFor(what string, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			w.t.Fatalf("timed out waiting for %s\nstdout:\n%s\nstderr:\n%s", what, w.stdout, w.stderr)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// events returns the event records written to stdout
+func (w *watchfsTest) events() []map[string]interface{} {
+	return w.stdout.recordsWith(w.t, "op")
+}
+
+// completed returns the actionCompleted records written to stdout
+func (w *watchfsTest) completed() (results []map[string]interface{}) {
+	for _, record := range w.stdout.recordsWith(w.t, "actionCompleted") {
+		results = append(results, record["actionCompleted"].(map[string]interface{}))
+	}
+	return results
+}
+
+// infos returns the info records written to stderr that have the key
+func (w *watchfsTest) infos(key string) (infos []map[string]interface{}) {
+	for _, record := range w.stderr.recordsWith(w.t, "info") {
+		if info, ok := record["info"].(map[string]interface{}); ok && info[key] != nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// TestMain runs watchfs itself instead of the tests if WATCHFS_TEST_MAIN is set (see startWatchfsProcess)
+func TestMain(m *testing.M) {
+	if os.Getenv("WATCHFS_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// watchfsProcess is watchfs running as a separate process, for tests of how it exits
+type watchfsProcess struct {
+	t      *testing.T
+	dir    string // the watched directory
+	cmd    *exec.Cmd
+	start  time.Time
+	stdout syncBuffer
+	stderr syncBuffer
+	exited chan struct{}
+}
+
+// startWatchfsProcess runs watchfs with the flags and the YAML config, in which $DIR is replaced
+// by a new temporary directory. It returns once the watches are set up.
+func startWatchfsProcess(t *testing.T, yaml string, args ...string) *watchfsProcess {
+	p := &watchfsProcess{t: t, dir: t.TempDir(), exited: make(chan struct{})}
+	path := writeFile(t, t.TempDir(), "watchfs.yaml", strings.Replace(yaml, "$DIR", p.dir, -1))
+	p.cmd = exec.Command(os.Args[0], append([]string{"-config", path, "-no-global-config"}, args...)...)
+	p.cmd.Dir = t.TempDir()
+	p.cmd.Env = append(os.Environ(), "WATCHFS_TEST_MAIN=1")
+	p.cmd.Stdout, p.cmd.Stderr = &p.stdout, &p.stderr
+	p.start = time.Now()
+	if err := p.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer close(p.exited)
+		p.cmd.Wait()
+	}()
+	t.Cleanup(func() {
+		p.cmd.Process.Kill()
+		<-p.exited
+	})
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(p.stderr.String(), `"watching"`) {
+		select {
+		case <-p.exited:
+			t.Fatalf("watchfs exited before the watches were set up:\n%s", &p.stderr)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the watches to be set up:\n%s", &p.stderr)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return p
+}
+
+// runWatchfsProcess runs watchfs like startWatchfsProcess, for when it is expected to exit
+// before setting up the watches. It returns the exit code and stderr.
+func runWatchfsProcess(t *testing.T, yaml string, args ...string) (int, string) {
+	path := writeFile(t, t.TempDir(), "watchfs.yaml", strings.Replace(yaml, "$DIR", t.TempDir(), -1))
+	cmd := exec.Command(os.Args[0], append([]string{"-config", path, "-no-global-config"}, args...)...)
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), "WATCHFS_TEST_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cmd.Run()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		<-done
+		t.Fatalf("watchfs has not exited\nstderr:\n%s", &stderr)
+	}
+	return cmd.ProcessState.ExitCode(), stderr.String()
+}
+
+// wait waits for watchfs to exit, and returns its exit code and how long it ran
+func (p *watchfsProcess) wait() (int, time.Duration) {
+	select {
+	case <-p.exited:
+	case <-time.After(10 * time.Second):
+		p.t.Fatalf("watchfs has not exited\nstdout:\n%s\nstderr:\n%s", &p.stdout, &p.stderr)
+	}
+	return p.cmd.ProcessState.ExitCode(), time.Since(p.start)
+}
+
+func TestTimeout(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	p := startWatchfsProcess(t, fmt.Sprintf(`
+paths: [$DIR]
+actions:
+- shell: {command: echo run >> %s; sleep 10}
+`, out), "-timeout", "500ms")
+	code, elapsed := p.wait()
+	if code != exitCodeTimeout {
+		t.Errorf("exit code %d; want %d\nstderr:\n%s", code, exitCodeTimeout, &p.stderr)
+	}
+	if elapsed < 500*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("exited after %v; want it to stop the running action after the timeout", elapsed)
+	}
+	if !strings.Contains(p.stderr.String(), "timeout of 500ms exceeded") {
+		t.Errorf("stderr does not report the timeout:\n%s", &p.stderr)
+	}
+	// the timeout does not restart the watch generation
+	time.Sleep(200 * time.Millisecond)
+	if data, _ := ioutil.ReadFile(out); string(data) != "run\n" {
+		t.Errorf("the action ran %q; want only the startup run", data)
+	}
+}
+
+func TestSelectActions(t *testing.T) {
+	actions := []Action{{Name: "build"}, {Name: "test"}, {}, {Name: "lint"}}
+	tests := []struct {
+		only, skip string
+		want       []string // the names of the selected actions
+		wantErr    string
+	}{
+		{"", "", []string{"build", "test", "", "lint"}, ""},
+		{"test", "", []string{"test"}, ""},
+		{"lint,build", "", []string{"build", "lint"}, ""},
+		{"", "test", []string{"build", "", "lint"}, ""},
+		{"build,test", "test", []string{"build"}, ""},
+		{"deploy", "", nil, `no action named "deploy"`},
+		{"", "build,deploy", nil, `no action named "deploy"`},
+	}
+	for _, tt := range tests {
+		selected, err := selectActions(actions, tt.only, tt.skip)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("selectActions(-only %q, -skip %q) = %v; want %q", tt.only, tt.skip, err, tt.wantErr)
+			}
+			continue
+		}
+		var got []string
+		for _, a := range selected {
+			got = append(got, a.Name)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") || err != nil {
+			t.Errorf("selectActions(-only %q, -skip %q) = %q, %v; want %q", tt.only, tt.skip, got, err, tt.want)
+		}
+	}
+}
+
+func TestOnlySelectedActionsRun(t *testing.T) {
+	savedOnly, savedSkip := onlyActionsCSV, skipActionsCSV
+	onlyActionsCSV, skipActionsCSV = "build,test", "test"
+	t.Cleanup(func() { onlyActionsCSV, skipActionsCSV = savedOnly, savedSkip })
+	w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- name: build
+  exec: {command: ["true"]}
+- name: test
+  exec: {command: ["true"]}
+- name: lint
+  exec: {command: ["true"]}
+`)
+	w.write("a.txt", "a")
+	w.waitFor("the run for the event", func() bool { return len(w.completed()) >= 2 })
+	w.stop()
+	for _, result := range w.completed() {
+		if result["name"] != "build" {
+			t.Errorf("actionCompleted = %v; want only runs of build", result)
+		}
+	}
+	if len(config.Actions) != 1 || config.Actions[0].Name != "build" {
+		t.Errorf("the generation has actions %v; want only build", config.Actions)
+	}
+}
+
+func TestExpandWatchPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{"cmd/a", "cmd/b", "pkg/x/internal", "pkg/y", "pkg/z/internal"} {
+		if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, dir, "cmd/main.go", "")
+	join := func(paths ...string) (out []string) {
+		for _, path := range paths {
+			out = append(out, filepath.Join(dir, path))
+		}
+		return out
+	}
+	tests := []struct {
+		paths       []string
+		want        []string
+		wantWarning string
+	}{
+		{[]string{"cmd/*"}, join("cmd/a", "cmd/b", "cmd/main.go"), ""},
+		{[]string{"pkg/*/internal"}, join("pkg/x/internal", "pkg/z/internal"), ""},
+		{[]string{"cmd/[ab]", "pkg/y"}, join("cmd/a", "cmd/b", "pkg/y"), ""},
+		// paths without glob metacharacters are kept, even if they do not exist
+		{[]string{"missing"}, join("missing"), ""},
+		{[]string{"cmd/*/missing", "pkg"}, join("pkg"), "does not match any files"},
+	}
+	for _, tt := range tests {
+		stderr := captureStderr(t)
+		var targets watchTargetList
+		for _, path := range join(tt.paths...) {
+			targets = append(targets, watchTarget{Path: path, Filter: Filter{Extensions: []string{"go"}}})
+		}
+		var got []string
+		for _, target := range expandWatchPaths(targets) {
+			got = append(got, target.Path)
+			if len(target.Extensions) != 1 {
+				t.Errorf("%v: the expanded target %s lost its filter", tt.paths, target.Path)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%v: expandWatchPaths() = %v; want %v", tt.paths, got, tt.want)
+		}
+		warnings := stderr.recordsWith(t, "warning")
+		switch {
+		case tt.wantWarning == "" && len(warnings) > 0:
+			t.Errorf("%v: got warnings %v", tt.paths, warnings)
+		case tt.wantWarning != "" && (len(warnings) != 1 || !strings.Contains(warnings[0]["warning"].(string), tt.wantWarning)):
+			t.Errorf("%v: got warnings %v; want one that the glob %s", tt.paths, warnings, tt.wantWarning)
+		}
+	}
+}
+
+// eventsFor returns the ops of the events for the path
+func (w *watchfsTest) eventsFor(path string) (ops []string) {
+	for _, e := range w.events() {
+		if e["path"] == path {
+			ops = append(ops, e["op"].(string))
+		}
+	}
+	return ops
+}
+
+func TestWatchSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", "a: 1")
+	w := startWatchfsIn(t, dir, `
+paths: [$DIR/config.yaml]
+`)
+	w.write("other.yaml", "b: 1")
+	w.write("config.yaml", "a: 2")
+	w.waitFor("an event for the file", func() bool { return len(w.eventsFor(path)) > 0 })
+	for _, e := range w.events() {
+		if e["path"] != path {
+			t.Errorf("got an event for %v; want only events for %s", e["path"], path)
+		}
+	}
+	if watching := w.infos("watching")[0]["watching"].(map[string]interface{}); watching["files"] != 1.0 || watching["dirs"] != 0.0 {
+		t.Errorf("watching %v; want only the file", watching)
+	}
+}
+
+func TestWatchedFileSurvivesAtomicSaves(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", "a: 1")
+	w := startWatchfsIn(t, dir, `
+paths: [$DIR/config.yaml]
+`)
+	for i := 0; i < 2; i++ {
+		before := len(w.eventsFor(path))
+		// save the way editors do: write a temporary file, and rename it over the original
+		temp := writeFile(t, dir, ".config.yaml.swp", fmt.Sprintf("a: %d", i+2))
+		if err := os.Rename(temp, path); err != nil {
+			t.Fatal(err)
+		}
+		w.waitFor(fmt.Sprintf("save %d to be reported", i+1), func() bool {
+			ops := w.eventsFor(path)
+			return len(ops) > before && ops[len(ops)-1] == "write"
+		})
+	}
+	before := len(w.eventsFor(path))
+	w.write("config.yaml", "a: 4")
+	w.waitFor("the file to be watched after the saves", func() bool { return len(w.eventsFor(path)) > before })
+}
+
+func TestWatchSummary(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{"a/b", "c"} {
+		if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, dir, "a/main.go", "")
+	w := startWatchfsIn(t, dir, `
+paths: [$DIR]
+exts: [go]
+ops: [write, create]
+actions:
+- name: build
+  exec: {command: ["true"]}
+- shell: {command: "true"}
+`)
+	got := w.infos("watching")[0]["watching"].(map[string]interface{})
+	want := map[string]interface{}{
+		"config": configPath,
+		"dirs":   4.0,
+		"files":  0.0,
+		"exts":   []interface{}{"go"},
+		"ops":    []interface{}{"write", "create"},
+		"actions": []interface{}{
+			map[string]interface{}{"type": "exec", "name": "build"},
+			map[string]interface{}{"type": "shell"},
+		},
+		"duration": got["duration"],
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("watching %v; want %v", got, want)
+	}
+}
+
+func TestListWatches(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "src/a.go", "a")
+	writeFile(t, dir, "src/vendor/lib/b.go", "b")
+	writeFile(t, dir, "docs/c.md", "c")
+	writeFile(t, dir, "build/out/d.o", "d")
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	savedStdout, savedList := os.Stdout, listWatches
+	os.Stdout, listWatches = out, true
+	t.Cleanup(func() { os.Stdout, listWatches = savedStdout, savedList })
+	w := startWatchfsIn(t, dir, `
+paths: [$DIR]
+ignore: [build, "**/vendor"]
+`)
+	w.stop()
+	var watches struct{ Dirs, Files []string }
+	data, _ := ioutil.ReadFile(out.Name())
+	if err := json.Unmarshal(data, &watches); err != nil {
+		t.Fatalf("invalid watch list %q: %v", data, err)
+	}
+	want := []string{dir, filepath.Join(dir, "docs"), filepath.Join(dir, "src")}
+	if !reflect.DeepEqual(watches.Dirs, want) || len(watches.Files) != 0 {
+		t.Errorf("listed dirs %q and files %q; want dirs %q", watches.Dirs, watches.Files, want)
+	}
+}
+
+func TestStartupGrace(t *testing.T) {
+	useVerbose(t, true)
+	w := startWatchfs(t, `
+paths: [$DIR]
+startupGrace: 1s
+actions:
+- exec: {command: ["true"]}
+`)
+	ready := time.Unix(0, atomic.LoadInt64(&readyAt))
+	w.write("early.txt", "a")
+	w.waitFor("the early event to be ignored", func() bool {
+		for _, info := range w.infos("filtered") {
+			if info["stage"] == "startupGrace" && info["filtered"] == w.path("early.txt") {
+				return true
+			}
+		}
+		return false
+	})
+	time.Sleep(time.Until(ready))
+	w.write("late.txt", "b")
+	w.waitFor("the run", func() bool {
+		for _, result := range w.completed() {
+			if result["path"] != nil {
+				return true
+			}
+		}
+		return false
+	})
+	w.stop()
+	for _, e := range w.events() {
+		if e["path"] == w.path("early.txt") {
+			t.Errorf("reported the event during the grace period: %v", e)
+		}
+	}
+	for _, result := range w.completed() {
+		if path := result["path"]; path != nil && path != w.path("late.txt") {
+			t.Errorf("ran for %v", path)
+		}
+	}
+}
+
+func TestIgnoreInitialFlag(t *testing.T) {
+	savedInitial, savedGrace := ignoreInitial, startupGrace
+	defer func() { ignoreInitial, startupGrace = savedInitial, savedGrace }()
+	tests := []struct {
+		ignoreInitial bool
+		startupGrace  string
+		want          string
+	}{
+		{true, "", defaultStartupGrace.String()},
+		{true, "2s", "2s"},
+		{false, "100", "100"},
+		{false, "", ""},
+	}
+	for _, tt := range tests {
+		ignoreInitial, startupGrace = tt.ignoreInitial, tt.startupGrace
+		useConfig(t, configuration{})
+		flagsToConfiguration()
+		if config.StartupGrace != tt.want {
+			t.Errorf("-ignore-initial=%v -startup-grace=%q: startupGrace = %q; want %q", tt.ignoreInitial, tt.startupGrace, config.StartupGrace, tt.want)
+		}
+	}
+}
+
+func TestRequirePaths(t *testing.T) {
+	tests := []struct {
+		yaml string
+		args []string
+		want string
+	}{
+		{"requirePaths: true\n", nil, "no paths to watch specified (requirePaths is set)"},
+		{"", []string{"-strict-paths"}, "no paths to watch specified (requirePaths is set)"},
+		{"requirePaths: true\npaths: ['$DIR/missing-*']\n", nil, "none of the paths to watch match any files (requirePaths is set)"},
+	}
+	for _, tt := range tests {
+		code, stderr := runWatchfsProcess(t, tt.yaml, tt.args...)
+		if code != 1 || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%q %q: exit code %d, stderr:\n%s\nwant exit code 1 and %q", tt.yaml, tt.args, code, stderr, tt.want)
+		}
+	}
+}
+
+func TestWatchCurrentDirectoryWithoutPaths(t *testing.T) {
+	p := startWatchfsProcess(t, "")
+	if stderr := p.stderr.String(); !strings.Contains(stderr, "no paths to watch specified. watching the current directory.") {
+		t.Errorf("stderr:\n%s\nwant the warning about watching the current directory", stderr)
+	}
+	if err := ioutil.WriteFile(filepath.Join(p.cmd.Dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(p.stdout.String(), `"path":"./a.txt"`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no event for the current directory\nstdout:\n%s", &p.stdout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metrics are the collectors describing the activity of this watchfs instance, in a
+// registry of their own so that only they are exported
+type metrics struct {
+	registry *prometheus.Registry
+
+	eventsSeen     prometheus.Counter
+	eventsFiltered prometheus.Counter
+	actionsRun     prometheus.Counter
+	actionFailures prometheus.Counter
+	lastEvent      prometheus.Gauge // unix seconds
+	durations      prometheus.Histogram
+}
+
+// durationBuckets are the upper bounds of the action duration histogram's buckets, in seconds
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		eventsSeen: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "watchfs_events_total",
+			Help: "Filesystem events seen.",
+		}),
+		eventsFiltered: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "watchfs_events_filtered_total",
+			Help: "Filesystem events rejected by the filters.",
+		}),
+		actionsRun: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "watchfs_action_runs_total",
+			Help: "Completed action runs.",
+		}),
+		actionFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "watchfs_action_failures_total",
+			Help: "Action runs that returned an error.",
+		}),
+		lastEvent: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "watchfs_last_event_timestamp_seconds",
+			Help: "Unix time of the last filesystem event.",
+		}),
+		durations: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "watchfs_action_duration_seconds",
+			Help:    "Duration of action runs.",
+			Buckets: durationBuckets,
+		}),
+	}
+	m.registry.MustRegister(
+		m.eventsSeen, m.eventsFiltered, m.actionsRun, m.actionFailures, m.lastEvent, m.durations,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "watchfs_watched_dirs",
+			Help: "Directories currently watched.",
+		}, func() float64 {
+			dirs, _ := watched.counts()
+			return float64(dirs)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "watchfs_watched_files",
+			Help: "Individual files currently watched.",
+		}, func() float64 {
+			_, files := watched.counts()
+			return float64(files)
+		}),
+	)
+	return m
+}
+
+var stats = newMetrics()
+
+func (m *metrics) onEvent() {
+	m.eventsSeen.Inc()
+	m.lastEvent.SetToCurrentTime()
+}
+
+func (m *metrics) onEventFiltered() {
+	m.eventsFiltered.Inc()
+}
+
+func (m *metrics) onActionCompleted(duration time.Duration, err error) {
+	m.actionsRun.Inc()
+	m.durations.Observe(duration.Seconds())
+	if err != nil {
+		m.actionFailures.Inc()
+	}
+}
+
+type metricsSnapshot struct {
+	EventsSeen     int64      `json:"eventsSeen"`
+	EventsFiltered int64      `json:"eventsFiltered"`
+	ActionsRun     int64      `json:"actionsRun"`
+	ActionFailures int64      `json:"actionFailures"`
+	WatchedDirs    int        `json:"watchedDirs"`
+	WatchedFiles   int        `json:"watchedFiles"`
+	LastEvent      *time.Time `json:"lastEvent"`
+}
+
+// snapshot returns the current values of the collectors, for the JSON at /stats
+func (m *metrics) snapshot() metricsSnapshot {
+	s := metricsSnapshot{
+		EventsSeen:     int64(counterValue(m.eventsSeen)),
+		EventsFiltered: int64(counterValue(m.eventsFiltered)),
+		ActionsRun:     int64(counterValue(m.actionsRun)),
+		ActionFailures: int64(counterValue(m.actionFailures)),
+	}
+	s.WatchedDirs, s.WatchedFiles = watched.counts()
+	var lastEvent dto.Metric
+	if m.lastEvent.Write(&lastEvent) == nil && lastEvent.GetGauge().GetValue() != 0 {
+		t := time.Unix(0, int64(lastEvent.GetGauge().GetValue()*1e9))
+		s.LastEvent = &t
+	}
+	return s
+}
+
+// counterValue returns the current value of the counter
+func counterValue(c prometheus.Counter) float64 {
+	var metric dto.Metric
+	if err := c.Write(&metric); err != nil {
+		return 0
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func (m *metrics) serveJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.snapshot())
+}
+
+// prometheusHandler serves the registry's metrics in the Prometheus exposition format
+// the scraper asks for, by default the text format
+func (m *metrics) prometheusHandler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+}
+
+// serveMetrics serves the metrics at addr until the returned function is called:
+// JSON at /stats (and /), and the Prometheus exposition format at /metrics.
+func serveMetrics(addr string) (stop func()) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", stats.serveJSON)
+	mux.HandleFunc("/stats", stats.serveJSON)
+	mux.Handle("/metrics", stats.prometheusHandler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			onError(err)
+		}
+	}()
+	return func() {
+		server.Shutdown(context.Background())
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestMetricsEndpoints(t *testing.T) {
+	m := newMetrics()
+	server := httptest.NewServer(http.HandlerFunc(m.serveJSON))
+	defer server.Close()
+	get := func() (s metricsSnapshot) {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if s := get(); s.EventsSeen != 0 || s.LastEvent != nil {
+		t.Errorf("got %+v before any events", s)
+	}
+	before := time.Now()
+	m.onEvent()
+	m.onEvent()
+	m.onEvent()
+	m.onEventFiltered()
+	m.onActionCompleted(20*time.Millisecond, nil)
+	m.onActionCompleted(2*time.Second, errors.New("exit status 1"))
+	s := get()
+	if s.EventsSeen != 3 || s.EventsFiltered != 1 || s.ActionsRun != 2 || s.ActionFailures != 1 {
+		t.Errorf("got %+v; want 3 events, 1 filtered, 2 runs and 1 failure", s)
+	}
+	if s.LastEvent == nil || s.LastEvent.Before(before.Truncate(time.Second)) {
+		t.Errorf("lastEvent = %v; want the time of the last event", s.LastEvent)
+	}
+
+	recorder := httptest.NewRecorder()
+	m.prometheusHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"# TYPE watchfs_events_total counter\nwatchfs_events_total 3\n",
+		"watchfs_events_filtered_total 1\n",
+		"watchfs_action_runs_total 2\n",
+		"watchfs_action_failures_total 1\n",
+		"# TYPE watchfs_action_duration_seconds histogram\n",
+		`watchfs_action_duration_seconds_bucket{le="0.01"} 0` + "\n",
+		`watchfs_action_duration_seconds_bucket{le="0.025"} 1` + "\n",
+		`watchfs_action_duration_seconds_bucket{le="2.5"} 2` + "\n",
+		`watchfs_action_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"watchfs_action_duration_seconds_sum 2.02\nwatchfs_action_duration_seconds_count 2\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the Prometheus metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsCountPipelineActivity(t *testing.T) {
+	before := stats.snapshot()
+	w := startWatchfs(t, `
+paths: [$DIR]
+exts: [go]
+actions:
+- exec: {command: ["false"]}
+`)
+	w.write("a.go", "package a")
+	w.write("a.txt", "filtered")
+	w.waitFor("the runs", func() bool { return len(w.completed()) >= 2 })
+	w.waitFor("the filtered event", func() bool { return stats.snapshot().EventsFiltered > before.EventsFiltered })
+	w.stop()
+
+	after := stats.snapshot()
+	if after.EventsSeen <= before.EventsSeen || after.LastEvent == nil {
+		t.Errorf("eventsSeen = %d, lastEvent = %v after the events", after.EventsSeen, after.LastEvent)
+	}
+	if runs, failures := after.ActionsRun-before.ActionsRun, after.ActionFailures-before.ActionFailures; runs != int64(len(w.completed())) || failures != runs {
+		t.Errorf("got %d runs and %d failures; want %d failed runs", runs, failures, len(w.completed()))
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	listener := httptest.NewServer(nil)
+	addr := listener.Listener.Addr().String()
+	listener.Close() // free the address for serveMetrics
+	stop := serveMetrics(addr)
+	var resp *http.Response
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if resp, err = http.Get("http://" + addr + "/stats"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"eventsSeen"`) {
+		t.Errorf("/stats = %s", body)
+	}
+	stop()
+	if _, err := http.Get("http://" + addr + "/stats"); err == nil {
+		t.Error("the server is still running after stop")
+	}
+}
+
+func TestPrometheusExposition(t *testing.T) {
+	m := newMetrics()
+	m.onEvent()
+	m.onActionCompleted(time.Second, nil)
+	recorder := httptest.NewRecorder()
+	m.prometheusHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q; want the text format", ct)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{}
+	for name, family := range families {
+		types[name] = strings.ToLower(family.GetType().String())
+		if family.GetHelp() == "" {
+			t.Errorf("%s has no HELP", name)
+		}
+	}
+	want := map[string]string{
+		"watchfs_events_total":                 "counter",
+		"watchfs_events_filtered_total":        "counter",
+		"watchfs_action_runs_total":            "counter",
+		"watchfs_action_failures_total":        "counter",
+		"watchfs_watched_dirs":                 "gauge",
+		"watchfs_watched_files":                "gauge",
+		"watchfs_last_event_timestamp_seconds": "gauge",
+		"watchfs_action_duration_seconds":      "histogram",
+	}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Errorf("got metrics %v; want %v", types, want)
+	}
+	h := families["watchfs_action_duration_seconds"].GetMetric()[0].GetHistogram()
+	if h.GetSampleCount() != 1 || h.GetSampleSum() != 1 || len(h.GetBucket()) != len(durationBuckets)+1 {
+		t.Errorf("histogram = %v; want the one run in the duration buckets and +Inf", h)
+	}
+
+	// scrapers asking for the protobuf format get it
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/metrics", nil)
+	request.Header.Set("Accept", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	m.prometheusHandler().ServeHTTP(recorder, request)
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/vnd.google.protobuf") {
+		t.Errorf("Content-Type = %q; want the protobuf format", ct)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// awaitPath waits for a watch path that does not exist (see `watchMissing`) by watching
+// its nearest existing ancestor directory. Once the path exists, it is watched as usual.
+func awaitPath(w Watcher, path string) {
+	for {
+		parent := existingAncestor(path)
+		if parent == path {
+			onInfo(struct {
+				WatchingCreated string `json:"watchingCreated"`
+			}{
+				WatchingCreated: path,
+			})
+			watchRecursive(w, path)
+			return
+		}
+		if err := w.Add(parent); err != nil {
+			onError(err)
+			return
+		}
+		if watched.addMissing(path, parent) {
+			onInfo(struct {
+				WaitingFor string `json:"waitingFor"`
+				Parent     string `json:"parent"`
+			}{
+				WaitingFor: path,
+				Parent:     parent,
+			})
+		}
+		// the path (or a directory on the way) may have been created before parent was watched
+		if existingAncestor(path) == parent {
+			return
+		}
+		watched.takeMissing(path)
+	}
+}
+
+// existingAncestor returns the path itself if it exists, or else its nearest existing ancestor
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// onMissingEvent continues waiting for the missing watch paths below a path that an
+// event creates or removes, and starts waiting again for a watch path that an event removes.
+// It returns whether the event should be reported: events in directories watched only
+// to wait for a missing path are not, except for those of the path itself.
+func onMissingEvent(w Watcher, e fsnotify.Event) bool {
+	if !config.WatchMissing {
+		return true
+	}
+	path := filepath.Clean(e.Name)
+	var missing []string
+	if e.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+		missing = watched.takeMissing(path)
+	}
+	if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && len(missing) == 0 && watched.isFound(path) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			missing = append(missing, path)
+		}
+	}
+	for _, path := range missing {
+		awaitPath(w, path)
+	}
+	return !watched.isWaiting(filepath.Dir(path)) || watched.isAwaited(path)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWatchMissing(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "dist", "out")
+	w := startWatchfsIn(t, dir, `
+paths: [$DIR/dist/out]
+watchMissing: true
+`)
+	w.waitFor("the wait for the missing path", func() bool { return len(w.infos("waitingFor")) > 0 })
+	if info := w.infos("waitingFor")[0]; info["waitingFor"] != out || info["parent"] != dir {
+		t.Errorf("waitingFor = %v; want %s waited for in %s", info, out, dir)
+	}
+
+	// the directories on the way are waited for in turn
+	w.write("other.txt", "not watched")
+	if err := os.MkdirAll(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	w.waitFor("the created path to be watched", func() bool { return len(w.infos("watchingCreated")) > 0 })
+	w.write("dist/out/a.txt", "a")
+	a := filepath.Join(out, "a.txt")
+	w.waitFor("the event in the created path", func() bool {
+		for _, e := range w.events() {
+			if e["path"] == a {
+				return true
+			}
+		}
+		return false
+	})
+
+	// a removed watch path is waited for again
+	waits := len(w.infos("waitingFor"))
+	if err := os.RemoveAll(out); err != nil {
+		t.Fatal(err)
+	}
+	w.waitFor("the wait for the removed path", func() bool { return len(w.infos("waitingFor")) > waits })
+	w.stop()
+	for _, e := range w.events() {
+		if path := e["path"].(string); path != out && !strings.HasPrefix(path, out+string(filepath.Separator)) {
+			t.Errorf("reported %v outside the watch path", e)
+		}
+	}
+}
+
+func TestMissingPathWithoutWatchMissing(t *testing.T) {
+	dir := t.TempDir()
+	w := startWatchfsIn(t, dir, `
+paths: [$DIR, $DIR/dist]
+`)
+	// the error is reported before the watches are set up
+	errors := w.stderr.recordsWith(t, "error")
+	if len(errors) != 1 || !strings.Contains(errors[0]["error"].(string), filepath.Join(dir, "dist")) {
+		t.Errorf("got errors %v; want one for the missing path", errors)
+	}
+	if len(w.infos("waitingFor")) != 0 {
+		t.Error("waits for the missing path without watchMissing")
+	}
+}
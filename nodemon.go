@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nodemonConfig is the subset of nodemon's `nodemon.json` that watchfs understands
+type nodemonConfig struct {
+	Exec            string            `json:"exec"`
+	Script          string            `json:"script"`
+	Args            []string          `json:"args"`
+	Ext             string            `json:"ext"`
+	Watch           stringList        `json:"watch"`
+	Ignore          stringList        `json:"ignore"`
+	Delay           json.RawMessage   `json:"delay"`
+	Signal          string            `json:"signal"`
+	Env             map[string]string `json:"env"`
+	ExecMap         map[string]string `json:"execMap"`
+	Events          map[string]string `json:"events"`
+	LegacyWatch     bool              `json:"legacyWatch"`
+	PollingInterval json.RawMessage   `json:"pollingInterval"`
+}
+
+// nodemonIgnoredOptions are nodemon options that have no effect on watchfs and are ignored without a warning
+var nodemonIgnoredOptions = map[string]bool{
+	"colours":     true,
+	"restartable": true,
+	"verbose":     true,
+}
+
+// nodemonDefaultExec is the interpreter nodemon runs `script` with if `execMap` has no entry for it
+const nodemonDefaultExec = "node"
+
+// decodeNodemon translates a nodemon.json into a configuration.
+// It returns the options that could not be translated.
+func decodeNodemon(data []byte) (c configuration, unsupported []string, err error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return c, nil, fmt.Errorf("nodemon.json: %v", err)
+	}
+	var n nodemonConfig
+	if err := json.Unmarshal(data, &n); err != nil {
+		return c, nil, fmt.Errorf("nodemon.json: %v", err)
+	}
+	known := make(map[string]bool)
+	for _, field := range []string{"exec", "script", "args", "ext", "watch", "ignore", "delay", "signal", "env", "execMap", "events", "legacyWatch", "pollingInterval"} {
+		known[field] = true
+	}
+	for key := range raw {
+		if !known[key] && !nodemonIgnoredOptions[key] {
+			unsupported = append(unsupported, key)
+		}
+	}
+	c.Paths = watchTargetsOf(n.Watch...)
+	for _, pattern := range n.Ignore {
+		glob, ok := nodemonIgnoreGlob(pattern)
+		if !ok {
+			unsupported = append(unsupported, "ignore: "+pattern)
+			continue
+		}
+		c.IgnoreWatch = append(c.IgnoreWatch, glob)
+	}
+	c.Extensions = nodemonExtensions(n.Ext)
+	c.Signal = n.Signal
+	c.Env = n.Env
+	c.Poll = n.LegacyWatch
+	if c.Delay, err = nodemonDelay(n.Delay); err != nil {
+		return c, nil, fmt.Errorf("nodemon.json: delay: %v", err)
+	}
+	if len(n.PollingInterval) > 0 {
+		var ms int64
+		if err := json.Unmarshal(n.PollingInterval, &ms); err != nil {
+			return c, nil, fmt.Errorf("nodemon.json: pollingInterval: %v", err)
+		}
+		c.PollInterval = fmt.Sprint(ms)
+	}
+	if command := n.command(); command != "" {
+		c.Actions = append(c.Actions, Action{
+			ActionShell: &ActionShell{Command: command},
+		})
+	}
+	for event, command := range n.Events {
+		if event != "start" {
+			unsupported = append(unsupported, "events."+event)
+			continue
+		}
+		c.Actions = append(c.Actions, Action{
+			Name:        "start",
+			ActionShell: &ActionShell{Command: command},
+		})
+	}
+	sort.Strings(unsupported)
+	return c, unsupported, nil
+}
+
+// command returns the shell command nodemon would run: `exec`, or `script` run with the
+// interpreter `execMap` gives for its extension, followed by `args`
+func (n *nodemonConfig) command() string {
+	command := n.Exec
+	if command == "" && n.Script != "" {
+		interpreter, ok := n.ExecMap[strings.TrimPrefix(filepath.Ext(n.Script), ".")]
+		if !ok {
+			interpreter = nodemonDefaultExec
+		}
+		command = interpreter + " " + n.Script
+	}
+	if command == "" {
+		return ""
+	}
+	for _, arg := range n.Args {
+		command += " " + fmt.Sprintf("%q", arg)
+	}
+	return command
+}
+
+// nodemonIgnoreGlob translates a nodemon `ignore` pattern (matched anywhere below the
+// watched paths, like `*.test.js` or `node_modules/**`) to an `ignore` glob: relative patterns
+// are prefixed with `**/`. It returns false for minimatch syntax that filepath.Match lacks
+// (negation, braces, extglobs) and for invalid patterns.
+func nodemonIgnoreGlob(pattern string) (string, bool) {
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+	if pattern == "" || strings.HasPrefix(pattern, "!") || strings.ContainsAny(pattern, "{}") {
+		return "", false
+	}
+	for _, extglob := range []string{"?(", "*(", "+(", "@(", "!("} {
+		if strings.Contains(pattern, extglob) {
+			return "", false
+		}
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return "", false
+	}
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**/") {
+		pattern = "**/" + pattern
+	}
+	return pattern, true
+}
+
+// nodemonExtensions splits nodemon's comma- or space-separated `ext`
+func nodemonExtensions(ext string) (exts []string) {
+	for _, e := range strings.FieldsFunc(ext, func(r rune) bool { return r == ',' || r == ' ' }) {
+		exts = append(exts, strings.TrimPrefix(e, "."))
+	}
+	return exts
+}
+
+// nodemonDelay converts nodemon's `delay` (milliseconds as a number, or seconds or a duration
+// as a string) to a watchfs duration string
+func nodemonDelay(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+	var ms float64
+	if err := json.Unmarshal(raw, &ms); err == nil {
+		return fmt.Sprint(time.Duration(ms * float64(time.Millisecond))), nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", err
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return fmt.Sprint(time.Duration(seconds * float64(time.Second))), nil
+	}
+	if _, err := time.ParseDuration(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
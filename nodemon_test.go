@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadNodemonConfig(t *testing.T) {
+	stderr := captureStderr(t)
+	path := writeFile(t, t.TempDir(), "nodemon.json", `{
+  "watch": ["src", "lib"],
+  "ext": "js, json .ts",
+  "ignore": ["*.test.js", "node_modules/**", "!keep.js"],
+  "exec": "npm start",
+  "args": ["--port", "8080"],
+  "delay": 2.5,
+  "signal": "SIGINT",
+  "env": {"NODE_ENV": "development"},
+  "legacyWatch": true,
+  "pollingInterval": 250,
+  "events": {"start": "echo started", "crash": "echo crashed"},
+  "verbose": true,
+  "runOnChangeOnly": true
+}`)
+	var c configuration
+	if err := c.load(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	want := configuration{
+		Paths:        watchTargetsOf("src", "lib"),
+		Extensions:   []string{"js", "json", "ts"},
+		IgnoreWatch:  []string{"**/*.test.js", "**/node_modules/**"},
+		Signal:       "SIGINT",
+		Env:          map[string]string{"NODE_ENV": "development"},
+		Poll:         true,
+		PollInterval: "250",
+		Delay:        "2.5ms",
+		Actions: []Action{
+			{ActionShell: &ActionShell{Command: `npm start "--port" "8080"`}},
+			{Name: "start", ActionShell: &ActionShell{Command: "echo started"}},
+		},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("loaded\n%+v\nwant\n%+v", c, want)
+	}
+	warning := stderr.String()
+	for _, option := range []string{"events.crash", "ignore: !keep.js", "runOnChangeOnly"} {
+		if !strings.Contains(warning, option) {
+			t.Errorf("warned %q; want %q listed as unsupported", warning, option)
+		}
+	}
+	if strings.Contains(warning, "verbose") {
+		t.Errorf("warned %q about an option without an effect", warning)
+	}
+}
+
+func TestNodemonCommand(t *testing.T) {
+	tests := []struct {
+		n    nodemonConfig
+		want string
+	}{
+		{nodemonConfig{Exec: "go run ."}, "go run ."},
+		{nodemonConfig{Script: "server.js"}, "node server.js"},
+		{nodemonConfig{Script: "app.py", ExecMap: map[string]string{"py": "python3"}}, "python3 app.py"},
+		{nodemonConfig{Exec: "make", Script: "ignored.js"}, "make"},
+		{nodemonConfig{Script: "server.js", Args: []string{"a b"}}, `node server.js "a b"`},
+		{nodemonConfig{Args: []string{"no command"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.n.command(); got != tt.want {
+			t.Errorf("command() of %+v = %q; want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNodemonDelay(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{``, "", false},
+		{`500`, "500ms", false},
+		{`"2.5"`, "2.5s", false},
+		{`"1m"`, "1m", false},
+		{`"soon"`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := nodemonDelay([]byte(tt.raw))
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("nodemonDelay(%s) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestNodemonIgnoreGlob(t *testing.T) {
+	var supported []string
+	for _, pattern := range []string{"dist", "./build/*", "/abs/**", "**/tmp", "!keep", "*.{js,ts}", "+(a|b)", "[", ""} {
+		if glob, ok := nodemonIgnoreGlob(pattern); ok {
+			supported = append(supported, glob)
+		}
+	}
+	sort.Strings(supported)
+	if want := []string{"**/build/*", "**/dist", "**/tmp", "/abs/**"}; !reflect.DeepEqual(supported, want) {
+		t.Errorf("translated %v; want %v", supported, want)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	defaultNotifyTitle   = "watchfs"
+	defaultNotifyMessage = "{{.Path}}"
+)
+
+// ActionNotify shows a desktop notification
+type ActionNotify struct {
+	Title   string `json:"title,omitempty" yaml:"title,omitempty"`
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+func (a *ActionNotify) makeCanonical() error {
+	if a.Title == "" {
+		a.Title = defaultNotifyTitle
+	}
+	if a.Message == "" {
+		a.Message = defaultNotifyMessage
+	}
+	return nil
+}
+
+// Notify notifies the action about a filesystem event
+func (a *ActionNotify) Notify(e Event) (bool, error) {
+	return false, nil
+}
+
+// Run shows the notification using the platform's notifier
+func (a *ActionNotify) Run(ctx context.Context, events []Event) error {
+	command, err := a.commandLine(events)
+	if err != nil {
+		return err
+	}
+	out, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+	if err != nil {
+		if output := strings.TrimSpace(string(out)); output != "" {
+			return fmt.Errorf("%s: %v: %s", command[0], err, output)
+		}
+		return fmt.Errorf("%s: %v", command[0], err)
+	}
+	return nil
+}
+
+// commandLine returns the notifier command line for the expanded title and message
+func (a *ActionNotify) commandLine(events []Event) ([]string, error) {
+	data := newTemplateData(events)
+	title, err := expandTemplate(a.Title, data)
+	if err != nil {
+		return nil, fmt.Errorf("title: %v", err)
+	}
+	message, err := expandTemplate(a.Message, data)
+	if err != nil {
+		return nil, fmt.Errorf("message: %v", err)
+	}
+	return notifyCommand(runtime.GOOS, title, message), nil
+}
+
+// notifyCommand returns the command showing a notification on the given OS:
+// osascript on macOS, a PowerShell toast on Windows, and notify-send elsewhere
+func notifyCommand(goos, title, message string) []string {
+	switch goos {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		return []string{"osascript", "-e", script}
+	case "windows":
+		script := fmt.Sprintf(windowsToastScript, powerShellString(title), powerShellString(message))
+		return []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", script}
+	}
+	return []string{"notify-send", "--", title, message}
+}
+
+// windowsToastScript shows a toast notification with a title and a message
+const windowsToastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null; ` +
+	`$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02); ` +
+	`$text = $xml.GetElementsByTagName('text'); ` +
+	`$text.Item(0).AppendChild($xml.CreateTextNode(%s)) > $null; ` +
+	`$text.Item(1).AppendChild($xml.CreateTextNode(%s)) > $null; ` +
+	`[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('watchfs').Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// powerShellString quotes s as a single-quoted PowerShell string literal.
+// PowerShell also accepts typographic single quotes as delimiters, so they are doubled too.
+func powerShellString(s string) string {
+	return "'" + strings.NewReplacer("'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019", "\u201a", "\u201a\u201a", "\u201b", "\u201b\u201b").Replace(s) + "'"
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestNotifyCommand(t *testing.T) {
+	tests := []struct {
+		goos string
+		want []string
+	}{
+		{"linux", []string{"notify-send", "--", `build "failed"`, "it's broken"}},
+		{"freebsd", []string{"notify-send", "--", `build "failed"`, "it's broken"}},
+		{"darwin", []string{"osascript", "-e", `display notification "it's broken" with title "build \"failed\""`}},
+	}
+	for _, tt := range tests {
+		if got := notifyCommand(tt.goos, `build "failed"`, "it's broken"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: notifyCommand() = %q; want %q", tt.goos, got, tt.want)
+		}
+	}
+	windows := notifyCommand("windows", `build "failed"`, "it's broken")
+	if len(windows) != 5 || windows[0] != "powershell" || windows[3] != "-Command" {
+		t.Fatalf("windows: notifyCommand() = %q; want a PowerShell command", windows)
+	}
+	if script := windows[4]; !strings.Contains(script, `CreateTextNode('build "failed"')`) || !strings.Contains(script, `CreateTextNode('it''s broken')`) {
+		t.Errorf("windows: the script does not contain the quoted title and message: %s", script)
+	}
+}
+
+func TestPowerShellString(t *testing.T) {
+	if got, want := powerShellString("it's ‘quoted’"), "'it''s ‘‘quoted’’'"; got != want {
+		t.Errorf("powerShellString() = %s; want %s", got, want)
+	}
+}
+
+func TestNotifyCommandLine(t *testing.T) {
+	a := &ActionNotify{Title: "{{.Base}} changed"}
+	if err := a.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := a.commandLine([]Event{{Name: "src/a.go", Op: fsnotify.Write}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := notifyCommand(runtime.GOOS, "a.go changed", "src/a.go"); !reflect.DeepEqual(got, want) {
+		t.Errorf("commandLine() = %q; want %q", got, want)
+	}
+	a.Message = "{{.Missing"
+	if _, err := a.commandLine(nil); err == nil || !strings.HasPrefix(err.Error(), "message: ") {
+		t.Errorf("commandLine() = %v; want an error for the invalid message template", err)
+	}
+}
+
+func TestBellOnFailure(t *testing.T) {
+	stderr := redirect(t, &os.Stderr)
+	w := startWatchfs(t, `
+paths: [$DIR]
+bell: true
+actions:
+- exec: {command: ["true"], ignoreSignals: true}
+- exec: {command: ["false"], ignoreSignals: true}
+`)
+	w.write("a.txt", "a")
+	w.waitFor("the runs", func() bool { return len(w.completed()) >= 4 })
+	w.stop()
+	var failures int
+	for _, result := range w.completed() {
+		if result["exitCode"] != 0.0 {
+			failures++
+		}
+	}
+	if got := strings.Count(stderr(), "\a"); got != failures {
+		t.Errorf("rang the bell %d times for %d failed runs", got, failures)
+	}
+}
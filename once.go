@@ -0,0 +1,47 @@
+package main
+
+import "sync"
+
+// onceRound tracks the single round of action runs performed with -once
+var onceRound struct {
+	mu        sync.Mutex
+	started   bool
+	remaining int
+}
+
+// exitStatus is the exit code of watchfs once it has been asked to exit
+var exitStatus int
+
+// startOnceRound starts the round with the given number of triggered runs
+// (one per action, or one per path for `perFile` actions).
+// It returns false if the round has already started.
+func startOnceRound(runs int) bool {
+	onceRound.mu.Lock()
+	defer onceRound.mu.Unlock()
+	if onceRound.started {
+		return false
+	}
+	onceRound.started = true
+	onceRound.remaining = runs
+	return true
+}
+
+// onceRunCompleted records the result of a run in the round. When all triggered
+// runs have completed, watchfs exits with the first non-zero exit code.
+func onceRunCompleted(err error) {
+	onceRound.mu.Lock()
+	defer onceRound.mu.Unlock()
+	if !onceRound.started || onceRound.remaining == 0 {
+		return
+	}
+	if code := exitCode(err); code != 0 && exitStatus == 0 {
+		exitStatus = code
+		if code < 0 {
+			exitStatus = 1
+		}
+	}
+	onceRound.remaining--
+	if onceRound.remaining == 0 {
+		requestShutdown()
+	}
+}
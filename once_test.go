@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		write   bool // whether a file is written after startup
+		want    int
+	}{
+		{"success", "true", true, 0},
+		{"failure", "exit 3", true, 3},
+		{"timeout", "true", false, exitCodeTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p := startWatchfsProcess(t, `
+paths: [$DIR]
+actions:
+- shell: {command: "`+tt.command+`"}
+`, "-once", "-timeout", "2s")
+			if tt.write {
+				writeFile(t, p.dir, "a.txt", "a")
+			}
+			code, elapsed := p.wait()
+			if code != tt.want {
+				t.Errorf("exit code %d; want %d\nstderr:\n%s", code, tt.want, &p.stderr)
+			}
+			if tt.write && elapsed >= 2*time.Second {
+				t.Errorf("exited after %v; want it to exit after the first run", elapsed)
+			}
+		})
+	}
+}
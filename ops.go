@@ -21,3 +21,21 @@ var ops = func() (ops []string) {
 	sort.Strings(ops)
 	return
 }()
+
+// opOrder lists the ops in the order of their fsnotify bits
+var opOrder = []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Remove, fsnotify.Rename, fsnotify.Chmod}
+
+// opNames returns the names of the ops combined in op, in the order of their bits
+func opNames(op fsnotify.Op) (names []string) {
+	for _, bit := range opOrder {
+		if op&bit == 0 {
+			continue
+		}
+		for name, o := range parseOp {
+			if o == bit {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestOpNames(t *testing.T) {
+	tests := []struct {
+		op   fsnotify.Op
+		want []string
+	}{
+		{fsnotify.Write, []string{"write"}},
+		{fsnotify.Create | fsnotify.Write, []string{"create", "write"}},
+		{fsnotify.Chmod | fsnotify.Rename | fsnotify.Remove, []string{"remove", "rename", "chmod"}},
+		{0, nil},
+	}
+	for _, tt := range tests {
+		if got := opNames(tt.op); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("opNames(%v) = %q; want %q", tt.op, got, tt.want)
+		}
+	}
+}
+
+func TestEventRecordOps(t *testing.T) {
+	stdout := captureStdout(t)
+	useConfig(t, configuration{})
+	d := newDispatcher(context.Background(), nil)
+	notify(d, Event{Name: "a.txt", Op: fsnotify.Write})
+	notify(d, Event{Name: "b.txt", Op: fsnotify.Create | fsnotify.Write})
+	records := stdout.recordsWith(t, "op")
+	if len(records) != 2 {
+		t.Fatalf("got records %v; want one per event", records)
+	}
+	want := []map[string]interface{}{
+		{"op": "write", "ops": []interface{}{"write"}},
+		{"op": "create|write", "ops": []interface{}{"create", "write"}},
+	}
+	for i, record := range records {
+		if got := map[string]interface{}{"op": record["op"], "ops": record["ops"]}; !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("record %v; want op and ops %v", record, want[i])
+		}
+	}
+}
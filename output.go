@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// actionOutput holds the writers an action's output is sent to
+type actionOutput struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Stdout returns the writer for the action's standard output
+func (o actionOutput) Stdout() io.Writer {
+	if o.stdout == nil {
+		return os.Stdout
+	}
+	return o.stdout
+}
+
+// Stderr returns the writer for the action's standard error
+func (o actionOutput) Stderr() io.Writer {
+	if o.stderr == nil {
+		return os.Stderr
+	}
+	return o.stderr
+}
+
+// tee returns the output with stdout and stderr also written to the given files (if set),
+// and a function closing the files. The files' parent directories are created as needed;
+// the files are truncated unless appendOutput is set. If both name the same file, it is
+// opened once and receives both streams.
+func (o actionOutput) tee(stdoutFile, stderrFile string, appendOutput bool) (actionOutput, func(), error) {
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	open := func(path string) (*os.File, error) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if appendOutput {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		return os.OpenFile(path, flags, 0644)
+	}
+	out := actionOutput{stdout: o.Stdout(), stderr: o.Stderr()}
+	var stdout *os.File
+	if stdoutFile != "" {
+		f, err := open(stdoutFile)
+		if err != nil {
+			return o, nil, fmt.Errorf("stdoutFile: %v", err)
+		}
+		files = append(files, f)
+		stdout = f
+		out.stdout = io.MultiWriter(out.stdout, f)
+	}
+	if stderrFile != "" {
+		f := stdout
+		if stderrFile != stdoutFile {
+			var err error
+			f, err = open(stderrFile)
+			if err != nil {
+				closeFiles()
+				return o, nil, fmt.Errorf("stderrFile: %v", err)
+			}
+			files = append(files, f)
+		}
+		out.stderr = io.MultiWriter(out.stderr, f)
+	}
+	return out, closeFiles, nil
+}
+
+// prefixWriter is a line-buffered writer that prefixes each line.
+// Complete lines are written to the underlying writer while holding mu,
+// which may be shared by several writers to keep their lines intact.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix []byte
+
+	bufMu sync.Mutex
+	buf   []byte
+}
+
+func newPrefixWriter(w io.Writer, mu *sync.Mutex, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, mu: mu, prefix: []byte(prefix)}
+}
+
+// Write implements io.Writer
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.bufMu.Lock()
+	defer p.bufMu.Unlock()
+	p.buf = append(p.buf, data...)
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i < 0 {
+		return len(data), nil
+	}
+	lines := p.buf[:i+1]
+	if err := p.writeLines(lines); err != nil {
+		return 0, err
+	}
+	p.buf = append(p.buf[:0], p.buf[i+1:]...)
+	return len(data), nil
+}
+
+// Flush writes any buffered partial line, terminated by a newline
+func (p *prefixWriter) Flush() error {
+	p.bufMu.Lock()
+	defer p.bufMu.Unlock()
+	if len(p.buf) == 0 {
+		return nil
+	}
+	err := p.writeLines(append(p.buf, '\n'))
+	p.buf = p.buf[:0]
+	return err
+}
+
+func (p *prefixWriter) writeLines(lines []byte) error {
+	var out bytes.Buffer
+	for len(lines) > 0 {
+		i := bytes.IndexByte(lines, '\n')
+		out.Write(p.prefix)
+		out.Write(lines[:i+1])
+		lines = lines[i+1:]
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string // after the writes
+		flush  string // after Flush
+	}{
+		{"line", []string{"a\n"}, "> a\n", "> a\n"},
+		{"lines", []string{"a\nb\n"}, "> a\n> b\n", "> a\n> b\n"},
+		{"partial line", []string{"a", "b\nc", "d\n"}, "> ab\n> cd\n", "> ab\n> cd\n"},
+		{"unterminated line", []string{"a\nb"}, "> a\n", "> a\n> b\n"},
+		{"empty lines", []string{"\n\n"}, "> \n> \n", "> \n> \n"},
+		{"nothing", nil, "", ""},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		var mu sync.Mutex
+		w := newPrefixWriter(&buf, &mu, "> ")
+		for _, s := range tt.writes {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Errorf("%s: Write(%q) = %d, %v", tt.name, s, n, err)
+			}
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: wrote %q; want %q", tt.name, buf.String(), tt.want)
+		}
+		w.Flush()
+		if buf.String() != tt.flush {
+			t.Errorf("%s: wrote %q after Flush; want %q", tt.name, buf.String(), tt.flush)
+		}
+	}
+}
+
+func TestPrefixWritersInterleaved(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range []string{"build", "test", "lint"} {
+		w := newPrefixWriter(&buf, &mu, "["+name+"] ")
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				// each line is written in several parts
+				fmt.Fprintf(w, "%s ", name)
+				fmt.Fprintf(w, "line %d\n%s", i, name)
+				fmt.Fprintf(w, " continued %d\n", i)
+			}
+		}(name)
+	}
+	wg.Wait()
+	counts := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var prefix, name, rest string
+		if i := strings.Index(line, "] "); i >= 0 {
+			prefix, line = line[1:i], line[i+2:]
+		}
+		if i := strings.Index(line, " "); i >= 0 {
+			name, rest = line[:i], line[i+1:]
+		}
+		if prefix != name || !strings.HasPrefix(rest, "line ") && !strings.HasPrefix(rest, "continued ") {
+			t.Fatalf("garbled line %q", line)
+		}
+		counts[name]++
+	}
+	if want := map[string]int{"build": 200, "test": 200, "lint": 200}; fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("got lines %v; want %v", counts, want)
+	}
+}
+
+func TestPrefixOutput(t *testing.T) {
+	captureStdout(t)
+	out, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = saved }()
+	c := configuration{Actions: []Action{
+		{Name: "build", PrefixOutput: true, ActionShell: &ActionShell{Command: "for i in 1 2 3; do printf 'build '; sleep 0.01; echo $i; done; printf partial"}},
+		{Name: "test", PrefixOutput: true, ActionShell: &ActionShell{Command: "for i in 1 2 3; do printf 'test '; sleep 0.01; echo $i; done"}},
+		{ActionShell: &ActionShell{Command: "echo raw"}},
+	}}
+	useConfig(t, c)
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range config.Actions {
+		wg.Add(1)
+		go func(a *Action) {
+			defer wg.Done()
+			if err := a.Run(context.Background(), nil); err != nil {
+				t.Error(err)
+			}
+		}(&config.Actions[i])
+	}
+	wg.Wait()
+	data, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	sort.Strings(lines)
+	want := []string{
+		"[build] build 1", "[build] build 2", "[build] build 3", "[build] partial",
+		"[test] test 1", "[test] test 2", "[test] test 3",
+		"raw",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got output lines %q; want %q", lines, want)
+	}
+}
+
+func TestTeeOutput(t *testing.T) {
+	for _, appendOutput := range []bool{false, true} {
+		captureStdout(t)
+		console, consoleErr := redirect(t, &os.Stdout), redirect(t, &os.Stderr)
+		logs := filepath.Join(t.TempDir(), "logs", "build")
+		stdoutFile, stderrFile := filepath.Join(logs, "out.log"), filepath.Join(logs, "err.log")
+		useConfig(t, configuration{Actions: []Action{
+			{ActionShell: &ActionShell{Command: "echo out; echo err >&2", Shell: stringList{"sh", "-c"}, StdoutFile: stdoutFile, StderrFile: stderrFile, AppendOutput: appendOutput}},
+			{ActionExec: &ActionExec{Command: []string{"sh", "-c", "echo both; echo both >&2"}, StdoutFile: filepath.Join(logs, "both.log"), StderrFile: filepath.Join(logs, "both.log")}},
+		}})
+		if err := config.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		for run := 0; run < 2; run++ {
+			for i := range config.Actions {
+				if err := config.Actions[i].Run(context.Background(), nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		read := func(path string) string {
+			data, _ := ioutil.ReadFile(path)
+			return string(data)
+		}
+		wantOut, wantErr := "out\n", "err\n"
+		if appendOutput {
+			wantOut, wantErr = "out\nout\n", "err\nerr\n"
+		}
+		if got := read(stdoutFile); got != wantOut {
+			t.Errorf("appendOutput: %v: stdoutFile has %q; want %q", appendOutput, got, wantOut)
+		}
+		if got := read(stderrFile); got != wantErr {
+			t.Errorf("appendOutput: %v: stderrFile has %q; want %q", appendOutput, got, wantErr)
+		}
+		if got := read(filepath.Join(logs, "both.log")); got != "both\nboth\n" {
+			t.Errorf("the file for both streams has %q", got)
+		}
+		// the output is still shown
+		if got := console(); got != "out\nboth\nout\nboth\n" {
+			t.Errorf("appendOutput: %v: stdout has %q", appendOutput, got)
+		}
+		if got := consoleErr(); got != "err\nboth\nerr\nboth\n" {
+			t.Errorf("appendOutput: %v: stderr has %q", appendOutput, got)
+		}
+	}
+}
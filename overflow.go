@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// onWatcherError reports an error from the watcher. When the OS event queue has
+// overflowed, a warning is reported instead, and the watched paths are rescanned
+// for changes made after `since` if `rescanOnOverflow` is set.
+// It returns whether a rescan was performed.
+func onWatcherError(w Watcher, d *dispatcher, paths []string, since time.Time, err error) bool {
+	if err != fsnotify.ErrEventOverflow {
+		onError(struct {
+			Message string `json:"message"`
+		}{
+			Message: err.Error(),
+		})
+		return false
+	}
+	stderrJSONEncode(struct {
+		Warning  string `json:"warning"`
+		Overflow bool   `json:"overflow"`
+		Rescan   bool   `json:"rescan"`
+	}{
+		Warning:  "event queue overflow, some changes may have been missed",
+		Overflow: true,
+		Rescan:   config.RescanOnOverflow,
+	})
+	if !config.RescanOnOverflow {
+		return false
+	}
+	rescan(w, d, paths, since)
+	return true
+}
+
+// rescan walks the watched paths, watches directories that are not yet watched,
+// and reports files modified after `since` as writes.
+func rescan(w Watcher, d *dispatcher, paths []string, since time.Time) {
+	for _, root := range paths {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if shouldExclude(path, info) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			now := time.Now().Format(time.RFC3339)
+			if info.IsDir() {
+				if !withinDepth([]string{root}, path) {
+					return filepath.SkipDir
+				}
+				if watched.hasDir(path) {
+					return nil
+				}
+				if err := w.Add(path); err != nil {
+					onError(err)
+					return filepath.SkipDir
+				}
+				watched.addDir(path)
+				onEvent(d, Event{Name: path, Op: fsnotify.Create, Time: now})
+				return nil
+			}
+			if info.ModTime().After(since) {
+				onEvent(d, Event{Name: path, Op: fsnotify.Write, Time: now})
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// addWatcher is a Watcher that records the paths added to it. Paths may be added concurrently.
+type addWatcher struct {
+	mu    sync.Mutex
+	added []string
+}
+
+func (w *addWatcher) Add(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.added = append(w.added, path)
+	return nil
+}
+
+// paths returns the added paths, sorted
+func (w *addWatcher) paths() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	paths := append([]string(nil), w.added...)
+	sort.Strings(paths)
+	return paths
+}
+
+func (w *addWatcher) Remove(path string) error      { return nil }
+func (w *addWatcher) Close() error                  { return nil }
+func (w *addWatcher) Events() <-chan fsnotify.Event { return nil }
+func (w *addWatcher) Errors() <-chan error          { return nil }
+
+func TestOnWatcherError(t *testing.T) {
+	dir := t.TempDir()
+	old := writeFile(t, dir, "old.txt", "old")
+	hourAgo := time.Now().Add(-time.Hour)
+	os.Chtimes(old, hourAgo, hourAgo)
+	changed := writeFile(t, dir, "changed.txt", "changed")
+	newDir := filepath.Join(dir, "new")
+	if err := os.Mkdir(newDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	since := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name       string
+		err        error
+		rescan     bool
+		wantRescan bool
+		wantEvents map[string]string
+	}{
+		{"other error", errors.New("read failed"), true, false, nil},
+		{"overflow", fsnotify.ErrEventOverflow, false, false, nil},
+		{"overflow with rescan", fsnotify.ErrEventOverflow, true, true, map[string]string{changed: "write", newDir: "create"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, configuration{RescanOnOverflow: tt.rescan})
+			if err := config.makeCanonical(); err != nil {
+				t.Fatal(err)
+			}
+			saved := watched
+			watched = newWatchSet()
+			watched.addDir(dir)
+			defer func() { watched = saved }()
+			stdout, stderr := captureStdout(t), captureStderr(t)
+			w := &addWatcher{}
+			d := newDispatcher(context.Background(), nil)
+			if got := onWatcherError(w, d, []string{dir}, since, tt.err); got != tt.wantRescan {
+				t.Errorf("onWatcherError() = %v; want %v", got, tt.wantRescan)
+			}
+			warnings, errs := stderr.recordsWith(t, "warning"), stderr.recordsWith(t, "error")
+			if tt.err != fsnotify.ErrEventOverflow {
+				if len(errs) != 1 || len(warnings) != 0 {
+					t.Errorf("got errors %v and warnings %v; want the error", errs, warnings)
+				}
+				return
+			}
+			if len(errs) != 0 || len(warnings) != 1 || warnings[0]["overflow"] != true || warnings[0]["rescan"] != tt.rescan {
+				t.Errorf("got errors %v and warnings %v; want an overflow warning", errs, warnings)
+			}
+			events := map[string]string{}
+			for _, e := range stdout.recordsWith(t, "op") {
+				events[e["path"].(string)] = e["op"].(string)
+			}
+			if len(events) == 0 {
+				events = nil
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("got events %v; want %v", events, tt.wantEvents)
+			}
+			if added := w.paths(); tt.wantRescan && !reflect.DeepEqual(added, []string{newDir}) {
+				t.Errorf("added watches for %v; want only the new directory", added)
+			}
+		})
+	}
+}
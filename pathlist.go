@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// pathListPrefix marks a watch path as a file listing paths to watch, e.g. `-watch @paths.txt`
+const pathListPrefix = "@"
+
+// expandPathLists replaces `@file` entries of paths with the paths listed in the file,
+// and appends the paths listed in each of the files in `fromFiles`.
+func expandPathLists(paths []string, fromFiles []string) (out []string, err error) {
+	var lists []string
+	for _, path := range paths {
+		if strings.HasPrefix(path, pathListPrefix) {
+			lists = append(lists, strings.TrimPrefix(path, pathListPrefix))
+			continue
+		}
+		out = append(out, path)
+	}
+	lists = append(lists, fromFiles...)
+	for _, list := range lists {
+		listed, listErr := loadPathList(list)
+		if listErr != nil && err == nil {
+			err = listErr
+		}
+		out = append(out, listed...)
+	}
+	return out, err
+}
+
+// loadPathList reads the paths listed in a file
+func loadPathList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	paths, err := parsePathList(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return paths, nil
+}
+
+// parsePathList parses one path per line. Blank lines and lines starting with `#` are skipped.
+func parsePathList(r io.Reader) (paths []string, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, scanner.Err()
+}
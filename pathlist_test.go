@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePathList(t *testing.T) {
+	paths, err := parsePathList(strings.NewReader("# generated\nsrc\n\n  lib/a  \n# docs\n\t\ncmd/watchfs\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"src", "lib/a", "cmd/watchfs"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("parsed %q; want %q", paths, want)
+	}
+}
+
+func TestExpandPathLists(t *testing.T) {
+	dir := t.TempDir()
+	list := writeFile(t, dir, "paths.txt", "# comment\nsrc\n\nlib\n")
+	more := writeFile(t, dir, "more.txt", "docs\n")
+	paths, err := expandPathLists([]string{"cmd", "@" + list, "test"}, []string{more})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"cmd", "test", "src", "lib", "docs"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("expanded to %q; want %q", paths, want)
+	}
+	if _, err := expandPathLists([]string{"@" + dir + "/missing.txt"}, nil); !os.IsNotExist(err) {
+		t.Errorf("expandPathLists() = %v for a missing list", err)
+	}
+}
+
+func TestWatchFromFile(t *testing.T) {
+	dir := t.TempDir()
+	list := writeFile(t, dir, "paths.txt", "# comment\nsrc\n\nlib\n")
+	useConfig(t, configuration{
+		Paths:         watchTargetList{{Path: "@" + list, Filter: Filter{Extensions: []string{"go"}}}, {Path: "cmd"}},
+		WatchFromFile: []string{list},
+	})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"src", "lib", "cmd", "src", "lib"}; !reflect.DeepEqual(config.Paths.paths(), want) {
+		t.Errorf("paths = %q; want %q", config.Paths.paths(), want)
+	}
+	// listed paths keep the filter of their `@file` entry
+	if exts := config.Paths[1].Extensions; !reflect.DeepEqual(exts, []string{"go"}) {
+		t.Errorf("exts of lib = %q; want the entry's filter", exts)
+	}
+	if config.Paths[4].hasFilter() {
+		t.Error("paths from watchFromFile have a filter")
+	}
+}
+
+func TestWatchListFlagWithGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"pkg/a/a.go", "pkg/b/b.go", "cmd/main.go"} {
+		writeFile(t, dir, name, "package a")
+	}
+	list := writeFile(t, t.TempDir(), "paths.txt", "# generated\n"+filepath.Join(dir, "pkg", "*")+"\n\n"+filepath.Join(dir, "cmd")+"\n")
+	saved := watch
+	watch = stringsSetVar{}
+	defer func() { watch = saved }()
+	if err := watch.Set("@" + list); err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, configuration{})
+	flagsToConfiguration()
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	// the listed globs are expanded like those given directly
+	want := []string{filepath.Join(dir, "pkg", "a"), filepath.Join(dir, "pkg", "b"), filepath.Join(dir, "cmd")}
+	if got := expandWatchPaths(config.Paths).paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("watching %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// writePIDFile writes watchfs's PID to the file at path (see `-pid-file`), replacing it
+// atomically. A file left behind by a watchfs that is no longer running is replaced with
+// a warning; one that names another running process is an error.
+func writePIDFile(path string) error {
+	if pid, ok := readPIDFile(path); ok && pid != os.Getpid() {
+		if processRunning(pid) {
+			return fmt.Errorf("pid file %s: process %d is still running", path, pid)
+		}
+		stderrJSONEncode(struct {
+			Warning string `json:"warning"`
+			PID     int    `json:"pid"`
+		}{
+			Warning: fmt.Sprintf("replacing stale pid file %s", path),
+			PID:     pid,
+		})
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// exit removes the pid file (see -pid-file) and exits with the code. All exits go through it,
+// so that no pid file is left behind.
+func exit(code int) {
+	removePIDFile(pidFilePath)
+	os.Exit(code)
+}
+
+// removePIDFile removes the file at path written by writePIDFile, unless it no longer holds watchfs's PID
+func removePIDFile(path string) {
+	if path == "" {
+		return
+	}
+	if pid, ok := readPIDFile(path); !ok || pid != os.Getpid() {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		onError(err)
+	}
+}
+
+// readPIDFile returns the PID in the file at path
+func readPIDFile(path string) (int, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, err == nil && pid > 0
+}
+
+// processRunning returns whether a process with the PID exists
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess fails on Windows if there is no such process
+		return true
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPIDFile(t *testing.T) {
+	captureStderr(t)
+	path := filepath.Join(t.TempDir(), "run", "watchfs.pid")
+	if err := writePIDFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if pid, ok := readPIDFile(path); !ok || pid != os.Getpid() {
+		t.Errorf("the pid file holds %d; want %d", pid, os.Getpid())
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file is left behind: %v", err)
+	}
+	removePIDFile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the pid file was not removed: %v", err)
+	}
+
+	// a pid file rewritten by someone else is left alone
+	writeFile(t, filepath.Dir(path), filepath.Base(path), "1\n")
+	removePIDFile(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("removed a pid file holding another PID: %v", err)
+	}
+}
+
+// exitedPID returns the PID of a process that has exited
+func exitedPID(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestStalePIDFile(t *testing.T) {
+	stderr := captureStderr(t)
+	dir := t.TempDir()
+	stale := exitedPID(t)
+	path := writeFile(t, dir, "watchfs.pid", strconv.Itoa(stale)+"\n")
+	if err := writePIDFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if pid, _ := readPIDFile(path); pid != os.Getpid() {
+		t.Errorf("the stale pid file holds %d; want it replaced with %d", pid, os.Getpid())
+	}
+	if warnings := stderr.recordsWith(t, "warning"); len(warnings) != 1 || warnings[0]["pid"] != float64(stale) {
+		t.Errorf("got warnings %v; want one for the stale PID %d", warnings, stale)
+	}
+
+	// the pid file of a running process, here the test's, is not replaced
+	path = writeFile(t, dir, "running.pid", strconv.Itoa(os.Getpid()))
+	code, out := runWatchfsProcess(t, "paths: [$DIR]\n", "-pid-file", path)
+	if code != 1 || !strings.Contains(out, "is still running") {
+		t.Errorf("exit code %d with a running process's pid file; want 1 and an error\nstderr:\n%s", code, out)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("the pid file of the running process was changed to %q", data)
+	}
+}
+
+func TestPIDFileRemovedOnExit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchfs.pid")
+	p := startWatchfsProcess(t, "paths: [$DIR]\n", "-pid-file", path, "-timeout", "500ms")
+	if pid, ok := readPIDFile(path); !ok || pid != p.cmd.Process.Pid {
+		t.Errorf("the pid file holds %d; want the PID %d of watchfs", pid, p.cmd.Process.Pid)
+	}
+	if code, _ := p.wait(); code != exitCodeTimeout {
+		t.Errorf("exit code %d; want %d\nstderr:\n%s", code, exitCodeTimeout, &p.stderr)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the pid file is left behind after exiting: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// ActionPlugin runs an external plugin binary, passing it the events as JSON on stdin
+type ActionPlugin struct {
+	Path    string            `json:"path,omitempty" yaml:"path,omitempty"`
+	Args    []string          `json:"args,omitempty" yaml:"args,flow,omitempty"`
+	Env     map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	EnvFile stringList        `json:"envFile,omitempty" yaml:"envFile,flow,omitempty"`
+	WorkDir string            `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+
+	envFile map[string]string
+	output  actionOutput
+}
+
+// pluginInput is the JSON document a plugin receives on stdin
+type pluginInput struct {
+	Events   []pluginEvent `json:"events"`
+	ExitCode *int          `json:"exitCode,omitempty"`
+}
+
+// pluginEvent describes an event in a pluginInput
+type pluginEvent struct {
+	Op   string   `json:"op"`
+	Ops  []string `json:"ops"`
+	Path string   `json:"path"`
+	Time string   `json:"time,omitempty"`
+}
+
+// pluginOutput is a JSON value written by a plugin to its stdout
+type pluginOutput struct {
+	Plugin string          `json:"plugin"`
+	Output json.RawMessage `json:"output"`
+}
+
+func (a *ActionPlugin) makeCanonical() error {
+	var pathErr error
+	if a.Path == "" {
+		pathErr = fmt.Errorf("plugin: path is required")
+	}
+	envFile, envErr := loadEnvFiles(a.EnvFile, config.environment())
+	a.envFile = envFile
+	return firstError(pathErr, envErr)
+}
+
+// Notify notifies the action about a filesystem event
+func (a *ActionPlugin) Notify(e Event) (bool, error) {
+	return false, nil
+}
+
+// Run runs the plugin with the events as JSON on stdin. Lines the plugin writes to stdout
+// that are JSON values are reported as `pluginOutput` records; other lines are passed through.
+func (a *ActionPlugin) Run(ctx context.Context, events []Event) error {
+	dir, err := resolveWorkDir(a.WorkDir, events)
+	if err != nil {
+		return err
+	}
+	input, err := json.Marshal(newPluginInput(events))
+	if err != nil {
+		return err
+	}
+	command := exec.CommandContext(ctx, a.Path, a.Args...)
+	command.Dir = dir
+	command.Stdin = bytes.NewReader(append(input, '\n'))
+	command.Stderr = a.output.Stderr()
+	command.Env = commandEnv(eventEnv(events), config.envFile, config.Env, a.envFile, a.Env)
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := command.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		a.handleOutputLine(scanner.Bytes())
+	}
+	var scanErr error
+	if err := scanner.Err(); err != nil {
+		scanErr = fmt.Errorf("plugin: reading output: %v", err)
+	}
+	// the scanner stops at an overlong line; drain the rest so that the plugin can exit
+	io.Copy(ioutil.Discard, stdout)
+	return firstError(command.Wait(), scanErr)
+}
+
+// handleOutputLine reports a line of the plugin's stdout
+func (a *ActionPlugin) handleOutputLine(line []byte) {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 || !json.Valid(trimmed) {
+		fmt.Fprintf(a.output.Stdout(), "%s\n", line)
+		return
+	}
+	output := pluginOutput{
+		Plugin: a.Path,
+		Output: json.RawMessage(trimmed),
+	}
+	stdoutRecord(true, func(n recordNumber) interface{} {
+		return struct {
+			PluginOutput pluginOutput `json:"pluginOutput"`
+			recordNumber
+		}{output, n}
+	})
+}
+
+// commandLine returns the plugin's command line
+func (a *ActionPlugin) commandLine() []string {
+	return append([]string{a.Path}, a.Args...)
+}
+
+// newPluginInput returns the plugin input for the events. Events without a path
+// (the initial run, or a hook's run for it) are omitted.
+func newPluginInput(events []Event) pluginInput {
+	input := pluginInput{Events: []pluginEvent{}}
+	for _, e := range events {
+		if e.result != nil && input.ExitCode == nil {
+			exitCode := e.result.exitCode
+			input.ExitCode = &exitCode
+		}
+		if e.Name == "" {
+			continue
+		}
+		input.Events = append(input.Events, pluginEvent{
+			Op:   strings.ToLower(e.Op.String()),
+			Ops:  opNames(e.Op),
+			Path: e.Name,
+			Time: e.Time,
+		})
+	}
+	return input
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestPluginAction(t *testing.T) {
+	stdout := captureStdout(t)
+	plain := redirect(t, &os.Stdout)
+	dir := t.TempDir()
+	plugin := writeFile(t, dir, "echo-plugin", "#!/bin/sh\necho \"args: $*\"\ncat\necho '{\"status\": \"ok\"}'\n")
+	if err := os.Chmod(plugin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, configuration{Actions: []Action{{ActionPlugin: &ActionPlugin{Path: plugin, Args: []string{"-v"}}}}})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	events := []Event{
+		{Name: "a.go", Op: fsnotify.Write, Time: "t1"},
+		{Name: "b.go", Op: fsnotify.Create | fsnotify.Write, Time: "t2"},
+	}
+	if err := config.Actions[0].Run(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	// the received input is echoed back as a JSON line, and reported as a record
+	var outputs []pluginOutput
+	for _, record := range stdout.recordsWith(t, "pluginOutput") {
+		data, _ := json.Marshal(record["pluginOutput"])
+		var output pluginOutput
+		if err := json.Unmarshal(data, &output); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, output)
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("got plugin outputs %+v; want the echoed input and the status", outputs)
+	}
+	var received pluginInput
+	if err := json.Unmarshal(outputs[0].Output, &received); err != nil {
+		t.Fatal(err)
+	}
+	want := pluginInput{Events: []pluginEvent{
+		{Op: "write", Ops: []string{"write"}, Path: "a.go", Time: "t1"},
+		{Op: "create|write", Ops: []string{"create", "write"}, Path: "b.go", Time: "t2"},
+	}}
+	if !reflect.DeepEqual(received, want) || outputs[0].Plugin != plugin {
+		t.Errorf("the plugin received %+v; want %+v", received, want)
+	}
+	if string(outputs[1].Output) != `{"status":"ok"}` {
+		t.Errorf("output = %s", outputs[1].Output)
+	}
+	// lines that are not JSON are passed through
+	if got := plain(); got != "args: -v\n" {
+		t.Errorf("passed through %q", got)
+	}
+}
+
+func TestNewPluginInput(t *testing.T) {
+	input := newPluginInput(withResult(nil, errors.New("exit status 1")))
+	if len(input.Events) != 0 || input.ExitCode == nil {
+		t.Errorf("the input for a hook of the initial run is %+v; want only an exit code", input)
+	}
+	data, _ := json.Marshal(newPluginInput(nil))
+	if string(data) != `{"events":[]}` {
+		t.Errorf("the input for the initial run is %s", data)
+	}
+}
+
+func TestPluginFailure(t *testing.T) {
+	captureStdout(t)
+	redirect(t, &os.Stdout)
+	dir := t.TempDir()
+	plugin := writeFile(t, dir, "failing-plugin", "#!/bin/sh\ncat > /dev/null\nexit 4\n")
+	os.Chmod(plugin, 0755)
+	a := &ActionPlugin{Path: plugin}
+	if err := a.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Run(context.Background(), nil); exitCode(err) != 4 {
+		t.Errorf("Run() = %v; want exit status 4", err)
+	}
+	a.Path = filepath.Join(dir, "missing")
+	if err := a.Run(context.Background(), nil); err == nil {
+		t.Error("running a missing plugin succeeded")
+	}
+	if err := (&ActionPlugin{}).makeCanonical(); err == nil || !strings.Contains(err.Error(), "path is required") {
+		t.Errorf("makeCanonical() = %v without a path", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const defaultPollInterval = time.Second
+
+// pollWatcher is a Watcher that periodically lists the watched paths and
+// synthesizes events by diffing each listing against the previous one.
+type pollWatcher struct {
+	interval  time.Duration
+	events    chan fsnotify.Event
+	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	paths    map[string]bool
+	snapshot map[string]fileState
+}
+
+// fileState is the part of a file's metadata that is compared between polls
+type fileState struct {
+	ModTime time.Time
+	Size    int64
+	Mode    os.FileMode
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	w := &pollWatcher{
+		interval: interval,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		paths:    make(map[string]bool),
+		snapshot: make(map[string]fileState),
+	}
+	go w.loop()
+	return w
+}
+
+// Add starts polling the given path. For directories, the direct children are polled.
+func (w *pollWatcher) Add(path string) error {
+	path = filepath.Clean(path)
+	states, err := scanPath(path)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paths[path] {
+		return nil
+	}
+	w.paths[path] = true
+	for name, s := range states {
+		w.snapshot[name] = s
+	}
+	return nil
+}
+
+// Remove stops polling the given path.
+func (w *pollWatcher) Remove(path string) error {
+	path = filepath.Clean(path)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.paths, path)
+	for name := range w.snapshot {
+		if name == path || filepath.Dir(name) == path {
+			delete(w.snapshot, name)
+		}
+	}
+	return nil
+}
+
+// Close stops polling and closes the event and error channels.
+func (w *pollWatcher) Close() error {
+	w.closeOnce.Do(func() { close(w.done) })
+	return nil
+}
+
+// Events returns the event channel
+func (w *pollWatcher) Events() <-chan fsnotify.Event {
+	return w.events
+}
+
+// Errors returns the error channel
+func (w *pollWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *pollWatcher) loop() {
+	defer close(w.errors)
+	defer close(w.events)
+	ticker := clock.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C():
+		}
+		events, errs := w.poll()
+		for _, err := range errs {
+			select {
+			case w.errors <- err:
+			case <-w.done:
+				return
+			}
+		}
+		for _, e := range events {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// poll re-scans all watched paths and returns the events since the last poll.
+func (w *pollWatcher) poll() (events []fsnotify.Event, errs []error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	snapshot := make(map[string]fileState, len(w.snapshot))
+	for path := range w.paths {
+		states, err := scanPath(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			delete(w.paths, path)
+			continue
+		}
+		for name, s := range states {
+			snapshot[name] = s
+		}
+	}
+	events = diffSnapshots(w.snapshot, snapshot)
+	w.snapshot = snapshot
+	return
+}
+
+// scanPath returns the states of a directory's direct children, or of the file itself.
+func scanPath(path string) (map[string]fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]fileState)
+	if !info.IsDir() {
+		states[path] = newFileState(info)
+		return states, nil
+	}
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		states[filepath.Join(path, info.Name())] = newFileState(info)
+	}
+	return states, nil
+}
+
+func newFileState(info os.FileInfo) fileState {
+	return fileState{
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+	}
+}
+
+// diffSnapshots returns the events that turn the old snapshot into the new one, sorted by path.
+func diffSnapshots(old, new map[string]fileState) (events []fsnotify.Event) {
+	for name, s := range new {
+		o, ok := old[name]
+		switch {
+		case !ok:
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Create})
+		case !s.Mode.IsDir() && (!o.ModTime.Equal(s.ModTime) || o.Size != s.Size):
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Write})
+		case o.Mode != s.Mode:
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Chmod})
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Remove})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Name < events[j].Name
+	})
+	return
+}
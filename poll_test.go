@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	file := fileState{ModTime: t0, Size: 10, Mode: 0644}
+	dir := fileState{ModTime: t0, Mode: os.ModeDir | 0755}
+	tests := []struct {
+		name     string
+		old, new map[string]fileState
+		want     []fsnotify.Event
+	}{
+		{"unchanged", map[string]fileState{"a": file, "d": dir}, map[string]fileState{"a": file, "d": dir}, nil},
+		{"created", map[string]fileState{}, map[string]fileState{"b": file, "a": dir}, []fsnotify.Event{
+			{Name: "a", Op: fsnotify.Create}, {Name: "b", Op: fsnotify.Create},
+		}},
+		{"removed", map[string]fileState{"a": file}, map[string]fileState{}, []fsnotify.Event{{Name: "a", Op: fsnotify.Remove}}},
+		{"modified", map[string]fileState{"a": file}, map[string]fileState{"a": {ModTime: t0.Add(time.Second), Size: 10, Mode: 0644}},
+			[]fsnotify.Event{{Name: "a", Op: fsnotify.Write}}},
+		{"resized", map[string]fileState{"a": file}, map[string]fileState{"a": {ModTime: t0, Size: 11, Mode: 0644}},
+			[]fsnotify.Event{{Name: "a", Op: fsnotify.Write}}},
+		{"mode changed", map[string]fileState{"a": file}, map[string]fileState{"a": {ModTime: t0, Size: 10, Mode: 0600}},
+			[]fsnotify.Event{{Name: "a", Op: fsnotify.Chmod}}},
+		// a directory's modification time changes with its entries, which are reported themselves
+		{"directory entries changed", map[string]fileState{"d": dir}, map[string]fileState{"d": {ModTime: t0.Add(time.Second), Size: 4096, Mode: os.ModeDir | 0755}}, nil},
+		{"mixed", map[string]fileState{"a": file, "b": file}, map[string]fileState{"b": {ModTime: t0, Size: 1, Mode: 0644}, "c": file}, []fsnotify.Event{
+			{Name: "a", Op: fsnotify.Remove}, {Name: "b", Op: fsnotify.Write}, {Name: "c", Op: fsnotify.Create},
+		}},
+	}
+	for _, tt := range tests {
+		if got := diffSnapshots(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: diffSnapshots() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPollWatcher(t *testing.T) {
+	useFakeClock(t) // the watcher does not poll by itself; the test calls poll
+	dir := t.TempDir()
+	writeFile(t, dir, "a.txt", "a")
+	writeFile(t, dir, "b.txt", "b")
+	w := newPollWatcher(time.Second)
+	t.Cleanup(func() {
+		// wait for the loop to end before the clock is restored
+		w.Close()
+		for range w.Events() {
+		}
+	})
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	if events, errs := w.poll(); len(events) != 0 || len(errs) != 0 {
+		t.Fatalf("poll() = %v, %v before any changes", events, errs)
+	}
+
+	c := writeFile(t, dir, "c.txt", "c")
+	a := writeFile(t, dir, "a.txt", "changed")
+	b := dir + string(os.PathSeparator) + "b.txt"
+	os.Remove(b)
+	want := []fsnotify.Event{{Name: a, Op: fsnotify.Write}, {Name: b, Op: fsnotify.Remove}, {Name: c, Op: fsnotify.Create}}
+	if events, errs := w.poll(); !reflect.DeepEqual(events, want) || len(errs) != 0 {
+		t.Errorf("poll() = %v, %v; want %v", events, errs, want)
+	}
+
+	if err := w.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "d.txt", "d")
+	if events, _ := w.poll(); len(events) != 0 {
+		t.Errorf("poll() = %v after Remove; want no events", events)
+	}
+	if err := w.Add(dir + "/missing"); err == nil {
+		t.Error("Add succeeded for a missing path")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPublishAddress = "localhost:6379"
+	defaultPublishMessage = "{{.Path}}"
+	publishTimeout        = 5 * time.Second
+)
+
+// ActionPublish publishes a message to a Redis channel
+type ActionPublish struct {
+	Address string `json:"address,omitempty" yaml:"address,omitempty"`
+	Channel string `json:"channel" yaml:"channel"`
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (a *ActionPublish) makeCanonical() error {
+	if a.Address == "" {
+		a.Address = defaultPublishAddress
+	}
+	if a.Message == "" {
+		a.Message = defaultPublishMessage
+	}
+	if a.Channel == "" {
+		return fmt.Errorf("publish: no channel specified")
+	}
+	return nil
+}
+
+// Notify notifies the action about a filesystem event
+func (a *ActionPublish) Notify(e Event) (bool, error) {
+	return false, nil
+}
+
+// Run publishes the message, connecting (or reconnecting once, if the connection was lost) as needed
+func (a *ActionPublish) Run(ctx context.Context, events []Event) error {
+	message, err := expandTemplate(a.Message, newTemplateData(events))
+	if err != nil {
+		return fmt.Errorf("message: %v", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	reused := a.conn != nil
+	err = a.publish(ctx, message)
+	if err != nil && reused && ctx.Err() == nil {
+		if _, ok := err.(redisError); !ok {
+			err = a.publish(ctx, message)
+		}
+	}
+	return err
+}
+
+// publish sends a PUBLISH command on the connection, dialing it first if necessary.
+// The connection is closed on I/O errors.
+func (a *ActionPublish) publish(ctx context.Context, message string) error {
+	if a.conn == nil {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", a.Address)
+		if err != nil {
+			return err
+		}
+		a.conn, a.reader = conn, bufio.NewReader(conn)
+	}
+	deadline := time.Now().Add(publishTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	a.conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	defer close(done)
+	go func(conn net.Conn) {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}(a.conn)
+	_, err := a.conn.Write(redisCommand("PUBLISH", a.Channel, message))
+	if err == nil {
+		err = readRedisReply(a.reader)
+	}
+	if _, ok := err.(redisError); err != nil && !ok {
+		a.conn.Close()
+		a.conn, a.reader = nil, nil
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// redisCommand encodes a command as a RESP array of bulk strings
+func redisCommand(args ...string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(b.String())
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// readRedisReply reads a simple string, integer or error reply
+func readRedisReply(r *bufio.Reader) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return redisError(line[1:])
+	}
+	return fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// close closes the connection, if any
+func (a *ActionPublish) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn != nil {
+		a.conn.Close()
+		a.conn, a.reader = nil, nil
+	}
+}
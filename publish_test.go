@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRedisCommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "*1\r\n$4\r\nPING\r\n"},
+		{[]string{"PUBLISH", "builds", "a.go"}, "*3\r\n$7\r\nPUBLISH\r\n$6\r\nbuilds\r\n$4\r\na.go\r\n"},
+		{[]string{"PUBLISH", "c", ""}, "*3\r\n$7\r\nPUBLISH\r\n$1\r\nc\r\n$0\r\n\r\n"},
+		// bulk strings are binary-safe: line breaks in messages are not escaped
+		{[]string{"PUBLISH", "c", "a\r\nb"}, "*3\r\n$7\r\nPUBLISH\r\n$1\r\nc\r\n$4\r\na\r\nb\r\n"},
+		{[]string{"PUBLISH", "c", "é"}, "*3\r\n$7\r\nPUBLISH\r\n$1\r\nc\r\n$2\r\né\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(redisCommand(tt.args...)); got != tt.want {
+			t.Errorf("redisCommand(%q) = %q; want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	tests := []struct {
+		reply   string
+		wantErr string
+	}{
+		{"+OK\r\n", ""},
+		{":3\r\n", ""},
+		{":0\n", ""},
+		{"-ERR unknown command\r\n", "redis: ERR unknown command"},
+		{"\r\n", "redis: empty reply"},
+		{"$5\r\nhello\r\n", `redis: unexpected reply "$5"`},
+		{"+OK", "EOF"},
+		{"", "EOF"},
+	}
+	for _, tt := range tests {
+		err := readRedisReply(bufio.NewReader(strings.NewReader(tt.reply)))
+		if got := errorString(err); got != tt.wantErr {
+			t.Errorf("readRedisReply(%q) = %q; want %q", tt.reply, got, tt.wantErr)
+		}
+	}
+	err := readRedisReply(bufio.NewReader(strings.NewReader("-NOPERM\r\n")))
+	if _, ok := err.(redisError); !ok {
+		t.Errorf("error reply: got %T; want a redisError", err)
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// fakeRedis is an in-process server that records the commands it receives. It answers
+// each with the next of replies (or `:1`) and closes the connection after closeAfter
+// commands, if set.
+type fakeRedis struct {
+	listener   net.Listener
+	replies    []string
+	closeAfter int
+
+	mu       sync.Mutex
+	commands [][]string
+	conns    int
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for n := 1; ; n++ {
+		command, err := readRESPArray(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		reply := ":1"
+		if len(s.replies) > 0 {
+			reply, s.replies = s.replies[0], s.replies[1:]
+		}
+		s.mu.Unlock()
+		io.WriteString(conn, reply+"\r\n")
+		if n == s.closeAfter {
+			return
+		}
+	}
+}
+
+// readRESPArray reads a command sent as a RESP array of bulk strings
+func readRESPArray(r *bufio.Reader) ([]string, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:length])
+	}
+	return args, nil
+}
+
+func (s *fakeRedis) received() ([][]string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands, s.conns
+}
+
+func TestPublishAction(t *testing.T) {
+	tests := []struct {
+		name         string
+		replies      []string
+		closeAfter   int
+		runs         int
+		wantCommands int
+		wantConns    int
+		wantErr      string
+	}{
+		{"one connection", nil, 0, 2, 2, 1, ""},
+		{"reconnects once when the connection was lost", nil, 1, 2, 2, 2, ""},
+		{"error replies are not retried", []string{":1", "-ERR denied"}, 0, 2, 2, 1, "redis: ERR denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startFakeRedis(t)
+			server.replies, server.closeAfter = tt.replies, tt.closeAfter
+			a := &ActionPublish{Address: server.listener.Addr().String(), Channel: "changes", Message: "{{.Op}} {{.Path}}"}
+			if err := a.makeCanonical(); err != nil {
+				t.Fatal(err)
+			}
+			defer a.close()
+			var err error
+			for i := 0; i < tt.runs; i++ {
+				err = a.Run(context.Background(), []Event{{Name: "src/a.go", Op: 2}})
+			}
+			if got := errorString(err); got != tt.wantErr {
+				t.Errorf("last Run() error = %q; want %q", got, tt.wantErr)
+			}
+			commands, conns := server.received()
+			if len(commands) != tt.wantCommands || conns != tt.wantConns {
+				t.Fatalf("got %d commands over %d connections; want %d over %d", len(commands), conns, tt.wantCommands, tt.wantConns)
+			}
+			if want := []string{"PUBLISH", "changes", "write src/a.go"}; !reflect.DeepEqual(commands[0], want) {
+				t.Errorf("published %q; want %q", commands[0], want)
+			}
+		})
+	}
+}
+
+func TestPublishActionRequiresChannel(t *testing.T) {
+	a := &ActionPublish{}
+	if err := a.makeCanonical(); err == nil {
+		t.Error("makeCanonical() succeeded without a channel")
+	}
+}
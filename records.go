@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync/atomic"
+)
+
+// recordStream numbers the records written to one output stream (stdout or stderr)
+type recordStream struct {
+	seq uint64 // the sequence number of the last record
+	tag byte   // distinguishes the IDs of the streams
+}
+
+var (
+	stdoutRecords = &recordStream{tag: 1}
+	stderrRecords = &recordStream{tag: 2}
+)
+
+// recordIDPrefix identifies this process in record IDs
+var recordIDPrefix = func() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%x-%x-%x", b[0:4], b[4:6], b[6:8])
+}()
+
+// recordNumber is the `seq` and `id` of a record. Embedded in a record, its fields are encoded as the record's own.
+type recordNumber struct {
+	Seq uint64 `json:"seq,omitempty"`
+	ID  string `json:"id,omitempty"`
+}
+
+// next returns a new sequence number, increasing by one with each record of the stream,
+// and an ID that is unique across streams and processes
+func (s *recordStream) next() recordNumber {
+	seq := atomic.AddUint64(&s.seq, 1)
+	return recordNumber{Seq: seq, ID: fmt.Sprintf("%s-%x%011x", recordIDPrefix, s.tag, seq)}
+}
+
+// stdoutRecord writes the record returned by the function to stdout, numbered in the
+// order the records are written. Unless show is set, the record is only written to the
+// log file, without a number, so that the numbers on stdout have no gaps.
+func stdoutRecord(show bool, record func(n recordNumber) interface{}) {
+	if !show {
+		logJSONEncode(record(recordNumber{}))
+		return
+	}
+	stdoutJSONMu.Lock()
+	defer stdoutJSONMu.Unlock()
+	v := record(stdoutRecords.next())
+	logJSONEncode(v)
+	stdoutJSON.Encode(v)
+}
+
+// stderrRecord writes the record returned by the function to stderr, numbered in the
+// order the records are written
+func stderrRecord(record func(n recordNumber) interface{}) {
+	stderrJSONMu.Lock()
+	defer stderrJSONMu.Unlock()
+	v := record(stderrRecords.next())
+	if err := logEncode(v); err != nil {
+		stderrJSON.Encode(logError{Error: err.Error()})
+	}
+	stderrJSON.Encode(v)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRecordStreamNumbers(t *testing.T) {
+	s := &recordStream{tag: 1}
+	other := &recordStream{tag: 2}
+	const n = 100
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seqs, ids := map[uint64]bool{}, map[string]bool{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := s.next()
+			mu.Lock()
+			defer mu.Unlock()
+			seqs[r.Seq], ids[r.ID] = true, true
+		}()
+	}
+	wg.Wait()
+	for seq := uint64(1); seq <= n; seq++ {
+		if !seqs[seq] {
+			t.Errorf("no record numbered %d out of %d", seq, n)
+		}
+	}
+	if len(ids) != n {
+		t.Errorf("got %d IDs for %d records", len(ids), n)
+	}
+	// the streams have their own sequences, but their IDs never collide
+	if r := other.next(); r.Seq != 1 || ids[r.ID] {
+		t.Errorf("the other stream's first record is %+v", r)
+	}
+	if r := s.next(); !strings.HasPrefix(r.ID, recordIDPrefix+"-") {
+		t.Errorf("ID %q does not start with the process's prefix %q", r.ID, recordIDPrefix)
+	}
+}
+
+func TestRecordNumbers(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- exec: {command: ["true"]}
+`)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		w.write(name, name)
+	}
+	w.waitFor("the events", func() bool { return len(w.events()) >= 3 })
+	w.stop()
+	check := func(stream string, records []map[string]interface{}, consecutive bool) {
+		ids := map[interface{}]bool{}
+		var previous float64
+		for i, record := range records {
+			seq, ok := record["seq"].(float64)
+			if !ok || record["id"] == nil {
+				t.Errorf("%s record %v has no seq and id", stream, record)
+				continue
+			}
+			if ids[record["id"]] {
+				t.Errorf("%s record %v repeats an ID", stream, record)
+			}
+			ids[record["id"]] = true
+			if consecutive && i > 0 && seq != previous+1 {
+				t.Errorf("%s record %v follows seq %v", stream, record, previous)
+			}
+			previous = seq
+		}
+	}
+	// stdout's records are numbered in the order they are written
+	check("stdout", w.stdout.records(t), true)
+	check("stderr", w.stderr.records(t), false)
+}
+
+func TestStderrRecordOrder(t *testing.T) {
+	stderr := captureStderr(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				onInfo(i)
+			} else {
+				onError(i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// the records are numbered in the order they are written
+	records := stderr.records(t)
+	for i, record := range records {
+		if i > 0 && record["seq"].(float64) != records[i-1]["seq"].(float64)+1 {
+			t.Errorf("record %v follows seq %v", record, records[i-1]["seq"])
+		}
+	}
+	if len(records) != 100 {
+		t.Errorf("got %d records; want 100", len(records))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultSelfReloadDelay = 100 * time.Millisecond
+
+// reload is the timer for a pending reload of the configuration file
+var reload struct {
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// scheduleReload reloads the configuration once the config file has not been
+// written to for `delay`, so that editors that write a file several times when
+// saving (or a quick series of edits) cause a single reload.
+func scheduleReload(delay time.Duration) {
+	reload.mu.Lock()
+	defer reload.mu.Unlock()
+	if reload.timer != nil && reload.timer.Stop() {
+		reload.timer.Reset(delay)
+		return
+	}
+	reload.timer = time.AfterFunc(delay, func() {
+		onInfo("reloading watchfs configuration")
+		requestReload(startSelfReload)
+	})
+}
+
+// Reasons for a (re)start of watchContext
+const (
+	startStartup    = "startup"
+	startSelfReload = "selfReload"
+	startSIGHUP     = "sighup"
+)
+
+// generation counts the (re)starts of watchContext, and holds the reason for the next one
+// and the function that stops the current one
+var generation struct {
+	mu     sync.Mutex
+	n      uint64
+	reason string
+	cancel func()
+}
+
+// setGenerationCancel makes cancel the function that stops the current watchContext,
+// and returns the previous one
+func setGenerationCancel(cancel func()) func() {
+	generation.mu.Lock()
+	defer generation.mu.Unlock()
+	previous := generation.cancel
+	generation.cancel = cancel
+	return previous
+}
+
+// requestReload stops the current watchContext, so that it restarts with the reloaded configuration
+func requestReload(reason string) {
+	generation.mu.Lock()
+	generation.reason = reason
+	cancel := generation.cancel
+	generation.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// startRecord describes a (re)start of watchContext
+type startRecord struct {
+	Generation   uint64 `json:"generation"`
+	Reason       string `json:"reason"`
+	Config       string `json:"config,omitempty"`
+	GlobalConfig string `json:"globalConfig,omitempty"`
+}
+
+// onStart reports a (re)start of watchContext, once its configuration has been loaded
+func onStart() {
+	generation.mu.Lock()
+	generation.n++
+	record := startRecord{Generation: generation.n, Reason: generation.reason}
+	generation.reason = ""
+	generation.mu.Unlock()
+	if record.Reason == "" {
+		record.Reason = startStartup
+	}
+	record.Config = configPathAbs
+	if configPath == configPathStdin {
+		record.Config = configPathStdin
+	}
+	record.GlobalConfig = globalConfigPath
+	onInfo(struct {
+		Start startRecord `json:"start"`
+	}{
+		Start: record,
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// countReloads counts the reloads requested until the test has finished
+func countReloads(t *testing.T) *int32 {
+	var n int32
+	saved := setGenerationCancel(func() { atomic.AddInt32(&n, 1) })
+	t.Cleanup(func() {
+		time.Sleep(50 * time.Millisecond) // let a pending reload fire while counted
+		setGenerationCancel(saved)
+		generation.mu.Lock()
+		generation.reason = ""
+		generation.mu.Unlock()
+	})
+	return &n
+}
+
+func TestScheduleReload(t *testing.T) {
+	captureStderr(t)
+	reloads := countReloads(t)
+	scheduleReload(30 * time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	scheduleReload(30 * time.Millisecond) // restarts the delay
+	time.Sleep(25 * time.Millisecond)
+	if n := atomic.LoadInt32(reloads); n != 0 {
+		t.Fatalf("reloaded %d times before the delay passed since the last write", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(reloads); n != 1 {
+		t.Fatalf("reloaded %d times; want once for both writes", n)
+	}
+	generation.mu.Lock()
+	reason := generation.reason
+	generation.mu.Unlock()
+	if reason != startSelfReload {
+		t.Errorf("the reload reason is %q; want %q", reason, startSelfReload)
+	}
+	scheduleReload(10 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(reloads); n != 2 {
+		t.Errorf("reloaded %d times; want a reload for the later write", n)
+	}
+}
+
+func TestConfigWritesReloadOnce(t *testing.T) {
+	captureStdout(t)
+	captureStderr(t)
+	dir := t.TempDir()
+	path := writeFile(t, dir, "watchfs.yaml", "")
+	tests := []struct {
+		name string
+		c    configuration
+		want int32
+	}{
+		{"double write", configuration{SelfReloadDelay: "30ms"}, 1},
+		{"self disabled", configuration{SelfReloadDelay: "30ms", Self: new(bool)}, 0},
+		{"selfIgnore base name", configuration{SelfReloadDelay: "30ms", SelfIgnore: []string{"*.yaml"}}, 0},
+		{"selfIgnore path", configuration{SelfReloadDelay: "30ms", SelfIgnore: []string{filepath.Join(dir, "*")}}, 0},
+		{"selfIgnore other", configuration{SelfReloadDelay: "30ms", SelfIgnore: []string{"*.json", "generated/*"}}, 1},
+	}
+	savedPath := configPathAbs
+	configPathAbs, _ = filepath.Abs(path)
+	defer func() { configPathAbs = savedPath }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, tt.c)
+			if err := config.makeCanonical(); err != nil {
+				t.Fatal(err)
+			}
+			reloads := countReloads(t)
+			d := newDispatcher(context.Background(), nil)
+			// editors may write a file more than once when saving it
+			onEvent(d, Event{Name: path, Op: fsnotify.Write})
+			onEvent(d, Event{Name: path, Op: fsnotify.Write})
+			time.Sleep(150 * time.Millisecond)
+			if n := atomic.LoadInt32(reloads); n != tt.want {
+				t.Errorf("reloaded %d times; want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoSelfFlag(t *testing.T) {
+	saved := noSelf
+	noSelf = true
+	defer func() { noSelf = saved }()
+	useConfig(t, configuration{})
+	flagsToConfiguration()
+	if config.Self == nil || *config.Self {
+		t.Errorf("self = %v with -no-self; want false", config.Self)
+	}
+}
+
+func TestStartRecords(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+selfReloadDelay: 10ms
+`)
+	start := func(i int) map[string]interface{} {
+		return w.infos("start")[i]["start"].(map[string]interface{})
+	}
+	first := start(0)
+	if first["reason"] != startStartup || first["config"] != configPathAbs {
+		t.Errorf("start record %v; want a startup with the config %s", first, configPathAbs)
+	}
+	savedCancel := setGenerationCancel(w.cancel)
+	defer setGenerationCancel(savedCancel)
+	// the config file is outside the watched directory, so its event is delivered directly
+	writeFile(t, filepath.Dir(configPath), filepath.Base(configPath), "paths: ["+w.dir+"]\n")
+	onEvent(newDispatcher(context.Background(), nil), Event{Name: configPath, Op: fsnotify.Write})
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the generation did not end when the config file was written")
+	}
+
+	// the next generation reports why it started
+	ctx, cancel := context.WithCancel(context.Background())
+	next := &watchfsTest{t: t, dir: w.dir, stdout: w.stdout, stderr: w.stderr, cancel: cancel, done: make(chan struct{})}
+	t.Cleanup(next.stop)
+	setGenerationCancel(cancel)
+	go func() {
+		defer close(next.done)
+		watchContext(ctx)
+	}()
+	next.waitFor("the next generation", func() bool { return len(next.infos("watching")) == 2 })
+	second := start(1)
+	if second["reason"] != startSelfReload || second["generation"] != first["generation"].(float64)+1 {
+		t.Errorf("start record %v after %v; want the next generation, started by a self-reload", second, first)
+	}
+}
+
+func TestRequestReloadDuringGenerationChange(t *testing.T) {
+	saved := setGenerationCancel(nil)
+	defer func() {
+		setGenerationCancel(saved)
+		generation.mu.Lock()
+		generation.reason = ""
+		generation.mu.Unlock()
+	}()
+	// reloads are requested from the signal handler and the reload timer, while main starts generations
+	var cancelled [10]int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			requestReload(startSIGHUP)
+		}
+	}()
+	for i := range cancelled {
+		i := i
+		setGenerationCancel(func() { atomic.AddInt32(&cancelled[i], 1) })
+		time.Sleep(time.Millisecond)
+	}
+	<-done
+	requestReload(startSIGHUP)
+	if atomic.LoadInt32(&cancelled[len(cancelled)-1]) == 0 {
+		t.Error("the reload did not cancel the current generation")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// replayWatcher is a Watcher that re-emits the event records of a recorded event log
+// (watchfs' stdout, or a -log-file), with the recorded timing scaled by 1/speed.
+// It does not watch the filesystem: Add and Remove do nothing.
+type replayWatcher struct {
+	path      string
+	speed     float64
+	events    chan fsnotify.Event
+	errors    chan error
+	done      chan struct{}
+	finished  chan struct{} // closed when all recorded events have been emitted
+	closeOnce sync.Once
+}
+
+// replayRecord is the part of an event record needed to replay it
+type replayRecord struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	Time string `json:"time"`
+}
+
+func newReplayWatcher(path string, speed float64) (*replayWatcher, error) {
+	records, err := readReplayRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &replayWatcher{
+		path:     path,
+		speed:    speed,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go w.loop(records)
+	return w, nil
+}
+
+// readReplayRecords reads the event records from a JSON Lines file, skipping all other records
+func readReplayRecords(path string) (records []replayRecord, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var r replayRecord
+		if err := json.Unmarshal([]byte(text), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if r.Op == "" || r.Path == "" {
+			continue
+		}
+		if _, err := parseRecordedOp(r.Op); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if r.Time != "" {
+			if _, err := time.Parse(time.RFC3339Nano, r.Time); err != nil {
+				return nil, fmt.Errorf("%s:%d: time: %v", path, line, err)
+			}
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// parseRecordedOp parses an op as written in event records, e.g. `write` or `create|write`
+func parseRecordedOp(s string) (op fsnotify.Op, err error) {
+	for _, name := range strings.Split(s, "|") {
+		o, ok := parseOp[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown op %q", name)
+		}
+		op |= o
+	}
+	return op, nil
+}
+
+func (w *replayWatcher) loop(records []replayRecord) {
+	defer close(w.finished)
+	var previous time.Time
+	for _, r := range records {
+		op, _ := parseRecordedOp(r.Op)
+		t, _ := time.Parse(time.RFC3339Nano, r.Time)
+		if !previous.IsZero() && !t.IsZero() && w.speed > 0 {
+			if gap := time.Duration(float64(t.Sub(previous)) / w.speed); gap > 0 {
+				select {
+				case <-time.After(gap):
+				case <-w.done:
+					return
+				}
+			}
+		}
+		if !t.IsZero() {
+			previous = t
+		}
+		select {
+		case w.events <- fsnotify.Event{Name: r.Path, Op: op}:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// wait waits until all recorded events have been emitted, and then until the runs they
+// triggered have completed: the longest debounce delay is awaited first, so that
+// events still being debounced are dispatched.
+func (w *replayWatcher) wait(ctx context.Context, actions []Action) {
+	select {
+	case <-w.finished:
+	case <-ctx.Done():
+		return
+	}
+	settle := config.globalDelay
+	for i := range actions {
+		if d := actions[i].delay; d > settle {
+			settle = d
+		}
+	}
+	select {
+	case <-time.After(settle + replaySettleMargin):
+	case <-ctx.Done():
+		return
+	}
+	for i := range actions {
+		actions[i].state.wait(ctx)
+	}
+}
+
+// replaySettleMargin is added to the longest debounce delay when waiting for replayed events to be dispatched
+const replaySettleMargin = 100 * time.Millisecond
+
+// Add does nothing; replayed events are not tied to watched paths
+func (w *replayWatcher) Add(path string) error {
+	return nil
+}
+
+// Remove does nothing
+func (w *replayWatcher) Remove(path string) error {
+	return nil
+}
+
+// Close stops the replay
+func (w *replayWatcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+	return nil
+}
+
+// Events returns the event channel
+func (w *replayWatcher) Events() <-chan fsnotify.Event {
+	return w.events
+}
+
+// Errors returns the error channel
+func (w *replayWatcher) Errors() <-chan error {
+	return w.errors
+}
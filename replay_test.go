@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// replayLog is a recorded event log of a.go, b.txt and c.go changing 100ms and 200ms apart,
+// interleaved with records that are not events
+const replayLog = `{"info":{"watching":{"dirs":1}},"seq":1}
+{"op":"write","ops":["write"],"path":"a.go","time":"2026-01-02T03:04:05.000Z","seq":1}
+{"actionCompleted":{"type":"exec","exitCode":0,"path":"a.go"},"seq":2}
+{"op":"write","ops":["write"],"path":"b.txt","time":"2026-01-02T03:04:05.100Z","seq":3}
+
+{"op":"create|write","ops":["create","write"],"path":"c.go","time":"2026-01-02T03:04:05.300Z","seq":4}
+`
+
+func TestReadReplayRecords(t *testing.T) {
+	dir := t.TempDir()
+	records, err := readReplayRecords(writeFile(t, dir, "events.ndjson", replayLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []replayRecord{
+		{Op: "write", Path: "a.go", Time: "2026-01-02T03:04:05.000Z"},
+		{Op: "write", Path: "b.txt", Time: "2026-01-02T03:04:05.100Z"},
+		{Op: "create|write", Path: "c.go", Time: "2026-01-02T03:04:05.300Z"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("read %+v; want %+v", records, want)
+	}
+	for _, invalid := range []string{
+		`{"op":"write"`,
+		`{"op":"modify","path":"a.go"}`,
+		`{"op":"write","path":"a.go","time":"yesterday"}`,
+	} {
+		path := writeFile(t, dir, "invalid.ndjson", replayLog+invalid+"\n")
+		if _, err := readReplayRecords(path); err == nil || !strings.Contains(err.Error(), path+":7:") {
+			t.Errorf("reading %s: %v; want an error for line 7", invalid, err)
+		}
+	}
+}
+
+func TestReplayWatcherTiming(t *testing.T) {
+	w, err := newReplayWatcher(writeFile(t, t.TempDir(), "events.ndjson", replayLog), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	start := time.Now()
+	var got []string
+	var times []time.Duration
+	for range []int{0, 1, 2} {
+		e := <-w.Events()
+		got = append(got, fmt.Sprintf("%s %v", e.Name, e.Op))
+		times = append(times, time.Since(start))
+	}
+	want := []string{
+		fmt.Sprintf("a.go %v", fsnotify.Write),
+		fmt.Sprintf("b.txt %v", fsnotify.Write),
+		fmt.Sprintf("c.go %v", fsnotify.Create|fsnotify.Write),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v; want %v", got, want)
+	}
+	// at twice the recorded speed, the gaps are 50ms and 100ms
+	if times[1] < 50*time.Millisecond || times[2] < 150*time.Millisecond || times[2] > 5*time.Second {
+		t.Errorf("replayed the events after %v; want them 50ms and 100ms apart", times)
+	}
+	select {
+	case <-w.finished:
+	case <-time.After(5 * time.Second):
+		t.Error("the replay has not finished after the last event")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	log := writeFile(t, t.TempDir(), "events.ndjson", replayLog)
+	p := startWatchfsProcess(t, fmt.Sprintf(`
+paths: [$DIR]
+exts: [go]
+actions:
+- shell: {command: cat >> %s, stdin: "{{lines .Paths}}", ignoreSignals: true}
+`, out), "-replay", log, "-replay-speed", "10")
+	if code, _ := p.wait(); code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, &p.stderr)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Fields(string(data))
+	sort.Strings(got)
+	if want := []string{"a.go", "c.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the action ran for %v; want %v", got, want)
+	}
+	if !strings.Contains(p.stderr.String(), "replay of "+log+" finished, exiting") {
+		t.Errorf("stderr:\n%s\nwant the replay to finish", &p.stderr)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+// jsonSchema is a JSON Schema object
+type jsonSchema map[string]interface{}
+
+// durationFields are fields that hold a duration: a duration string or a number of milliseconds
+var durationFields = map[string]bool{
+	"delay":           true,
+	"globalDelay":     true,
+	"batchWindow":     true,
+	"lockTimeout":     true,
+	"pollInterval":    true,
+	"selfReloadDelay": true,
+	"startupGrace":    true,
+	"cooldown":        true,
+	"after":           true,
+}
+
+// schemaEnums are the allowed values of string fields, by struct type and field name
+var schemaEnums = map[reflect.Type]map[string][]string{
+	reflect.TypeOf(configuration{}):    {"signal": signals, "debounceStrategy": debounceStrategies},
+	reflect.TypeOf(Action{}):           {"debounceStrategy": debounceStrategies},
+	reflect.TypeOf(Filter{}):           {"ops": ops, "only": onlyChoices, "matchMode": matchModes},
+	reflect.TypeOf(signalStep{}):       {"signal": signals},
+	reflect.TypeOf(ActionExec{}):       {"signal": signals},
+	reflect.TypeOf(ActionShell{}):      {"signal": signals},
+	reflect.TypeOf(ActionDockerRun{}):  {"signal": signals},
+	reflect.TypeOf(ActionComposeRun{}): {"signal": signals, "command": composeCommands},
+	reflect.TypeOf(dockerVolume{}):     {"consistency": dockerVolumeConsistencies},
+}
+
+// configurationSchema returns a JSON Schema for the configuration file
+func configurationSchema() jsonSchema {
+	schema := schemaForType(reflect.TypeOf(configuration{}))
+	// actions are defined once and referenced, since they can be nested (`after`)
+	schema["definitions"] = jsonSchema{"action": structSchema(reflect.TypeOf(Action{}))}
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "watchfs configuration"
+	return schema
+}
+
+func schemaForType(t reflect.Type) jsonSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(Action{}):
+		return jsonSchema{"$ref": "#/definitions/action"}
+	case reflect.TypeOf(stringList{}):
+		return jsonSchema{"oneOf": []jsonSchema{
+			{"type": "string"},
+			{"type": "array", "items": jsonSchema{"type": "string"}},
+		}}
+	case reflect.TypeOf(watchTarget{}):
+		properties := jsonSchema{}
+		addStructProperties(properties, t)
+		return jsonSchema{"oneOf": []jsonSchema{
+			{"type": "string"},
+			{"type": "object", "properties": properties, "required": []string{"path"}, "additionalProperties": false},
+		}}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{"type": "array", "items": schemaForType(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": schemaForType(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return jsonSchema{}
+}
+
+func structSchema(t reflect.Type) jsonSchema {
+	properties := jsonSchema{}
+	addStructProperties(properties, t)
+	return jsonSchema{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// addStructProperties adds the struct's fields as they are named by encoding/json:
+// embedded structs without a JSON name are flattened into the parent.
+func addStructProperties(properties jsonSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			addStructProperties(properties, embedded)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := schemaForType(field.Type)
+		if durationFields[name] && schema["type"] == "string" {
+			schema = jsonSchema{"type": []string{"string", "integer"}}
+		}
+		if enum, ok := schemaEnums[t][name]; ok {
+			if items, ok := schema["items"].(jsonSchema); ok {
+				items["enum"] = enum
+			} else {
+				schema["enum"] = enum
+			}
+		}
+		properties[name] = schema
+	}
+}
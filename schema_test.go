@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validate checks the JSON value against the schema, supporting the keywords
+// that configurationSchema uses. It returns the first violation, if any.
+func validate(root, schema map[string]interface{}, value interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/definitions/")
+		return validate(root, root["definitions"].(map[string]interface{})[name].(map[string]interface{}), value, path)
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, s := range oneOf {
+			if validate(root, s.(map[string]interface{}), value, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: %v matches %d of the oneOf schemas", path, value, matches)
+		}
+		return nil
+	}
+	if t, ok := schema["type"]; ok {
+		types, ok := t.([]interface{})
+		if !ok {
+			types = []interface{}{t}
+		}
+		matched := false
+		for _, t := range types {
+			matched = matched || hasJSONType(value, t.(string))
+		}
+		if !matched {
+			return fmt.Errorf("%s: %v is not of type %v", path, value, t)
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			found = found || v == value
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+	switch value := value.(type) {
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				if err := validate(root, items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing %s", path, name)
+			}
+		}
+		for name, v := range value {
+			s, ok := properties[name].(map[string]interface{})
+			if !ok {
+				s, ok = schema["additionalProperties"].(map[string]interface{})
+			}
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s: unknown property %s", path, name)
+				}
+				continue
+			}
+			if err := validate(root, s, v, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func hasJSONType(value interface{}, t string) bool {
+	switch value := value.(type) {
+	case string:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case float64:
+		return t == "number" || t == "integer" && value == float64(int64(value))
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return t == "null"
+}
+
+// generatedSchema returns the configuration schema as it is printed by -print-schema
+func generatedSchema(t *testing.T) map[string]interface{} {
+	data, err := json.Marshal(configurationSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+const knownGoodConfig = `{
+  "paths": ["src", {"path": "docs", "exts": ["md"]}],
+  "ignore": ["build"],
+  "exts": ["go"],
+  "ops": ["create", "write"],
+  "delay": 100,
+  "signal": "SIGINT",
+  "signals": [{"signal": "SIGTERM"}, {"signal": "SIGKILL", "after": "5s"}],
+  "env": {"GOFLAGS": "-mod=vendor"},
+  "maxConcurrency": 2,
+  "actions": [
+    {"name": "build", "exec": {"command": ["go", "build", "./..."]}, "only": "file", "cooldown": "1s"},
+    {"shell": {"command": "go test ./..."}, "after": {"shell": {"command": "echo done"}}},
+    {"dockerRun": {"image": "golang", "volumes": [{"source": ".", "target": "/src", "readonly": true, "consistency": "cached"}]}},
+    {"composeRun": {"service": "web", "command": "restart"}}
+  ]
+}`
+
+func TestSchemaValidatesAKnownGoodConfig(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "watchfs.json", knownGoodConfig)
+	var c configuration
+	if err := c.load(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.makeCanonical(); err != nil {
+		t.Fatalf("the known-good config is invalid: %v", err)
+	}
+	schema := generatedSchema(t)
+	var value interface{}
+	if err := json.Unmarshal([]byte(knownGoodConfig), &value); err != nil {
+		t.Fatal(err)
+	}
+	if err := validate(schema, schema, value, "config"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSchemaRejectsInvalidConfigs(t *testing.T) {
+	schema := generatedSchema(t)
+	tests := []struct{ config, want string }{
+		{`{"pathz": ["src"]}`, "unknown property pathz"},
+		{`{"signal": "SIGTREM"}`, "not one of"},
+		{`{"delay": true}`, "not of type"},
+		{`{"paths": [{"exts": ["go"]}]}`, "matches 0 of the oneOf schemas"},
+		{`{"actions": [{"exec": {"command": "make"}}]}`, "not of type"},
+		{`{"actions": [{"composeRun": {"command": "down"}}]}`, "not one of"},
+		{`{"actions": [{"after": {"ops": ["delete"]}}]}`, "not one of"},
+	}
+	for _, tt := range tests {
+		var value interface{}
+		if err := json.Unmarshal([]byte(tt.config), &value); err != nil {
+			t.Fatal(err)
+		}
+		if err := validate(schema, schema, value, "config"); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("validate(%s) = %v; want an error containing %q", tt.config, err, tt.want)
+		}
+	}
+}
+
+func TestSchemaCoversTheStructFields(t *testing.T) {
+	schema := configurationSchema()
+	action := schema["definitions"].(jsonSchema)["action"].(jsonSchema)["properties"].(jsonSchema)
+	for _, tt := range []struct {
+		properties jsonSchema
+		t          reflect.Type
+	}{
+		{schema["properties"].(jsonSchema), reflect.TypeOf(configuration{})},
+		{action, reflect.TypeOf(Action{})},
+		{action["exec"].(jsonSchema)["properties"].(jsonSchema), reflect.TypeOf(ActionExec{})},
+	} {
+		for i := 0; i < tt.t.NumField(); i++ {
+			field := tt.t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if field.PkgPath != "" || field.Anonymous || name == "-" {
+				continue
+			}
+			if _, ok := tt.properties[name]; !ok {
+				t.Errorf("the schema of %v has no property %q for %s", tt.t, name, field.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const defaultServeAddr = "localhost:8080"
+
+// liveReloadFileServer serves a directory, injecting a script tag into HTML pages
+type liveReloadFileServer struct {
+	root   http.Dir
+	files  http.Handler
+	script []byte
+}
+
+func newLiveReloadFileServer(dir, scriptPath string) *liveReloadFileServer {
+	return &liveReloadFileServer{
+		root:   http.Dir(dir),
+		files:  http.FileServer(http.Dir(dir)),
+		script: []byte(fmt.Sprintf("<script src=%q></script>\n", scriptPath)),
+	}
+}
+
+func (s *liveReloadFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".html", ".htm":
+	default:
+		s.files.ServeHTTP(w, r)
+		return
+	}
+	f, err := s.root.Open(name)
+	if err != nil {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+	page, err := ioutil.ReadAll(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(s.inject(page)))
+}
+
+// inject inserts the script tag before the closing body tag, or appends it
+func (s *liveReloadFileServer) inject(page []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		return append(page, s.script...)
+	}
+	out := make([]byte, 0, len(page)+len(s.script))
+	out = append(out, page[:i]...)
+	out = append(out, s.script...)
+	return append(out, page[i:]...)
+}
+
+// serveAction returns the action for -serve: a webSocket action serving dir,
+// with its WebSocket endpoint and reload script under reloadPath
+func serveAction(dir, addr, reloadPath string) (Action, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return Action{}, err
+	}
+	if !info.IsDir() {
+		return Action{}, fmt.Errorf("-serve: %s is not a directory", dir)
+	}
+	return Action{
+		Name: "serve",
+		ActionWebSocket: &ActionWebSocket{
+			Addr:  addr,
+			Serve: filepath.Clean(dir),
+			Path:  reloadPath,
+		},
+	}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveReloadFileServer(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "index.html", "<html><body><h1>hi</h1></BODY></html>")
+	writeFile(t, dir, "fragment.htm", "<p>no body</p>")
+	writeFile(t, dir, "style.css", "body {}")
+	server := httptest.NewServer(newLiveReloadFileServer(dir, "/.watchfs/reload.js"))
+	defer server.Close()
+	script := `<script src="/.watchfs/reload.js"></script>` + "\n"
+	tests := []struct {
+		path, want string
+	}{
+		{"/", "<html><body><h1>hi</h1>" + script + "</BODY></html>"},
+		{"/index.html", "<html><body><h1>hi</h1>" + script + "</BODY></html>"},
+		{"/fragment.htm", "<p>no body</p>" + script},
+		{"/style.css", "body {}"},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(server.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != tt.want {
+			t.Errorf("GET %s: %d %q; want %q", tt.path, resp.StatusCode, body, tt.want)
+		}
+	}
+	if _, err := serveAction(writeFile(t, dir, "file.txt", ""), defaultServeAddr, defaultWebSocketServePath); err == nil {
+		t.Error("serveAction() accepted a file")
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "index.html", "<html><body>v1</body></html>")
+	listener := httptest.NewServer(nil)
+	addr := listener.Listener.Addr().String()
+	listener.Close() // free the address for the server
+	savedDir, savedAddr, savedPath := serveDir, serveAddr, serveReloadPath
+	serveDir, serveAddr, serveReloadPath = dir, addr, "/livereload/"
+	t.Cleanup(func() { serveDir, serveAddr, serveReloadPath = savedDir, savedAddr, savedPath })
+	w := startWatchfsIn(t, dir, "")
+
+	var resp *http.Response
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if resp, err = http.Get("http://" + addr + "/"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), `<script src="/livereload/reload.js"></script>`) {
+		t.Errorf("the page %q does not load the reload script", page)
+	}
+
+	conn, r, response := dialWebSocketPath(t, addr, "/livereload/", "http://"+addr)
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: status %d; want %d", response.StatusCode, http.StatusSwitchingProtocols)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// a ping is answered once the client is registered
+	conn.Write(clientFrame(webSocketPing, nil, [4]byte{1, 2, 3, 4}))
+	if opcode, _, err := readWebSocketFrame(r); err != nil || opcode != webSocketPong {
+		t.Fatalf("ping: got opcode %#x, %v; want a pong", opcode, err)
+	}
+	w.write("index.html", "<html><body>v2</body></html>")
+	if opcode, payload, err := readWebSocketFrame(r); err != nil || opcode != webSocketText || string(payload) != defaultWebSocketMessage {
+		t.Fatalf("got opcode %#x, %q, %v; want the reload message", opcode, payload, err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// shellArgs returns the arguments passed before the command to a shell given without
+// arguments: `/c` for cmd, `-NoProfile -NonInteractive -Command` for PowerShell, `-c`
+// for fish and nu, and defaultShellArgs for all other shells
+func shellArgs(shell string) []string {
+	name := strings.ToLower(filepath.Base(shell))
+	name = strings.TrimSuffix(name, ".exe")
+	switch name {
+	case "cmd":
+		return []string{"/c"}
+	case "powershell", "pwsh":
+		return []string{"-NoProfile", "-NonInteractive", "-Command"}
+	case "fish", "nu":
+		return []string{"-c"}
+	}
+	return append([]string(nil), defaultShellArgs...)
+}
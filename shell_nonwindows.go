@@ -2,7 +2,26 @@
 
 package main
 
-var (
-	defaultShell     = "sh"
-	defaultShellArgs = []string{"-cv"}
+import (
+	"os"
+	"path/filepath"
 )
+
+// defaultShellArgs are the arguments passed before the command to shells not known to shellArgs
+var defaultShellArgs = []string{"-cv"}
+
+// noLoginShells are the base names of shells that refuse to run commands, as set as
+// $SHELL for service accounts
+var noLoginShells = map[string]bool{
+	"nologin": true,
+	"false":   true,
+}
+
+// defaultShell returns the shell used by `shell` actions if none is configured: $SHELL
+// (unless it is a shell that refuses to run commands, such as nologin), or sh
+func defaultShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" && !noLoginShells[filepath.Base(shell)] {
+		return shell
+	}
+	return "sh"
+}
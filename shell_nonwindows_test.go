@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefaultShell(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{"/usr/bin/zsh", "/usr/bin/zsh"},
+		{"", "sh"},
+		{"/usr/sbin/nologin", "sh"},
+		{"/bin/false", "sh"},
+	}
+	for _, tt := range tests {
+		t.Setenv("SHELL", tt.shell)
+		if got := defaultShell(); got != tt.want {
+			t.Errorf("SHELL=%q: defaultShell() = %q; want %q", tt.shell, got, tt.want)
+		}
+	}
+}
+
+func TestShellResolutionOrder(t *testing.T) {
+	t.Setenv("SHELL", "/bin/zsh")
+	tests := []struct {
+		action, config stringList
+		want           []string
+	}{
+		{stringList{"bash", "-c"}, stringList{"dash"}, []string{"bash", "-c", "make"}},
+		{nil, stringList{"dash"}, []string{"dash", "-cv", "make"}},
+		{nil, nil, []string{"/bin/zsh", "-cv", "make"}},
+		{nil, stringList{"/usr/bin/fish"}, []string{"/usr/bin/fish", "-c", "make"}},
+	}
+	for _, tt := range tests {
+		useConfig(t, configuration{Shell: tt.config})
+		name, args := (&ActionShell{Command: "make", Shell: tt.action}).commandLine()
+		if got := append([]string{name}, args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("action shell %q, config shell %q: ran %q; want %q", tt.action, tt.config, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigShell(t *testing.T) {
+	tests := []struct {
+		yaml     string
+		action   stringList
+		wantName string
+		wantArgs []string
+	}{
+		{"shell: /bin/bash\n", nil, "/bin/bash", append(append([]string(nil), defaultShellArgs...), "make")},
+		{"shell: [/bin/bash, -euo, pipefail, -c]\n", nil, "/bin/bash", []string{"-euo", "pipefail", "-c", "make"}},
+		{"shell: pwsh\n", nil, "pwsh", []string{"-NoProfile", "-NonInteractive", "-Command", "make"}},
+		// the action's shell overrides the top-level one
+		{"shell: [/bin/bash, -c]\n", stringList{"zsh", "-c"}, "zsh", []string{"-c", "make"}},
+	}
+	for _, tt := range tests {
+		var c configuration
+		if err := c.decode(strings.NewReader(tt.yaml), formatYAML); err != nil {
+			t.Fatal(err)
+		}
+		useConfig(t, c)
+		a := &ActionShell{Command: "make", Shell: tt.action}
+		if name, args := a.commandLine(); name != tt.wantName || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("%q: commandLine() = %q %q; want %q %q", tt.yaml, name, args, tt.wantName, tt.wantArgs)
+		}
+	}
+}
+
+func TestConfigShellRuns(t *testing.T) {
+	captureStdout(t)
+	out := filepath.Join(t.TempDir(), "out")
+	var c configuration
+	// the arguments after -c become $0 and $1
+	if err := c.decode(strings.NewReader("shell: [sh, -c, 'echo \"$0 $1\" > \"$1\"', via-config]\n"), formatYAML); err != nil {
+		t.Fatal(err)
+	}
+	c.Actions = []Action{{ActionShell: &ActionShell{Command: out}}}
+	useConfig(t, c)
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Actions[0].Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(out); string(data) != "via-config "+out+"\n" {
+		t.Errorf("the configured shell wrote %q", data)
+	}
+}
@@ -2,7 +2,15 @@
 
 package main
 
-var (
-	defaultShell     = "cmd"
-	defaultShellArgs = []string{"/c"}
-)
+import "os"
+
+// defaultShellArgs are the arguments passed before the command to shells not known to shellArgs
+var defaultShellArgs = []string{"-c"}
+
+// defaultShell returns the shell used by `shell` actions if none is configured: %COMSPEC%, or cmd
+func defaultShell() string {
+	if shell := os.Getenv("COMSPEC"); shell != "" {
+		return shell
+	}
+	return "cmd"
+}
@@ -0,0 +1,40 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefaultShell(t *testing.T) {
+	t.Setenv("COMSPEC", `C:\Windows\system32\cmd.exe`)
+	if got := defaultShell(); got != `C:\Windows\system32\cmd.exe` {
+		t.Errorf("defaultShell() = %q; want %%COMSPEC%%", got)
+	}
+	t.Setenv("COMSPEC", "")
+	if got := defaultShell(); got != "cmd" {
+		t.Errorf("defaultShell() = %q without %%COMSPEC%%; want cmd", got)
+	}
+}
+
+func TestShellResolutionOrder(t *testing.T) {
+	t.Setenv("COMSPEC", `C:\Windows\system32\cmd.exe`)
+	tests := []struct {
+		action, config stringList
+		want           []string
+	}{
+		{stringList{"bash", "-c"}, stringList{"pwsh"}, []string{"bash", "-c", "make"}},
+		{nil, stringList{"pwsh.exe"}, []string{"pwsh.exe", "-NoProfile", "-NonInteractive", "-Command", "make"}},
+		{nil, nil, []string{`C:\Windows\system32\cmd.exe`, "/c", "make"}},
+		{nil, stringList{"bash"}, []string{"bash", "-c", "make"}},
+	}
+	for _, tt := range tests {
+		useConfig(t, configuration{Shell: tt.config})
+		name, args := (&ActionShell{Command: "make", Shell: tt.action}).commandLine()
+		if got := append([]string{name}, args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("action shell %q, config shell %q: ran %q; want %q", tt.action, tt.config, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	ossignal "os/signal"
+	"sync"
+	"syscall"
+)
+
+// shutdown is closed when watchfs has been asked to exit
+var shutdown = make(chan struct{})
+
+var shutdownOnce sync.Once
+
+// requestShutdown asks watchfs to exit
+func requestShutdown() {
+	shutdownOnce.Do(func() {
+		close(shutdown)
+	})
+}
+
+// handleShutdownSignals closes `shutdown` on the first SIGINT/SIGTERM, and exits
+// immediately on the second one.
+func handleShutdownSignals() {
+	signals := make(chan os.Signal, 2)
+	ossignal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		requestShutdown()
+		<-signals
+		exit(1)
+	}()
+}
+
+// handleReloadSignal reloads the configuration on SIGHUP, in the same way as when
+// the configuration file changes.
+func handleReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	ossignal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			onInfo("reloading watchfs configuration (SIGHUP)")
+			requestReload(startSIGHUP)
+		}
+	}()
+}
@@ -0,0 +1,86 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	ossignal "os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSIGHUPReloadsTheConfiguration(t *testing.T) {
+	w := startWatchfs(t, `
+paths: [$DIR]
+actions:
+- name: before
+  exec: {command: [sleep, "10"]}
+`)
+	savedCancel := setGenerationCancel(w.cancel)
+	handleReloadSignal()
+	t.Cleanup(func() {
+		ossignal.Reset(syscall.SIGHUP)
+		setGenerationCancel(savedCancel)
+	})
+	w.waitFor("the initial run to start", func() bool { return len(w.stdout.recordsWith(t, "actionStarted")) == 1 })
+	yaml, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configPath, []byte(strings.Replace(string(yaml), "before", "after", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the generation did not end on SIGHUP")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the running action was stopped after %v", elapsed)
+	}
+	if len(w.completed()) != 1 {
+		t.Errorf("got %d completed runs; want the running action to be stopped", len(w.completed()))
+	}
+
+	// the next generation starts with the configuration read again
+	ctx, cancel := context.WithCancel(context.Background())
+	next := &watchfsTest{t: t, dir: w.dir, stdout: w.stdout, stderr: w.stderr, cancel: cancel, done: make(chan struct{})}
+	t.Cleanup(next.stop)
+	setGenerationCancel(cancel)
+	go func() {
+		defer close(next.done)
+		watchContext(ctx)
+	}()
+	next.waitFor("the next generation", func() bool { return len(next.infos("watching")) == 2 })
+	starts := next.infos("start")
+	if reason := starts[len(starts)-1]["start"].(map[string]interface{})["reason"]; reason != startSIGHUP {
+		t.Errorf("the generation started for %v; want %s", reason, startSIGHUP)
+	}
+	if len(config.Actions) != 1 || config.Actions[0].Name != "after" {
+		t.Errorf("the generation has actions %v; want the reloaded configuration", config.Actions)
+	}
+}
+
+func TestPIDFileRemovedOnShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchfs.pid")
+	p := startWatchfsProcess(t, "paths: [$DIR]\n", "-pid-file", path)
+	if pid, ok := readPIDFile(path); !ok || pid != p.cmd.Process.Pid {
+		t.Errorf("the pid file holds %d; want the PID %d of watchfs", pid, p.cmd.Process.Pid)
+	}
+	p.cmd.Process.Signal(syscall.SIGTERM)
+	if code, _ := p.wait(); code != 0 {
+		t.Errorf("exit code %d after SIGTERM; want 0\nstderr:\n%s", code, &p.stderr)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the pid file is left behind after shutting down: %v", err)
+	}
+}
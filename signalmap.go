@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// signalMap maps filename extensions (or suffixes) and ops to the signal to send
+// when an event for them arrives while the action is running
+type signalMap struct {
+	suffixes []signalMapEntry // longest suffix first
+	ops      map[fsnotify.Op]os.Signal
+}
+
+type signalMapEntry struct {
+	suffix string
+	signal os.Signal
+}
+
+// parseSignalMap parses a `signalMap`. Keys naming an op (e.g. `write`) match events with that op;
+// all other keys are extensions (`tmpl` or `.tmpl`) or, if they contain a dot other than
+// a leading one, filename suffixes (e.g. `_test.go`).
+func parseSignalMap(entries map[string]string) (m signalMap, err error) {
+	for key, name := range entries {
+		signal, lookupErr := lookupSignal(name)
+		if lookupErr != nil {
+			if err == nil {
+				err = fmt.Errorf("signalMap %q: %v", key, lookupErr)
+			}
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if op, ok := parseOp[key]; ok {
+			if m.ops == nil {
+				m.ops = make(map[fsnotify.Op]os.Signal)
+			}
+			m.ops[op] = signal
+			continue
+		}
+		suffix := key
+		if strings.HasPrefix(key, ".") || !strings.Contains(key, ".") {
+			suffix = "." + strings.TrimPrefix(key, ".")
+		}
+		m.suffixes = append(m.suffixes, signalMapEntry{suffix: suffix, signal: signal})
+	}
+	sort.Slice(m.suffixes, func(i, j int) bool {
+		if len(m.suffixes[i].suffix) != len(m.suffixes[j].suffix) {
+			return len(m.suffixes[i].suffix) > len(m.suffixes[j].suffix)
+		}
+		return m.suffixes[i].suffix < m.suffixes[j].suffix
+	})
+	return m, err
+}
+
+// lookup returns the signal for the event: that of the longest matching extension or suffix,
+// or else that of the first of the event's op bits that has one
+func (m signalMap) lookup(e Event) (os.Signal, bool) {
+	name := strings.ToLower(filepath.Base(e.Name))
+	for _, entry := range m.suffixes {
+		if strings.HasSuffix(name, entry.suffix) {
+			return entry.signal, true
+		}
+	}
+	for _, op := range opOrder {
+		if signal, ok := m.ops[op]; ok && e.Op&op != 0 {
+			return signal, true
+		}
+	}
+	return nil, false
+}
+
+// eventSignalSteps returns the signal sequence to send for the event: the signal
+// `signalMap` gives for it, or else the sequence given by signalSteps
+func eventSignalSteps(e Event, m signalMap, steps []signalStep, signal *os.Signal) []signalStep {
+	if s, ok := m.lookup(e); ok {
+		return []signalStep{{signal: s}}
+	}
+	return signalSteps(steps, signal)
+}
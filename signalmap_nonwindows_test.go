@@ -0,0 +1,89 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestSignalMapLookup(t *testing.T) {
+	m, err := parseSignalMap(map[string]string{
+		"tmpl":     "SIGHUP",
+		".CSS":     "SIGUSR1",
+		"_test.go": "SIGINT",
+		"go":       "SIGTERM",
+		"create":   "SIGUSR2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		op   fsnotify.Op
+		want os.Signal // nil: no entry
+	}{
+		{"views/index.tmpl", fsnotify.Write, syscall.SIGHUP},
+		{"style.css", fsnotify.Write, syscall.SIGUSR1},
+		{"main.go", fsnotify.Write, syscall.SIGTERM},
+		{"main_test.go", fsnotify.Write, syscall.SIGINT},
+		{"MAIN.GO", fsnotify.Create, syscall.SIGTERM},
+		{"README.md", fsnotify.Create, syscall.SIGUSR2},
+		{"README.md", fsnotify.Write, nil},
+		{"tmpl", fsnotify.Write, nil},
+	}
+	for _, tt := range tests {
+		got, ok := m.lookup(Event{Name: tt.name, Op: tt.op})
+		if ok != (tt.want != nil) || ok && got != tt.want {
+			t.Errorf("lookup(%s %v) = %v, %v; want %v", tt.name, tt.op, got, ok, tt.want)
+		}
+	}
+	if _, err := parseSignalMap(map[string]string{"go": "SIGTREM"}); err == nil {
+		t.Error("parsed an unknown signal")
+	}
+}
+
+func TestEventSignalSteps(t *testing.T) {
+	m, err := parseSignalMap(map[string]string{"tmpl": "SIGHUP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	term := os.Signal(syscall.SIGTERM)
+	for name, want := range map[string]os.Signal{"a.tmpl": syscall.SIGHUP, "a.go": syscall.SIGTERM} {
+		if got := eventSignalSteps(Event{Name: name, Op: fsnotify.Write}, m, nil, &term); len(got) != 1 || got[0].signal != want {
+			t.Errorf("%s: eventSignalSteps() = %v; want %v", name, got, want)
+		}
+	}
+}
+
+func TestSignalMapAction(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+actions:
+- shell:
+    command: trap 'echo HUP >> %s' HUP; trap 'echo TERM >> %[1]s; exit' TERM; echo started >> %[1]s; while :; do sleep 0.01; done
+    signal: SIGTERM
+    signalMap: {tmpl: SIGHUP}
+`, out))
+	read := func() string {
+		data, _ := ioutil.ReadFile(out)
+		return string(data)
+	}
+	w.waitFor("the command to start", func() bool { return read() == "started\n" })
+	w.write("index.tmpl", "a")
+	w.waitFor("the reload", func() bool { return strings.Contains(read(), "HUP\n") })
+	w.write("main.go", "b")
+	w.waitFor("the restart", func() bool { return strings.Contains(read(), "TERM\n") })
+	if got := read(); !strings.HasPrefix(got, "started\nHUP\nTERM\n") {
+		t.Errorf("the command wrote %q; want SIGHUP for the template and SIGTERM for the Go file", got)
+	}
+}
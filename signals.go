@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// portableSignalNames are signals supported on some, but not all, platforms
+var portableSignalNames = []string{
+	"SIGABRT", "SIGALRM", "SIGBUS", "SIGCHLD", "SIGCONT", "SIGFPE", "SIGHUP", "SIGILL",
+	"SIGINT", "SIGIO", "SIGIOT", "SIGKILL", "SIGPIPE", "SIGPROF", "SIGQUIT", "SIGSEGV",
+	"SIGSTOP", "SIGSYS", "SIGTERM", "SIGTRAP", "SIGTSTP", "SIGTTIN", "SIGTTOU", "SIGURG",
+	"SIGUSR1", "SIGUSR2", "SIGVTALRM", "SIGWINCH", "SIGXCPU", "SIGXFSZ",
+}
+
+func mergeSignals(maps ...map[string]os.Signal) map[string]os.Signal {
+	out := make(map[string]os.Signal)
+	for _, m := range maps {
+		for name, signal := range m {
+			out[name] = signal
+		}
+	}
+	return out
+}
+
+// lookupSignal returns the signal with the given name, or defaultSignal for the empty name.
+// Unknown names and signals that are not supported on this platform are an error.
+func lookupSignal(name string) (os.Signal, error) {
+	if name == "" {
+		return defaultSignal, nil
+	}
+	if signal, ok := parseSignal[name]; ok {
+		return signal, nil
+	}
+	if strings.HasPrefix(name, "SIGRT") {
+		return nil, fmt.Errorf("signal %s is not supported on %s", name, runtime.GOOS)
+	}
+	for _, portable := range portableSignalNames {
+		if name == portable {
+			return nil, fmt.Errorf("signal %s is not supported on %s", name, runtime.GOOS)
+		}
+	}
+	return nil, fmt.Errorf("unknown signal %q (choices: %v)", name, signals)
+}
+
+// parseSignalOption parses an optional signal name; the empty name yields nil
+func parseSignalOption(name string) (*os.Signal, error) {
+	if name == "" {
+		return nil, nil
+	}
+	signal, err := lookupSignal(name)
+	if err != nil {
+		return nil, err
+	}
+	return &signal, nil
+}
@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const (
+	sigrtmin = 34 // the first real-time signal not reserved by glibc
+	sigrtmax = 64
+)
+
+// platformSignals are the real-time signals, named as by `kill -l`
+var platformSignals = func() map[string]os.Signal {
+	signals := make(map[string]os.Signal)
+	mid := (sigrtmin + sigrtmax) / 2
+	for n := sigrtmin; n <= sigrtmax; n++ {
+		var name string
+		switch {
+		case n == sigrtmin:
+			name = "SIGRTMIN"
+		case n == sigrtmax:
+			name = "SIGRTMAX"
+		case n <= mid:
+			name = fmt.Sprintf("SIGRTMIN+%d", n-sigrtmin)
+		default:
+			name = fmt.Sprintf("SIGRTMAX-%d", sigrtmax-n)
+		}
+		signals[name] = syscall.Signal(n)
+	}
+	return signals
+}()
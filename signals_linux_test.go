@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestLinuxSignals(t *testing.T) {
+	tests := map[string]syscall.Signal{
+		"SIGCONT":     syscall.SIGCONT,
+		"SIGUSR1":     syscall.SIGUSR1,
+		"SIGUSR2":     syscall.SIGUSR2,
+		"SIGWINCH":    syscall.SIGWINCH,
+		"SIGRTMIN":    34,
+		"SIGRTMIN+1":  35,
+		"SIGRTMIN+15": 49,
+		"SIGRTMAX-14": 50,
+		"SIGRTMAX-1":  63,
+		"SIGRTMAX":    64,
+	}
+	for name, want := range tests {
+		if s, err := lookupSignal(name); s != want || err != nil {
+			t.Errorf("lookupSignal(%q) = %v, %v; want %v", name, s, err, want)
+		}
+	}
+	if _, err := lookupSignal("SIGRTMIN+16"); err == nil || err.Error() != "signal SIGRTMIN+16 is not supported on linux" {
+		t.Errorf("lookupSignal(SIGRTMIN+16) = %v; want it to be unsupported", err)
+	}
+	if want := len(portableSignalNames) + 31; len(signals) != want {
+		t.Errorf("got %d signals; want the %d portable and real-time signals", len(signals), want)
+	}
+}
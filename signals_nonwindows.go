@@ -8,7 +8,7 @@ import (
 	"syscall"
 )
 
-var parseSignal = map[string]os.Signal{
+var parseSignal = mergeSignals(platformSignals, map[string]os.Signal{
 	"SIGABRT":   syscall.SIGABRT,
 	"SIGALRM":   syscall.SIGALRM,
 	"SIGBUS":    syscall.SIGBUS,
@@ -39,7 +39,7 @@ var parseSignal = map[string]os.Signal{
 	"SIGWINCH":  syscall.SIGWINCH,
 	"SIGXCPU":   syscall.SIGXCPU,
 	"SIGXFSZ":   syscall.SIGXFSZ,
-}
+})
 
 var signals = func() (signals []string) {
 	for signal := range parseSignal {
@@ -0,0 +1,8 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package main
+
+import "os"
+
+var platformSignals = map[string]os.Signal{}
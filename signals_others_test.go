@@ -0,0 +1,23 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestUnixSignals(t *testing.T) {
+	for _, name := range []string{"SIGCONT", "SIGUSR1", "SIGUSR2", "SIGWINCH"} {
+		if _, err := lookupSignal(name); err != nil {
+			t.Errorf("lookupSignal(%q) = %v", name, err)
+		}
+	}
+	if _, err := lookupSignal("SIGRTMIN"); err == nil || err.Error() != "signal SIGRTMIN is not supported on "+runtime.GOOS {
+		t.Errorf("lookupSignal(SIGRTMIN) = %v; want it to be unsupported", err)
+	}
+	if len(signals) != len(portableSignalNames) {
+		t.Errorf("got signals %v; want the portable signals", signals)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLookupSignal(t *testing.T) {
+	if s, err := lookupSignal(""); s != defaultSignal || err != nil {
+		t.Errorf("lookupSignal(\"\") = %v, %v; want the default signal", s, err)
+	}
+	for _, name := range signals {
+		if s, err := lookupSignal(name); s == nil || err != nil {
+			t.Errorf("lookupSignal(%q) = %v, %v", name, s, err)
+		}
+	}
+	if _, err := lookupSignal("SIGTREM"); err == nil || !strings.HasPrefix(err.Error(), `unknown signal "SIGTREM"`) {
+		t.Errorf("lookupSignal(SIGTREM) = %v; want an unknown signal error", err)
+	}
+	if !sort.StringsAreSorted(signals) {
+		t.Errorf("signals are not sorted: %v", signals)
+	}
+}
+
+func TestUnknownSignalIsAConfigError(t *testing.T) {
+	typo := []signalStep{{Signal: "SIGTREM"}}
+	tests := []struct {
+		name string
+		c    configuration
+	}{
+		{"signal", configuration{Signal: "SIGTREM"}},
+		{"signals", configuration{Signals: typo}},
+		{"exec signal", configuration{Actions: []Action{{ActionExec: &ActionExec{Command: []string{"true"}, Signal: "SIGTREM"}}}}},
+		{"exec signals", configuration{Actions: []Action{{ActionExec: &ActionExec{Command: []string{"true"}, Signals: typo}}}}},
+		{"exec signalMap", configuration{Actions: []Action{{ActionExec: &ActionExec{Command: []string{"true"}, SignalMap: map[string]string{"*.go": "SIGTREM"}}}}}},
+		{"shell signal", configuration{Actions: []Action{{ActionShell: &ActionShell{Command: "true", Signal: "SIGTREM"}}}}},
+		{"shell signals", configuration{Actions: []Action{{ActionShell: &ActionShell{Command: "true", Signals: typo}}}}},
+		{"dockerRun signal", configuration{Actions: []Action{{ActionDockerRun: &ActionDockerRun{Image: "alpine", Signal: "SIGTREM"}}}}},
+		{"composeRun signal", configuration{Actions: []Action{{ActionComposeRun: &ActionComposeRun{Service: "app", Signal: "SIGTREM"}}}}},
+	}
+	for _, tt := range tests {
+		useConfig(t, tt.c)
+		if err := config.makeCanonical(); err == nil || !strings.Contains(err.Error(), `unknown signal "SIGTREM"`) {
+			t.Errorf("%s: makeCanonical() = %v; want an unknown signal error", tt.name, err)
+		}
+	}
+	useConfig(t, configuration{Actions: []Action{{ActionExec: &ActionExec{Command: []string{"true"}}}}})
+	if err := config.makeCanonical(); err != nil || config.signal != defaultSignal {
+		t.Errorf("makeCanonical() = %v with signal %v; want the default signal", err, config.signal)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWindowsSignals(t *testing.T) {
+	want := []string{
+		"SIGABRT", "SIGALRM", "SIGBUS", "SIGFPE", "SIGHUP", "SIGILL",
+		"SIGKILL", "SIGPIPE", "SIGQUIT", "SIGSEGV", "SIGTERM", "SIGTRAP",
+	}
+	if !reflect.DeepEqual(signals, want) {
+		t.Errorf("signals = %v; want %v", signals, want)
+	}
+	for _, name := range []string{"SIGUSR1", "SIGUSR2", "SIGWINCH", "SIGCONT", "SIGRTMIN"} {
+		if _, err := lookupSignal(name); err == nil || err.Error() != "signal "+name+" is not supported on windows" {
+			t.Errorf("lookupSignal(%q) = %v; want it to be unsupported", name, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// signalStep is one step of a signal sequence: a signal sent some time after the sequence starts
+type signalStep struct {
+	Signal string `json:"signal" yaml:"signal"`
+	After  string `json:"after,omitempty" yaml:"after,omitempty"`
+
+	signal os.Signal
+	after  time.Duration
+}
+
+func (s *signalStep) makeCanonical() error {
+	signal, err := lookupSignal(s.Signal)
+	if err != nil {
+		signal = defaultSignal
+	}
+	s.signal = signal
+	if n, err := strconv.ParseInt(s.After, 10, 64); err == nil {
+		s.After = fmt.Sprint(time.Millisecond * time.Duration(n))
+	}
+	s.after, _ = time.ParseDuration(s.After)
+	return err
+}
+
+// makeSignalStepsCanonical canonicalizes the steps and sorts them by their delay
+func makeSignalStepsCanonical(steps []signalStep) error {
+	var errs []error
+	for i := range steps {
+		errs = append(errs, steps[i].makeCanonical())
+	}
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].after < steps[j].after
+	})
+	return firstError(errs...)
+}
+
+// signalSteps returns the signal sequence to use: the first non-empty of the
+// action's `signals`, its `signal`, the top-level `signals`, and the top-level `signal`.
+func signalSteps(steps []signalStep, signal *os.Signal) []signalStep {
+	switch {
+	case len(steps) > 0:
+		return steps
+	case signal != nil:
+		return []signalStep{{signal: *signal}}
+	case len(config.Signals) > 0:
+		return config.Signals
+	}
+	return []signalStep{{signal: config.signal}}
+}
+
+// runningProcess is the process of an action's run, which Notify signals from the
+// event loop while Run waits for it to exit
+type runningProcess struct {
+	mu      sync.Mutex
+	process *os.Process
+	done    chan struct{} // closed once the process has exited
+}
+
+// run starts the command and waits for it to exit
+func (r *runningProcess) run(command *exec.Cmd) error {
+	if err := command.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	r.mu.Lock()
+	r.process, r.done = command.Process, done
+	r.mu.Unlock()
+	err := command.Wait()
+	r.mu.Lock()
+	r.process = nil
+	r.mu.Unlock()
+	close(done)
+	return err
+}
+
+// get returns the running process (nil if there is none), and the channel closed once it exits
+func (r *runningProcess) get() (*os.Process, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.process, r.done
+}
+
+// signalProcess sends the signal sequence to the process. Steps due immediately
+// are sent synchronously; the remaining steps are sent in the background unless
+// `done` is closed (i.e. the process exits) first.
+func signalProcess(p *os.Process, done <-chan struct{}, steps []signalStep) error {
+	return signalSequence(p.Signal, done, steps)
+}
+
+// signalSequence is signalProcess for an arbitrary way of sending a signal
+func signalSequence(send func(os.Signal) error, done <-chan struct{}, steps []signalStep) error {
+	var err error
+	for len(steps) > 0 && steps[0].after <= 0 {
+		err = send(steps[0].signal)
+		steps = steps[1:]
+	}
+	if len(steps) == 0 {
+		return err
+	}
+	start := time.Now()
+	go func() {
+		for _, step := range steps {
+			timer := time.NewTimer(step.after - time.Since(start))
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+				send(step.signal)
+			}
+		}
+	}()
+	return err
+}
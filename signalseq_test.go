@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMakeSignalStepsCanonical(t *testing.T) {
+	steps := []signalStep{{Signal: "SIGKILL", After: "5s"}, {Signal: "SIGINT", After: "100"}, {Signal: "SIGTERM"}}
+	if err := makeSignalStepsCanonical(steps); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, step := range steps {
+		got = append(got, fmt.Sprintf("%s@%v", step.signal, step.after))
+	}
+	want := []string{
+		fmt.Sprintf("%s@0s", syscall.SIGTERM),
+		fmt.Sprintf("%s@100ms", syscall.SIGINT),
+		fmt.Sprintf("%s@5s", syscall.SIGKILL),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("steps = %v; want %v", got, want)
+	}
+	if steps[1].After != "100ms" {
+		t.Errorf("after = %q; want the integer read as milliseconds", steps[1].After)
+	}
+	if err := makeSignalStepsCanonical([]signalStep{{Signal: "SIGTERM"}, {Signal: "SIGTREM"}}); err == nil {
+		t.Error("accepted an unknown signal")
+	}
+}
+
+func TestSignalStepsPrecedence(t *testing.T) {
+	term, hup := os.Signal(syscall.SIGTERM), os.Signal(syscall.SIGHUP)
+	actionSteps := []signalStep{{signal: syscall.SIGINT}}
+	configSteps := []signalStep{{signal: syscall.SIGQUIT}}
+	tests := []struct {
+		name        string
+		steps       []signalStep
+		signal      *os.Signal
+		configSteps []signalStep
+		want        os.Signal
+	}{
+		{"action signals", actionSteps, &term, configSteps, syscall.SIGINT},
+		{"action signal", nil, &term, configSteps, syscall.SIGTERM},
+		{"config signals", nil, nil, configSteps, syscall.SIGQUIT},
+		{"config signal", nil, nil, nil, syscall.SIGHUP},
+	}
+	for _, tt := range tests {
+		useConfig(t, configuration{Signals: tt.configSteps, signal: hup})
+		if got := signalSteps(tt.steps, tt.signal); len(got) != 1 || got[0].signal != tt.want {
+			t.Errorf("%s: signalSteps() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// signalRecorder records the signals sent to it, and when
+type signalRecorder struct {
+	mu      sync.Mutex
+	start   time.Time
+	signals []os.Signal
+	times   []time.Duration
+}
+
+func (r *signalRecorder) send(s os.Signal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.signals = append(r.signals, s)
+	r.times = append(r.times, time.Since(r.start))
+	return nil
+}
+
+func (r *signalRecorder) sent() ([]os.Signal, []time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]os.Signal(nil), r.signals...), append([]time.Duration(nil), r.times...)
+}
+
+func TestSignalSequence(t *testing.T) {
+	steps := []signalStep{{signal: syscall.SIGTERM}, {signal: syscall.SIGINT, after: 50 * time.Millisecond}, {signal: syscall.SIGKILL, after: 100 * time.Millisecond}}
+	r := &signalRecorder{start: time.Now()}
+	done := make(chan struct{})
+	signalSequence(r.send, done, steps)
+	if signals, _ := r.sent(); !reflect.DeepEqual(signals, []os.Signal{syscall.SIGTERM}) {
+		t.Fatalf("sent %v synchronously; want only the immediate step", signals)
+	}
+	time.Sleep(300 * time.Millisecond)
+	signals, times := r.sent()
+	if want := []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL}; !reflect.DeepEqual(signals, want) {
+		t.Fatalf("sent %v; want %v", signals, want)
+	}
+	for i, step := range steps {
+		if times[i] < step.after {
+			t.Errorf("sent %v after %v; want it after %v", signals[i], times[i], step.after)
+		}
+	}
+
+	// the sequence stops once the process has exited
+	r = &signalRecorder{start: time.Now()}
+	signalSequence(r.send, done, steps[:1])
+	close(done)
+	signalSequence(r.send, done, steps)
+	time.Sleep(150 * time.Millisecond)
+	if signals, _ := r.sent(); len(signals) != 2 {
+		t.Errorf("sent %v after the process exited; want only the immediate steps", signals)
+	}
+}
+
+func TestSignalEscalation(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	w := startWatchfs(t, fmt.Sprintf(`
+paths: [$DIR]
+actions:
+- shell:
+    command: trap 'echo TERM >> %s' TERM; echo started >> %[1]s; while :; do sleep 0.01; done
+    signals: [{signal: SIGTERM}, {signal: SIGKILL, after: 200ms}]
+`, out))
+	w.waitFor("the command to start", func() bool {
+		data, _ := ioutil.ReadFile(out)
+		return len(data) > 0
+	})
+	start := time.Now()
+	w.write("a.txt", "a")
+	w.waitFor("the run to end", func() bool { return len(w.completed()) > 0 })
+	elapsed := time.Since(start)
+	data, _ := ioutil.ReadFile(out)
+	// the killed run is followed by one for the event, which starts again
+	if !strings.HasPrefix(string(data), "started\nTERM\n") {
+		t.Errorf("the command wrote %q; want it to trap SIGTERM", data)
+	}
+	if elapsed < 200*time.Millisecond {
+		t.Errorf("the command was killed after %v; want SIGKILL after 200ms", elapsed)
+	}
+	if result := w.completed()[0]; result["exitCode"] != -1.0 {
+		t.Errorf("actionCompleted = %v; want the command to be killed", result)
+	}
+}
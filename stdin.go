@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// stdinForwarder copies watchfs's stdin to the stdin of the currently running interactive action.
+// Input read while no interactive action is running is discarded.
+type stdinForwarder struct {
+	src  io.Reader
+	once sync.Once
+	mu   sync.Mutex
+	dst  io.Writer
+}
+
+var interactiveStdin = &stdinForwarder{src: os.Stdin}
+
+// attach makes w the destination for forwarded input
+func (f *stdinForwarder) attach(w io.Writer) {
+	f.once.Do(func() { go f.forward() })
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dst = w
+}
+
+// detach stops forwarding input to w, if it is the current destination
+func (f *stdinForwarder) detach(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dst == w {
+		f.dst = nil
+	}
+}
+
+func (f *stdinForwarder) forward() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.src.Read(buf)
+		if n > 0 {
+			f.mu.Lock()
+			if f.dst != nil {
+				f.dst.Write(buf[:n])
+			}
+			f.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
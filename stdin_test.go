@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useStdin makes the returned pipe watchfs's stdin for interactive actions until the test has finished
+func useStdin(t *testing.T) (*stdinForwarder, *io.PipeWriter) {
+	r, w := io.Pipe()
+	saved := interactiveStdin
+	interactiveStdin = &stdinForwarder{src: r}
+	t.Cleanup(func() {
+		w.Close()
+		interactiveStdin = saved
+	})
+	return interactiveStdin, w
+}
+
+// attached waits until the forwarder has a destination
+func (f *stdinForwarder) attached(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		dst := f.dst
+		f.mu.Unlock()
+		if dst != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no interactive action is attached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStdinForwarder(t *testing.T) {
+	f, stdin := useStdin(t)
+	var first, second syncBuffer
+	forwarded := func(b *syncBuffer, want string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for b.String() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("forwarded %q; want %q", b.String(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	f.attach(&first)
+	fmt.Fprint(stdin, "a")
+	forwarded(&first, "a")
+	f.detach(&second) // not the destination
+	fmt.Fprint(stdin, "b")
+	forwarded(&first, "ab")
+	f.detach(&first)
+	f.attach(&second)
+	fmt.Fprint(stdin, "c")
+	forwarded(&second, "c")
+	if first.String() != "ab" {
+		t.Errorf("forwarded %q to a detached destination", first.String())
+	}
+}
+
+func TestInteractiveAction(t *testing.T) {
+	captureStdout(t)
+	f, stdin := useStdin(t)
+	out := filepath.Join(t.TempDir(), "out")
+	c := configuration{Actions: []Action{
+		{ActionExec: &ActionExec{Command: []string{"sh", "-c", "read line; echo \"$line\" >> " + out}, Interactive: true}},
+	}}
+	useConfig(t, c)
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	// the input reaches each run of the action in turn, including after it restarts
+	for _, line := range []string{"hello", "again"} {
+		done := make(chan error)
+		go func() { done <- config.Actions[0].Run(context.Background(), nil) }()
+		f.attached(t)
+		fmt.Fprintln(stdin, line)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\nagain\n" {
+		t.Errorf("the runs read %q; want each line once", data)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dst != nil {
+		t.Error("the action is still attached after its run")
+	}
+}
+
+func TestAtMostOneInteractiveAction(t *testing.T) {
+	useConfig(t, configuration{Actions: []Action{
+		{ActionExec: &ActionExec{Command: []string{"cat"}, Interactive: true}},
+		{ActionShell: &ActionShell{Command: "cat", Interactive: true}},
+	}})
+	if err := config.makeCanonical(); err == nil || !strings.Contains(err.Error(), "at most one action can be interactive") {
+		t.Errorf("makeCanonical() = %v; want an error for two interactive actions", err)
+	}
+}
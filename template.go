@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/google/shlex"
+)
+
+// templateData is the data available to templated action fields
+type templateData struct {
+	// Path is the path of the last triggering event
+	Path string
+	// Dir is the directory of Path
+	Dir string
+	// Base is the last element of Path
+	Base string
+	// Ext is the extension of Path, without the leading dot
+	Ext string
+	// Op is the operation of the last triggering event
+	Op string
+	// Time is the time of the last triggering event
+	Time string
+	// Paths are the paths of all events coalesced into this run
+	Paths []string
+	// ExitCode is the exit code of the run a hook (e.g. `after`) runs for
+	ExitCode int
+}
+
+func newTemplateData(events []Event) (data templateData) {
+	for _, e := range events {
+		if e.Name != "" {
+			data.Paths = append(data.Paths, e.Name)
+		}
+	}
+	data.Dir = "."
+	if len(events) == 0 {
+		return
+	}
+	e := events[len(events)-1]
+	if e.result != nil {
+		data.ExitCode = e.result.exitCode
+	}
+	if e.Name == "" {
+		return
+	}
+	data.Path = e.Name
+	data.Dir = filepath.Dir(e.Name)
+	data.Base = filepath.Base(e.Name)
+	data.Ext = ext(e.Name)
+	data.Op = strings.ToLower(e.Op.String())
+	data.Time = e.Time
+	return
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"lines": func(lines []string) string {
+		if len(lines) == 0 {
+			return ""
+		}
+		return strings.Join(lines, "\n") + "\n"
+	},
+}
+
+// expandTemplate executes text as a Go template against data.
+// Strings without template actions are returned unchanged.
+func expandTemplate(text string, data templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	t, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// expandTemplates expands each of the texts for the given events
+func expandTemplates(texts []string, events []Event) ([]string, error) {
+	if len(texts) == 0 {
+		return texts, nil
+	}
+	data := newTemplateData(events)
+	out := make([]string, len(texts))
+	for i, text := range texts {
+		var err error
+		if out[i], err = expandTemplate(text, data); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// templatePlaceholder stands in for the i-th template action while a command line is split
+const templatePlaceholder = "\x00%d\x00"
+
+// splitCommandTemplate splits a command line into arguments like a shell would,
+// keeping each template action (`{{...}}`) intact within its argument, so that
+// e.g. `go build {{join .Paths " "}}` is split into three arguments.
+func splitCommandTemplate(command string) ([]string, error) {
+	var actions []string
+	var protected strings.Builder
+	rest := command
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			break
+		}
+		end += start + len("}}")
+		protected.WriteString(rest[:start])
+		fmt.Fprintf(&protected, templatePlaceholder, len(actions))
+		actions = append(actions, rest[start:end])
+		rest = rest[end:]
+	}
+	protected.WriteString(rest)
+	args, err := shlex.Split(protected.String())
+	if err != nil {
+		return nil, err
+	}
+	for i := range args {
+		for j, action := range actions {
+			args[i] = strings.Replace(args[i], fmt.Sprintf(templatePlaceholder, j), action, -1)
+		}
+	}
+	return args, nil
+}
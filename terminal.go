@@ -0,0 +1,34 @@
+package main
+
+import "os"
+
+// clearScreenSequence moves the cursor home and clears the screen and the scrollback
+const clearScreenSequence = "\x1b[H\x1b[2J\x1b[3J"
+
+// stdoutIsTerminal is whether stdout is a terminal (character device)
+var stdoutIsTerminal = isTerminal(os.Stdout)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// clearScreen clears the terminal (for `clearScreen`), unless stdout is not a terminal or -quiet is set
+func clearScreen() {
+	if !config.ClearScreen || quiet || !stdoutIsTerminal {
+		return
+	}
+	stdoutJSONMu.Lock()
+	defer stdoutJSONMu.Unlock()
+	os.Stdout.WriteString(clearScreenSequence)
+}
+
+// ringBell writes the terminal bell to stderr (for `bell`)
+func ringBell() {
+	if !config.Bell {
+		return
+	}
+	stderrJSONMu.Lock()
+	defer stderrJSONMu.Unlock()
+	os.Stderr.WriteString("\a")
+}
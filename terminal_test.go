@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// redirect makes a new file the given standard stream (os.Stdout or os.Stderr) until the
+// test has finished, and returns a function reading what was written to it
+func redirect(t *testing.T, stream **os.File) func() string {
+	f, err := os.Create(filepath.Join(t.TempDir(), "stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *stream
+	*stream = f
+	t.Cleanup(func() {
+		*stream = saved
+		f.Close()
+	})
+	return func() string {
+		data, _ := ioutil.ReadFile(f.Name())
+		return string(data)
+	}
+}
+
+// useTerminal sets whether stdout is taken to be a terminal until the test has finished
+func useTerminal(t *testing.T, terminal bool) {
+	saved := stdoutIsTerminal
+	stdoutIsTerminal = terminal
+	t.Cleanup(func() { stdoutIsTerminal = saved })
+}
+
+func TestClearScreen(t *testing.T) {
+	savedQuiet := quiet
+	defer func() { quiet = savedQuiet }()
+	tests := []struct {
+		clear, terminal, quiet bool
+		want                   string
+	}{
+		{true, true, false, clearScreenSequence},
+		{false, true, false, ""},
+		{true, false, false, ""},
+		{true, true, true, ""},
+	}
+	for _, tt := range tests {
+		stdout := redirect(t, &os.Stdout)
+		useTerminal(t, tt.terminal)
+		useConfig(t, configuration{ClearScreen: tt.clear})
+		quiet = tt.quiet
+		clearScreen()
+		if got := stdout(); got != tt.want {
+			t.Errorf("clearScreen: %v, terminal: %v, quiet: %v: wrote %q; want %q", tt.clear, tt.terminal, tt.quiet, got, tt.want)
+		}
+	}
+}
+
+func TestClearScreenBeforeEachRun(t *testing.T) {
+	stdout := redirect(t, &os.Stdout)
+	useTerminal(t, true)
+	w := startWatchfs(t, `
+paths: [$DIR]
+clearScreen: true
+actions:
+- exec: {command: [echo, hello], ignoreSignals: true}
+`)
+	w.write("a.txt", "a")
+	w.waitFor("the run", func() bool { return len(w.completed()) > 0 })
+	w.stop()
+	output := stdout()
+	runs := strings.Count(output, "hello\n")
+	if runs == 0 || strings.Count(output, clearScreenSequence+"hello\n") != runs {
+		t.Errorf("wrote %q; want the clear sequence before the output of each run", output)
+	}
+}
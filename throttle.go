@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// throttleReportWindow is the period over which dropped events are counted before they are reported
+var throttleReportWindow = time.Second
+
+// eventThrottle is the global event rate limit, see `maxEventsPerSecond`
+var eventThrottle throttle
+
+// throttle is a token bucket refilled at `rate` tokens per second, holding at most one second's
+// worth, but no less than one token, so that rates below one event per second still let events pass
+type throttle struct {
+	mu      sync.Mutex
+	rate    float64
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+// setRate sets the rate limit in events per second; a rate <= 0 disables the limit
+func (t *throttle) setRate(rate float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rate = rate
+	t.tokens = t.capacity()
+	t.last = clock.Now()
+}
+
+// capacity returns the maximum number of tokens in the bucket
+func (t *throttle) capacity() float64 {
+	if t.rate < 1 {
+		return 1
+	}
+	return t.rate
+}
+
+// allow returns whether an event may pass. Events that may not are counted, and
+// reported as a single warning with their count at the end of each throttleReportWindow.
+func (t *throttle) allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rate <= 0 {
+		return true
+	}
+	now := clock.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if capacity := t.capacity(); t.tokens > capacity {
+		t.tokens = capacity
+	}
+	t.last = now
+	if t.tokens >= 1 {
+		t.tokens--
+		return true
+	}
+	if t.dropped == 0 {
+		clock.AfterFunc(throttleReportWindow, t.report)
+	}
+	t.dropped++
+	return false
+}
+
+func (t *throttle) report() {
+	t.mu.Lock()
+	dropped, rate := t.dropped, t.rate
+	t.dropped = 0
+	t.mu.Unlock()
+	stderrJSONEncode(struct {
+		Warning            string  `json:"warning"`
+		Dropped            int     `json:"dropped"`
+		MaxEventsPerSecond float64 `json:"maxEventsPerSecond"`
+	}{
+		Warning:            "event rate limit exceeded, dropped events",
+		Dropped:            dropped,
+		MaxEventsPerSecond: rate,
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	tests := []struct {
+		name string
+		rate float64
+		// the events arrive every interval, and are allowed as in want ('+': allowed, '-': dropped)
+		interval time.Duration
+		want     string
+	}{
+		{"unlimited", 0, time.Millisecond, "++++++++++"},
+		{"a burst within the capacity passes", 10, time.Millisecond, "++++++++++--"},
+		{"at the rate", 10, 100 * time.Millisecond, "++++++++++++++++++++"},
+		// after the initial capacity, one event in four passes
+		{"four times the rate", 10, 25 * time.Millisecond, "+++++++++++++---+---+---+---+-"},
+		{"below one per second", 0.5, 500 * time.Millisecond, "+---+---+"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := useFakeClock(t)
+			captureStderr(t)
+			var throttle throttle
+			throttle.setRate(tt.rate)
+			var got strings.Builder
+			for i := range tt.want {
+				if i > 0 {
+					c.advance(tt.interval)
+				}
+				if throttle.allow() {
+					got.WriteByte('+')
+				} else {
+					got.WriteByte('-')
+				}
+			}
+			if got.String() != tt.want {
+				t.Errorf("allowed %s; want %s", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottleReportsDroppedEventsOncePerWindow(t *testing.T) {
+	c := useFakeClock(t)
+	stderr := captureStderr(t)
+	var throttle throttle
+	throttle.setRate(2)
+	for i := 0; i < 5; i++ {
+		throttle.allow()
+	}
+	if warnings := stderr.recordsWith(t, "warning"); len(warnings) != 0 {
+		t.Fatalf("reported %v before the end of the window", warnings)
+	}
+	c.advance(throttleReportWindow)
+	throttle.allow() // refilled
+	throttle.allow()
+	throttle.allow()
+	c.advance(throttleReportWindow)
+	warnings := stderr.recordsWith(t, "warning")
+	if len(warnings) != 2 {
+		t.Fatalf("got warnings %v; want one per window", warnings)
+	}
+	for i, want := range []float64{3, 1} {
+		if warnings[i]["dropped"] != want || warnings[i]["maxEventsPerSecond"] != 2.0 {
+			t.Errorf("warning %d = %v; want %v dropped at 2 events per second", i, warnings[i], want)
+		}
+	}
+	c.advance(throttleReportWindow)
+	if warnings := stderr.recordsWith(t, "warning"); len(warnings) != 2 {
+		t.Errorf("reported a window without dropped events: %v", warnings[2:])
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// decodeTOML parses a TOML document into maps, slices, strings, int64s, float64s and bools.
+// It supports the subset of TOML needed for configuration files: tables, arrays of tables,
+// dotted and quoted keys, inline tables, arrays, all string forms, numbers and booleans.
+// Dates and times are not supported.
+func decodeTOML(data string) (map[string]interface{}, error) {
+	p := &tomlParser{s: data}
+	root := make(map[string]interface{})
+	if err := p.parse(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+type tomlParser struct {
+	s   string
+	pos int
+}
+
+type tomlError struct {
+	line    int
+	message string
+}
+
+func (e *tomlError) Error() string {
+	return fmt.Sprintf("toml: line %d: %s", e.line, e.message)
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return &tomlError{
+		line:    strings.Count(p.s[:p.pos], "\n") + 1,
+		message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *tomlParser) consume(prefix string) bool {
+	if strings.HasPrefix(p.s[p.pos:], prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// skipSpace skips spaces and tabs
+func (p *tomlParser) skipSpace() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.pos++
+	}
+}
+
+// skipComment skips a comment up to (not including) the end of the line
+func (p *tomlParser) skipComment() {
+	if p.peek() != '#' {
+		return
+	}
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments
+func (p *tomlParser) skipBlank() {
+	for {
+		p.skipSpace()
+		p.skipComment()
+		if !p.consume("\n") && !p.consume("\r\n") {
+			return
+		}
+	}
+}
+
+// endLine expects only whitespace and a comment before the end of the line
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+	p.skipComment()
+	if p.eof() || p.consume("\n") || p.consume("\r\n") {
+		return nil
+	}
+	return p.errorf("expected the end of the line, found %q", p.peek())
+}
+
+func (p *tomlParser) parse(root map[string]interface{}) error {
+	current := root
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil
+		}
+		switch {
+		case p.consume("[["):
+			keys, err := p.parseKey()
+			if err != nil {
+				return err
+			}
+			if !p.consume("]]") {
+				return p.errorf("expected ]] after the array of tables header")
+			}
+			parent, err := p.table(root, keys[:len(keys)-1])
+			if err != nil {
+				return err
+			}
+			last := keys[len(keys)-1]
+			tables, ok := parent[last].([]interface{})
+			if _, exists := parent[last]; exists && !ok {
+				return p.errorf("key %q is not an array of tables", last)
+			}
+			current = make(map[string]interface{})
+			parent[last] = append(tables, current)
+		case p.consume("["):
+			keys, err := p.parseKey()
+			if err != nil {
+				return err
+			}
+			if !p.consume("]") {
+				return p.errorf("expected ] after the table header")
+			}
+			if current, err = p.table(root, keys); err != nil {
+				return err
+			}
+		default:
+			if err := p.parseKeyValue(current); err != nil {
+				return err
+			}
+		}
+		if err := p.endLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// table returns the table at the dotted key path, creating missing tables.
+// For arrays of tables, the last table of the array is used.
+func (p *tomlParser) table(m map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		switch v := m[key].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			m[key] = next
+			m = next
+		case map[string]interface{}:
+			m = v
+		case []interface{}:
+			last, ok := interface{}(nil), false
+			if len(v) > 0 {
+				last = v[len(v)-1]
+			}
+			if m, ok = last.(map[string]interface{}); !ok {
+				return nil, p.errorf("key %q is not a table", key)
+			}
+		default:
+			return nil, p.errorf("key %q is not a table", key)
+		}
+	}
+	return m, nil
+}
+
+// parseKeyValue parses `key = value` into the table
+func (p *tomlParser) parseKeyValue(m map[string]interface{}) error {
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if !p.consume("=") {
+		return p.errorf("expected = after key %q", strings.Join(keys, "."))
+	}
+	p.skipSpace()
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	m, err = p.table(m, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := m[last]; exists {
+		return p.errorf("duplicate key %q", strings.Join(keys, "."))
+	}
+	m[last] = value
+	return nil
+}
+
+// parseKey parses a (possibly dotted and quoted) key, and the spaces around it
+func (p *tomlParser) parseKey() (keys []string, err error) {
+	for {
+		p.skipSpace()
+		var key string
+		switch p.peek() {
+		case '"':
+			p.pos++
+			key, err = p.parseBasicString()
+		case '\'':
+			p.pos++
+			key, err = p.parseLiteralString()
+		default:
+			start := p.pos
+			for c := p.peek(); isTOMLBareKeyChar(c); c = p.peek() {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected a key, found %q", p.peek())
+			}
+			key = p.s[start:p.pos]
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		p.skipSpace()
+		if !p.consume(".") {
+			return keys, nil
+		}
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	switch {
+	case p.consume(`"""`):
+		return p.parseMultilineBasicString()
+	case p.consume(`'''`):
+		return p.parseMultilineLiteralString()
+	case p.consume(`"`):
+		return p.parseBasicString()
+	case p.consume(`'`):
+		return p.parseLiteralString()
+	case p.consume("["):
+		return p.parseArray()
+	case p.consume("{"):
+		return p.parseInlineTable()
+	}
+	start := p.pos
+	for c := p.peek(); isTOMLBareKeyChar(c) || c == '+' || c == '.' || c == ':'; c = p.peek() {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	switch token {
+	case "":
+		return nil, p.errorf("expected a value, found %q", p.peek())
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return p.parseNumber(token)
+}
+
+func (p *tomlParser) parseNumber(token string) (interface{}, error) {
+	if strings.Contains(token, ":") || len(token) >= 10 && token[4] == '-' {
+		return nil, p.errorf("dates and times are not supported: %q", token)
+	}
+	digits := strings.Replace(token, "_", "", -1)
+	for prefix, base := range map[string]int{"0x": 16, "0o": 8, "0b": 2} {
+		if strings.HasPrefix(digits, prefix) {
+			n, err := strconv.ParseInt(digits[2:], base, 64)
+			if err != nil {
+				return nil, p.errorf("invalid integer %q", token)
+			}
+			return n, nil
+		}
+	}
+	special := strings.TrimLeft(digits, "+-")
+	numeric := strings.IndexAny(special, "0123456789") == 0
+	if special == "inf" || special == "nan" || numeric && strings.ContainsAny(digits, ".eE") {
+		f, err := strconv.ParseFloat(digits, 64)
+		if err != nil {
+			return nil, p.errorf("invalid float %q", token)
+		}
+		return f, nil
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return nil, p.errorf("invalid value %q", token)
+	}
+	return n, nil
+}
+
+func (p *tomlParser) parseArray() (interface{}, error) {
+	values := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.consume("]") {
+			return values, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.skipBlank()
+		if p.consume("]") {
+			return values, nil
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expected , or ] in array, found %q", p.peek())
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (interface{}, error) {
+	m := make(map[string]interface{})
+	p.skipSpace()
+	if p.consume("}") {
+		return m, nil
+	}
+	for {
+		if err := p.parseKeyValue(m); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.consume("}") {
+			return m, nil
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expected , or } in inline table, found %q", p.peek())
+		}
+	}
+}
+
+// parseBasicString parses the rest of a "..." string
+func (p *tomlParser) parseBasicString() (string, error) {
+	var b strings.Builder
+	for {
+		switch c := p.peek(); c {
+		case 0, '\n':
+			return "", p.errorf("unterminated string")
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\\':
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// parseMultilineBasicString parses the rest of a """...""" string
+func (p *tomlParser) parseMultilineBasicString() (string, error) {
+	p.consume("\r")
+	p.consume("\n")
+	var b strings.Builder
+	for {
+		switch {
+		case p.eof():
+			return "", p.errorf("unterminated string")
+		case p.consume(`"""`):
+			// up to two quotes may directly precede the closing delimiter
+			for i := 0; i < 2 && p.consume(`"`); i++ {
+				b.WriteByte('"')
+			}
+			return b.String(), nil
+		case p.consume("\\\n"), p.consume("\\\r\n"):
+			// a line-ending backslash trims the following whitespace
+			for c := p.peek(); c == ' ' || c == '\t' || c == '\r' || c == '\n'; c = p.peek() {
+				p.pos++
+			}
+		case p.peek() == '\\':
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(p.peek())
+			p.pos++
+		}
+	}
+}
+
+// parseEscape parses an escape sequence in a basic string
+func (p *tomlParser) parseEscape(b *strings.Builder) error {
+	p.pos++ // backslash
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.s) {
+			return p.errorf("invalid unicode escape")
+		}
+		r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return p.errorf("invalid unicode escape %q", p.s[p.pos:p.pos+n])
+		}
+		p.pos += n
+		b.WriteRune(rune(r))
+	default:
+		p.pos--
+		return p.errorf("invalid escape sequence \\%c", c)
+	}
+	return nil
+}
+
+// parseLiteralString parses the rest of a '...' string
+func (p *tomlParser) parseLiteralString() (string, error) {
+	start := p.pos
+	for {
+		switch p.peek() {
+		case 0, '\n':
+			return "", p.errorf("unterminated string")
+		case '\'':
+			p.pos++
+			return p.s[start : p.pos-1], nil
+		}
+		p.pos++
+	}
+}
+
+// parseMultilineLiteralString parses the rest of a multi-line literal string (delimited by three single quotes)
+func (p *tomlParser) parseMultilineLiteralString() (string, error) {
+	p.consume("\r")
+	p.consume("\n")
+	end := strings.Index(p.s[p.pos:], `'''`)
+	if end < 0 {
+		p.pos = len(p.s)
+		return "", p.errorf("unterminated string")
+	}
+	s := p.s[p.pos : p.pos+end]
+	p.pos += end + 3
+	// up to two quotes may directly precede the closing delimiter
+	for i := 0; i < 2 && p.consume(`'`); i++ {
+		s += `'`
+	}
+	return s, nil
+}
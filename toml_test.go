@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type tomlDoc = map[string]interface{}
+
+func TestDecodeTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want tomlDoc
+	}{
+		{"empty", "", tomlDoc{}},
+		{"comments and blank lines", "# a comment\n\n  # another\r\n", tomlDoc{}},
+		{"key values", "a = 1\nb = true\nc = \"x\" # comment\nd = 1.5\n", tomlDoc{"a": int64(1), "b": true, "c": "x", "d": 1.5}},
+		{"crlf", "a = 1\r\nb = 2\r\n", tomlDoc{"a": int64(1), "b": int64(2)}},
+		{"bare key chars", "a-b_c1 = 1", tomlDoc{"a-b_c1": int64(1)}},
+		{"quoted keys", `"a.b" = 1` + "\n" + `'c d' = 2`, tomlDoc{"a.b": int64(1), "c d": int64(2)}},
+		{"dotted keys", "a.b.c = 1\na . d = 2", tomlDoc{"a": tomlDoc{"b": tomlDoc{"c": int64(1)}, "d": int64(2)}}},
+		{"tables", "[a]\nx = 1\n[b.c]\ny = 2\n[a.d]\nz = 3", tomlDoc{
+			"a": tomlDoc{"x": int64(1), "d": tomlDoc{"z": int64(3)}},
+			"b": tomlDoc{"c": tomlDoc{"y": int64(2)}},
+		}},
+		{"arrays of tables", "[[actions]]\nname = \"a\"\n[actions.exec]\ncommand = [\"make\"]\n[[actions]]\nname = \"b\"", tomlDoc{
+			"actions": []interface{}{
+				tomlDoc{"name": "a", "exec": tomlDoc{"command": []interface{}{"make"}}},
+				tomlDoc{"name": "b"},
+			},
+		}},
+		{"inline tables", `a = { x = 1, y.z = "s" }` + "\nb = {}", tomlDoc{"a": tomlDoc{"x": int64(1), "y": tomlDoc{"z": "s"}}, "b": tomlDoc{}}},
+		{"arrays", "a = [1, 2, ]\nb = [\n  \"x\", # first\n  \"y\"\n]\nc = []\nd = [[1], [\"a\"]]", tomlDoc{
+			"a": []interface{}{int64(1), int64(2)},
+			"b": []interface{}{"x", "y"},
+			"c": []interface{}{},
+			"d": []interface{}{[]interface{}{int64(1)}, []interface{}{"a"}},
+		}},
+		{"integers", "a = +1\nb = -2\nc = 1_000\nd = 0xff\ne = 0o17\nf = 0b101", tomlDoc{
+			"a": int64(1), "b": int64(-2), "c": int64(1000), "d": int64(255), "e": int64(15), "f": int64(5),
+		}},
+		{"floats", "a = 1e3\nb = -0.5\nc = 6.626E-34\nd = inf\ne = 1_000.5\nf = -inf", tomlDoc{
+			"a": 1e3, "b": -0.5, "c": 6.626e-34, "d": math.Inf(1), "e": 1000.5, "f": math.Inf(-1),
+		}},
+		{"basic string escapes", `a = "tab\there \"q\" \\ \u00e9 \U0001F600"`, tomlDoc{"a": "tab\there \"q\" \\ é 😀"}},
+		{"literal strings", `a = 'C:\path\*.go'`, tomlDoc{"a": `C:\path\*.go`}},
+		{"multi-line basic strings", "a = \"\"\"\nline 1\nline 2\"\"\"\nb = \"\"\"one \\\n    two\"\"\"\nc = \"\"\"x\"\"\"\"\"", tomlDoc{
+			"a": "line 1\nline 2", "b": "one two", "c": `x""`,
+		}},
+		{"multi-line literal strings", "a = '''\nno \\escapes\n'''\nb = '''x''''", tomlDoc{"a": "no \\escapes\n", "b": "x'"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeTOML(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeTOML() = %#v; want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"missing equals", "a 1", "line 1: expected = after key"},
+		{"missing value", "a =", "line 1: expected a value"},
+		{"missing key", "= 1", "line 1: expected a key"},
+		{"duplicate key", "a = 1\na = 2", `line 2: duplicate key "a"`},
+		{"duplicate dotted key", "a.b = 1\n[a]\nb = 2", `line 3: duplicate key "b"`},
+		{"trailing garbage", "a = 1 2", "line 1: expected the end of the line"},
+		{"unterminated string", "a = \"x\nb = 1", "line 1: unterminated string"},
+		{"unterminated literal", "a = 'x", "line 1: unterminated string"},
+		{"unterminated multi-line string", "a = \"\"\"x\n\n", "line 3: unterminated string"},
+		{"unterminated multi-line literal", "\na = '''x", "line 2: unterminated string"},
+		{"invalid escape", `a = "\q"`, `line 1: invalid escape sequence \q`},
+		{"invalid unicode escape", `a = "\uZZZZ"`, "line 1: invalid unicode escape"},
+		{"surrogate escape", `a = "\uD800"`, "line 1: invalid unicode escape"},
+		{"unclosed table header", "[a\nx = 1", "line 1: expected ] after the table header"},
+		{"unclosed array of tables header", "[[a]\nx = 1", "line 1: expected ]] after the array of tables header"},
+		{"unclosed array", "a = [1, 2", "line 1: expected , or ] in array"},
+		{"array separator", "a = [1 2]", "line 1: expected , or ] in array"},
+		{"inline table separator", "a = {x = 1 y = 2}", "line 1: expected , or } in inline table"},
+		{"table over value", "a = 1\n[a]", `line 2: key "a" is not a table`},
+		{"array of tables over table", "[a]\n[[a]]", `line 2: key "a" is not an array of tables`},
+		{"dotted key over value", "a = 1\na.b = 2", `line 2: key "a" is not a table`},
+		{"date", "a = 1979-05-27", "line 1: dates and times are not supported"},
+		{"time", "a = 07:32:00", "line 1: dates and times are not supported"},
+		{"invalid integer", "a = 0xzz", `line 1: invalid integer "0xzz"`},
+		{"invalid float", "a = 1.2.3", `line 1: invalid float "1.2.3"`},
+		{"bare word", "a = yes", `line 1: invalid value "yes"`},
+		{"bare word ending in inf", "a = xinf", `line 1: invalid value "xinf"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeTOML(tt.data)
+			if err == nil {
+				t.Fatalf("decodeTOML(%q) succeeded; want an error containing %q", tt.data, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) || !strings.HasPrefix(err.Error(), "toml: ") {
+				t.Errorf("decodeTOML(%q) error = %q; want it to contain %q", tt.data, err, tt.wantErr)
+			}
+		})
+	}
+}
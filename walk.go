@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// walkWorkers is the number of directories read concurrently by walkDirs
+var walkWorkers = 4 * runtime.NumCPU()
+
+// walkDirs visits the directory root and its subdirectories using a bounded pool of
+// workers. visit is called concurrently, once per directory; its subdirectories are
+// visited only if it returns true. Errors reading a directory or one of its entries are passed
+// to onWalkError; the walk skips only the directory or entry, and continues with its siblings.
+// If `followSymlinks` is set, symlinks to directories are visited as subdirectories
+// (under the symlink's path); a directory whose real path was already visited is skipped.
+// If `contentHash` is set, the hashes of the regular files not excluded are primed.
+func walkDirs(root string, info os.FileInfo, visit func(path string, info os.FileInfo) bool) {
+	type dir struct {
+		path string
+		info os.FileInfo
+		real string // the real path, if following symlinks
+	}
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		queue   = []dir{{root, info, realPath(root)}}
+		pending = 1
+		visited = map[string]bool{queue[0].real: true}
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < walkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(queue) == 0 && pending > 0 {
+					cond.Wait()
+				}
+				if pending == 0 {
+					mu.Unlock()
+					return
+				}
+				d := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				mu.Unlock()
+
+				var subdirs []dir
+				if visit(d.path, d.info) {
+					entries, errs := readDir(d.path)
+					for _, err := range errs {
+						onWalkError(err)
+					}
+					for _, entry := range entries {
+						path := filepath.Join(d.path, entry.Name())
+						switch {
+						case entry.IsDir():
+							subdirs = append(subdirs, dir{path, entry, filepath.Join(d.real, entry.Name())})
+						case config.FollowSymlinks && entry.Mode()&os.ModeSymlink != 0:
+							if target, err := os.Stat(path); err == nil && target.IsDir() {
+								subdirs = append(subdirs, dir{path, target, realPath(path)})
+							}
+						case config.ContentHash && entry.Mode().IsRegular() && !shouldExclude(path, entry):
+							contentHashes.prime(path)
+						}
+					}
+				}
+
+				mu.Lock()
+				if config.FollowSymlinks {
+					unvisited := subdirs[:0]
+					for _, sub := range subdirs {
+						if visited[sub.real] {
+							onInfo(struct {
+								RedundantWatch string `json:"redundantWatch"`
+								RealPath       string `json:"realPath"`
+							}{
+								RedundantWatch: sub.path,
+								RealPath:       sub.real,
+							})
+							continue
+						}
+						visited[sub.real] = true
+						unvisited = append(unvisited, sub)
+					}
+					subdirs = unvisited
+				}
+				queue = append(queue, subdirs...)
+				pending += len(subdirs) - 1
+				cond.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// readDir returns the entries of the directory. Unlike ioutil.ReadDir, which fails as
+// a whole, it skips the entries it cannot stat and returns their errors.
+func readDir(path string) (entries []os.FileInfo, errs []error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, name := range names {
+		info, err := os.Lstat(filepath.Join(path, name))
+		switch {
+		case os.IsNotExist(err):
+			// removed since the directory was read
+		case err != nil:
+			errs = append(errs, err)
+		default:
+			entries = append(entries, info)
+		}
+	}
+	return entries, errs
+}
+
+// onWalkError reports an error encountered while walking a directory tree
+func onWalkError(err error) {
+	switch v := err.(type) {
+	case *os.PathError:
+		onError(struct {
+			Op      string `json:"op"`
+			Path    string `json:"path"`
+			Message string `json:"message"`
+		}{
+			Op:      v.Op,
+			Path:    v.Path,
+			Message: v.Err.Error(),
+		})
+	default:
+		onError(err)
+	}
+}
+
+// realPath returns the absolute path with all symlinks resolved, or if that fails, the absolute path
+func realPath(path string) string {
+	if !config.FollowSymlinks {
+		return ""
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
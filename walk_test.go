@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// makeTree creates a tree below dir in which each directory has `width` subdirectories and a
+// file, `depth` levels deep. It returns the directories created.
+func makeTree(t testing.TB, dir string, width, depth int) (dirs []string) {
+	for i := 0; depth > 0 && i < width; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("d%d", i))
+		writeFile(t, sub, "f.txt", "f")
+		dirs = append(dirs, sub)
+		dirs = append(dirs, makeTree(t, sub, width, depth-1)...)
+	}
+	return dirs
+}
+
+func TestWatchRecursiveWatchesAllDirs(t *testing.T) {
+	dir := t.TempDir()
+	want := append([]string{dir}, makeTree(t, dir, 4, 3)...)
+	// ignored directories are pruned with everything below them
+	makeTree(t, filepath.Join(dir, "d1", "node_modules"), 3, 2)
+	makeTree(t, filepath.Join(dir, "d2", "d3", "node_modules"), 3, 2)
+	sort.Strings(want)
+	useConfig(t, configuration{IgnoreWatch: []string{"**/node_modules"}})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	saved := watched
+	watched = newWatchSet()
+	defer func() { watched = saved }()
+	w := &addWatcher{}
+	watchRecursive(w, dir)
+	if got := w.paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("watched %d dirs %v; want %d dirs %v", len(got), got, len(want), want)
+	}
+	if dirs, files := watched.counts(); dirs != len(want) || files != 0 {
+		t.Errorf("the watch set has %d dirs and %d files; want %d dirs", dirs, files, len(want))
+	}
+}
+
+func BenchmarkWatchRecursive(b *testing.B) {
+	dir := b.TempDir()
+	makeTree(b, dir, 10, 3)
+	saved, savedWatched := config, watched
+	defer func() { config, watched = saved, savedWatched }()
+	config = configuration{}
+	if err := config.makeCanonical(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		watched = newWatchSet()
+		watchRecursive(&addWatcher{}, dir)
+	}
+}
+
+func TestWatchDepth(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, 2, 3)
+	makeTree(t, filepath.Join(dir, "node_modules"), 2, 1)
+	depth := func(d int) *int { return &d }
+	tests := []struct {
+		depth *int
+		want  []string // relative to dir
+	}{
+		{depth(0), []string{"."}},
+		{depth(1), []string{".", "d0", "d1"}},
+		{depth(2), []string{".", "d0", "d0/d0", "d0/d1", "d1", "d1/d0", "d1/d1"}},
+		{nil, []string{".", "d0", "d0/d0", "d0/d0/d0", "d0/d0/d1", "d0/d1", "d0/d1/d0", "d0/d1/d1",
+			"d1", "d1/d0", "d1/d0/d0", "d1/d0/d1", "d1/d1", "d1/d1/d0", "d1/d1/d1"}},
+	}
+	for _, tt := range tests {
+		useConfig(t, configuration{Depth: tt.depth, IgnoreWatch: []string{"**/node_modules"}})
+		if err := config.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		saved := watched
+		watched = newWatchSet()
+		w := &addWatcher{}
+		watchRecursive(w, dir)
+		watched = saved
+		var got []string
+		for _, path := range w.paths() {
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("depth %v: watched %q; want %q", config.depth, got, tt.want)
+		}
+		for _, rel := range tt.want {
+			if !withinDepth([]string{dir}, filepath.Join(dir, rel)) {
+				t.Errorf("depth %v: %s is not within the depth", config.depth, rel)
+			}
+		}
+		if tt.depth != nil && withinDepth([]string{dir}, filepath.Join(dir, "d0/d0/d0/d0")) {
+			t.Errorf("depth %v: a deeper directory is within the depth", config.depth)
+		}
+	}
+}
+
+func TestNoRecursiveFlag(t *testing.T) {
+	savedDepth, savedNoRecursive := depth, noRecursive
+	noRecursive = true
+	defer func() { depth, noRecursive = savedDepth, savedNoRecursive }()
+	useConfig(t, configuration{})
+	flagsToConfiguration()
+	if config.Depth == nil || *config.Depth != 0 {
+		t.Errorf("depth = %v with -no-recursive; want 0", config.Depth)
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "src/sub/a.go", "package a")
+	root := filepath.Join(dir, "watched")
+	writeFile(t, root, "b.go", "package b")
+	if err := os.Symlink(filepath.Join(dir, "src"), filepath.Join(root, "link")); err != nil {
+		t.Skip("cannot create symlinks:", err)
+	}
+	// links back to a visited directory would make the walk loop
+	os.Symlink(root, filepath.Join(root, "loop"))
+	os.Symlink(filepath.Join(dir, "src"), filepath.Join(root, "again"))
+	tests := []struct {
+		follow bool
+		want   []string // relative to root
+	}{
+		{false, []string{"."}},
+		{true, []string{".", "again", "again/sub"}},
+	}
+	for _, tt := range tests {
+		useConfig(t, configuration{FollowSymlinks: tt.follow})
+		if err := config.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		stderr := captureStderr(t)
+		saved := watched
+		watched = newWatchSet()
+		w := &addWatcher{}
+		watchRecursive(w, root)
+		watched = saved
+		var got []string
+		for _, path := range w.paths() {
+			rel, _ := filepath.Rel(root, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		// which of the two links to src is visited first depends on the walk order
+		for i, rel := range got {
+			got[i] = strings.Replace(rel, "link", "again", 1)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("followSymlinks %v: watched %q; want %q", tt.follow, got, tt.want)
+		}
+		redundant := 0
+		for _, record := range stderr.recordsWith(t, "info") {
+			if info, ok := record["info"].(map[string]interface{}); ok && info["redundantWatch"] != nil {
+				redundant++
+			}
+		}
+		if want := map[bool]int{true: 2}[tt.follow]; redundant != want {
+			t.Errorf("followSymlinks %v: reported %d redundant watches; want %d\n%s", tt.follow, redundant, want, stderr)
+		}
+	}
+}
+
+func TestFollowSymlinksEvents(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeFile(t, src, "sub/a.go", "package a")
+	root := filepath.Join(dir, "watched")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(src, filepath.Join(root, "link")); err != nil {
+		t.Skip("cannot create symlinks:", err)
+	}
+	w := startWatchfsIn(t, root, `
+paths: [$DIR]
+followSymlinks: true
+`)
+	writeFile(t, src, "sub/a.go", "package a // changed")
+	want := filepath.Join(root, "link", "sub", "a.go")
+	w.waitFor("the event below the link", func() bool {
+		for _, e := range w.events() {
+			if e["path"] == want {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestWalkSkipsUnreadableDirs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a/a1/f.txt", "locked/inner/f.txt", "z/z1/f.txt"} {
+		writeFile(t, dir, name, "f")
+	}
+	locked := filepath.Join(dir, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+	if f, err := os.Open(locked); err == nil {
+		f.Close()
+		t.Skip("the directory is still readable (e.g. when running as root)")
+	}
+	useConfig(t, configuration{})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	stderr := captureStderr(t)
+	saved := watched
+	watched = newWatchSet()
+	defer func() { watched = saved }()
+	w := &addWatcher{}
+	watchRecursive(w, dir)
+	var got []string
+	for _, path := range w.paths() {
+		rel, _ := filepath.Rel(dir, path)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	// the unreadable directory itself is watched, but not what is below it
+	if want := []string{".", "a", "a/a1", "locked", "z", "z/z1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("watched %q; want %q", got, want)
+	}
+	errors := stderr.recordsWith(t, "error")
+	if len(errors) != 1 {
+		t.Fatalf("got errors %v; want one for the unreadable directory", errors)
+	}
+	if err, ok := errors[0]["error"].(map[string]interface{}); !ok || err["path"] != locked || err["op"] != "open" {
+		t.Errorf("error = %v; want the open of %s", errors[0]["error"], locked)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "github.com/fsnotify/fsnotify"
+
+// Watcher is a source of filesystem events
+type Watcher interface {
+	Add(path string) error
+	Remove(path string) error
+	Close() error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+}
+
+func newWatcher() (Watcher, error) {
+	if replayPath != "" {
+		w, err := newReplayWatcher(replayPath, replaySpeed)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	if config.Poll {
+		return newPollWatcher(config.pollInterval), nil
+	}
+	if config.CloseWrite {
+		return newCloseWriteWatcher()
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &fsnotifyWatcher{w}, nil
+}
+
+// fsnotifyWatcher is a Watcher backed by the native OS notification API
+type fsnotifyWatcher struct {
+	*fsnotify.Watcher
+}
+
+// Events returns the event channel
+func (w *fsnotifyWatcher) Events() <-chan fsnotify.Event {
+	return w.Watcher.Events
+}
+
+// Errors returns the error channel
+func (w *fsnotifyWatcher) Errors() <-chan error {
+	return w.Watcher.Errors
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// watchSet tracks the directories and individual files that are being watched
+type watchSet struct {
+	mu    sync.Mutex
+	dirs  map[string]bool
+	files map[string]bool
+
+	rewatching map[string]bool
+	missing    map[string]bool   // watch paths waited for while they do not exist, and whether they do not
+	waiting    map[string]bool   // directories watched only to wait for a missing path
+	waitingOn  map[string]string // the directory each missing path was last waited for in
+}
+
+func newWatchSet() *watchSet {
+	return &watchSet{
+		dirs:  make(map[string]bool),
+		files: make(map[string]bool),
+
+		rewatching: make(map[string]bool),
+		missing:    make(map[string]bool),
+		waiting:    make(map[string]bool),
+		waitingOn:  make(map[string]string),
+	}
+}
+
+func (s *watchSet) addDir(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirs[path] = true
+}
+
+func (s *watchSet) addFile(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = true
+}
+
+// hasDir returns whether the directory is watched
+func (s *watchSet) hasDir(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirs[path]
+}
+
+// isFile returns whether the path is watched as an individual file
+func (s *watchSet) isFile(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files[path]
+}
+
+// startRewatch marks the file as being re-added; it returns false if it already is
+func (s *watchSet) startRewatch(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rewatching[path] {
+		return false
+	}
+	s.rewatching[path] = true
+	return true
+}
+
+func (s *watchSet) endRewatch(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rewatching, path)
+}
+
+// addMissing records that the watch path does not exist, and that parent is watched to wait for it.
+// It returns false if the path was already waited for in parent.
+func (s *watchSet) addMissing(path, parent string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.missing[path] = true
+	if !s.dirs[parent] {
+		s.waiting[parent] = true
+	}
+	if s.waitingOn[path] == parent {
+		return false
+	}
+	s.waitingOn[path] = parent
+	return true
+}
+
+// takeMissing returns the missing watch paths that are the path or below it, and marks them as found
+func (s *watchSet) takeMissing(path string) (paths []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for missing, pending := range s.missing {
+		if pending && pathDepth(path, missing) >= 0 {
+			s.missing[missing] = false
+			paths = append(paths, missing)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// found records that the watch path exists; it is waited for again if it is removed
+func (s *watchSet) found(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.missing[path] = false
+	delete(s.waitingOn, path)
+}
+
+// isFound returns whether the path is a watch path that is waited for while it does not exist, and exists
+func (s *watchSet) isFound(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	missing, ok := s.missing[path]
+	return ok && !missing
+}
+
+// isAwaited returns whether the path is a watch path that is waited for while it does not exist
+func (s *watchSet) isAwaited(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.missing[path]
+	return ok
+}
+
+// isWaiting returns whether the directory is watched only to wait for a missing path
+func (s *watchSet) isWaiting(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiting[path] && !s.dirs[path]
+}
+
+// counts returns the number of watched directories and individual files
+func (s *watchSet) counts() (dirs, files int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.dirs), len(s.files)
+}
+
+// list returns the sorted watched directories and individual files
+func (s *watchSet) list() (dirs, files []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dirs = sortedKeys(s.dirs)
+	files = sortedKeys(s.files)
+	return
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
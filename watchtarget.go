@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// watchTarget is an entry of `paths`: a path to watch, with an optional filter
+// for the events below it. It may be given as just the path string.
+type watchTarget struct {
+	Path   string `json:"path" yaml:"path"`
+	Filter `yaml:",inline,omitempty"`
+}
+
+// watchTargetList is a list of watch targets
+type watchTargetList []watchTarget
+
+// watchTargetsOf returns the targets for paths without filters
+func watchTargetsOf(paths ...string) (targets watchTargetList) {
+	for _, path := range paths {
+		targets = append(targets, watchTarget{Path: path})
+	}
+	return targets
+}
+
+// paths returns the paths of the targets
+func (l watchTargetList) paths() (paths []string) {
+	for _, t := range l {
+		paths = append(paths, t.Path)
+	}
+	return paths
+}
+
+// hasFilter returns whether any of exts, ops, only or contentType is set for the target,
+// i.e. whether its filter replaces the top-level filter
+func (t *watchTarget) hasFilter() bool {
+	f := &t.Filter
+	return f.ExtensionsCSV != "" || len(f.Extensions) > 0 || f.OpsCSV != "" || len(f.Ops) > 0 || f.Only != "" || len(f.ContentTypes) > 0
+}
+
+// isPlain returns whether none of the target's filter fields is set, so that it is
+// written as just its path
+func (t *watchTarget) isPlain() bool {
+	return !t.hasFilter() && t.Filter.MatchMode == "" && t.Filter.CaseSensitive == nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (t *watchTarget) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		*t = watchTarget{Path: path}
+		return nil
+	}
+	type plain watchTarget
+	return unmarshal((*plain)(t))
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (t watchTarget) MarshalYAML() (interface{}, error) {
+	if t.isPlain() {
+		return t.Path, nil
+	}
+	type plain watchTarget
+	return plain(t), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *watchTarget) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*t = watchTarget{Path: path}
+		return nil
+	}
+	type plain watchTarget
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+// MarshalJSON implements json.Marshaler
+func (t watchTarget) MarshalJSON() ([]byte, error) {
+	if t.isPlain() {
+		return json.Marshal(t.Path)
+	}
+	type plain watchTarget
+	return json.Marshal(plain(t))
+}
+
+// makeCanonical expands `@file` entries into the paths listed in the file (with the
+// entry's filter), appends the paths listed in `fromFiles`, and prepares the filters
+func (l watchTargetList) makeCanonical(fromFiles []string, caseSensitive *bool) (out watchTargetList, err error) {
+	for _, t := range l {
+		paths, listErr := expandPathLists([]string{t.Path}, nil)
+		if listErr != nil && err == nil {
+			err = listErr
+		}
+		for _, path := range paths {
+			target := t
+			target.Path = path
+			out = append(out, target)
+		}
+	}
+	listed, listErr := expandPathLists(nil, fromFiles)
+	if listErr != nil && err == nil {
+		err = listErr
+	}
+	out = append(out, watchTargetsOf(listed...)...)
+	for i := range out {
+		if out[i].CaseSensitive == nil {
+			out[i].CaseSensitive = caseSensitive
+		}
+		if filterErr := out[i].Filter.makeCanonical(); filterErr != nil && err == nil {
+			err = fmt.Errorf("paths %q: %v", out[i].Path, filterErr)
+		}
+	}
+	return out, err
+}
+
+// walkRoots returns the cleaned paths of the targets to walk. Targets are compared by their
+// absolute paths: duplicates are dropped, and so are directories inside another target's
+// directory that its walk reaches anyway (the depth is unlimited, and no directory on the
+// way is ignored). Dropped targets are reported as info; their filters still apply.
+func walkRoots(targets watchTargetList) (paths []string) {
+	var roots []walkRoot
+	seen := make(map[string]string)
+	for _, t := range targets {
+		path := filepath.Clean(t.Path)
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		if first, ok := seen[abs]; ok {
+			onInfo(struct {
+				RedundantWatch string `json:"redundantWatch"`
+				Duplicate      string `json:"duplicate"`
+			}{
+				RedundantWatch: t.Path,
+				Duplicate:      first,
+			})
+			continue
+		}
+		seen[abs] = t.Path
+		info, err := os.Stat(path)
+		roots = append(roots, walkRoot{path: path, abs: abs, isDir: err == nil && info.IsDir()})
+	}
+	for _, r := range roots {
+		if parent, ok := r.walkedWithin(roots); ok {
+			onInfo(struct {
+				RedundantWatch string `json:"redundantWatch"`
+				Within         string `json:"within"`
+			}{
+				RedundantWatch: r.path,
+				Within:         parent,
+			})
+			continue
+		}
+		paths = append(paths, r.path)
+	}
+	return paths
+}
+
+// walkRoot is a target path to walk
+type walkRoot struct {
+	path  string
+	abs   string
+	isDir bool
+}
+
+// walkedWithin returns the path of another root directory whose walk reaches this directory
+func (r walkRoot) walkedWithin(roots []walkRoot) (string, bool) {
+	if !r.isDir || config.depth >= 0 {
+		return "", false
+	}
+	for _, parent := range roots {
+		if !parent.isDir || pathDepth(parent.abs, r.abs) <= 0 {
+			continue
+		}
+		if !excludedBetween(parent.path, parent.abs, r.abs) {
+			return parent.path, true
+		}
+	}
+	return "", false
+}
+
+// excludedBetween returns whether the walk of the root directory would exclude the
+// directory at abs, or one of the directories leading to it
+func excludedBetween(root, rootAbs, abs string) bool {
+	rel, err := filepath.Rel(rootAbs, abs)
+	if err != nil {
+		return true
+	}
+	path := root
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, name)
+		info, err := os.Stat(path)
+		if err != nil || shouldExclude(path, info) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchRoots are the watched targets after glob expansion, for matching events against their filters
+var watchRoots struct {
+	sync.Mutex
+	targets watchTargetList
+}
+
+func setWatchRoots(targets watchTargetList) {
+	watchRoots.Lock()
+	defer watchRoots.Unlock()
+	watchRoots.targets = targets
+}
+
+// watchRootOf returns the target with the deepest path containing the given path, if any
+func watchRootOf(path string) (root *watchTarget, index int) {
+	watchRoots.Lock()
+	defer watchRoots.Unlock()
+	best := -1
+	for i := range watchRoots.targets {
+		t := &watchRoots.targets[i]
+		if pathDepth(t.Path, path) < 0 {
+			continue
+		}
+		if root == nil || len(t.Path) > len(root.Path) {
+			root, best = t, i
+		}
+	}
+	return root, best
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestDecodeWatchTargets(t *testing.T) {
+	want := watchTargetList{
+		{Path: "proto", Filter: Filter{Extensions: []string{"proto"}}},
+		{Path: "cmd", Filter: Filter{Extensions: []string{"go"}, Only: "file"}},
+		{Path: "docs"},
+	}
+	var fromYAML, fromJSON configuration
+	if err := fromYAML.decode(strings.NewReader("paths: [{path: proto, exts: [proto]}, {path: cmd, exts: [go], only: file}, docs]\n"), formatYAML); err != nil {
+		t.Fatal(err)
+	}
+	if err := fromJSON.decode(strings.NewReader(`{"paths": [{"path": "proto", "exts": ["proto"]}, {"path": "cmd", "exts": ["go"], "only": "file"}, "docs"]}`), formatJSON); err != nil {
+		t.Fatal(err)
+	}
+	for format, c := range map[string]configuration{formatYAML: fromYAML, formatJSON: fromJSON} {
+		if !reflect.DeepEqual(c.Paths, want) {
+			t.Errorf("%s: decoded %+v; want %+v", format, c.Paths, want)
+		}
+	}
+
+	// targets without a filter are written as their path
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != `[{"path":"proto","exts":["proto"]},{"path":"cmd","exts":["go"],"only":"file"},"docs"]` {
+		t.Errorf("JSON = %s", got)
+	}
+	data, err = yaml.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundTrip watchTargetList
+	if err := yaml.Unmarshal(data, &roundTrip); err != nil || !reflect.DeepEqual(roundTrip, want) {
+		t.Errorf("YAML %s decodes to %+v, %v", data, roundTrip, err)
+	}
+}
+
+func TestWatchRootOf(t *testing.T) {
+	saved := watchRoots.targets
+	defer setWatchRoots(saved)
+	setWatchRoots(watchTargetList{{Path: "src"}, {Path: filepath.Join("src", "proto")}, {Path: "cmd"}})
+	tests := []struct {
+		path  string
+		index int
+	}{
+		{filepath.Join("src", "a.go"), 0},
+		{filepath.Join("src", "proto", "a.proto"), 1},
+		{filepath.Join("src", "protobuf", "a.proto"), 0},
+		{filepath.Join("cmd", "main.go"), 2},
+		{filepath.Join("docs", "a.md"), -1},
+	}
+	for _, tt := range tests {
+		if _, i := watchRootOf(tt.path); i != tt.index {
+			t.Errorf("watchRootOf(%q) = paths[%d]; want paths[%d]", tt.path, i, tt.index)
+		}
+	}
+}
+
+func TestPerWatchRootFilters(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "proto/.keep", "")
+	writeFile(t, dir, "cmd/.keep", "")
+	w := startWatchfsIn(t, dir, `
+paths:
+- {path: $DIR/proto, exts: [proto]}
+- {path: $DIR/cmd, exts: [go]}
+exts: [md]
+`)
+	for _, name := range []string{"proto/a.go", "proto/a.md", "cmd/a.proto", "cmd/a.md", "proto/a.proto", "cmd/a.go"} {
+		w.write(name, name)
+	}
+	want := map[string]bool{w.path("proto/a.proto"): true, w.path("cmd/a.go"): true}
+	got := map[string]bool{}
+	w.waitFor("the events", func() bool {
+		for _, e := range w.events() {
+			got[e["path"].(string)] = true
+		}
+		return len(got) >= len(want)
+	})
+	w.stop()
+	// the filtered files are written first, so their events would have arrived by now
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events for %v; want only %v", got, want)
+	}
+}
+
+func TestWalkRoots(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	makeTree(t, src, 2, 2)
+	writeFile(t, dir, "build/out/a.o", "a")
+	file := writeFile(t, dir, "README.md", "r")
+	depth := func(d int) *int { return &d }
+	tests := []struct {
+		name    string
+		paths   []string
+		depth   *int
+		want    []string
+		dropped int // the number of redundantWatch infos
+	}{
+		{"nested", []string{dir, src, filepath.Join(src, "d0")}, nil, []string{dir}, 2},
+		{"duplicates", []string{src, src + "/", filepath.Join(dir, ".", "src")}, nil, []string{src}, 2},
+		{"disjoint", []string{src, filepath.Join(dir, "build")}, nil, []string{src, filepath.Join(dir, "build")}, 0},
+		// the walk of dir does not reach into an ignored directory, or below the depth
+		{"ignored on the way", []string{dir, filepath.Join(dir, "build", "out")}, nil, []string{dir, filepath.Join(dir, "build", "out")}, 0},
+		{"limited depth", []string{dir, src}, depth(1), []string{dir, src}, 0},
+		// files are watched through their directory, but remain targets of their own
+		{"file", []string{dir, file}, nil, []string{dir, file}, 0},
+	}
+	for _, tt := range tests {
+		stderr := captureStderr(t)
+		useConfig(t, configuration{Paths: watchTargetsOf(tt.paths...), Depth: tt.depth, IgnoreWatch: []string{"build"}})
+		if err := config.makeCanonical(); err != nil {
+			t.Fatal(err)
+		}
+		if got := walkRoots(config.Paths); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: walkRoots() = %q; want %q", tt.name, got, tt.want)
+		}
+		if got := len(stderr.recordsWith(t, "info")); got != tt.dropped {
+			t.Errorf("%s: got %d infos; want one per dropped target (%d):\n%s", tt.name, got, tt.dropped, stderr)
+		}
+	}
+}
+
+func TestOverlappingTargetsWatchedOnce(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, 2, 2)
+	captureStderr(t)
+	useConfig(t, configuration{Paths: watchTargetsOf(dir, filepath.Join(dir, "d0"), dir+"/", filepath.Join(dir, "d1", "d0"))})
+	if err := config.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	saved := watched
+	watched = newWatchSet()
+	defer func() { watched = saved }()
+	w := &addWatcher{}
+	for _, path := range walkRoots(config.Paths) {
+		watchRecursive(w, path)
+	}
+	seen := map[string]bool{}
+	for _, path := range w.paths() {
+		if seen[path] {
+			t.Errorf("%s is watched more than once", path)
+		}
+		seen[path] = true
+	}
+	if len(seen) != 7 {
+		t.Errorf("watched %q; want the 7 directories of the tree", w.paths())
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebSocketAddr      = "localhost:35729"
+	defaultWebSocketMessage   = "reload"
+	defaultWebSocketPath      = "/"
+	defaultWebSocketServePath = "/.watchfs/"
+)
+
+// ActionWebSocket broadcasts a message to all clients connected to a WebSocket server.
+// The server may also serve a directory, injecting the reload script into HTML pages.
+type ActionWebSocket struct {
+	Addr    string     `json:"addr,omitempty" yaml:"addr,omitempty"`
+	Message string     `json:"message,omitempty" yaml:"message,omitempty"`
+	Path    string     `json:"path,omitempty" yaml:"path,omitempty"`
+	Serve   string     `json:"serve,omitempty" yaml:"serve,omitempty"`
+	Origins stringList `json:"origins,omitempty" yaml:"origins,flow,omitempty"`
+
+	hub *webSocketHub
+}
+
+func (a *ActionWebSocket) makeCanonical() error {
+	if a.Addr == "" {
+		a.Addr = defaultWebSocketAddr
+	}
+	if a.Message == "" {
+		a.Message = defaultWebSocketMessage
+	}
+	if a.Path == "" {
+		a.Path = defaultWebSocketPath
+		if a.Serve != "" {
+			a.Path = defaultWebSocketServePath
+		}
+	}
+	if !strings.HasPrefix(a.Path, "/") {
+		a.Path = "/" + a.Path
+	}
+	if !strings.HasSuffix(a.Path, "/") {
+		a.Path += "/"
+	}
+	a.hub = newWebSocketHub(a.Origins)
+	return nil
+}
+
+// Notify notifies the action about a filesystem event
+func (a *ActionWebSocket) Notify(e Event) (bool, error) {
+	return false, nil
+}
+
+// Run sends the message to all connected clients
+func (a *ActionWebSocket) Run(ctx context.Context, events []Event) error {
+	message, err := expandTemplate(a.Message, newTemplateData(events))
+	if err != nil {
+		return fmt.Errorf("message: %v", err)
+	}
+	a.hub.broadcast(message)
+	return nil
+}
+
+// scriptPath is the URL path of the reload script
+func (a *ActionWebSocket) scriptPath() string {
+	return a.Path + "reload.js"
+}
+
+// serve serves WebSocket connections at `path`, the reload script at `path`reload.js,
+// and the `serve` directory (if any) until the returned function is called
+func (a *ActionWebSocket) serve() (stop func()) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(a.scriptPath(), a.serveReloadScript)
+	var files http.Handler
+	if a.Serve != "" {
+		files = newLiveReloadFileServer(a.Serve, a.scriptPath())
+		onInfo(fmt.Sprintf("serving %s at http://%s/", a.Serve, a.Addr))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == a.Path && headerHasToken(r.Header, "Upgrade", "websocket"):
+			a.hub.ServeHTTP(w, r)
+		case files != nil:
+			files.ServeHTTP(w, r)
+		case r.URL.Path == a.Path:
+			a.hub.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	server := &http.Server{Addr: a.Addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			onError(err)
+		}
+	}()
+	return func() {
+		server.Shutdown(context.Background())
+		a.hub.closeAll()
+	}
+}
+
+// reloadScript reloads the page when it receives a message, reconnecting when the connection is lost
+const reloadScript = `(function () {
+  var url = (location.protocol === "https:" ? "wss://" : "ws://") + %q + %q;
+  function connect() {
+    var socket = new WebSocket(url);
+    socket.onmessage = function () { location.reload(); };
+    socket.onclose = function () { setTimeout(connect, 1000); };
+  }
+  connect();
+})();
+`
+
+func (a *ActionWebSocket) serveReloadScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	fmt.Fprintf(w, reloadScript, r.Host, a.Path)
+}
+
+// webSocketGUID is appended to the client's key to compute the handshake response (RFC 6455, section 4.2.2)
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes
+const (
+	webSocketText  = 0x1
+	webSocketClose = 0x8
+	webSocketPing  = 0x9
+	webSocketPong  = 0xA
+)
+
+const (
+	webSocketMaxPayload   = 1 << 16
+	webSocketWriteTimeout = 5 * time.Second
+)
+
+// webSocketHub accepts WebSocket connections and broadcasts text messages to them
+type webSocketHub struct {
+	origins []string // origins allowed in addition to those of the server's host ("*": any)
+
+	mu      sync.Mutex
+	clients map[*webSocketConn]bool
+}
+
+func newWebSocketHub(origins []string) *webSocketHub {
+	return &webSocketHub{origins: origins, clients: make(map[*webSocketConn]bool)}
+}
+
+// originAllowed returns whether the handshake may be accepted: browsers send the page's
+// Origin, which must be on the server's host (on any port; loopback names and addresses
+// count as one host) or listed in `origins`. Requests without an Origin are not from a browser.
+func (h *webSocketHub) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = hostname
+	}
+	host = strings.Trim(host, "[]")
+	return strings.EqualFold(u.Hostname(), host) || (isLoopbackHost(u.Hostname()) && isLoopbackHost(host))
+}
+
+// isLoopbackHost returns whether the host name is localhost or a loopback address
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeHTTP performs the WebSocket opening handshake and registers the connection
+func (h *webSocketHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return
+	}
+	if !h.originAllowed(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		onError(err)
+		return
+	}
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+	c := &webSocketConn{conn: conn}
+	h.mu.Lock()
+	h.clients[c] = true
+	h.mu.Unlock()
+	go func() {
+		defer h.remove(c)
+		c.readLoop(rw.Reader)
+	}()
+}
+
+func (h *webSocketHub) remove(c *webSocketConn) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+	c.conn.Close()
+}
+
+// broadcast sends the message to all clients, dropping those that cannot be written to
+func (h *webSocketHub) broadcast(message string) {
+	h.mu.Lock()
+	clients := make([]*webSocketConn, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.Unlock()
+	for _, c := range clients {
+		if err := c.writeFrame(webSocketText, []byte(message)); err != nil {
+			h.remove(c)
+		}
+	}
+}
+
+func (h *webSocketHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		c.writeFrame(webSocketClose, nil)
+		c.conn.Close()
+		delete(h.clients, c)
+	}
+}
+
+// webSocketConn is a server-side WebSocket connection
+type webSocketConn struct {
+	conn net.Conn
+	mu   sync.Mutex // serializes writes
+}
+
+// writeFrame writes a single unmasked, unfragmented frame
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(n))
+		header = append(header, length[:]...)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readLoop reads frames sent by the client until it closes the connection, answering pings
+func (c *webSocketConn) readLoop(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readWebSocketFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case webSocketClose:
+			c.writeFrame(webSocketClose, nil)
+			return
+		case webSocketPing:
+			c.writeFrame(webSocketPong, payload)
+		}
+	}
+}
+
+// readWebSocketFrame reads a frame, removing the client's masking
+func readWebSocketFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(length[:]))
+	case 127:
+		var length [8]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(length[:])
+	}
+	if n > webSocketMaxPayload {
+		return 0, nil, errors.New("websocket: frame too large")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// headerHasToken returns whether the comma-separated header contains the token, ignoring case
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
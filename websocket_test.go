@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientFrame returns a masked, unfragmented frame as sent by a client
+func clientFrame(opcode byte, payload []byte, mask [4]byte) []byte {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(n))
+		frame = append(append(frame, 0x80|127), length[:]...)
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestReadWebSocketFrame(t *testing.T) {
+	mask := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	medium := bytes.Repeat([]byte("x"), 300)
+	large := bytes.Repeat([]byte("y"), 0x10000)
+	tests := []struct {
+		name        string
+		frame       []byte
+		wantOpcode  byte
+		wantPayload []byte
+		wantErr     bool
+	}{
+		{"masked text", clientFrame(webSocketText, []byte("Hello"), mask), webSocketText, []byte("Hello"), false},
+		// the example of RFC 6455, section 5.7
+		{"rfc example", []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, webSocketText, []byte("Hello"), false},
+		{"unmasked", []byte{0x89, 0x02, 'h', 'i'}, webSocketPing, []byte("hi"), false},
+		{"empty close", clientFrame(webSocketClose, nil, mask), webSocketClose, []byte{}, false},
+		{"16-bit length", clientFrame(webSocketText, medium, mask), webSocketText, medium, false},
+		{"64-bit length", clientFrame(webSocketText, large, mask), webSocketText, large, false},
+		{"too large", clientFrame(webSocketText, append(large, 'z'), mask), 0, nil, true},
+		{"truncated header", []byte{0x81}, 0, nil, true},
+		{"truncated length", []byte{0x81, 0x80 | 126, 0x01}, 0, nil, true},
+		{"truncated mask", []byte{0x81, 0x85, 0x37}, 0, nil, true},
+		{"truncated payload", clientFrame(webSocketText, []byte("Hello"), mask)[:8], 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opcode, payload, err := readWebSocketFrame(bufio.NewReader(bytes.NewReader(tt.frame)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v; want error: %v", err, tt.wantErr)
+			}
+			if opcode != tt.wantOpcode || !bytes.Equal(payload, tt.wantPayload) {
+				t.Errorf("got opcode %#x with %d bytes; want %#x with %d bytes", opcode, len(payload), tt.wantOpcode, len(tt.wantPayload))
+			}
+		})
+	}
+}
+
+func TestWriteFrame(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    []byte
+		wantHeader []byte
+	}{
+		{"short", []byte("reload"), []byte{0x81, 6}},
+		{"125 bytes", bytes.Repeat([]byte("a"), 125), []byte{0x81, 125}},
+		{"126 bytes", bytes.Repeat([]byte("a"), 126), []byte{0x81, 126, 0, 126}},
+		{"65536 bytes", bytes.Repeat([]byte("a"), 0x10000), []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			c := &webSocketConn{conn: server}
+			go func() {
+				c.writeFrame(webSocketText, tt.payload)
+				server.Close()
+			}()
+			frame := readAll(t, client)
+			if !bytes.HasPrefix(frame, tt.wantHeader) {
+				t.Fatalf("header = %v; want %v", frame[:len(tt.wantHeader)], tt.wantHeader)
+			}
+			// server frames are unmasked
+			opcode, payload, err := readWebSocketFrame(bufio.NewReader(bytes.NewReader(frame)))
+			if err != nil || opcode != webSocketText || !bytes.Equal(payload, tt.payload) {
+				t.Errorf("read back opcode %#x, %d bytes, %v; want a text frame with %d bytes", opcode, len(payload), err, len(tt.payload))
+			}
+		})
+	}
+}
+
+func readAll(t *testing.T, r io.Reader) []byte {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		host    string
+		origin  string
+		origins []string
+		want    bool
+	}{
+		{"localhost:35729", "", nil, true},
+		{"localhost:35729", "http://localhost:8080", nil, true},
+		{"localhost:35729", "http://127.0.0.1:3000", nil, true},
+		{"127.0.0.1:35729", "http://[::1]:3000", nil, true},
+		{"dev.example.com:35729", "https://DEV.example.com", nil, true},
+		{"[::1]:35729", "http://localhost", nil, true},
+		{"localhost:35729", "https://evil.example.com", nil, false},
+		{"dev.example.com:35729", "http://localhost:8080", nil, false},
+		{"localhost:35729", "null", nil, false},
+		{"localhost:35729", "https://app.example.com", []string{"https://app.example.com/"}, true},
+		{"localhost:35729", "https://app.example.com:8443", []string{"https://app.example.com"}, false},
+		{"localhost:35729", "https://evil.example.com", []string{"*"}, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := newWebSocketHub(tt.origins).originAllowed(r); got != tt.want {
+			t.Errorf("originAllowed(host %q, origin %q, origins %q) = %v; want %v", tt.host, tt.origin, tt.origins, got, tt.want)
+		}
+	}
+}
+
+// dialWebSocket performs the opening handshake with the server, returning the
+// connection and the response status line and headers
+func dialWebSocket(t *testing.T, addr, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	return dialWebSocketPath(t, addr, "/", origin)
+}
+
+// dialWebSocketPath is dialWebSocket for an endpoint at the given URL path
+func dialWebSocketPath(t *testing.T, addr, path, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	request := "GET " + path + " HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	if origin != "" {
+		request += "Origin: " + origin + "\r\n"
+	}
+	if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	response, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, r, response
+}
+
+func TestWebSocketAction(t *testing.T) {
+	a := &ActionWebSocket{Message: "changed {{.Path}}"}
+	if err := a.makeCanonical(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(a.hub)
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	if _, _, response := dialWebSocket(t, addr, "https://evil.example.com"); response.StatusCode != http.StatusForbidden {
+		t.Errorf("handshake from a foreign origin: status %d; want %d", response.StatusCode, http.StatusForbidden)
+	}
+	conn, r, response := dialWebSocket(t, addr, "http://"+addr)
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: status %d; want %d", response.StatusCode, http.StatusSwitchingProtocols)
+	}
+	// the example key and accept value of RFC 6455, section 1.3
+	if got := response.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// a ping is answered before the broadcast, so the client is registered by then
+	conn.Write(clientFrame(webSocketPing, []byte("p"), [4]byte{1, 2, 3, 4}))
+	if opcode, payload, err := readWebSocketFrame(r); err != nil || opcode != webSocketPong || string(payload) != "p" {
+		t.Fatalf("ping: got opcode %#x, %q, %v; want a pong", opcode, payload, err)
+	}
+	if err := a.Run(context.Background(), []Event{{Name: "index.html"}}); err != nil {
+		t.Fatal(err)
+	}
+	if opcode, payload, err := readWebSocketFrame(r); err != nil || opcode != webSocketText || string(payload) != "changed index.html" {
+		t.Fatalf("broadcast: got opcode %#x, %q, %v", opcode, payload, err)
+	}
+	conn.Write(clientFrame(webSocketClose, nil, [4]byte{1, 2, 3, 4}))
+	if opcode, _, err := readWebSocketFrame(r); err != nil || opcode != webSocketClose {
+		t.Fatalf("close: got opcode %#x, %v; want a close frame", opcode, err)
+	}
+}
+
+func TestHeaderHasToken(t *testing.T) {
+	header := http.Header{"Connection": {"keep-alive, Upgrade"}, "Upgrade": {"WebSocket"}}
+	tests := []struct {
+		name, token string
+		want        bool
+	}{
+		{"Connection", "upgrade", true},
+		{"connection", "keep-alive", true},
+		{"Connection", "close", false},
+		{"Upgrade", "websocket", true},
+		{"Origin", "websocket", false},
+	}
+	for _, tt := range tests {
+		if got := headerHasToken(header, tt.name, tt.token); got != tt.want {
+			t.Errorf("headerHasToken(%q, %q) = %v; want %v", tt.name, tt.token, got, tt.want)
+		}
+	}
+}